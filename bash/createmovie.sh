@@ -1,13 +1,13 @@
 INPUTMESSAGE="***** Request Body *****"
 OUTPUTMESSAGE="***** Response *****"
 
-# Should give json.SyntaxError
+# Should give 415 Unsupported Media Type
 echo Sending XML as request body...
 echo "$INPUTMESSAGE"
 BODY='<?xml version="1.0" encoding="UTF-8"?><note><to>Alex</to></note>'
 echo "$BODY"
 echo "$OUTPUTMESSAGE"
-curl -i -d "$BODY" localhost:4000/v1/movies
+curl -i -H "Content-Type: application/xml" -d "$BODY" localhost:4000/v1/movies
 echo
 
 # Should give json.SyntaxError
@@ -16,7 +16,7 @@ echo "$INPUTMESSAGE"
 BODY='{"title": "Moana", }'
 echo "$BODY"
 echo "$OUTPUTMESSAGE"
-curl -i -d "$BODY" localhost:4000/v1/movies
+curl -i -H "Content-Type: application/json" -d "$BODY" localhost:4000/v1/movies
 echo
 
 # Should give json.UnmarshalTypeError
@@ -25,7 +25,7 @@ echo "$INPUTMESSAGE"
 BODY='["foo", "bar"]'
 echo "$BODY"
 echo "$OUTPUTMESSAGE"
-curl -i -d "$BODY" localhost:4000/v1/movies
+curl -i -H "Content-Type: application/json" -d "$BODY" localhost:4000/v1/movies
 echo
 
 # Should give json.UnmarshalTypeError
@@ -34,7 +34,7 @@ echo "$INPUTMESSAGE"
 BODY='{"title": 123}'
 echo "$BODY"
 echo "$OUTPUTMESSAGE"
-curl -i -d "$BODY" localhost:4000/v1/movies
+curl -i -H "Content-Type: application/json" -d "$BODY" localhost:4000/v1/movies
 echo
 
 # Should give io.EOF
@@ -49,7 +49,7 @@ echo "$INPUTMESSAGE"
 BODY='{"title": "Moana", "rating": "PG"}'
 echo "$BODY"
 echo "$OUTPUTMESSAGE"
-curl -i -d "$BODY" localhost:4000/v1/movies
+curl -i -H "Content-Type: application/json" -d "$BODY" localhost:4000/v1/movies
 echo
 
 # Multiple JSON values instead of one
@@ -58,7 +58,7 @@ echo "$INPUTMESSAGE"
 BODY='{"title": "Moana"}{"title": "Top Gun"}'
 echo "$BODY"
 echo "$OUTPUTMESSAGE"
-curl -i -d "$BODY" localhost:4000/v1/movies
+curl -i -H "Content-Type: application/json" -d "$BODY" localhost:4000/v1/movies
 echo
 
 # Body contains garbage content after the first JSON value
@@ -67,7 +67,7 @@ echo "$INPUTMESSAGE"
 BODY='{"title": "Moana"} :~()'
 echo "$BODY"
 echo "$OUTPUTMESSAGE"
-curl -i -d "$BODY" localhost:4000/v1/movies
+curl -i -H "Content-Type: application/json" -d "$BODY" localhost:4000/v1/movies
 echo
 
 # Send a 1.5MB JSON file
@@ -78,7 +78,7 @@ if [ ! -f "$FILE" ]; then
   wget -O /tmp/largefile.json https://www.alexedwards.net/static/largefile.json
 fi
 echo "$OUTPUTMESSAGE"
-curl -i -d @/tmp/largefile.json localhost:4000/v1/movies
+curl -i -H "Content-Type: application/json" -d @/tmp/largefile.json localhost:4000/v1/movies
 echo
 
 # Test validation logic of handler
@@ -87,7 +87,7 @@ echo "$INPUTMESSAGE"
 BODY='{"title":"","year":1000,"runtime":"-123 mins","genres":["sci-fi","sci-fi"]}'
 echo "$BODY"
 echo "$OUTPUTMESSAGE"
-curl -i -d "$BODY" localhost:4000/v1/movies
+curl -i -H "Content-Type: application/json" -d "$BODY" localhost:4000/v1/movies
 echo
 
 # Valid request
@@ -96,7 +96,7 @@ echo "$INPUTMESSAGE"
 BODY='{"title":"Moana","year":2016,"runtime":"107 mins","genres":["animation","adventure"]}'
 echo "$BODY"
 echo "$OUTPUTMESSAGE"
-curl -i -d "$BODY" localhost:4000/v1/movies
+curl -i -H "Content-Type: application/json" -d "$BODY" localhost:4000/v1/movies
 echo
 
 # Create more movies
@@ -105,19 +105,19 @@ echo "$INPUTMESSAGE"
 BODY='{"title":"Deadpool","year":2016, "runtime":"108 mins","genres":["action","comedy"]}'
 echo "$BODY"
 echo "$OUTPUTMESSAGE"
-curl -d "$BODY" localhost:4000/v1/movies
+curl -H "Content-Type: application/json" -d "$BODY" localhost:4000/v1/movies
 
 echo Creating Black Panther movie...
 echo "$INPUTMESSAGE"
 BODY='{"title":"Black Panther","year":2018,"runtime":"134 mins","genres":["action","adventure"]}'
 echo "$BODY"
 echo "$OUTPUTMESSAGE"
-curl -d "$BODY" localhost:4000/v1/movies
+curl -H "Content-Type: application/json" -d "$BODY" localhost:4000/v1/movies
 
 echo Creating The Breakfast Club movie...
 echo "$INPUTMESSAGE"
 BODY='{"title":"The Breakfast Club","year":1986, "runtime":"96 mins","genres":["drama"]}'
 echo "$BODY"
 echo "$OUTPUTMESSAGE"
-curl -d "$BODY" localhost:4000/v1/movies
+curl -H "Content-Type: application/json" -d "$BODY" localhost:4000/v1/movies
 
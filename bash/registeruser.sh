@@ -7,7 +7,7 @@ echo "$INPUTMESSAGE"
 BODY='{"name": "Alice Smith", "email": "ALICE@example.com", "password": "pa55word"}'
 echo "$BODY"
 echo "$OUTPUTMESSAGE"
-curl -w '\nTime: %{time_total}\n' -i -d "$BODY" localhost:4000/v1/users
+curl -w '\nTime: %{time_total}\n' -i -H "Content-Type: application/json" -d "$BODY" localhost:4000/v1/users
 echo
 
 # Register a new user with valid credentials but duplicate email
@@ -16,7 +16,7 @@ echo "$INPUTMESSAGE"
 BODY='{"name": "Alice Smith", "email": "alice@example.com", "password": "pa55word"}'
 echo "$BODY"
 echo "$OUTPUTMESSAGE"
-curl -w '\nTime: %{time_total}\n' -i -d "$BODY" localhost:4000/v1/users
+curl -w '\nTime: %{time_total}\n' -i -H "Content-Type: application/json" -d "$BODY" localhost:4000/v1/users
 echo
 
 # Register a new user with invalid credentials
@@ -25,5 +25,5 @@ echo "$INPUTMESSAGE"
 BODY='{"name": "", "email": "alice@invalid.", "password": "123"}'
 echo "$BODY"
 echo "$OUTPUTMESSAGE"
-curl -w '\nTime: %{time_total}\n' -i -d "$BODY" localhost:4000/v1/users
+curl -w '\nTime: %{time_total}\n' -i -H "Content-Type: application/json" -d "$BODY" localhost:4000/v1/users
 echo
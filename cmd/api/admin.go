@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jseow5177/greenlight/internal/data"
+	"github.com/jseow5177/greenlight/internal/validator"
+)
+
+// The permissions granted to the admin user created with the -create-admin flag.
+var adminPermissions = []string{"movies:write", "users:read", "audit:read"}
+
+// createAdmin() inserts a new activated user and grants it the admin permissions.
+// It is used to bootstrap the first privileged account without having to write SQL by hand. The password must
+// have at least the given strength score, like the password of any other new user.
+func createAdmin(models data.Models, name, email, password string, minPasswordScore int) (*data.User, error) {
+	user := &data.User{
+		Name:      name,
+		Email:     email,
+		Activated: true,
+	}
+
+	err := user.Password.Set(password)
+	if err != nil {
+		return nil, err
+	}
+
+	v := validator.New()
+
+	if data.ValidateUser(v, user, minPasswordScore); !v.Valid() {
+		// Flatten the validation errors into a single message for the command line.
+		messages := make([]string, 0, len(v.Errors))
+		for key, message := range v.Errors {
+			messages = append(messages, fmt.Sprintf("%s %s", key, message))
+		}
+		return nil, fmt.Errorf("invalid admin user: %s", strings.Join(messages, ", "))
+	}
+
+	err = models.Users.Insert(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			return nil, fmt.Errorf("a user with the email address %q already exists", email)
+		default:
+			return nil, err
+		}
+	}
+
+	err = models.Permissions.AddForUser(user.ID, adminPermissions...)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
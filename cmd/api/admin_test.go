@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jseow5177/greenlight/internal/data"
+)
+
+func TestCreateAdmin(t *testing.T) {
+	app := newTestApplicationWithDB(t)
+
+	created, err := createAdmin(app.models, "Admin", "admin@example.com", "correct-horse-battery-staple", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := app.models.Users.GetByEmail("admin@example.com")
+	if err != nil {
+		t.Fatalf("the admin user wasn't stored: %v", err)
+	}
+	if user.ID != created.ID || !user.Activated {
+		t.Errorf("got user %d, activated %t; want %d, true", user.ID, user.Activated, created.ID)
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, code := range adminPermissions {
+		if !permissions.Include(code) {
+			t.Errorf("the admin user is missing the %s permission", code)
+		}
+	}
+
+	_, err = createAdmin(app.models, "Admin", "admin@example.com", "correct-horse-battery-staple", 0)
+	if err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("second admin with the same email: got error %v; want an already exists error", err)
+	}
+}
+
+func TestCreateAdminInvalid(t *testing.T) {
+	// The user is validated before anything is stored, so no database is needed.
+	_, err := createAdmin(data.Models{}, "Admin", "not-an-email", "correct-horse-battery-staple", 0)
+	if err == nil || !strings.Contains(err.Error(), "email") {
+		t.Errorf("got error %v; want an invalid email error", err)
+	}
+}
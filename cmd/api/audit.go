@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/jseow5177/greenlight/internal/data"
+	"github.com/jseow5177/greenlight/internal/validator"
+)
+
+// recordAudit() adds an entry to the audit log for a change made by the current user.
+// The states are encoded straight away, so that later changes to them don't affect the entry. Writing the entry
+// is best-effort: it happens in a background routine and any error is logged, so it never blocks or fails
+// the change itself. Pass nil for the before state of a created record, or the after state of a deleted one.
+func (app *application) recordAudit(r *http.Request, action, entity string, entityID int64, before, after interface{}) {
+	user := app.contextGetUser(r)
+
+	beforeJSON, err := auditJSON(before)
+	if err != nil {
+		app.logError(r, err)
+		return
+	}
+
+	afterJSON, err := auditJSON(after)
+	if err != nil {
+		app.logError(r, err)
+		return
+	}
+
+	// The models are looked up before the routine starts, so that the query is logged with the trace ID.
+	models := app.modelsFor(r)
+
+	app.runBackground(func() {
+		err := models.Audit.Record(user.ID, action, entity, entityID, beforeJSON, afterJSON)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{
+				"action": action,
+				"entity": entity,
+			})
+		}
+	})
+}
+
+// auditJSON() encodes a record state for the audit log, or returns nil if there is no state.
+func auditJSON(state interface{}) (json.RawMessage, error) {
+	if state == nil {
+		return nil, nil
+	}
+	return json.Marshal(state)
+}
+
+// Add a listAuditHandler for "GET /v1/audit"
+func (app *application) listAuditHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	// Show the most recent entries first by default
+	input.Filters.Sort = app.readString(qs, "sort", "-id")
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", app.config.defaultPageSize, v)
+	input.Filters.MaxPageSize = app.config.maxPageSize
+	input.Filters.MaxOffset = app.config.maxOffset
+
+	input.Filters = input.Filters.WithSortSafeList("id", "-id")
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	entries, metadata, err := app.modelsFor(r).Audit.GetAll(input.Filters)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInvalidSort):
+			v.AddError("sort", "invalid sort value")
+			app.failedValidationResponse(w, r, v)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"audit": entries, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
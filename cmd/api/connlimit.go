@@ -0,0 +1,137 @@
+package main
+
+import (
+	"net"
+	"sync"
+)
+
+// connLimitListener wraps a net.Listener to bound the number of connections which are open at the same time,
+// like golang.org/x/net/netutil.LimitListener, and optionally the number of connections from each IP address.
+// Once the total limit is reached, Accept() waits for a connection to close, so that new connections queue in
+// the listen backlog. A connection beyond the per-IP limit is closed straight away instead, so that a single
+// client can't take up all of the connections.
+type connLimitListener struct {
+	net.Listener
+	sem   chan struct{} // Holds a value for each open connection. Nil if the total isn't limited.
+	perIP int           // Maximum number of connections from one IP address. 0 if it isn't limited.
+
+	mu    sync.Mutex
+	conns map[string]int // Number of open connections by IP address
+
+	closeOnce sync.Once
+	done      chan struct{} // Closed by Close(), to stop Accept() from waiting for a connection to close
+}
+
+// limitListener() returns the listener wrapped to allow at most total connections at the same time, and at most
+// perIP connections from each IP address. A limit of 0 disables it. The listener is returned unchanged if neither
+// limit is set.
+func limitListener(l net.Listener, total, perIP int) net.Listener {
+	if total <= 0 && perIP <= 0 {
+		return l
+	}
+
+	ll := &connLimitListener{
+		Listener: l,
+		perIP:    perIP,
+		conns:    make(map[string]int),
+		done:     make(chan struct{}),
+	}
+
+	if total > 0 {
+		ll.sem = make(chan struct{}, total)
+	}
+
+	return ll
+}
+
+func (l *connLimitListener) Accept() (net.Conn, error) {
+	for {
+		if l.sem != nil {
+			select {
+			case l.sem <- struct{}{}:
+			case <-l.done:
+				return nil, net.ErrClosed
+			}
+		}
+
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			l.releaseSlot()
+			return nil, err
+		}
+
+		ip, ok := l.acquireIP(conn)
+		if !ok {
+			metrics.Add("connections_rejected", 1)
+			conn.Close()
+			l.releaseSlot()
+			continue
+		}
+
+		return &limitedConn{Conn: conn, release: func() { l.release(ip) }}, nil
+	}
+}
+
+func (l *connLimitListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+// acquireIP() counts a new connection against the limit of its IP address, and reports false if the limit has
+// already been reached. Connections without an IP address, such as on a Unix socket, aren't limited per IP.
+func (l *connLimitListener) acquireIP(conn net.Conn) (string, bool) {
+	if l.perIP <= 0 {
+		return "", true
+	}
+
+	addr, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return "", true
+	}
+
+	ip := addr.IP.String()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conns[ip] >= l.perIP {
+		return "", false
+	}
+
+	l.conns[ip]++
+	return ip, true
+}
+
+// release() gives back the slots of a closed connection.
+func (l *connLimitListener) release(ip string) {
+	if ip != "" {
+		l.mu.Lock()
+		l.conns[ip]--
+		if l.conns[ip] <= 0 {
+			delete(l.conns, ip)
+		}
+		l.mu.Unlock()
+	}
+
+	l.releaseSlot()
+}
+
+func (l *connLimitListener) releaseSlot() {
+	if l.sem != nil {
+		<-l.sem
+	}
+}
+
+// limitedConn releases its slots in the listener when it is closed. The http.Server can close a connection
+// more than once, so the slots are only released the first time.
+type limitedConn struct {
+	net.Conn
+	releaseOnce sync.Once
+	release     func()
+}
+
+func (c *limitedConn) Close() error {
+	err := c.Conn.Close()
+	c.releaseOnce.Do(c.release)
+	return err
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// acceptAll() accepts the connections of the listener in the background, and sends them on the returned channel.
+func acceptAll(l net.Listener) <-chan net.Conn {
+	conns := make(chan net.Conn, 10)
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conns <- conn
+		}
+	}()
+
+	return conns
+}
+
+// dial() opens a TCP connection to the listener, and closes it at the end of the test.
+func dial(t *testing.T, l net.Listener) net.Conn {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+func newLimitListener(t *testing.T, total, perIP int) net.Listener {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ll := limitListener(l, total, perIP)
+	t.Cleanup(func() { ll.Close() })
+
+	return ll
+}
+
+func TestLimitListenerNoLimit(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	if got := limitListener(l, 0, 0); got != l {
+		t.Errorf("got listener %T; want the listener unchanged", got)
+	}
+}
+
+func TestLimitListenerTotal(t *testing.T) {
+	l := newLimitListener(t, 2, 0)
+	conns := acceptAll(l)
+
+	for i := 0; i < 3; i++ {
+		dial(t, l)
+	}
+
+	// Only 2 connections are accepted, and the third one waits in the listen backlog.
+	var accepted []net.Conn
+	for i := 0; i < 2; i++ {
+		select {
+		case conn := <-conns:
+			accepted = append(accepted, conn)
+		case <-time.After(time.Second):
+			t.Fatalf("only %d connections were accepted; want 2", i)
+		}
+	}
+
+	select {
+	case <-conns:
+		t.Fatal("a third connection was accepted beyond the limit")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Closing a connection frees a slot for the waiting one. Closing it twice doesn't free another.
+	accepted[0].Close()
+	accepted[0].Close()
+
+	select {
+	case conn := <-conns:
+		defer conn.Close()
+	case <-time.After(time.Second):
+		t.Fatal("the waiting connection wasn't accepted once a slot was freed")
+	}
+
+	dial(t, l)
+	select {
+	case <-conns:
+		t.Fatal("a connection was accepted beyond the limit after a double close")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestLimitListenerPerIP(t *testing.T) {
+	l := newLimitListener(t, 0, 1)
+	conns := acceptAll(l)
+
+	rejected := metricValue("connections_rejected")
+
+	dial(t, l)
+	select {
+	case conn := <-conns:
+		defer conn.Close()
+	case <-time.After(time.Second):
+		t.Fatal("the first connection wasn't accepted")
+	}
+
+	// The second connection from the same IP address is closed straight away.
+	second := dial(t, l)
+	second.SetReadDeadline(time.Now().Add(time.Second))
+	_, err := second.Read(make([]byte, 1))
+	// The server may close the connection gracefully (EOF) or reset it, but it mustn't leave it open.
+	var netErr net.Error
+	if err == nil || (errors.As(err, &netErr) && netErr.Timeout()) {
+		t.Errorf("got error %v reading the second connection; want it closed by the server", err)
+	}
+
+	select {
+	case <-conns:
+		t.Error("a second connection from the same IP address was accepted")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if got := metricValue("connections_rejected") - rejected; got != 1 {
+		t.Errorf("got %d rejected connections; want 1", got)
+	}
+}
+
+func TestLimitListenerCloseStopsAccept(t *testing.T) {
+	l := newLimitListener(t, 1, 0)
+	conns := acceptAll(l)
+
+	dial(t, l)
+	select {
+	case conn := <-conns:
+		defer conn.Close()
+	case <-time.After(time.Second):
+		t.Fatal("the first connection wasn't accepted")
+	}
+
+	// Accept() is now waiting for a slot, and returns once the listener is closed.
+	errs := make(chan error, 1)
+	go func() {
+		_, err := l.Accept()
+		errs <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	l.Close()
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Errorf("got error %v; want %v", err, net.ErrClosed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Accept() didn't return after Close()")
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/jseow5177/greenlight/internal/data"
+	"github.com/jseow5177/greenlight/internal/jsonlog"
+)
+
+// Define a custom contextKey type, with the underlying type string.
+// Using a custom type avoids collisions with context keys set by other packages.
+type contextKey string
+
+// Convert the string "user" to a contextKey type and assign it to the userContextKey constant.
+// We use this constant as the key for getting and setting user information in the request context.
+const userContextKey = contextKey("user")
+
+// traceIDContextKey is the key of the trace ID of the request, set by the trace() middleware.
+const traceIDContextKey = contextKey("trace_id")
+
+// permissionsContextKey is the key of the permissions of the user, once they have been loaded for the request.
+const permissionsContextKey = contextKey("permissions")
+
+// contextSetUser() returns a new copy of the request with the provided User struct added to the context.
+func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	ctx := context.WithValue(r.Context(), userContextKey, user)
+	return r.WithContext(ctx)
+}
+
+// contextGetUser() retrieves the User struct from the request context.
+// The only time this helper is used is when we logically expect there to be a User struct value in the context.
+// If it doesn't exist, it will firmly be an 'unexpected' error, so we panic.
+func (app *application) contextGetUser(r *http.Request) *data.User {
+	user, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		panic("missing user value in request context")
+	}
+
+	return user
+}
+
+// contextSetTraceID() returns a new copy of the request with the trace ID added to the context.
+func (app *application) contextSetTraceID(r *http.Request, traceID string) *http.Request {
+	ctx := context.WithValue(r.Context(), traceIDContextKey, traceID)
+	return r.WithContext(ctx)
+}
+
+// contextGetTraceID() retrieves the trace ID from the request context. Unlike the user, it is not an error for it
+// to be missing, e.g. for a request which didn't go through the middleware chain, so an empty string is returned.
+func (app *application) contextGetTraceID(r *http.Request) string {
+	traceID, _ := r.Context().Value(traceIDContextKey).(string)
+	return traceID
+}
+
+// modelsFor() returns the models to use for the queries of a request. With DEBUG logging or a slow query threshold,
+// their queries are logged with the trace ID of the request, so that the log entries of a request can be found
+// together.
+func (app *application) modelsFor(r *http.Request) data.Models {
+	if !app.logger.Enabled(jsonlog.LevelDebug) && app.config.db.slowQuery <= 0 {
+		return app.models
+	}
+
+	return app.models.WithQueryLog(queryLogger(app.logger, app.config.db.slowQuery, app.contextGetTraceID(r)))
+}
+
+// contextSetPermissions() returns a new copy of the request with the permissions of the user added to the context,
+// so that they are only loaded once per request.
+func (app *application) contextSetPermissions(r *http.Request, permissions data.Permissions) *http.Request {
+	ctx := context.WithValue(r.Context(), permissionsContextKey, permissions)
+	return r.WithContext(ctx)
+}
+
+// contextGetPermissions() retrieves the permissions of the user from the request context, and reports whether
+// they have been loaded.
+func (app *application) contextGetPermissions(r *http.Request) (data.Permissions, bool) {
+	permissions, ok := r.Context().Value(permissionsContextKey).(data.Permissions)
+	return permissions, ok
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jseow5177/greenlight/internal/data"
+	"github.com/jseow5177/greenlight/internal/validator"
+)
+
+// emailPreviewData holds the sample data which each email template is rendered with by the preview endpoint.
+// Add an entry here when a new template is added, with the same type of data that the template is sent with.
+var emailPreviewData = map[string]interface{}{
+	"user_welcome.html": &data.User{
+		ID:        123,
+		CreatedAt: time.Date(2021, time.January, 1, 12, 0, 0, 0, time.UTC),
+		Name:      "Alice Smith",
+		Email:     "alice@example.com",
+		Activated: true,
+	},
+}
+
+// Add an emailPreviewHandler for "GET /v1/dev/email-preview"
+// It renders an email template with sample data and returns the subject, plain-text body and HTML body, so
+// that developers can iterate on the templates without sending any email. It is only registered with -email-preview.
+func (app *application) emailPreviewHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	templateFile := app.readString(r.URL.Query(), "template", "")
+
+	templates := make([]string, 0, len(emailPreviewData))
+	for name := range emailPreviewData {
+		templates = append(templates, name)
+	}
+	sort.Strings(templates)
+
+	v.Check(templateFile != "", "template", "must be provided")
+	if templateFile != "" {
+		v.Checkf(validator.In(templateFile, templates...), "template", "must be one of %s", strings.Join(templates, ", "))
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	email, err := app.mailer.Render(templateFile, emailPreviewData[templateFile])
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"email": email}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
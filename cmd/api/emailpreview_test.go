@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestEmailPreviewRoute(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     string
+		preview bool
+		want    int
+	}{
+		{"development without the flag", "development", false, http.StatusNotFound},
+		{"production without the flag", "production", false, http.StatusNotFound},
+		{"with the flag", "staging", true, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			app.config.env = tt.env
+			app.config.emailPreview = tt.preview
+
+			rr := do(t, app.routes(), http.MethodGet, "/v1/dev/email-preview?template=user_welcome.html", "", nil)
+			if rr.Code != tt.want {
+				t.Errorf("got status %d; want %d: %s", rr.Code, tt.want, rr.Body)
+			}
+		})
+	}
+}
+
+func TestEmailPreviewTemplates(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.emailPreview = true
+	h := app.routes()
+
+	// Every template with sample data must render.
+	for name := range emailPreviewData {
+		rr := do(t, h, http.MethodGet, "/v1/dev/email-preview?template="+name, "", nil)
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: got status %d; want %d: %s", name, rr.Code, http.StatusOK, rr.Body)
+		}
+	}
+
+	rr := do(t, h, http.MethodGet, "/v1/dev/email-preview?template=missing.html", "", nil)
+	if rr.Code == http.StatusOK {
+		t.Errorf("missing template: got status %d", rr.Code)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jseow5177/greenlight/internal/mailer"
+)
+
+// emailJob is an email waiting in the queue to be sent by a worker.
+type emailJob struct {
+	recipient    string
+	templateFile string
+	data         interface{}
+}
+
+// startEmailWorkers() starts n background routines which send the emails from the queue. The workers are
+// tracked by the WaitGroup, and stop once the queue has been closed on shutdown and every queued email has
+// been sent.
+func (app *application) startEmailWorkers(n int) {
+	for i := 0; i < n; i++ {
+		app.runBackground(func() {
+			for job := range app.emails {
+				app.sendEmail(job)
+			}
+		})
+	}
+}
+
+// enqueueEmail() adds an email to the queue without blocking, as long as the queue has space. If the queue
+// is full, because the workers have fallen behind, it waits up to -smtp-queue-timeout for space, and then
+// sends the email itself, in the calling goroutine. The request is slowed down, but the email is never dropped,
+// unless the queue has already been closed on shutdown, e.g. by a handler which outlived a failed graceful shutdown.
+func (app *application) enqueueEmail(job emailJob) {
+	// Hold the read lock while sending to the queue, so that closeEmails() can't close it in the meantime.
+	app.emailsMu.RLock()
+
+	if app.emailsClosed {
+		app.emailsMu.RUnlock()
+		app.logger.PrintWarn("email queue closed, dropping email", map[string]string{
+			"template": job.templateFile,
+		})
+		return
+	}
+
+	queued := app.queueEmail(job)
+	app.emailsMu.RUnlock()
+
+	if queued {
+		return
+	}
+
+	app.logger.PrintWarn("email queue full, sending email inline", map[string]string{
+		"queue_size": strconv.Itoa(cap(app.emails)),
+		"timeout":    app.config.smtp.queueTimeout.String(),
+	})
+
+	app.sendEmail(job)
+}
+
+// queueEmail() adds an email to the queue, waiting up to -smtp-queue-timeout for space, and reports whether it
+// was added. The caller must hold the read lock of emailsMu.
+func (app *application) queueEmail(job emailJob) bool {
+	select {
+	case app.emails <- job:
+		return true
+	default:
+	}
+
+	timer := time.NewTimer(app.config.smtp.queueTimeout)
+	defer timer.Stop()
+
+	select {
+	case app.emails <- job:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// closeEmails() closes the email queue, so that the workers stop once they have sent the remaining emails. Emails
+// enqueued afterwards are dropped, instead of panicking on the closed channel. It is safe to call more than once.
+func (app *application) closeEmails() {
+	app.emailsMu.Lock()
+	defer app.emailsMu.Unlock()
+
+	if !app.emailsClosed {
+		app.emailsClosed = true
+		close(app.emails)
+	}
+}
+
+// sendEmail() sends an email and logs any error. A panic is recovered and logged too, so that it doesn't
+// stop the worker which is sending the email.
+func (app *application) sendEmail(job emailJob) {
+	defer func() {
+		if err := recover(); err != nil {
+			app.logger.PrintError(fmt.Errorf("%s", err), nil)
+		}
+	}()
+
+	// The email is sent in the background, after the request has completed, so it gets a deadline of its own
+	// rather than the request's context.
+	ctx, cancel := context.WithTimeout(context.Background(), app.config.smtp.sendTimeout)
+	defer cancel()
+
+	err := app.mailer.SendContext(ctx, job.recipient, job.templateFile, job.data)
+	if err != nil {
+		switch {
+		// A recipient who has reached the daily limit is skipped on purpose, so only log it at INFO level.
+		case errors.Is(err, mailer.ErrDailyLimitExceeded):
+			app.logger.PrintInfo("email suppressed, recipient daily limit reached", map[string]string{
+				"recipient": job.recipient,
+			})
+		default:
+			app.logger.PrintError(err, nil)
+		}
+	}
+}
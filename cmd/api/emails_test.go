@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jseow5177/greenlight/internal/data"
+	"github.com/jseow5177/greenlight/internal/mailer"
+)
+
+func TestEnqueueEmailSendsInlineWhenQueueIsFull(t *testing.T) {
+	host, port, recipients := newTestSMTPServer(t)
+
+	app := newTestApplication(t)
+	app.config.smtp.queueTimeout = 10 * time.Millisecond
+	app.config.smtp.sendTimeout = 5 * time.Second
+	app.emails = make(chan emailJob, 1)
+
+	m, err := mailer.New(host, port, "", "", "Greenlight <no-reply@greenlight.test>", mailer.TLSModeNone, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.mailer = m
+
+	// No worker is running, so the first email fills the queue and the second one has to be sent inline.
+	user := &data.User{ID: 1, Name: "Alice"}
+	app.enqueueEmail(emailJob{recipient: "queued@example.com", templateFile: "user_welcome.html", data: user})
+	app.enqueueEmail(emailJob{recipient: "inline@example.com", templateFile: "user_welcome.html", data: user})
+
+	select {
+	case recipient := <-recipients:
+		if recipient != "inline@example.com" {
+			t.Errorf("got an email for %q; want one for %q", recipient, "inline@example.com")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the email wasn't sent inline")
+	}
+
+	if len(app.emails) != 1 {
+		t.Errorf("got %d queued emails; want 1", len(app.emails))
+	}
+}
+
+func TestEnqueueEmailAfterClose(t *testing.T) {
+	app := newTestApplication(t)
+
+	app.closeEmails()
+	// A second call must not panic on the closed channel either.
+	app.closeEmails()
+
+	// Neither must an email enqueued by a handler which outlived the shutdown. It is dropped.
+	app.enqueueEmail(emailJob{recipient: "late@example.com", templateFile: "user_welcome.html"})
+
+	if _, ok := <-app.emails; ok {
+		t.Error("an email was queued after the queue was closed")
+	}
+}
+
+func TestMailerDailyLimit(t *testing.T) {
+	host, port, recipients := newTestSMTPServer(t)
+
+	m, err := mailer.New(host, port, "", "", "Greenlight <no-reply@greenlight.test>", mailer.TLSModeNone, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m = m.WithDailyLimit(2)
+
+	user := &data.User{ID: 1, Name: "Alice"}
+
+	sends := []struct {
+		recipient string
+		want      error
+	}{
+		{"alice@example.com", nil},
+		{"Alice@Example.com", nil}, // The same inbox
+		{"alice@example.com", mailer.ErrDailyLimitExceeded},
+		{"bob@example.com", nil},
+		{"alice@example.com", mailer.ErrDailyLimitExceeded},
+	}
+
+	for i, send := range sends {
+		err := m.Send(send.recipient, "user_welcome.html", user)
+		if !errors.Is(err, send.want) {
+			t.Errorf("send %d to %s: got error %v; want %v", i+1, send.recipient, err, send.want)
+		}
+	}
+
+	var got []string
+	for len(got) < 3 {
+		select {
+		case recipient := <-recipients:
+			got = append(got, strings.ToLower(recipient))
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got emails for %v; want 3", got)
+		}
+	}
+
+	want := []string{"alice@example.com", "alice@example.com", "bob@example.com"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got emails for %v; want %v", got, want)
+	}
+
+	select {
+	case recipient := <-recipients:
+		t.Errorf("got an email for %s beyond the limit", recipient)
+	default:
+	}
+}
@@ -1,8 +1,16 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jseow5177/greenlight/internal/i18n"
+	"github.com/jseow5177/greenlight/internal/validator"
 )
 
 // logError() is a generic helper for logging an error message.
@@ -11,6 +19,7 @@ func (app *application) logError(r *http.Request, err error) {
 	app.logger.PrintError(err, map[string]string{
 		"method": r.Method,
 		"url": r.URL.String(),
+		"trace_id": app.contextGetTraceID(r),
 	})
 }
 
@@ -18,25 +27,107 @@ func (app *application) logError(r *http.Request, err error) {
 // messages to the client with a given status code. 
 // The message has an interface{} type instead of string type to give more flexibility
 // over the values that can be included in the response.
+// A string message is translated to the language negotiated from the Accept-Language header, if the catalog
+// has a translation for it.
 func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message interface{}) {
+	locale := app.locale(w, r)
+
+	if text, ok := message.(string); ok {
+		message = i18n.Translate(locale, text)
+	}
+
 	env := envelope{"error": message}
 
 	// Write the response using the writeJSON() helper.
-	// If an error is returned, an empty response is sent with a 500 Server Internal Error status code.
+	// writeJSON() only fails if the envelope can't be marshaled, before anything has been written, so the
+	// status code is still unset and the plain-text fallback can be sent instead.
 	err := app.writeJSON(w, status, env, nil)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		app.logError(r, err)
+		writePlainServerError(w)
 	}
 }
 
+// writePlainServerError() is the last resort when an error response can't be encoded as JSON. It sends a fixed
+// plain-text message with a 500 Internal Server Error status code, which can't fail to encode.
+func writePlainServerError(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusInternalServerError)
+	w.Write([]byte(serverErrorMessage + "\n"))
+}
+
+// locale() negotiates the language of the error messages from the Accept-Language header.
+// The response varies with the header, and its language is announced in the Content-Language header.
+func (app *application) locale(w http.ResponseWriter, r *http.Request) string {
+	locale := i18n.Negotiate(r.Header.Get("Accept-Language"))
+
+	addVary(w, "Accept-Language")
+	w.Header().Set("Content-Language", locale)
+
+	return locale
+}
+
 // serverErrorResponse() is used when the application encounters unexpected problem at runtime.
 // It logs the detailed error message and uses the errorResponse() helper to send a 500 status code and JSON
 // response to the client.
 func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
 	app.logError(r, err)
 
-	message := "the server encountered a problem and could not process your request"
-	app.errorResponse(w, r, http.StatusInternalServerError, message)
+	// Running out of database connections is a temporary overload rather than a bug, so tell the client
+	// to try again later instead.
+	if app.dbPoolExhausted(err) {
+		app.serviceUnavailableResponse(w, r)
+		return
+	}
+
+	app.errorResponse(w, r, http.StatusInternalServerError, serverErrorMessage)
+}
+
+// serverErrorMessage is sent to the client for unexpected errors, without any details of what went wrong.
+const serverErrorMessage = "the server encountered a problem and could not process your request"
+
+// panicResponse() method is used when a handler panics. The recovered value is logged with its type
+// and formatted value as properties, instead of being flattened into an error, and a 500 Internal Server
+// Error is sent. The log entry is written while the panic is being recovered, so its trace includes the
+// stack of the goroutine at the point of the panic.
+func (app *application) panicResponse(w http.ResponseWriter, r *http.Request, recovered interface{}) {
+	app.logger.PrintError(fmt.Errorf("panic: %v", recovered), map[string]string{
+		"method":      r.Method,
+		"url":         r.URL.String(),
+		"panic_type":  fmt.Sprintf("%T", recovered),
+		"panic_value": fmt.Sprintf("%v", recovered),
+		"trace_id":    app.contextGetTraceID(r),
+	})
+
+	app.errorResponse(w, r, http.StatusInternalServerError, serverErrorMessage)
+}
+
+// retryAfterSeconds is the number of seconds a client is asked to wait before retrying an overloaded request.
+const retryAfterSeconds = 5
+
+// dbPoolExhausted() reports whether the error comes from a query which timed out waiting for a free connection.
+// database/sql returns context.DeadlineExceeded when the context expires before a connection becomes available.
+// The pool statistics are checked too, so that a slow query on an otherwise idle pool is still a 500.
+func (app *application) dbPoolExhausted(err error) bool {
+	if app.db == nil || !errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	stats := app.db.Stats()
+
+	return stats.Idle == 0 && stats.MaxOpenConnections > 0 && stats.InUse >= stats.MaxOpenConnections
+}
+
+// serviceUnavailableResponse() is used to send a 503 Service Unavailable status code and JSON response to the client,
+// with a Retry-After header telling the client when to try again.
+func (app *application) serviceUnavailableResponse(w http.ResponseWriter, r *http.Request) {
+	metrics.Add("db_pool_exhausted", 1)
+
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds))
+
+	message := "the server is temporarily overloaded, please try again later"
+	app.errorResponse(w, r, http.StatusServiceUnavailable, message)
 }
 
 // notFoundResponse() is used to send a 404 Not Found status code and JSON response to the client.
@@ -47,20 +138,45 @@ func (app *application) notFoundResponse(w http.ResponseWriter, r *http.Request)
 
 // methodNotAllowedResponse() is used to send a 405 Method Not Allowed status code and JSON response to the client.
 func (app *application) methodNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
-	message := fmt.Sprintf("the %s method is not supported for this resource", r.Method)
+	message := i18n.Translate(app.locale(w, r), "the %s method is not supported for this resource", r.Method)
 	app.errorResponse(w, r, http.StatusMethodNotAllowed, message)
 }
 
 // badRequestResponse() is used to send a 400 Bad Request status code and JSON response to the client.
 // Deals with syntatic errors
+// A request body which isn't JSON gets a 415 Unsupported Media Type response instead.
 func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Request, err error) {
+	var mediaTypeErr *unsupportedMediaTypeError
+	if errors.As(err, &mediaTypeErr) {
+		app.unsupportedMediaTypeResponse(w, r, mediaTypeErr)
+		return
+	}
+
 	app.errorResponse(w, r, http.StatusBadRequest, err.Error())
 }
 
+// unsupportedMediaTypeResponse() is used to send a 415 Unsupported Media Type status code and JSON response
+// to the client when the request body has the wrong Content-Type.
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.errorResponse(w, r, http.StatusUnsupportedMediaType, err.Error())
+}
+
 // failedValidationResponse() is used to send a 422 Unprocessable Entity status code and JSON response to the client
 // Deals with semantic errors
-func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
-	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
+// By default the errors are sent as a map of field names to messages. With -validation-errors=list they are sent
+// as an array of {field, message} objects instead, in the order the checks failed.
+func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, v *validator.Validator) {
+	locale := app.locale(w, r)
+	v = v.Translate(func(format string, args ...interface{}) string {
+		return i18n.Translate(locale, format, args...)
+	})
+
+	if app.config.validationErrors == "list" {
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, v.OrderedErrors())
+		return
+	}
+
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, v.Errors)
 }
 
 // editConflictResponse() is used to send a 409 Conflict status code and JSON response to the client
@@ -72,7 +188,96 @@ func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Requ
 
 // rateLimitExceededResponse() sends a 429 Too Many Requests JSON response
 // Used to perform rate limiting (control rate of requests)
-func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
+// If the time until the next request is allowed is known, it is sent in the Retry-After header, in seconds
+// rounded up, so that well-behaved clients can back off for exactly as long as needed.
+func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	if retryAfter > 0 {
+		seconds := int64(math.Ceil(retryAfter.Seconds()))
+		w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+	}
+
 	message := "rate limit exceeded"
 	app.errorResponse(w, r, http.StatusTooManyRequests, message)
-}
\ No newline at end of file
+}
+
+// duplicateMovieResponse() sends a 422 Unprocessable Entity JSON response to the client when a movie would have
+// the same title and year as another movie.
+func (app *application) duplicateMovieResponse(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	v.AddError("title", "a movie with this title and year already exists")
+	app.failedValidationResponse(w, r, v)
+}
+
+// uriTooLongResponse() sends a 414 URI Too Long JSON response to the client when the request URI is longer than
+// -max-uri-length.
+func (app *application) uriTooLongResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the request URI is too long"
+	app.errorResponse(w, r, http.StatusRequestURITooLong, message)
+}
+
+// tooManyQueryParamsResponse() sends a 400 Bad Request JSON response to the client when the query string has
+// more parameters than -max-query-params.
+func (app *application) tooManyQueryParamsResponse(w http.ResponseWriter, r *http.Request) {
+	message := i18n.Translate(app.locale(w, r), "the query string must not have more than %d parameters", app.config.maxQueryParams)
+	app.errorResponse(w, r, http.StatusBadRequest, message)
+}
+
+// invalidCredentialsResponse() sends a 401 Unauthorized JSON response when the client provides
+// an unknown email address or a wrong password.
+func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid authentication credentials"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+// invalidAuthenticationTokenResponse() sends a 401 Unauthorized JSON response when the client provides
+// an invalid or expired authentication token.
+// The WWW-Authenticate header reminds the client that a bearer token is expected.
+func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+
+	message := "invalid or missing authentication token"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+// basicAuthRequiredResponse() sends a 401 Unauthorized JSON response when a route protected by basic
+// authentication is requested without the right username and password.
+func (app *application) basicAuthRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="metrics", charset="UTF-8"`)
+
+	message := "invalid authentication credentials"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+// authenticationRequiredResponse() sends a 401 Unauthorized JSON response when an anonymous client
+// accesses a route which requires authentication.
+func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "you must be authenticated to access this resource"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+// notPermittedResponse() is used to send a 403 Forbidden status code and JSON response to the client
+// when the authenticated user doesn't have the permission required by a route.
+func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account doesn't have the necessary permissions to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+// ipForbiddenResponse() sends a 403 Forbidden JSON response to the client when its IP address isn't allowed
+// to reach the route by -ip-allow and -ip-deny.
+func (app *application) ipForbiddenResponse(w http.ResponseWriter, r *http.Request) {
+	message := "access to this resource is not allowed from your IP address"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+// decodeAndValidateErrorResponse() sends the response matching an error returned by decodeAndValidate():
+// a 422 Unprocessable Entity for a semantic (validation) error and a 400 Bad Request for a syntactic one.
+func (app *application) decodeAndValidateErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	var validationErr *failedValidationError
+
+	switch {
+	case errors.As(err, &validationErr):
+		app.failedValidationResponse(w, r, validationErr.v)
+	default:
+		app.badRequestResponse(w, r, err)
+	}
+}
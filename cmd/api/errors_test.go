@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jseow5177/greenlight/internal/data"
+	"github.com/jseow5177/greenlight/internal/validator"
+)
+
+func TestFailedValidationResponseFormats(t *testing.T) {
+	newValidator := func() *validator.Validator {
+		v := validator.New()
+		v.AddError("year", "must be provided")
+		v.AddError("title", "must be provided")
+		v.AddError("runtime", "must be provided")
+		v.AddError("genres", "must be provided")
+		return v
+	}
+
+	t.Run("list", func(t *testing.T) {
+		app := newTestApplication(t)
+		app.config.validationErrors = "list"
+
+		rr := httptest.NewRecorder()
+		app.failedValidationResponse(rr, httptest.NewRequest(http.MethodPost, "/v1/movies", nil), newValidator())
+
+		if rr.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("got status %d; want %d", rr.Code, http.StatusUnprocessableEntity)
+		}
+
+		var body struct {
+			Error []validator.FieldError `json:"error"`
+		}
+		err := json.Unmarshal(rr.Body.Bytes(), &body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var fields []string
+		for _, e := range body.Error {
+			fields = append(fields, e.Field)
+		}
+
+		want := []string{"year", "title", "runtime", "genres"}
+		if !reflect.DeepEqual(fields, want) {
+			t.Errorf("got fields %v; want %v", fields, want)
+		}
+	})
+
+	t.Run("map", func(t *testing.T) {
+		app := newTestApplication(t)
+
+		rr := httptest.NewRecorder()
+		app.failedValidationResponse(rr, httptest.NewRequest(http.MethodPost, "/v1/movies", nil), newValidator())
+
+		var body struct {
+			Error map[string]string `json:"error"`
+		}
+		err := json.Unmarshal(rr.Body.Bytes(), &body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(body.Error) != 4 || body.Error["title"] != "must be provided" {
+			t.Errorf("got errors %v; want a map of the 4 fields", body.Error)
+		}
+	})
+}
+
+// slowConnector is a database/sql connector whose queries never complete: they only return once their context
+// is done.
+type slowConnector struct{}
+
+func (slowConnector) Connect(context.Context) (driver.Conn, error) { return slowConn{}, nil }
+func (slowConnector) Driver() driver.Driver                        { return nil }
+
+type slowConn struct{}
+
+func (slowConn) QueryContext(ctx context.Context, _ string, _ []driver.NamedValue) (driver.Rows, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (slowConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (slowConn) Close() error                        { return nil }
+func (slowConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+// newSlowDBApplication() returns a test application whose database pool has a single connection, and whose
+// queries time out after a short time.
+func newSlowDBApplication(t *testing.T) *application {
+	t.Helper()
+
+	app := newTestApplication(t)
+
+	app.db = sql.OpenDB(slowConnector{})
+	app.db.SetMaxOpenConns(1)
+	t.Cleanup(func() { app.db.Close() })
+
+	app.models = data.NewModels(app.db, 50*time.Millisecond)
+
+	return app
+}
+
+func TestServerErrorResponsePoolExhausted(t *testing.T) {
+	app := newSlowDBApplication(t)
+
+	// Take the only connection of the pool, so that the query times out waiting for one.
+	conn, err := app.db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	rr := do(t, app.routes(), http.MethodGet, "/v1/movies/1", "", nil)
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("got status %d; want %d: %s", rr.Code, http.StatusServiceUnavailable, rr.Body)
+	}
+	if got := rr.Header().Get("Retry-After"); got != "5" {
+		t.Errorf("got Retry-After %q; want \"5\"", got)
+	}
+}
+
+func TestServerErrorResponseSlowQuery(t *testing.T) {
+	app := newSlowDBApplication(t)
+
+	// The query times out while running, rather than waiting for a connection, so it's a genuine server error.
+	rr := do(t, app.routes(), http.MethodGet, "/v1/movies/1", "", nil)
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Fatalf("got status %d; want %d: %s", rr.Code, http.StatusInternalServerError, rr.Body)
+	}
+	if got := rr.Header().Get("Retry-After"); got != "" {
+		t.Errorf("got Retry-After %q; want none", got)
+	}
+}
+
+func TestErrorResponseLocalized(t *testing.T) {
+	app := newTestApplication(t)
+	h := app.routes()
+
+	tests := []struct {
+		name string
+		url  string
+		want map[string]interface{}
+	}{
+		{"not found", "/v1/no-such-route", map[string]interface{}{
+			"en": "the requested resource could not be found",
+			"es": "no se pudo encontrar el recurso solicitado",
+		}},
+		{"validation", "/v1/movies?page_size=0", map[string]interface{}{
+			"en": map[string]interface{}{"page_size": "must be greater than zero"},
+			"es": map[string]interface{}{"page_size": "debe ser mayor que cero"},
+		}},
+	}
+
+	for _, tt := range tests {
+		for _, acceptLanguage := range []string{"en", "es-MX,es;q=0.9"} {
+			r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			r.Header.Set("Accept-Language", acceptLanguage)
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, r)
+
+			locale := rr.Header().Get("Content-Language")
+			if want := acceptLanguage[:2]; locale != want {
+				t.Errorf("%s, %q: got Content-Language %q; want %q", tt.name, acceptLanguage, locale, want)
+			}
+			if !hasVary(rr, "Accept-Language") {
+				t.Errorf("%s, %q: the response doesn't vary with Accept-Language", tt.name, acceptLanguage)
+			}
+
+			var body struct {
+				Error interface{} `json:"error"`
+			}
+			err := json.Unmarshal(rr.Body.Bytes(), &body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want := tt.want[acceptLanguage[:2]]; !reflect.DeepEqual(body.Error, want) {
+				t.Errorf("%s, %q: got error %v; want %v", tt.name, acceptLanguage, body.Error, want)
+			}
+		}
+	}
+}
+
+// headerCounter is a ResponseWriter which counts the calls to WriteHeader().
+type headerCounter struct {
+	*httptest.ResponseRecorder
+	writeHeaders int
+}
+
+func (w *headerCounter) WriteHeader(status int) {
+	w.writeHeaders++
+	w.ResponseRecorder.WriteHeader(status)
+}
+
+func TestErrorResponseMarshalFailure(t *testing.T) {
+	app := newTestApplication(t)
+
+	w := &headerCounter{ResponseRecorder: httptest.NewRecorder()}
+	r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+
+	// A channel can't be encoded as JSON, so the error response falls back to plain text.
+	app.errorResponse(w, r, http.StatusBadRequest, make(chan int))
+
+	if w.writeHeaders != 1 {
+		t.Errorf("got %d calls to WriteHeader(); want 1", w.writeHeaders)
+	}
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d; want %d", w.Code, http.StatusInternalServerError)
+	}
+	if got := w.Header().Get("Content-Type"); got != "text/plain; charset=utf-8" {
+		t.Errorf("got Content-Type %q; want text/plain", got)
+	}
+	if got, want := w.Body.String(), serverErrorMessage+"\n"; got != want {
+		t.Errorf("got body %q; want %q", got, want)
+	}
+}
+
+func TestWriteJSONMarshalFailure(t *testing.T) {
+	app := newTestApplication(t)
+
+	// writeJSON() fails before writing anything, so the caller can still choose the status.
+	w := &headerCounter{ResponseRecorder: httptest.NewRecorder()}
+	err := app.writeJSON(w, http.StatusOK, envelope{"movie": make(chan int)}, nil)
+	if err == nil {
+		t.Fatal("got no error; want a marshal error")
+	}
+
+	if w.writeHeaders != 0 || w.Body.Len() != 0 {
+		t.Errorf("got %d calls to WriteHeader() and body %q; want nothing written", w.writeHeaders, w.Body)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jseow5177/greenlight/internal/data"
+)
+
+// accepts() reports whether the client listed the media type in the Accept header.
+func accepts(r *http.Request, mediaType string) bool {
+	for _, value := range strings.Split(r.Header.Get("Accept"), ",") {
+		accepted := strings.TrimSpace(strings.Split(value, ";")[0])
+		if strings.EqualFold(accepted, mediaType) {
+			return true
+		}
+	}
+	return false
+}
+
+// movieEncoder writes the movies of an export in a specific format.
+type movieEncoder interface {
+	contentType() string
+	// begin() is called once before the first movie, even if there are no movies.
+	begin() error
+	encode(movie *data.Movie) error
+	// flush() writes out anything buffered by the encoder.
+	flush() error
+}
+
+// exportMovies() streams every movie matching the filters with the encoder, without an envelope or pagination
+// metadata. The page and page_size parameters are ignored. The movies are read from the database in batches,
+// and the response is flushed after each batch, so that clients can process (or show the progress of) a large
+// export as it arrives. The X-Total-Records header holds the number of matching movies, counted just before the
+// export starts, so it is only approximate if the movies change during the export.
+func (app *application) exportMovies(w http.ResponseWriter, r *http.Request, title string, genres, tags []string, quality data.QualityFilters, filters data.Filters, enc movieEncoder) {
+	total, err := app.modelsFor(r).Movies.Count(title, genres, tags, quality)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// The headers are only sent with the first movie, so errors before that can still get a proper error response.
+	w.Header().Set("Content-Type", enc.contentType())
+	w.Header().Set("X-Total-Records", strconv.Itoa(total))
+
+	started := false
+
+	start := func() error {
+		if started {
+			return nil
+		}
+		started = true
+		return enc.begin()
+	}
+
+	// Not every http.ResponseWriter supports flushing. Without it, the data is still sent, just less promptly.
+	flusher, _ := w.(http.Flusher)
+
+	err = app.modelsFor(r).Movies.Stream(title, genres, tags, quality, filters, func(movie *data.Movie) error {
+		err := start()
+		if err != nil {
+			return err
+		}
+		return enc.encode(movie)
+	}, func() {
+		if !started {
+			return
+		}
+		if enc.flush() == nil && flusher != nil {
+			flusher.Flush()
+		}
+	})
+	if err == nil {
+		err = start()
+	}
+	if err == nil {
+		err = enc.flush()
+	}
+	if err != nil {
+		if started {
+			// The status code has already been sent, so the error can only be logged.
+			app.logError(r, err)
+			return
+		}
+
+		w.Header().Del("Content-Type")
+		w.Header().Del("X-Total-Records")
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// ndjsonMovieEncoder writes newline-delimited JSON: one standalone JSON object per line.
+type ndjsonMovieEncoder struct {
+	app *application
+	w   http.ResponseWriter
+}
+
+func (e *ndjsonMovieEncoder) contentType() string { return "application/x-ndjson" }
+
+func (e *ndjsonMovieEncoder) begin() error { return nil }
+
+func (e *ndjsonMovieEncoder) encode(movie *data.Movie) error {
+	// Each line is compact, even with -json-pretty, but the keys follow -json-naming.
+	js, err := json.Marshal(movie)
+	if err != nil {
+		return err
+	}
+
+	js, err = e.app.applyNaming(js)
+	if err != nil {
+		return err
+	}
+
+	_, err = e.w.Write(append(js, '\n'))
+	return err
+}
+
+func (e *ndjsonMovieEncoder) flush() error { return nil }
+
+// csvMovieEncoder writes CSV with a header row. The genres and the tags are each joined with semicolons in a
+// single column.
+type csvMovieEncoder struct {
+	w *csv.Writer
+}
+
+func (e *csvMovieEncoder) contentType() string { return "text/csv; charset=utf-8" }
+
+func (e *csvMovieEncoder) begin() error {
+	return e.w.Write([]string{"id", "title", "year", "runtime", "genres", "tags", "version"})
+}
+
+func (e *csvMovieEncoder) encode(movie *data.Movie) error {
+	return e.w.Write([]string{
+		fmt.Sprint(data.MovieIDs.Encode(movie.ID)),
+		csvCell(movie.Title),
+		strconv.Itoa(int(movie.Year)),
+		strconv.Itoa(int(movie.Runtime)),
+		csvCell(strings.Join(movie.Genres, ";")),
+		csvCell(strings.Join(movie.Tags, ";")),
+		strconv.Itoa(int(movie.Version)),
+	})
+}
+
+func (e *csvMovieEncoder) flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// csvCell() neutralises a cell which spreadsheet applications would run as a formula (CSV injection), because it
+// starts with "=", "+", "-" or "@", or with a tab or carriage return, by prefixing it with a single quote. The
+// titles, genres and tags are set by clients, and exports are usually opened in a spreadsheet.
+func csvCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jseow5177/greenlight/internal/data"
+)
+
+func TestCSVMovieEncoder(t *testing.T) {
+	var buf bytes.Buffer
+	enc := &csvMovieEncoder{w: csv.NewWriter(&buf)}
+
+	movies := []*data.Movie{
+		{ID: 1, Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation", "adventure"}, Tags: []string{"disney", "rewatchable"}, Version: 1},
+		{ID: 2, Title: "Deadpool", Year: 2016, Runtime: 108, Genres: []string{"action"}, Version: 3},
+	}
+
+	if err := enc.begin(); err != nil {
+		t.Fatal(err)
+	}
+	for _, movie := range movies {
+		if err := enc.encode(movie); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := enc.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := [][]string{
+		{"id", "title", "year", "runtime", "genres", "tags", "version"},
+		{fmt.Sprint(data.MovieIDs.Encode(1)), "Moana", "2016", "107", "animation;adventure", "disney;rewatchable", "1"},
+		{fmt.Sprint(data.MovieIDs.Encode(2)), "Deadpool", "2016", "108", "action", "", "3"},
+	}
+
+	if len(records) != len(want) {
+		t.Fatalf("got %d records; want %d: %q", len(records), len(want), records)
+	}
+	for i := range want {
+		for j := range want[i] {
+			if records[i][j] != want[i][j] {
+				t.Errorf("record %d, column %q = %q; want %q", i, want[0][j], records[i][j], want[i][j])
+			}
+		}
+	}
+}
+
+func TestCSVCell(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"Moana", "Moana"},
+		{"", ""},
+		{"=HYPERLINK(\"http://evil.example.com\")", "'=HYPERLINK(\"http://evil.example.com\")"},
+		{"+1+1", "'+1+1"},
+		{"-2+3", "'-2+3"},
+		{"@SUM(A1:A2)", "'@SUM(A1:A2)"},
+		{"\t=1", "'\t=1"},
+		{"\r=1", "'\r=1"},
+		{"a=1", "a=1"},
+		{"Mission: Impossible - Fallout", "Mission: Impossible - Fallout"},
+	}
+
+	for _, tt := range tests {
+		if got := csvCell(tt.value); got != tt.want {
+			t.Errorf("csvCell(%q) = %q; want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestCSVMovieEncoderEscapesFormulas(t *testing.T) {
+	var buf bytes.Buffer
+	enc := &csvMovieEncoder{w: csv.NewWriter(&buf)}
+
+	movie := &data.Movie{ID: 1, Title: "=cmd|'/C calc'!A0", Year: 2016, Runtime: 107, Genres: []string{"@drama"}, Tags: []string{"+tag", "ok"}, Version: 1}
+	if err := enc.encode(movie); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, want := range map[int]string{1: "'=cmd|'/C calc'!A0", 4: "'@drama", 5: "'+tag;ok"} {
+		if records[0][i] != want {
+			t.Errorf("column %d = %q; want %q", i, records[0][i], want)
+		}
+	}
+}
+
+func TestNDJSONMovieEncoder(t *testing.T) {
+	rr := httptest.NewRecorder()
+	enc := &ndjsonMovieEncoder{app: newTestApplication(t), w: rr}
+
+	movies := []*data.Movie{
+		{ID: 1, Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation"}, Version: 1},
+		{ID: 2, Title: "Deadpool", Year: 2016, Runtime: 108, Genres: []string{"action"}, Version: 3},
+	}
+
+	if err := enc.begin(); err != nil {
+		t.Fatal(err)
+	}
+	for _, movie := range movies {
+		if err := enc.encode(movie); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := enc.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	titles := ndjsonTitles(t, rr.Body.Bytes())
+	if len(titles) != len(movies) {
+		t.Fatalf("got %d lines; want %d", len(titles), len(movies))
+	}
+	for i, movie := range movies {
+		if titles[i] != movie.Title {
+			t.Errorf("line %d: got title %q; want %q", i+1, titles[i], movie.Title)
+		}
+	}
+}
+
+// ndjsonTitles() checks that every line of the body is a standalone JSON object, and returns their titles.
+func ndjsonTitles(t *testing.T, body []byte) []string {
+	t.Helper()
+
+	var titles []string
+
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		var movie struct {
+			Title string `json:"title"`
+		}
+		err := json.Unmarshal(scanner.Bytes(), &movie)
+		if err != nil {
+			t.Fatalf("line %d isn't valid JSON: %v: %s", len(titles)+1, err, scanner.Bytes())
+		}
+		titles = append(titles, movie.Title)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	return titles
+}
+
+func TestListMoviesNDJSON(t *testing.T) {
+	app := newTestApplicationWithDB(t)
+	h := app.routes()
+
+	_, token := newTestUser(t, app, "editor@example.com", "movies:read", "movies:write")
+	for i := 0; i < 3; i++ {
+		createTestMovie(t, h, token, moanaJSON, nil)
+	}
+
+	// The page size doesn't limit an export.
+	r := httptest.NewRequest(http.MethodGet, "/v1/movies?page_size=1", nil)
+	r.Header.Set("Accept", "application/x-ndjson")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("got Content-Type %q; want %q", ct, "application/x-ndjson")
+	}
+
+	titles := ndjsonTitles(t, rr.Body.Bytes())
+	if len(titles) != 3 {
+		t.Errorf("got %d lines; want 3", len(titles))
+	}
+	if total := rr.Header().Get("X-Total-Records"); total != "3" {
+		t.Errorf("got X-Total-Records %q; want %q", total, "3")
+	}
+}
+
+// unflushableWriter hides the http.Flusher of the response writer it wraps.
+type unflushableWriter struct {
+	http.ResponseWriter
+}
+
+func TestExportMoviesCSV(t *testing.T) {
+	app := newTestApplicationWithDB(t)
+	h := app.routes()
+
+	_, token := newTestUser(t, app, "editor@example.com", "movies:read", "movies:write")
+	for i := 0; i < 3; i++ {
+		createTestMovie(t, h, token, moanaJSON, nil)
+	}
+
+	filters := data.Filters{Sort: "id"}.WithSortSafeList(data.MovieSortSafeList(data.MovieSortColumns)...)
+
+	for _, flushable := range []bool{true, false} {
+		rr := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+
+		var w http.ResponseWriter = rr
+		if !flushable {
+			w = unflushableWriter{rr}
+		}
+
+		app.exportMovies(w, r, "", nil, nil, data.QualityFilters{}, filters, &csvMovieEncoder{w: csv.NewWriter(w)})
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("flushable %t: got status %d; want %d: %s", flushable, rr.Code, http.StatusOK, rr.Body)
+		}
+		if total := rr.Header().Get("X-Total-Records"); total != "3" {
+			t.Errorf("flushable %t: got X-Total-Records %q; want %q", flushable, total, "3")
+		}
+		// The rows are flushed after each batch when the writer supports it.
+		if rr.Flushed != flushable {
+			t.Errorf("flushable %t: got flushed %t", flushable, rr.Flushed)
+		}
+
+		records, err := csv.NewReader(rr.Body).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != 4 {
+			t.Errorf("flushable %t: got %d records; want the header and 3 rows", flushable, len(records))
+		}
+	}
+}
+
+func TestExportMoviesError(t *testing.T) {
+	app := newSlowDBApplication(t)
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+	r.Header.Set("Accept", "text/csv")
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, r)
+
+	// Nothing had been sent yet, so the client gets a proper error response.
+	if rr.Code != http.StatusInternalServerError {
+		t.Fatalf("got status %d; want %d", rr.Code, http.StatusInternalServerError)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("got Content-Type %q; want %q", ct, "application/json")
+	}
+	if total := rr.Header().Get("X-Total-Records"); total != "" {
+		t.Errorf("got X-Total-Records %q; want none", total)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"compress/gzip"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// Content types which are already compressed. They are never compressed again, even if they are
+// included in the configured allowlist.
+var incompressibleTypes = []string{"image/", "video/", "audio/", "application/gzip", "application/zip"}
+
+// gzipResponseWriter wraps a http.ResponseWriter and buffers the start of the response body until either
+// the minimum size is reached or the handler returns. Only then is the decision made whether to compress,
+// because that depends on the size of the body and the Content-Type set by the handler.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	minSize      int
+	contentTypes []string
+	status       int
+	buf          []byte
+	decided      bool
+	gz           *gzip.Writer
+}
+
+func (gw *gzipResponseWriter) WriteHeader(status int) {
+	// Hold on to the status code until we know whether the body will be compressed, as the
+	// Content-Encoding header has to be set before the status code is written.
+	if gw.status == 0 {
+		gw.status = status
+	}
+}
+
+func (gw *gzipResponseWriter) Write(b []byte) (int, error) {
+	if gw.status == 0 {
+		gw.status = http.StatusOK
+	}
+
+	if !gw.decided {
+		gw.buf = append(gw.buf, b...)
+
+		if len(gw.buf) < gw.minSize {
+			return len(b), nil
+		}
+
+		err := gw.decide(true)
+		return len(b), err
+	}
+
+	if gw.gz != nil {
+		return gw.gz.Write(b)
+	}
+
+	return gw.ResponseWriter.Write(b)
+}
+
+// Flush() sends any buffered data to the client, so that streaming handlers keep working.
+func (gw *gzipResponseWriter) Flush() {
+	if !gw.decided {
+		gw.decide(len(gw.buf) >= gw.minSize)
+	}
+
+	if gw.gz != nil {
+		gw.gz.Flush()
+	}
+
+	if flusher, ok := gw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// decide() writes the headers, the status code and the buffered body. The body is compressed if it is
+// large enough and has a compressible content type.
+func (gw *gzipResponseWriter) decide(largeEnough bool) error {
+	gw.decided = true
+
+	if gw.status == 0 {
+		gw.status = http.StatusOK
+	}
+
+	header := gw.Header()
+
+	compress := largeEnough &&
+		header.Get("Content-Encoding") == "" &&
+		gw.status != http.StatusNoContent && gw.status != http.StatusNotModified &&
+		gw.compressible(header.Get("Content-Type"))
+
+	if !compress {
+		metrics.Add("responses_uncompressed", 1)
+
+		gw.ResponseWriter.WriteHeader(gw.status)
+		if len(gw.buf) == 0 {
+			return nil
+		}
+
+		_, err := gw.ResponseWriter.Write(gw.buf)
+		return err
+	}
+
+	metrics.Add("responses_compressed", 1)
+
+	// The length of the compressed body isn't known up front.
+	header.Set("Content-Encoding", "gzip")
+	header.Del("Content-Length")
+
+	gw.ResponseWriter.WriteHeader(gw.status)
+
+	gw.gz = gzip.NewWriter(gw.ResponseWriter)
+	_, err := gw.gz.Write(gw.buf)
+	return err
+}
+
+// compressible() checks whether a content type is in the allowlist and is not already compressed.
+func (gw *gzipResponseWriter) compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for _, prefix := range incompressibleTypes {
+		if strings.HasPrefix(mediaType, prefix) {
+			return false
+		}
+	}
+
+	for _, allowed := range gw.contentTypes {
+		if mediaType == allowed {
+			return true
+		}
+	}
+
+	return false
+}
+
+// close() completes the response once the handler has returned. A body which never reached the minimum
+// size is sent uncompressed.
+func (gw *gzipResponseWriter) close() error {
+	if !gw.decided {
+		if gw.status == 0 {
+			// The handler didn't write anything. Let the http.Server send its default response.
+			return nil
+		}
+
+		err := gw.decide(false)
+		if err != nil {
+			return err
+		}
+	}
+
+	if gw.gz != nil {
+		return gw.gz.Close()
+	}
+
+	return nil
+}
+
+// compress() middleware compresses response bodies for clients which accept gzip encoding.
+// Bodies smaller than the configured minimum size, and content types not in the allowlist, are sent
+// uncompressed. The number of compressed and uncompressed responses is recorded in the metrics map.
+func (app *application) compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.config.gzip.enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// The response depends on the Accept-Encoding header, so tell any caches between us and the client.
+		addVary(w, "Accept-Encoding")
+
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gw := &gzipResponseWriter{
+			ResponseWriter: w,
+			minSize:        app.config.gzip.minSize,
+			contentTypes:   app.config.gzip.contentTypes,
+		}
+
+		next.ServeHTTP(gw, r)
+
+		err := gw.close()
+		if err != nil {
+			app.logError(r, err)
+		}
+	})
+}
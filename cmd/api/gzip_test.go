@@ -0,0 +1,110 @@
+package main
+
+import (
+	"compress/gzip"
+	"expvar"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// metricValue() returns the current value of an integer in the metrics map, or 0 if it hasn't been set.
+func metricValue(name string) int64 {
+	if v, ok := metrics.Get(name).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func TestCompress(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.gzip.enabled = true
+	app.config.gzip.minSize = 100
+	app.config.gzip.contentTypes = []string{"application/json", "text/csv", "image/png"}
+
+	tests := []struct {
+		name        string
+		contentType string
+		size        int
+		compressed  bool
+	}{
+		{"below the threshold", "application/json", 99, false},
+		{"at the threshold", "application/json", 100, true},
+		{"above the threshold", "application/json; charset=utf-8", 1000, true},
+		{"not in the allowlist", "text/plain", 1000, false},
+		{"already compressed", "image/png", 1000, false},
+	}
+
+	for _, tt := range tests {
+		body := strings.Repeat("a", tt.size)
+
+		h := app.compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", tt.contentType)
+			io.WriteString(w, body)
+		}))
+
+		compressedBefore, uncompressedBefore := metricValue("responses_compressed"), metricValue("responses_uncompressed")
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Encoding", "gzip, deflate")
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, r)
+
+		compressed := rr.Header().Get("Content-Encoding") == "gzip"
+		if compressed != tt.compressed {
+			t.Errorf("%s: got compressed %t; want %t", tt.name, compressed, tt.compressed)
+			continue
+		}
+
+		got := rr.Body.String()
+		if compressed {
+			gz, err := gzip.NewReader(rr.Body)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			b, err := io.ReadAll(gz)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			got = string(b)
+		}
+		if got != body {
+			t.Errorf("%s: got a body of %d bytes; want %d", tt.name, len(got), len(body))
+		}
+
+		if !hasVary(rr, "Accept-Encoding") {
+			t.Errorf("%s: got Vary %q; want it to include Accept-Encoding", tt.name, rr.Header().Values("Vary"))
+		}
+
+		compressedDelta := metricValue("responses_compressed") - compressedBefore
+		uncompressedDelta := metricValue("responses_uncompressed") - uncompressedBefore
+		if tt.compressed && (compressedDelta != 1 || uncompressedDelta != 0) ||
+			!tt.compressed && (compressedDelta != 0 || uncompressedDelta != 1) {
+			t.Errorf("%s: the metrics counted %d compressed and %d uncompressed responses", tt.name, compressedDelta, uncompressedDelta)
+		}
+	}
+}
+
+func TestCompressWithoutAcceptEncoding(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.gzip.enabled = true
+	app.config.gzip.minSize = 0
+	app.config.gzip.contentTypes = []string{"application/json"}
+
+	h := app.compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"status": "available"}`)
+	}))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rr.Header().Get("Content-Encoding") != "" {
+		t.Errorf("got Content-Encoding %q; want none", rr.Header().Get("Content-Encoding"))
+	}
+	if rr.Body.String() != `{"status": "available"}` {
+		t.Errorf("got body %q", rr.Body)
+	}
+}
@@ -1,23 +1,190 @@
 package main
 
 import (
+	"context"
 	"net/http"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// Define the health statuses reported by the healthcheck, for the application and for each component.
+const (
+	healthHealthy   = "healthy"   // Every component is working
+	healthDegraded  = "degraded"  // A non-critical component is failing, but requests can still be served
+	healthUnhealthy = "unhealthy" // A critical component is failing
+)
+
+// healthCheckTimeout is the time given to each component check, so that the healthcheck stays responsive
+// even if a component isn't.
+const healthCheckTimeout = time.Second
+
+// cachedCheck wraps a check so that its result is reused until it is older than the interval. Connecting to the
+// SMTP server is slow compared to pinging the database, and providers may throttle clients which connect too
+// often, so it isn't worth doing on every probe. Concurrent callers wait for the check in progress.
+type cachedCheck struct {
+	mu       sync.Mutex
+	check    func(ctx context.Context) error
+	interval time.Duration
+	err      error
+	expires  time.Time
+}
+
+func newCachedCheck(check func(ctx context.Context) error, interval time.Duration) *cachedCheck {
+	return &cachedCheck{check: check, interval: interval}
+}
+
+// run() returns the cached result of the check if it hasn't expired, and runs the check otherwise.
+func (c *cachedCheck) run(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Now().Before(c.expires) {
+		return c.err
+	}
+
+	c.err = c.check(ctx)
+	c.expires = time.Now().Add(c.interval)
+
+	return c.err
+}
+
+// healthCheck is the check of a single component. A failing critical component makes the application
+// unhealthy, while a failing non-critical one only makes it degraded.
+type healthCheck struct {
+	name     string
+	critical bool
+	check    func(ctx context.Context) error
+}
+
+// healthChecks() returns the checks of the components the application depends on: the primary database,
+// the read replica (if there is one) and the SMTP server (cached, see cachedCheck).
+func (app *application) healthChecks() []healthCheck {
+	checks := []healthCheck{
+		{name: "database", critical: true, check: app.db.PingContext},
+	}
+
+	if app.replica != nil {
+		checks = append(checks, healthCheck{name: "replica", check: app.replica.PingContext})
+	}
+
+	checks = append(checks, healthCheck{name: "mailer", check: app.mailerCheck.run})
+
+	return checks
+}
+
+// runHealthChecks() runs the checks concurrently, each with its own timeout, and returns the overall status
+// along with the status of each component.
+func runHealthChecks(ctx context.Context, checks []healthCheck) (string, map[string]string) {
+	results := make([]error, len(checks))
+
+	var wg sync.WaitGroup
+
+	for i, c := range checks {
+		wg.Add(1)
+
+		go func(i int, c healthCheck) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+			defer cancel()
+
+			results[i] = c.check(ctx)
+		}(i, c)
+	}
+
+	wg.Wait()
+
+	status := healthHealthy
+	components := make(map[string]string, len(checks))
+
+	for i, c := range checks {
+		if results[i] == nil {
+			components[c.name] = healthHealthy
+			continue
+		}
+
+		components[c.name] = healthUnhealthy
+
+		switch {
+		case c.critical:
+			status = healthUnhealthy
+		case status == healthHealthy:
+			status = healthDegraded
+		}
+	}
+
+	return status, components
+}
+
 // Declare a handler which writes a JSON response with information about the
 // application status, operating environment and version.
+// The status is "healthy", "degraded" (a non-critical component such as the replica or the SMTP server is
+// failing) or "unhealthy" (the primary database is failing), with the status of each component. An unhealthy
+// application gets a 503 Service Unavailable response. The reasons for failures aren't included, as they can
+// give away details of the infrastructure. The "database" field of earlier versions is kept for the clients
+// which still read it.
+// It also includes a snapshot of the runtime (uptime, goroutines and memory) to help with triage.
 func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Request) {
+	status, components := runHealthChecks(r.Context(), app.healthChecks())
+
+	code := http.StatusOK
+	if status == healthUnhealthy {
+		code = http.StatusServiceUnavailable
+	}
+
+	database := "available"
+	if components["database"] != healthHealthy {
+		database = "unavailable"
+	}
+
+	// runtime.ReadMemStats() populates the MemStats struct with the memory allocator statistics.
+	// Alloc is the number of bytes of allocated heap objects, and Sys is the total number of bytes
+	// of memory obtained from the OS.
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+
 	env := envelope{
-		"status": "available",
-		"system_info": map[string]string {
+		"status":     status,
+		"components": components,
+		"database":   database,
+		"system_info": map[string]string{
 			"environment": app.config.env,
-			"version": version,
+			"version":     version,
+		},
+		"system": map[string]interface{}{
+			"uptime":     time.Since(app.startTime).Round(time.Second).String(),
+			"goroutines": runtime.NumGoroutine(),
+			"memory": map[string]uint64{
+				"alloc": memStats.Alloc,
+				"sys":   memStats.Sys,
+			},
 		},
 	}
 
-	err := app.writeJSON(w, http.StatusOK, env, nil)
+	err := app.writeJSON(w, code, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-}
\ No newline at end of file
+}
+
+// Declare a readiness handler for "GET /v1/readyz". Unlike the healthcheck, which only tells whether the
+// application is alive, it reports whether the application should receive traffic. It starts sending a
+// 503 Service Unavailable as soon as a shutdown signal is received, so that the load balancer stops routing
+// new requests here before the server stops.
+func (app *application) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	status := http.StatusOK
+	env := envelope{"status": "ready"}
+
+	if atomic.LoadInt32(&app.draining) == 1 {
+		status = http.StatusServiceUnavailable
+		env = envelope{"status": "draining"}
+	}
+
+	err := app.writeJSON(w, status, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCachedCheck(t *testing.T) {
+	calls := 0
+	check := func(ctx context.Context) error {
+		calls++
+		return errors.New("unreachable")
+	}
+
+	c := newCachedCheck(check, time.Minute)
+	for i := 0; i < 3; i++ {
+		if err := c.run(context.Background()); err == nil {
+			t.Fatal("got nil error; want the cached error")
+		}
+	}
+	if calls != 1 {
+		t.Errorf("check ran %d times within the interval; want 1", calls)
+	}
+
+	// With no interval, the check runs every time.
+	calls = 0
+	c = newCachedCheck(check, 0)
+	for i := 0; i < 3; i++ {
+		c.run(context.Background())
+	}
+	if calls != 3 {
+		t.Errorf("check ran %d times with no interval; want 3", calls)
+	}
+}
+
+func TestRunHealthChecks(t *testing.T) {
+	ok := func(ctx context.Context) error { return nil }
+	failing := func(ctx context.Context) error { return errors.New("down") }
+
+	tests := []struct {
+		name   string
+		checks []healthCheck
+		want   string
+	}{
+		{"all healthy", []healthCheck{{name: "database", critical: true, check: ok}, {name: "mailer", check: ok}}, healthHealthy},
+		{"non-critical failing", []healthCheck{{name: "database", critical: true, check: ok}, {name: "mailer", check: failing}}, healthDegraded},
+		{"critical failing", []healthCheck{{name: "database", critical: true, check: failing}, {name: "mailer", check: failing}}, healthUnhealthy},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, components := runHealthChecks(context.Background(), tt.checks)
+			if status != tt.want {
+				t.Errorf("got status %q; want %q", status, tt.want)
+			}
+			if len(components) != len(tt.checks) {
+				t.Errorf("got %d components; want %d", len(components), len(tt.checks))
+			}
+		})
+	}
+}
+
+func TestHealthcheckHandler(t *testing.T) {
+	app := newTestApplicationWithDB(t)
+
+	rr := do(t, app.routes(), http.MethodGet, "/v1/healthcheck", "", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d; want %d", rr.Code, http.StatusOK)
+	}
+
+	var body struct {
+		Status     string            `json:"status"`
+		Database   string            `json:"database"`
+		Components map[string]string `json:"components"`
+	}
+	err := json.NewDecoder(rr.Body).Decode(&body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The test application has no SMTP server, so it is degraded, but the database is available.
+	if body.Status != healthDegraded || body.Database != "available" || body.Components["database"] != healthHealthy {
+		t.Errorf("got status %q, database %q, components %v", body.Status, body.Database, body.Components)
+	}
+}
+
+func TestReadinessHandler(t *testing.T) {
+	app := newTestApplication(t)
+	h := app.routes()
+
+	rr := do(t, h, http.MethodGet, "/v1/readyz", "", nil)
+	if rr.Code != http.StatusOK {
+		t.Errorf("before the shutdown: got status %d; want %d", rr.Code, http.StatusOK)
+	}
+
+	// Once a shutdown signal is received, the readiness check fails for the whole drain delay.
+	atomic.StoreInt32(&app.draining, 1)
+
+	rr = do(t, h, http.MethodGet, "/v1/readyz", "", nil)
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("while draining: got status %d; want %d", rr.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestHealthcheckSystemInfo(t *testing.T) {
+	app := newTestApplicationWithDB(t)
+	h := app.routes()
+
+	type system struct {
+		Uptime     string            `json:"uptime"`
+		Goroutines int               `json:"goroutines"`
+		Memory     map[string]uint64 `json:"memory"`
+	}
+
+	uptime := func() time.Duration {
+		t.Helper()
+
+		rr := do(t, h, http.MethodGet, "/v1/healthcheck", "", nil)
+
+		var body struct {
+			System system `json:"system"`
+		}
+		err := json.NewDecoder(rr.Body).Decode(&body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if body.System.Goroutines < 1 || body.System.Memory["alloc"] == 0 || body.System.Memory["sys"] == 0 {
+			t.Errorf("got system %+v; want the goroutines and memory", body.System)
+		}
+
+		d, err := time.ParseDuration(body.System.Uptime)
+		if err != nil {
+			t.Fatalf("uptime %q: %v", body.System.Uptime, err)
+		}
+		return d
+	}
+
+	// The uptime is rounded to the second, so move the start time back rather than sleeping.
+	app.startTime = time.Now().Add(-time.Minute)
+	first := uptime()
+
+	app.startTime = app.startTime.Add(-2 * time.Second)
+	second := uptime()
+
+	if second <= first {
+		t.Errorf("uptime went from %s to %s; want it to increase", first, second)
+	}
+}
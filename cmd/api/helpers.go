@@ -1,24 +1,62 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"math"
+	"mime"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
 
+	"github.com/jseow5177/greenlight/internal/data"
+	"github.com/jseow5177/greenlight/internal/i18n"
 	"github.com/jseow5177/greenlight/internal/validator"
 	"github.com/julienschmidt/httprouter"
 )
 
-const RequestBodyTooLargeMessage = "http: request body too large"
-
 // Define an envelope type
 type envelope map[string]interface{}
 
+// warnings accumulates the problems of a request which partially succeeded, such as a batch request where some
+// of the IDs don't match a movie. Instead of failing the whole request, the handler sends a 200 OK response with
+// the results it has, and the warnings in a "warnings" array alongside them.
+// Like error messages, each warning is kept as a format and its arguments, so that it can be translated.
+type warnings []warning
+
+type warning struct {
+	format string
+	args   []interface{}
+}
+
+// Add() adds a warning, e.g. ws.Add("movie %v not found", id).
+func (ws *warnings) Add(format string, args ...interface{}) {
+	*ws = append(*ws, warning{format: format, args: args})
+}
+
+// withWarnings() adds the warnings to the envelope in the language negotiated for the request.
+// The "warnings" key is only added if there are warnings, so responses which fully succeeded are unchanged.
+func (app *application) withWarnings(w http.ResponseWriter, r *http.Request, env envelope, ws warnings) envelope {
+	if len(ws) == 0 {
+		return env
+	}
+
+	locale := app.locale(w, r)
+
+	messages := make([]string, 0, len(ws))
+	for _, warning := range ws {
+		messages = append(messages, i18n.Translate(locale, warning.format, warning.args...))
+	}
+
+	env["warnings"] = messages
+	return env
+}
+
 // Retrieve the "id" URL parameter from the current request context, then convert it to
 // an integer and return it.
 // The integer is base10 with type int (machine-dependent bit size).
@@ -34,26 +72,134 @@ func (app *application) readIDParam(r *http.Request) (int64, error) {
 	return id, nil
 }
 
+// readIfMatchVersion() reads the expected record version from the If-Match header. The version may be sent
+// as a plain number or as an entity tag (e.g. "3"). It returns 0 if the header is absent or is "*", which
+// means any version matches.
+func (app *application) readIfMatchVersion(r *http.Request) (int32, error) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return 0, nil
+	}
+
+	header = strings.Trim(strings.TrimPrefix(header, "W/"), `"`)
+
+	version, err := strconv.ParseInt(header, 10, 32)
+	if err != nil || version < 1 {
+		return 0, errors.New("If-Match header must contain a valid version number")
+	}
+
+	return int32(version), nil
+}
+
+// ifNoneMatch() reports whether the If-None-Match header matches the entity tag, using the weak comparison
+// (the W/ prefix is ignored), which is the comparison required for If-None-Match. "*" matches any tag.
+func ifNoneMatch(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+
+	etag = strings.TrimPrefix(etag, "W/")
+
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// clientIP() returns the IP address of the client which sent the request. It is used for everything keyed on the
+// client address: rate limiting, the IP filter and the sessions.
+// Behind a proxy, every request comes from the proxy's address, and the proxy appends the address of its client to
+// the X-Forwarded-For header. Clients connecting directly could set the header themselves, so it is only read when
+// the request comes from one of the -trusted-proxies. The header is then read from the end, skipping the other
+// trusted proxies in a chain, and the first address which isn't one of them is the client's. The earlier entries
+// were sent by the client and can't be trusted.
+func (app *application) clientIP(r *http.Request) string {
+	ip := remoteIP(r)
+
+	if len(app.config.trustedProxies) == 0 || !isTrustedProxy(app.config.trustedProxies, ip) {
+		return ip
+	}
+
+	forwarded := r.Header.Values("X-Forwarded-For")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addrs := strings.Split(forwarded[i], ",")
+		for j := len(addrs) - 1; j >= 0; j-- {
+			addr := strings.TrimSpace(addrs[j])
+			if net.ParseIP(addr) == nil {
+				// A malformed entry can't be followed any further, so the last trusted address is used.
+				return ip
+			}
+
+			ip = addr
+			if !isTrustedProxy(app.config.trustedProxies, ip) {
+				return ip
+			}
+		}
+	}
+
+	return ip
+}
+
+// isTrustedProxy() reports whether the IP address is in one of the networks of the trusted proxies.
+func isTrustedProxy(proxies []*net.IPNet, ip string) bool {
+	parsed := net.ParseIP(ip)
+	return parsed != nil && containsIP(proxies, parsed)
+}
+
+// remoteIP() returns the IP address of the client from the request's remote address.
+// Connections over a Unix domain socket have no port (the address is usually empty or "@"), so the remote
+// address is returned unchanged when it can't be split.
+func remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// readMovieIDParam() retrieves the "id" URL parameter and decodes it from the public movie ID (an integer,
+// or a hashid with -public-ids=hashid) to the primary key.
+func (app *application) readMovieIDParam(r *http.Request) (int64, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := data.MovieIDs.Decode(params.ByName("id"))
+	if err != nil {
+		return 0, errors.New("invalid id parameter")
+	}
+
+	return id, nil
+}
+
 // Define a writeJSON() helper for sending JSON responses. This takes the destination
 // http.ResponseWriter, the HTTP status code to send, the data to encode to JSON, and a
 // header map containing any additional HTTP headers we want to include in the response.
+// The data is marshaled before anything is written, and an error is only returned if marshaling fails. The status
+// code is then still unset, so the caller can safely send an error response instead.
 func (app *application) writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
+	return app.writeJSONAs(w, status, "application/json", data, headers)
+}
+
+// writeJSONAs() is like writeJSON(), but sends the JSON with the given media type, such as
+// application/vnd.api+json for JSON:API documents.
+func (app *application) writeJSONAs(w http.ResponseWriter, status int, contentType string, data envelope, headers http.Header) error {
 	// Encode the data to JSON, return error if any.
-	js, err := json.MarshalIndent(data, "", "\t")
+	js, err := app.marshalJSON(data)
 	if err != nil {
 		return err
 	}
 
-	// Append a newline to make it easier to view in terminal applications.
-	js = append(js, '\n')
-
 	// Loop through the header map and add each header to the http.ResponseWriter header map.
 	for key, value := range headers {
 		w.Header()[key] = value
 	}
 
-	// Add the "Content-Type: application/json" header.
-	w.Header().Set("Content-Type", "application/json")
+	// Add the Content-Type header, e.g. "Content-Type: application/json".
+	w.Header().Set("Content-Type", contentType)
 	// Write status code.
 	w.WriteHeader(status)
 	w.Write(js)
@@ -61,17 +207,191 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data envelo
 	return nil
 }
 
+// marshalJSON() encodes the data to JSON, with the keys named according to the -json-naming flag.
+// With the -json-pretty flag the JSON is indented with tabs and a trailing newline is appended, to make it
+// easier to view in terminal applications. Otherwise compact JSON is returned, to save bandwidth.
+func (app *application) marshalJSON(data interface{}) ([]byte, error) {
+	js, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	js, err = app.applyNaming(js)
+	if err != nil {
+		return nil, err
+	}
+
+	if !app.config.jsonPretty {
+		return js, nil
+	}
+
+	var indented bytes.Buffer
+
+	err = json.Indent(&indented, js, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+
+	return append(indented.Bytes(), '\n'), nil
+}
+
+// addVary() adds a header name to the Vary header of the response. Several middleware add to the Vary header,
+// so calling w.Header().Set() would clobber the earlier values. Instead, the names are combined into a single
+// comma-separated header, and a name which is already present (in any letter case) is not added again.
+func addVary(w http.ResponseWriter, value string) {
+	var values []string
+
+	for _, header := range w.Header().Values("Vary") {
+		for _, v := range strings.Split(header, ",") {
+			v = strings.TrimSpace(v)
+			if v == "" {
+				continue
+			}
+			if strings.EqualFold(v, value) {
+				return
+			}
+			values = append(values, v)
+		}
+	}
+
+	values = append(values, value)
+	w.Header().Set("Vary", strings.Join(values, ", "))
+}
+
+// writeJSONStream() is an alternative to writeJSON() for large responses. Instead of marshaling the data into
+// a single byte slice first, it encodes the data directly to the http.ResponseWriter with a json.Encoder,
+// so the whole response is never buffered in memory.
+// The trade-off is that the headers and status code are sent before encoding starts. If encoding fails part way
+// through, the client receives a truncated response and the error can only be logged, not sent to the client.
+func (app *application) writeJSONStream(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
+	// The keys can only be renamed once the whole response has been encoded, so fall back to buffering.
+	if app.config.jsonNaming != namingTags {
+		return app.writeJSON(w, status, data, headers)
+	}
+
+	for key, value := range headers {
+		w.Header()[key] = value
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	// Use the same indentation as writeJSON(). Encode() always appends a newline after the JSON value, which
+	// writeJSON() only sends in pretty mode, so it is dropped from compact responses.
+	var enc *json.Encoder
+	if app.config.jsonPretty {
+		enc = json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+	} else {
+		enc = json.NewEncoder(trimNewlineWriter{w})
+	}
+
+	return enc.Encode(data)
+}
+
+// trimNewlineWriter drops a trailing newline from each write. json.Encoder writes each encoded value, followed by
+// its newline, in a single call to Write().
+type trimNewlineWriter struct {
+	w io.Writer
+}
+
+func (t trimNewlineWriter) Write(p []byte) (int, error) {
+	n, err := t.w.Write(bytes.TrimSuffix(p, []byte("\n")))
+	if err == nil && n < len(p) {
+		n = len(p)
+	}
+	return n, err
+}
+
+// unsupportedMediaTypeError is returned by readJSON() when the request body isn't declared as JSON.
+// badRequestResponse() sends a 415 Unsupported Media Type response for it, instead of a 400 Bad Request.
+type unsupportedMediaTypeError struct {
+	contentType string
+}
+
+func (e *unsupportedMediaTypeError) Error() string {
+	if e.contentType == "" {
+		return "the Content-Type header must be application/json"
+	}
+	return fmt.Sprintf("the Content-Type header must be application/json, not %q", e.contentType)
+}
+
+// bodyTooLargeError is returned when reading a request body limited with limitBody() goes past the limit.
+// http.MaxBytesReader() has no error type of its own (see https://github.com/golang/go/issues/30715), so its
+// error could otherwise only be told apart by its message.
+type bodyTooLargeError struct {
+	limit int64
+}
+
+func (e *bodyTooLargeError) Error() string {
+	return fmt.Sprintf("body must not be larger than %d bytes", e.limit)
+}
+
+// limitedBody is a request body limited by limitBody(). Exceeded is set once the body has gone past the limit,
+// for the callers which only get a wrapped copy of the error, such as the multipart parser.
+type limitedBody struct {
+	io.ReadCloser
+	limit    int64
+	n        int64
+	exceeded bool
+}
+
+// Read() counts the bytes read, and turns the error of http.MaxBytesReader() into a *bodyTooLargeError. It only
+// returns an error once the limit has been read, so any other error is passed on unchanged.
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+
+	if err != nil && err != io.EOF && b.n >= b.limit {
+		b.exceeded = true
+		return n, &bodyTooLargeError{limit: b.limit}
+	}
+
+	return n, err
+}
+
+// limitBody() limits the size of the request body to limit bytes with http.MaxBytesReader(), which also makes
+// the server close the connection once the limit is reached, and returns the limited body.
+func limitBody(w http.ResponseWriter, r *http.Request, limit int64) *limitedBody {
+	body := &limitedBody{ReadCloser: http.MaxBytesReader(w, r.Body, limit), limit: limit}
+	r.Body = body
+	return body
+}
+
 // readJSON() helper reads JSON data in the request body into a destination dst.
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst interface{}) error {
-	// Use http.MaxBytesReader() to limit the size of request body to 1MB.
-	maxBytes := 1_048_576
-	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+	// A create or update request with a Content-Length of 0 can't hold any JSON, so reject it straight away.
+	// Bodies of unknown length (e.g. chunked) are caught by the io.EOF check after decoding instead.
+	if r.ContentLength == 0 && (r.Method == http.MethodPost || r.Method == http.MethodPut || r.Method == http.MethodPatch) {
+		recordRequestBodySize(0)
+		return errors.New("body must not be empty")
+	}
+
+	// Check that the body is declared as JSON, so that a client sending e.g. XML gets a clear error rather
+	// than a confusing JSON syntax error. A missing Content-Type is only accepted without a body
+	// (ContentLength is -1 when the length is unknown, e.g. for a chunked body).
+	contentType := r.Header.Get("Content-Type")
+	if contentType != "" || r.ContentLength != 0 {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || mediaType != "application/json" {
+			return &unsupportedMediaTypeError{contentType: contentType}
+		}
+	}
+
+	// Limit the size of request body to 1MB.
+	limitBody(w, r, 1_048_576)
+
+	// Count the bytes read from the body, and record the size once readJSON() returns. The body is read
+	// to the end when it is valid, so the full size is recorded. For an invalid body, only the bytes read up
+	// to the error are counted.
+	body := &countingReader{r: r.Body}
+	defer func() { recordRequestBodySize(body.n) }()
 
 	// Initialize the json.Decoder, and call the DisallowUnknownFields() method on it before
 	// decoding. This means that if the JSON from the client includes any field that cannot
 	// be mapped to the target destination, the decoder will return an error instead of just
 	// ignoring the field.
-	dec := json.NewDecoder(r.Body)
+	dec := json.NewDecoder(body)
 	dec.DisallowUnknownFields()
 
 	// Decode the request body into the target destination
@@ -80,6 +400,7 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst int
 		var syntaxError *json.SyntaxError
 		var unmarshalTypeError *json.UnmarshalTypeError
 		var invalidUnmarshalError *json.InvalidUnmarshalError
+		var tooLargeError *bodyTooLargeError
 
 		switch {
 		// Catch syntax error with JSON being decoded.
@@ -116,11 +437,10 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst int
 			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
 			return fmt.Errorf("body contains unknown key %s", fieldName)
 
-		// If the request body exceeds 1MB in size, the decode will now fail with the error
-		// "http: request body too large". Currently, the error checking is done with string comparison.
-		// There is an open issue to turn it into a distinct error type: https://github.com/golang/go/issues/30715.
-		case err.Error() == RequestBodyTooLargeMessage:
-			return fmt.Errorf("body must not be larger than %d bytes", maxBytes)
+		// If the request body exceeds 1MB in size, the decode will now fail with a *bodyTooLargeError from
+		// limitBody(), whose message gives the limit.
+		case errors.As(err, &tooLargeError):
+			return tooLargeError
 
 		default:
 			return err
@@ -138,6 +458,68 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst int
 	return nil
 }
 
+// countingReader wraps an io.Reader and counts the bytes read from it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// requestBodyBuckets are the upper bounds, in bytes, of the buckets of the request body size histogram.
+var requestBodyBuckets = []int64{256, 1024, 4096, 16384, 65536, 262144, 1048576}
+
+// recordRequestBodySize() adds the size of a JSON request body to the histogram in the application metrics.
+// Like a Prometheus histogram, the buckets are cumulative: request_body_bytes_le_<n> counts the bodies of at
+// most n bytes. request_body_bytes_count and request_body_bytes_sum hold the number and total size of the bodies.
+func recordRequestBodySize(n int64) {
+	metrics.Add("request_body_bytes_count", 1)
+	metrics.Add("request_body_bytes_sum", n)
+
+	for _, bound := range requestBodyBuckets {
+		if n <= bound {
+			metrics.Add(fmt.Sprintf("request_body_bytes_le_%d", bound), 1)
+		}
+	}
+}
+
+// validatable is implemented by request input structs which know how to validate their own data.
+type validatable interface {
+	Validate(v *validator.Validator)
+}
+
+// failedValidationError is returned by decodeAndValidate() when the request body was decoded successfully,
+// but the data failed validation. It carries the validator holding the validation errors.
+type failedValidationError struct {
+	v *validator.Validator
+}
+
+func (e *failedValidationError) Error() string {
+	return "failed validation"
+}
+
+// decodeAndValidate() helper reads the JSON request body into dst and then validates it in one call.
+// A *failedValidationError is returned if validation fails. Any other error is a problem decoding the
+// request body. Use decodeAndValidateErrorResponse() to send the matching response to the client.
+func (app *application) decodeAndValidate(w http.ResponseWriter, r *http.Request, dst validatable) error {
+	err := app.readJSON(w, r, dst)
+	if err != nil {
+		return err
+	}
+
+	v := validator.New()
+
+	if dst.Validate(v); !v.Valid() {
+		return &failedValidationError{v: v}
+	}
+
+	return nil
+}
+
 // readString() helper returns a string value from the query string, or the provided default value if no matching
 // key could be found
 func (app *application) readString(qs url.Values, key string, defaultValue string) string {
@@ -169,6 +551,71 @@ func (app *application) readCSV(qs url.Values, key string, defaultValue []string
 	return strings.Split(csv, ",")
 }
 
+// readIDs() helper reads a comma-separated list of public IDs from the query string and decodes them to int64 values
+// with the provided PublicIDs.
+// If no matching key is found, it returns nil.
+// If any value is not a valid ID, then we record the error message in the provided Validator instance.
+func (app *application) readIDs(qs url.Values, key string, publicIDs data.PublicIDs, v *validator.Validator) []int64 {
+	csv := app.readCSV(qs, key, nil)
+	if csv == nil {
+		return nil
+	}
+
+	return app.decodeIDs(csv, key, publicIDs, v)
+}
+
+// publicIDList is a JSON array of public IDs in a request body. The IDs may be integers or (with hashid public IDs)
+// strings, so both are accepted and kept as strings, ready to be passed to decodeIDs().
+type publicIDList []string
+
+func (l *publicIDList) UnmarshalJSON(b []byte) error {
+	var values []json.RawMessage
+
+	err := json.Unmarshal(b, &values)
+	if err != nil {
+		return err
+	}
+
+	list := make(publicIDList, 0, len(values))
+
+	for _, value := range values {
+		var s string
+
+		// A quoted value is a string ID, anything else is used as written (e.g. 42).
+		if len(value) > 0 && value[0] == '"' {
+			err := json.Unmarshal(value, &s)
+			if err != nil {
+				return err
+			}
+		} else {
+			s = string(value)
+		}
+
+		list = append(list, s)
+	}
+
+	*l = list
+	return nil
+}
+
+// decodeIDs() helper decodes a list of public IDs to int64 values with the provided PublicIDs.
+// If any value is not a valid ID, then we record the error message in the provided Validator instance.
+func (app *application) decodeIDs(values []string, key string, publicIDs data.PublicIDs, v *validator.Validator) []int64 {
+	ids := make([]int64, 0, len(values))
+
+	for _, s := range values {
+		id, err := publicIDs.Decode(strings.TrimSpace(s))
+		if err != nil {
+			v.AddError(key, "must only contain valid IDs")
+			return nil
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
 // readInt() helper reads a string value from the query string and converts it to an integer before returning.
 // If no matching key is found, it returns the provided default value.
 // If the value could not be converted to an integer, then we record the error message in the provided Validator instance.
@@ -193,6 +640,56 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return i
 }
 
+// readBool() helper reads a string value from the query string and converts it to a bool before returning a pointer
+// to it. If no matching key is found, it returns nil, so that the caller can tell that the parameter wasn't set.
+// If the value could not be converted to a bool, then we record the error message in the provided Validator instance.
+func (app *application) readBool(qs url.Values, key string, v *validator.Validator) *bool {
+	// Extract the value from query string
+	s := qs.Get(key)
+
+	// If no key exists (or the value is empty), return nil
+	if s == "" {
+		return nil
+	}
+
+	// Try to convert the value to a bool. strconv.ParseBool() accepts values such as "true", "false", "1" and "0".
+	// If it fails, add an error message to the validator instance and return nil
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return nil
+	}
+
+	// Otherwise, return the converted bool value
+	return &b
+}
+
+// readFloat() helper reads a string value from the query string and converts it to a float64 before returning.
+// If no matching key is found, it returns the provided default value.
+// If the value could not be converted to a finite float64, then we record the error message in the provided
+// Validator instance. strconv.ParseFloat() accepts "NaN" and "Inf", which are never meaningful in a filter.
+func (app *application) readFloat(qs url.Values, key string, defaultValue float64, v *validator.Validator) float64 {
+	// Extract the value from query string
+	s := qs.Get(key)
+
+	// If no key exists (or the value is empty), return the default value
+	if s == "" {
+		return defaultValue
+	}
+
+	// Try to convert the value to a float64.
+	// If it fails, or the value is not finite, add an error message to the validator instance and return the
+	// default value
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		v.AddError(key, "must be a decimal value")
+		return defaultValue
+	}
+
+	// Otherwise, return the converted float value
+	return f
+}
+
 // runBackground() accepts and executes an arbitrary function in a new goroutine.
 // It catches and logs any error as a result of a panic.
 func (app *application) runBackground(fn func()) {
@@ -0,0 +1,568 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jseow5177/greenlight/internal/data"
+	"github.com/jseow5177/greenlight/internal/validator"
+)
+
+// benchmarkMovies() returns a list response of 1000 movies, as sent by listMoviesHandler().
+func benchmarkMovies() envelope {
+	movies := make([]*data.Movie, 1000)
+	for i := range movies {
+		movies[i] = &data.Movie{
+			ID:      int64(i + 1),
+			Title:   "Moana",
+			Year:    2016,
+			Runtime: 107,
+			Genres:  []string{"animation", "adventure"},
+			Version: 1,
+		}
+	}
+
+	return envelope{"movies": movies, "metadata": data.Metadata{CurrentPage: 1, PageSize: 1000}}
+}
+
+func BenchmarkWriteJSON(b *testing.B) {
+	app := newTestApplication(b)
+	env := benchmarkMovies()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		err := app.writeJSON(httptest.NewRecorder(), http.StatusOK, env, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWriteJSONStream(b *testing.B) {
+	app := newTestApplication(b)
+	env := benchmarkMovies()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		err := app.writeJSONStream(httptest.NewRecorder(), http.StatusOK, env, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestReadFloat(t *testing.T) {
+	app := newTestApplication(t)
+
+	tests := []struct {
+		query   string
+		want    float64
+		wantErr bool
+	}{
+		{"", 2.5, false},
+		{"min_rating=", 2.5, false},
+		{"min_rating=3.5", 3.5, false},
+		{"min_rating=4", 4, false},
+		{"min_rating=-0.25", -0.25, false},
+		{"min_rating=high", 2.5, true},
+		{"min_rating=3.5.1", 2.5, true},
+		{"min_rating=NaN", 2.5, true},
+		{"min_rating=Inf", 2.5, true},
+		{"min_rating=-Inf", 2.5, true},
+		{"min_rating=1e400", 2.5, true},
+	}
+
+	for _, tt := range tests {
+		qs, err := url.ParseQuery(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		v := validator.New()
+
+		got := app.readFloat(qs, "min_rating", 2.5, v)
+		if got != tt.want {
+			t.Errorf("%q: got %v; want %v", tt.query, got, tt.want)
+		}
+
+		if _, gotErr := v.Errors["min_rating"]; gotErr != tt.wantErr {
+			t.Errorf("%q: got errors %v; want an error %t", tt.query, v.Errors, tt.wantErr)
+		}
+	}
+}
+
+func TestDecodeAndValidate(t *testing.T) {
+	app := newTestApplication(t)
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int    // 0 when the body is valid
+		wantField  string // The field with a validation error, for a 422
+	}{
+		{"valid", `{"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": ["animation"]}`, 0, ""},
+		{"malformed JSON", `{"title": "Moana",`, http.StatusBadRequest, ""},
+		{"unknown field", `{"title": "Moana", "rating": 5}`, http.StatusBadRequest, ""},
+		{"wrong type", `{"title": 42}`, http.StatusBadRequest, ""},
+		{"empty body", ``, http.StatusBadRequest, ""},
+		{"missing title", `{"year": 2016, "runtime": "107 mins", "genres": ["animation"]}`, http.StatusUnprocessableEntity, "title"},
+		{"invalid year", `{"title": "Moana", "year": 1000, "runtime": "107 mins", "genres": ["animation"]}`, http.StatusUnprocessableEntity, "year"},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/v1/movies", strings.NewReader(tt.body))
+		r.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+
+		var input createMovieInput
+		err := app.decodeAndValidate(rr, r, &input)
+
+		if tt.wantStatus == 0 {
+			if err != nil {
+				t.Errorf("%s: got error %v; want nil", tt.name, err)
+			}
+			continue
+		}
+
+		var validationErr *failedValidationError
+		if got := errors.As(err, &validationErr); got != (tt.wantStatus == http.StatusUnprocessableEntity) {
+			t.Errorf("%s: got error %v; want a validation error %t", tt.name, err, !got)
+		}
+
+		app.decodeAndValidateErrorResponse(rr, r, err)
+		if rr.Code != tt.wantStatus {
+			t.Errorf("%s: got status %d; want %d", tt.name, rr.Code, tt.wantStatus)
+		}
+		if tt.wantField != "" && !strings.Contains(rr.Body.String(), `"`+tt.wantField+`"`) {
+			t.Errorf("%s: the response doesn't mention %s: %s", tt.name, tt.wantField, rr.Body)
+		}
+	}
+}
+
+func TestCreateMovieHandlerDecodeErrors(t *testing.T) {
+	app := newTestApplication(t)
+
+	tests := []struct {
+		body string
+		want int
+	}{
+		{`{"title": "Moana", "year": 2016`, http.StatusBadRequest},
+		{`{"title": "", "year": 2016, "runtime": "107 mins", "genres": ["animation"]}`, http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/v1/movies", strings.NewReader(tt.body))
+		r.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+
+		app.createMovieHandler(rr, r)
+
+		if rr.Code != tt.want {
+			t.Errorf("body %s: got status %d; want %d", tt.body, rr.Code, tt.want)
+		}
+	}
+}
+
+func TestWriteJSONFormat(t *testing.T) {
+	for _, pretty := range []bool{false, true} {
+		for _, stream := range []bool{false, true} {
+			app := newTestApplication(t)
+			app.config.jsonPretty = pretty
+
+			write := app.writeJSON
+			if stream {
+				write = app.writeJSONStream
+			}
+
+			rr := httptest.NewRecorder()
+			err := write(rr, http.StatusOK, envelope{"movie": map[string]string{"title": "Moana"}}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Only pretty responses end with a newline.
+			body := rr.Body.String()
+			if pretty && !strings.HasSuffix(body, "}\n") {
+				t.Errorf("pretty %t, stream %t: got body %q; want it to end with a single newline", pretty, stream, body)
+			}
+			if !pretty && !strings.HasSuffix(body, "}") {
+				t.Errorf("pretty %t, stream %t: got body %q; want no trailing newline", pretty, stream, body)
+			}
+
+			// Pretty output is indented with tabs, one value per line. Compact output is on a single line.
+			indented := strings.Contains(body, "\n\t")
+			if indented != pretty {
+				t.Errorf("pretty %t, stream %t: got body %q; want indentation %t", pretty, stream, body, pretty)
+			}
+			if !pretty && strings.ContainsAny(body, "\n\t") {
+				t.Errorf("stream %t: got compact body %q; want a single line", stream, body)
+			}
+		}
+	}
+}
+
+func TestAddVary(t *testing.T) {
+	rr := httptest.NewRecorder()
+
+	addVary(rr, "Origin")
+	addVary(rr, "Authorization")
+	addVary(rr, "origin") // Header names are case-insensitive, so this is a duplicate
+
+	if got := rr.Header().Values("Vary"); len(got) != 1 || got[0] != "Origin, Authorization" {
+		t.Errorf("got Vary %q; want a single \"Origin, Authorization\" header", got)
+	}
+
+	// Values set by other code, possibly as separate headers, are kept.
+	rr = httptest.NewRecorder()
+	rr.Header().Add("Vary", "Accept-Encoding")
+	rr.Header().Add("Vary", "Accept, Origin")
+
+	addVary(rr, "Authorization")
+
+	if got := rr.Header().Values("Vary"); len(got) != 1 || got[0] != "Accept-Encoding, Accept, Origin, Authorization" {
+		t.Errorf("got Vary %q; want a single \"Accept-Encoding, Accept, Origin, Authorization\" header", got)
+	}
+}
+
+func TestAuthenticateAddsVary(t *testing.T) {
+	app := newTestApplication(t)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	rr := httptest.NewRecorder()
+	rr.Header().Set("Vary", "Origin")
+	app.authenticate(next).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/movies", nil))
+
+	if got := rr.Header().Get("Vary"); got != "Origin, Authorization" {
+		t.Errorf("got Vary %q; want \"Origin, Authorization\"", got)
+	}
+}
+
+func TestReadIfMatchVersion(t *testing.T) {
+	app := newTestApplication(t)
+
+	tests := []struct {
+		header  string
+		want    int32
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"*", 0, false},
+		{"3", 3, false},
+		{`"3"`, 3, false},
+		{`W/"3"`, 3, false},
+		{"0", 0, true},
+		{"-1", 0, true},
+		{"three", 0, true},
+		{"99999999999", 0, true},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodDelete, "/v1/movies/1", nil)
+		if tt.header != "" {
+			r.Header.Set("If-Match", tt.header)
+		}
+
+		got, err := app.readIfMatchVersion(r)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("If-Match %q: got %d, error %v; want %d, an error %t", tt.header, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestReadJSONBodyTooLarge(t *testing.T) {
+	app := newTestApplication(t)
+
+	body := `{"title": "` + strings.Repeat("a", 1_048_576) + `"}`
+	r := httptest.NewRequest(http.MethodPost, "/v1/movies", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+
+	var dst struct {
+		Title string `json:"title"`
+	}
+	err := app.readJSON(httptest.NewRecorder(), r, &dst)
+
+	var tooLarge *bodyTooLargeError
+	if !errors.As(err, &tooLarge) {
+		t.Fatalf("got error %v; want a *bodyTooLargeError", err)
+	}
+	if got, want := err.Error(), "body must not be larger than 1048576 bytes"; got != want {
+		t.Errorf("got message %q; want %q", got, want)
+	}
+}
+
+func TestReadJSONBodyWithinLimit(t *testing.T) {
+	app := newTestApplication(t)
+
+	r := httptest.NewRequest(http.MethodPost, "/v1/movies", strings.NewReader(`{"title": "Moana"}`))
+	r.Header.Set("Content-Type", "application/json")
+
+	var dst struct {
+		Title string `json:"title"`
+	}
+	err := app.readJSON(httptest.NewRecorder(), r, &dst)
+	if err != nil || dst.Title != "Moana" {
+		t.Errorf("got error %v, title %q", err, dst.Title)
+	}
+}
+
+// failingReader returns an error after its data, like a connection which breaks.
+type failingReader struct {
+	data string
+	err  error
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	if f.data == "" {
+		return 0, f.err
+	}
+	n := copy(p, f.data)
+	f.data = f.data[n:]
+	return n, nil
+}
+
+func TestLimitBodyPassesOtherErrors(t *testing.T) {
+	broken := errors.New("connection reset")
+
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.Body = io.NopCloser(&failingReader{data: "short", err: broken})
+
+	body := limitBody(httptest.NewRecorder(), r, 100)
+
+	_, err := io.ReadAll(r.Body)
+	if !errors.Is(err, broken) || body.exceeded {
+		t.Errorf("got error %v, exceeded %t; want the original error", err, body.exceeded)
+	}
+}
+
+func TestLimitBodyExactLimit(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(strings.Repeat("a", 100)))
+
+	body := limitBody(httptest.NewRecorder(), r, 100)
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil || len(data) != 100 || body.exceeded {
+		t.Errorf("got %d bytes, error %v, exceeded %t; want the whole body", len(data), err, body.exceeded)
+	}
+}
+
+func TestIfNoneMatch(t *testing.T) {
+	const etag = `W/"abc"`
+
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{`W/"abc"`, true},
+		{`"abc"`, true},
+		{`W/"def", W/"abc"`, true},
+		{`W/"def"`, false},
+		{"*", true},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+		if tt.header != "" {
+			r.Header.Set("If-None-Match", tt.header)
+		}
+
+		if got := ifNoneMatch(r, etag); got != tt.want {
+			t.Errorf("If-None-Match %q: got %t; want %t", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestReadJSONContentType(t *testing.T) {
+	app := newTestApplication(t)
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		// The Content-Length is unknown, as for a chunked body
+		chunked bool
+		wantErr string
+	}{
+		{"JSON", "application/json", `{"title": "Moana"}`, false, ""},
+		{"JSON with charset", "application/json; charset=utf-8", `{"title": "Moana"}`, false, ""},
+		{"wrong type", "text/xml", `<movie><title>Moana</title></movie>`, false, `the Content-Type header must be application/json, not "text/xml"`},
+		{"invalid type", "application/", `{"title": "Moana"}`, false, `the Content-Type header must be application/json, not "application/"`},
+		{"missing type with a body", "", `{"title": "Moana"}`, false, "the Content-Type header must be application/json"},
+		{"missing type with a chunked body", "", `{"title": "Moana"}`, true, "the Content-Type header must be application/json"},
+		{"missing type without a body", "", "", false, "body must not be empty"},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/v1/movies", strings.NewReader(tt.body))
+		if tt.contentType != "" {
+			r.Header.Set("Content-Type", tt.contentType)
+		}
+		if tt.chunked {
+			r.ContentLength = -1
+		}
+
+		var dst struct {
+			Title string `json:"title"`
+		}
+		err := app.readJSON(httptest.NewRecorder(), r, &dst)
+
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s: got error %v; want nil", tt.name, err)
+		case tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr):
+			t.Errorf("%s: got error %v; want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCreateMovieHandlerContentType(t *testing.T) {
+	app := newTestApplication(t)
+
+	tests := []struct {
+		contentType string
+		want        int
+	}{
+		// The body is valid JSON, but not a valid movie, so it fails validation before the database is used.
+		{"application/json", http.StatusUnprocessableEntity},
+		{"text/xml", http.StatusUnsupportedMediaType},
+		{"", http.StatusUnsupportedMediaType},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/v1/movies", strings.NewReader(`{"title": ""}`))
+		if tt.contentType != "" {
+			r.Header.Set("Content-Type", tt.contentType)
+		}
+		rr := httptest.NewRecorder()
+
+		app.createMovieHandler(rr, r)
+
+		if rr.Code != tt.want {
+			t.Errorf("Content-Type %q: got status %d; want %d: %s", tt.contentType, rr.Code, tt.want, rr.Body)
+		}
+	}
+}
+
+func TestReadJSONBodySizeMetric(t *testing.T) {
+	app := newTestApplication(t)
+
+	names := []string{"request_body_bytes_count", "request_body_bytes_sum", "request_body_bytes_le_1024", "request_body_bytes_le_4096", "request_body_bytes_le_1048576"}
+	before := make(map[string]int64)
+	for _, name := range names {
+		before[name] = metricValue(name)
+	}
+
+	body := `{"title": "` + strings.Repeat("a", 2000) + `"}`
+	r := httptest.NewRequest(http.MethodPost, "/v1/movies", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+
+	var dst struct {
+		Title string `json:"title"`
+	}
+	err := app.readJSON(httptest.NewRecorder(), r, &dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The buckets are cumulative, so the body is counted in every bucket from 4096 bytes up.
+	want := map[string]int64{
+		"request_body_bytes_count":      1,
+		"request_body_bytes_sum":        int64(len(body)),
+		"request_body_bytes_le_1024":    0,
+		"request_body_bytes_le_4096":    1,
+		"request_body_bytes_le_1048576": 1,
+	}
+	for _, name := range names {
+		if delta := metricValue(name) - before[name]; delta != want[name] {
+			t.Errorf("%s: got an increase of %d; want %d", name, delta, want[name])
+		}
+	}
+}
+
+func TestReadJSONEmptyBody(t *testing.T) {
+	app := newTestApplication(t)
+
+	for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodPatch} {
+		for _, chunked := range []bool{false, true} {
+			count := metricValue("request_body_bytes_count")
+
+			r := httptest.NewRequest(method, "/v1/movies", strings.NewReader(""))
+			r.Header.Set("Content-Type", "application/json")
+			// Without a Content-Length, the empty body is only found when decoding.
+			if chunked {
+				r.ContentLength = -1
+			}
+
+			var dst struct{}
+			err := app.readJSON(httptest.NewRecorder(), r, &dst)
+			if err == nil || err.Error() != "body must not be empty" {
+				t.Errorf("%s, chunked %t: got error %v; want %q", method, chunked, err, "body must not be empty")
+			}
+
+			// Empty bodies are recorded too.
+			if delta := metricValue("request_body_bytes_count") - count; delta != 1 {
+				t.Errorf("%s, chunked %t: got a count increase of %d; want 1", method, chunked, delta)
+			}
+		}
+	}
+
+	// The handler responds with a 400 Bad Request.
+	r := httptest.NewRequest(http.MethodPost, "/v1/movies", nil)
+	r.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	app.createMovieHandler(rr, r)
+
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "body must not be empty") {
+		t.Errorf("got status %d and body %s; want %d and an empty body error", rr.Code, rr.Body, http.StatusBadRequest)
+	}
+}
+
+func TestWithWarnings(t *testing.T) {
+	app := newTestApplication(t)
+
+	// Without warnings the envelope is unchanged, and the response doesn't vary with the language.
+	rr := httptest.NewRecorder()
+	env := app.withWarnings(rr, httptest.NewRequest(http.MethodGet, "/v1/movies", nil), envelope{"movies": nil}, nil)
+	if _, ok := env["warnings"]; ok || hasVary(rr, "Accept-Language") {
+		t.Errorf("got envelope %v and Vary %q; want no warnings", env, rr.Header().Values("Vary"))
+	}
+
+	var ws warnings
+	ws.Add("movie %v not found", 42)
+	ws.Add("movie %v not found", 43)
+
+	tests := []struct {
+		acceptLanguage string
+		want           []string
+	}{
+		{"en", []string{"movie 42 not found", "movie 43 not found"}},
+		{"es", []string{"no se encontró la película 42", "no se encontró la película 43"}},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+		r.Header.Set("Accept-Language", tt.acceptLanguage)
+		rr := httptest.NewRecorder()
+
+		env := app.withWarnings(rr, r, envelope{"movies": nil}, ws)
+
+		if got, _ := env["warnings"].([]string); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got warnings %q; want %q", tt.acceptLanguage, env["warnings"], tt.want)
+		}
+		if _, ok := env["movies"]; !ok {
+			t.Errorf("%s: the data was dropped from the envelope", tt.acceptLanguage)
+		}
+		if !hasVary(rr, "Accept-Language") {
+			t.Errorf("%s: the response doesn't vary with Accept-Language", tt.acceptLanguage)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// mustParseCIDRs() is parseCIDRs() for networks known to be valid.
+func mustParseCIDRs(t *testing.T, val string) []*net.IPNet {
+	t.Helper()
+
+	networks, err := parseCIDRs(val)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return networks
+}
+
+func TestParseCIDRs(t *testing.T) {
+	networks := mustParseCIDRs(t, "10.0.0.0/8, 192.168.1.10/32,,2001:db8::/32")
+	if len(networks) != 3 {
+		t.Errorf("got %d networks; want 3", len(networks))
+	}
+
+	for _, val := range []string{"10.0.0.1", "10.0.0.0/33", "not-a-network"} {
+		if _, err := parseCIDRs(val); err == nil {
+			t.Errorf("parseCIDRs(%q): got nil error", val)
+		}
+	}
+}
+
+func TestIPFilter(t *testing.T) {
+	tests := []struct {
+		name       string
+		allow      string
+		deny       string
+		remoteAddr string
+		want       int
+	}{
+		{"no lists", "", "", "203.0.113.5:1234", http.StatusOK},
+		{"allowed", "10.0.0.0/8", "", "10.1.2.3:1234", http.StatusOK},
+		{"not allowed", "10.0.0.0/8", "", "203.0.113.5:1234", http.StatusForbidden},
+		{"denied", "", "203.0.113.0/24", "203.0.113.5:1234", http.StatusForbidden},
+		{"not denied", "", "203.0.113.0/24", "198.51.100.1:1234", http.StatusOK},
+		{"denied within allowed", "10.0.0.0/8", "10.0.5.0/24", "10.0.5.1:1234", http.StatusForbidden},
+		{"single address", "192.168.1.10/32", "", "192.168.1.11:1234", http.StatusForbidden},
+		{"IPv6", "2001:db8::/32", "", "[2001:db8::1]:1234", http.StatusOK},
+		{"unix socket with allow list", "10.0.0.0/8", "", "@", http.StatusForbidden},
+		{"unix socket with deny list", "", "10.0.0.0/8", "@", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			app.config.ipAllow = mustParseCIDRs(t, tt.allow)
+			app.config.ipDeny = mustParseCIDRs(t, tt.deny)
+
+			h := app.ipFilter(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			r := httptest.NewRequest(http.MethodGet, "/debug/vars", nil)
+			r.RemoteAddr = tt.remoteAddr
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, r)
+
+			if rr.Code != tt.want {
+				t.Errorf("got status %d; want %d", rr.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		trusted    string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"no proxies", "", "203.0.113.5:1234", []string{"198.51.100.1"}, "203.0.113.5"},
+		{"untrusted remote", "10.0.0.0/8", "203.0.113.5:1234", []string{"198.51.100.1"}, "203.0.113.5"},
+		{"trusted proxy", "10.0.0.0/8", "10.0.0.2:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"spoofed entry", "10.0.0.0/8", "10.0.0.2:1234", []string{"1.2.3.4, 198.51.100.1"}, "198.51.100.1"},
+		{"proxy chain", "10.0.0.0/8", "10.0.0.2:1234", []string{"198.51.100.1, 10.0.0.3"}, "198.51.100.1"},
+		{"several headers", "10.0.0.0/8", "10.0.0.2:1234", []string{"1.2.3.4", "198.51.100.1, 10.0.0.3"}, "198.51.100.1"},
+		{"no header", "10.0.0.0/8", "10.0.0.2:1234", nil, "10.0.0.2"},
+		{"malformed entry", "10.0.0.0/8", "10.0.0.2:1234", []string{"198.51.100.1, garbage"}, "10.0.0.2"},
+		{"only proxies", "10.0.0.0/8", "10.0.0.2:1234", []string{"10.0.0.4, 10.0.0.3"}, "10.0.0.4"},
+		{"unix socket", "10.0.0.0/8", "@", []string{"198.51.100.1"}, "@"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			app.config.trustedProxies = mustParseCIDRs(t, tt.trusted)
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+
+			if got := app.clientIP(r); got != tt.want {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
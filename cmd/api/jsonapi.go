@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/jseow5177/greenlight/internal/data"
+)
+
+// jsonAPIMediaType is the media type of JSON:API documents (https://jsonapi.org). Clients which send it in the
+// Accept header get the movies as JSON:API resource objects instead of the usual envelope.
+const jsonAPIMediaType = "application/vnd.api+json"
+
+// wantsJSONAPI() reports whether the client asked for a JSON:API document.
+func wantsJSONAPI(r *http.Request) bool {
+	return accepts(r, jsonAPIMediaType)
+}
+
+// jsonAPIMovie() converts a movie to a JSON:API resource object. The attributes are the fields of the usual
+// movie JSON, without the ID, which is a string in JSON:API.
+func jsonAPIMovie(movie *data.Movie) (envelope, error) {
+	js, err := json.Marshal(movie)
+	if err != nil {
+		return nil, err
+	}
+
+	var attributes map[string]interface{}
+
+	err = json.Unmarshal(js, &attributes)
+	if err != nil {
+		return nil, err
+	}
+	delete(attributes, "id")
+
+	return envelope{
+		"type":       "movies",
+		"id":         fmt.Sprint(data.MovieIDs.Encode(movie.ID)),
+		"attributes": attributes,
+	}, nil
+}
+
+// writeJSONAPIMovie() sends a single movie as a JSON:API document.
+func (app *application) writeJSONAPIMovie(w http.ResponseWriter, status int, movie *data.Movie, headers http.Header) error {
+	resource, err := jsonAPIMovie(movie)
+	if err != nil {
+		return err
+	}
+
+	return app.writeJSONAs(w, status, jsonAPIMediaType, envelope{"data": resource}, headers)
+}
+
+// writeJSONAPIMovies() sends a page of movies as a JSON:API document. The pagination metadata is in the top-level
+// "meta" member, and the links to the first, previous, next and last pages are in "links".
+func (app *application) writeJSONAPIMovies(w http.ResponseWriter, r *http.Request, movies []*data.Movie, metadata data.Metadata) error {
+	resources := make([]envelope, 0, len(movies))
+
+	for _, movie := range movies {
+		resource, err := jsonAPIMovie(movie)
+		if err != nil {
+			return err
+		}
+		resources = append(resources, resource)
+	}
+
+	return app.writeJSONAs(w, http.StatusOK, jsonAPIMediaType, envelope{
+		"data":  resources,
+		"meta":  metadata,
+		"links": jsonAPIPageLinks(r, metadata),
+	}, nil)
+}
+
+// jsonAPIPageLinks() returns the links to the current page and to the pages around it, with the page query
+// parameter changed and the other parameters kept. Links to pages which don't exist are null, as in JSON:API.
+func jsonAPIPageLinks(r *http.Request, metadata data.Metadata) envelope {
+	link := func(page int) interface{} {
+		if page < 1 || metadata.LastPage == 0 || page > metadata.LastPage {
+			return nil
+		}
+
+		u := *r.URL
+		qs := u.Query()
+		qs.Set("page", strconv.Itoa(page))
+		u.RawQuery = qs.Encode()
+
+		return u.RequestURI()
+	}
+
+	return envelope{
+		"self":  r.URL.RequestURI(),
+		"first": link(metadata.FirstPage),
+		"prev":  link(metadata.CurrentPage - 1),
+		"next":  link(metadata.CurrentPage + 1),
+		"last":  link(metadata.LastPage),
+	}
+}
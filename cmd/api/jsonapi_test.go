@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/jseow5177/greenlight/internal/data"
+)
+
+// jsonAPIDocument is a JSON:API document with one or more movies as the primary data.
+type jsonAPIDocument struct {
+	Data  json.RawMessage        `json:"data"`
+	Meta  map[string]interface{} `json:"meta"`
+	Links map[string]interface{} `json:"links"`
+}
+
+type jsonAPIResource struct {
+	Type       string                 `json:"type"`
+	ID         string                 `json:"id"`
+	Attributes map[string]interface{} `json:"attributes"`
+}
+
+func TestWantsJSONAPI(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   bool
+	}{
+		{"", false},
+		{"application/json", false},
+		{"application/vnd.api+json", true},
+		{"application/json, application/vnd.api+json; q=0.9", true},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+		r.Header.Set("Accept", tt.accept)
+
+		if got := wantsJSONAPI(r); got != tt.want {
+			t.Errorf("Accept %q: got %t; want %t", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestJSONAPIMovie(t *testing.T) {
+	movie := &data.Movie{ID: 7, Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation"}, Version: 1}
+
+	resource, err := jsonAPIMovie(movie)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resource["type"] != "movies" || resource["id"] != "7" {
+		t.Errorf("got type %v and id %#v; want \"movies\" and \"7\"", resource["type"], resource["id"])
+	}
+
+	attributes := resource["attributes"].(map[string]interface{})
+	if _, ok := attributes["id"]; ok {
+		t.Error("the ID is repeated in the attributes")
+	}
+	if attributes["title"] != "Moana" || attributes["runtime"] != "107 mins" {
+		t.Errorf("got attributes %v; want the fields of the movie JSON", attributes)
+	}
+}
+
+func TestWriteJSONAPIMovies(t *testing.T) {
+	app := newTestApplication(t)
+
+	movies := []*data.Movie{
+		{ID: 3, Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation"}, Version: 1},
+		{ID: 4, Title: "Frozen", Year: 2013, Runtime: 102, Genres: []string{"animation"}, Version: 1},
+	}
+	metadata := data.Metadata{CurrentPage: 2, PageSize: 2, FirstPage: 1, LastPage: 3, TotalRecords: 6, TotalPages: 3, HasNextPage: true, HasPreviousPage: true}
+
+	rr := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/v1/movies?genres=animation&page=2&page_size=2", nil)
+
+	err := app.writeJSONAPIMovies(rr, r, movies, metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := rr.Header().Get("Content-Type"); got != jsonAPIMediaType {
+		t.Errorf("got Content-Type %q; want %q", got, jsonAPIMediaType)
+	}
+
+	var doc jsonAPIDocument
+	err = json.Unmarshal(rr.Body.Bytes(), &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var resources []jsonAPIResource
+	err = json.Unmarshal(doc.Data, &resources)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resources) != 2 || resources[0].ID != "3" || resources[1].Attributes["title"] != "Frozen" {
+		t.Errorf("got data %s; want the 2 movies as resource objects", doc.Data)
+	}
+
+	if doc.Meta["current_page"] != float64(2) || doc.Meta["total_records"] != float64(6) {
+		t.Errorf("got meta %v; want the pagination metadata", doc.Meta)
+	}
+
+	// The links keep the other query parameters, and change the page.
+	wantLinks := map[string]interface{}{
+		"self":  "/v1/movies?genres=animation&page=2&page_size=2",
+		"first": "/v1/movies?genres=animation&page=1&page_size=2",
+		"prev":  "/v1/movies?genres=animation&page=1&page_size=2",
+		"next":  "/v1/movies?genres=animation&page=3&page_size=2",
+		"last":  "/v1/movies?genres=animation&page=3&page_size=2",
+	}
+	if !reflect.DeepEqual(doc.Links, wantLinks) {
+		t.Errorf("got links %v; want %v", doc.Links, wantLinks)
+	}
+}
+
+func TestJSONAPIPageLinksBounds(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/v1/movies?page=1", nil)
+
+	// There is no page before the first one, nor after the last one.
+	links := jsonAPIPageLinks(r, data.Metadata{CurrentPage: 1, PageSize: 20, FirstPage: 1, LastPage: 1})
+	if links["prev"] != nil || links["next"] != nil {
+		t.Errorf("got prev %v and next %v; want null", links["prev"], links["next"])
+	}
+
+	// Without any results, there are no pages at all.
+	links = jsonAPIPageLinks(r, data.Metadata{})
+	if links["first"] != nil || links["last"] != nil {
+		t.Errorf("without results: got first %v and last %v; want null", links["first"], links["last"])
+	}
+}
+
+func TestMoviesJSONAPI(t *testing.T) {
+	app := newTestApplicationWithDB(t)
+	h := app.routes()
+
+	_, token := newTestUser(t, app, "editor@example.com", "movies:read", "movies:write")
+
+	var ids []int64
+	for _, title := range []string{"Moana", "Deadpool", "Frozen"} {
+		ids = append(ids, movieID(t, createTestMovie(t, h, token, fmt.Sprintf(`{"title": %q, "year": 2016, "runtime": "100 mins", "genres": ["drama"]}`, title), nil)))
+	}
+
+	get := func(url, accept string) *httptest.ResponseRecorder {
+		t.Helper()
+
+		r := httptest.NewRequest(http.MethodGet, url, nil)
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, r)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: got status %d; want %d: %s", url, rr.Code, http.StatusOK, rr.Body)
+		}
+		return rr
+	}
+
+	// A single movie.
+	rr := get(fmt.Sprintf("/v1/movies/%d", ids[0]), jsonAPIMediaType)
+	if got := rr.Header().Get("Content-Type"); got != jsonAPIMediaType {
+		t.Errorf("detail: got Content-Type %q; want %q", got, jsonAPIMediaType)
+	}
+
+	var doc jsonAPIDocument
+	err := json.Unmarshal(rr.Body.Bytes(), &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var resource jsonAPIResource
+	err = json.Unmarshal(doc.Data, &resource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resource.Type != "movies" || resource.ID != fmt.Sprint(ids[0]) || resource.Attributes["title"] != "Moana" {
+		t.Errorf("detail: got %+v; want Moana as a resource object", resource)
+	}
+
+	// A paginated list.
+	rr = get("/v1/movies?sort=id&page=2&page_size=2", jsonAPIMediaType)
+
+	doc = jsonAPIDocument{}
+	err = json.Unmarshal(rr.Body.Bytes(), &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var resources []jsonAPIResource
+	err = json.Unmarshal(doc.Data, &resources)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resources) != 1 || resources[0].Attributes["title"] != "Frozen" {
+		t.Errorf("list: got data %s; want only Frozen", doc.Data)
+	}
+	if doc.Meta["total_records"] != float64(3) || doc.Links["next"] != nil || doc.Links["prev"] != "/v1/movies?page=1&page_size=2&sort=id" {
+		t.Errorf("list: got meta %v and links %v", doc.Meta, doc.Links)
+	}
+
+	// The usual envelope is kept by default.
+	rr = get("/v1/movies?sort=id", "")
+	var body struct {
+		Movies []json.RawMessage `json:"movies"`
+	}
+	err = json.Unmarshal(rr.Body.Bytes(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Movies) != 3 || rr.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("default: got %d movies as %q; want 3 as application/json", len(body.Movies), rr.Header().Get("Content-Type"))
+	}
+}
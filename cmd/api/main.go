@@ -3,10 +3,17 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"expvar"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net"
+	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -14,36 +21,157 @@ import (
 	"github.com/jseow5177/greenlight/internal/data"
 	"github.com/jseow5177/greenlight/internal/jsonlog"
 	"github.com/jseow5177/greenlight/internal/mailer"
+	"github.com/jseow5177/greenlight/internal/validator"
 	_ "github.com/lib/pq"
+	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 // Declare a string containing the application version number.
 // This will be generated automatically at build time later.
 const version = "1.0.0"
 
+// Declare a map of application metrics. It is published by the expvar package under the "metrics" key,
+// so the counters are included in the GET /debug/vars response.
+var metrics = expvar.NewMap("metrics")
+
 // Define a config struct to hold all the configuration settings for our application.
 // The configuration settings will be read from command-line flags when application starts.
 // They will have sensible default values if not provided in command-line.
 type config struct {
-	port int
-	env  string
-	db   struct {
-		dsn          string
-		maxOpenConns int
-		maxIdleConns int
-		maxIdleTime  string
+	port             int
+	socket           string // Path of a Unix domain socket to listen on instead of the TCP port
+	env              string
+	shutdownTimeout  time.Duration // Time given to in-flight requests to complete on graceful shutdown
+	drainDelay       time.Duration // Time between failing the readiness check and starting the graceful shutdown
+	publicIDs        string        // Form of the movie IDs in the public API (int|hashid)
+	publicIDSalt     string        // Salt used to generate hashid movie IDs
+	maxSessions      int           // Maximum number of authentication tokens per user
+	tokenEntropy     int           // Number of random bytes used to generate tokens
+	maxOffset        int           // Maximum number of rows skipped by pagination
+	defaultPageSize  int           // Page size used when a request doesn't set page_size
+	maxPageSize      int           // Maximum page size accepted in a request
+	minGenres        int           // Minimum number of genres of a movie
+	maxGenres        int           // Maximum number of genres of a movie
+	uniqueTitleYear  bool          // Reject a movie with the same title and year as another movie
+	listMaxGenres    int           // Maximum number of genres of each movie in list responses (0 for no limit)
+	maxIDs           int           // Maximum number of IDs accepted in a single request
+	movieSortFields  []string      // Fields which movie lists can be sorted by
+	passwordMinScore int           // Lowest strength score (0-4) accepted for a new password
+	jsonPretty       bool          // Indent JSON responses, instead of sending compact JSON
+	jsonNaming       string        // Naming of the JSON response fields (tags|snake_case)
+	validationErrors string        // Format of validation errors in responses (map|list)
+	deleteResponse   int           // Status code of a successful movie delete (200 with a message, or 204)
+	trustProxy       bool          // Trust the X-Forwarded-Proto header set by a proxy
+	trustedProxies   []*net.IPNet  // Networks of the proxies whose X-Forwarded-For header is trusted
+	ipAllow          []*net.IPNet  // Networks allowed to reach the IP-filtered routes (empty allows all)
+	ipDeny           []*net.IPNet  // Networks denied from the IP-filtered routes
+	queryTokenRoutes []string      // Route patterns which accept the token in the access_token query parameter
+	serverTiming     bool          // Report the time spent handling each request in a Server-Timing header
+	emailPreview     bool          // Register GET /v1/dev/email-preview, for developing the email templates
+	maxConns         int           // Maximum number of open connections (0 for no limit)
+	maxConnsPerIP    int           // Maximum number of open connections from one IP address (0 for no limit)
+	maxURILength     int           // Maximum length in bytes of the request URI (0 for no limit)
+	maxQueryParams   int           // Maximum number of query parameters in a request (0 for no limit)
+	skipSchemaCheck  bool          // Start without checking that the database migrations have been applied
+	tls              struct {
+		certFile          string // Certificate served when the server terminates TLS itself
+		keyFile           string // Private key of the certificate
+		clientCA          string // CA certificates which client certificates are verified against (optional)
+		requireClientCert bool   // Reject clients which don't present a certificate signed by the client CA
+	}
+	http struct {
+		idleTimeout  time.Duration // Time to keep idle persistent connections open
+		readTimeout  time.Duration // Time allowed to read the whole request, including the body
+		writeTimeout time.Duration // Time allowed to write the response
+	}
+	db struct {
+		dsn            string
+		replicaDSN     string // DSN of a read replica (optional)
+		maxOpenConns   int
+		maxIdleConns   int
+		maxIdleTime    string
+		timeout        time.Duration // Timeout for each database query
+		warmup         bool          // Fill the pool with idle connections at startup
+		retries        int           // Number of retries of an update which fails with a serialization failure
+		slowQuery      time.Duration // Queries slower than this are logged at the WARN level (0 disables it)
+		connectRetries int           // Number of times connecting to the database is retried at startup
+		connectBackoff time.Duration // Delay before the first connection retry, doubled on each retry
 	}
 	limiter struct {
-		rps     float64 // Request per second limiter
-		burst   int     // Burst value for limiter
-		enabled bool    // Boolean value to enable or disable rate limitting
+		rps             float64       // Request per second limiter
+		burst           int           // Burst value for limiter
+		enabled         bool          // Boolean value to enable or disable rate limitting
+		authRPS         float64       // Request per second limiter for the account and authentication routes
+		authBurst       int           // Burst value for the account and authentication routes
+		cleanupInterval time.Duration // How often clients which haven't been seen recently are removed
+		idleTTL         time.Duration // How long a client can go unseen before it is removed
+		exempt          []string      // Route patterns which are never rate limited, e.g. health checks
+
+		privilegedPermission string  // Permission which gives a user the privileged limit instead of the IP-based one
+		privilegedRPS        float64 // Request per second limiter for privileged users (0 for no limit)
+		privilegedBurst      int     // Burst value for privileged users
+	}
+	log struct {
+		level      string // Minimum severity of the log entries which are written (debug|info|warn|error)
+		output     string // Where log entries are written (stdout|stderr|file)
+		file       string // Path of the log file when the output is "file"
+		maxSize    int    // Size in megabytes at which the log file is rotated
+		maxBackups int    // Number of rotated log files to keep
+		maxAge     int    // Number of days to keep rotated log files
+	}
+	metrics struct {
+		expose   bool   // Expose /debug/vars in production, behind basic authentication
+		username string // Basic authentication credentials for /debug/vars in production
+		password string
 	}
 	smtp struct {
-		host     string
-		port     int
-		username string
+		host              string
+		port              int
+		username          string
+		password          string
+		sender            string
+		senders           map[string]*mailer.Sender // Senders of the kinds of email, which default to sender
+		tlsMode           string                    // How the connection is secured (starttls|implicit|none)
+		insecure          bool                      // Skip verifying the certificate of the SMTP server
+		perRecipientDaily int                       // Maximum number of emails sent to a recipient in 24 hours
+		workers           int                       // Number of background workers sending the queued emails
+		queueSize         int                       // Number of emails which can wait in the queue
+		queueTimeout      time.Duration             // Time to wait for space in a full queue before sending inline
+		sendTimeout       time.Duration             // Deadline for sending an email, including the retries
+		healthInterval    time.Duration             // How long the healthcheck reuses the result of the SMTP check
+	}
+	gzip struct {
+		enabled      bool
+		minSize      int      // Minimum response body size (in bytes) to compress
+		contentTypes []string // Content types which are compressed
+	}
+	openapi struct {
+		dump   bool   // Write the OpenAPI document and exit, instead of starting the server
+		output string // File to write the OpenAPI document to, or stdout if empty
+	}
+	admin struct {
+		create   bool // Create an activated admin user and exit, instead of starting the server
+		name     string
+		email    string
 		password string
-		sender   string
+	}
+	poster struct {
+		dir       string // Directory in which uploaded posters are stored
+		urlPrefix string // URL prefix of the stored posters, if they aren't served by the application
+		maxBytes  int64  // Maximum size of a poster upload request
+	}
+	softDelete struct {
+		retention     time.Duration // Time for which deleted movies are kept before they can be purged
+		purgeInterval time.Duration // Interval of the scheduled purge of deleted movies (0 disables it)
+	}
+	movieCache struct {
+		ttl  time.Duration // Time for which a movie read by GET /v1/movies/:id is cached (0 disables the cache)
+		size int           // Maximum number of cached movies
+	}
+	seed struct {
+		enabled bool // Seed the database with development data and exit, instead of starting the server
+		count   int
+		force   bool
 	}
 }
 
@@ -55,6 +183,26 @@ type application struct {
 	models data.Models
 	mailer mailer.Mailer
 	wg     sync.WaitGroup
+	// HTTP client used to deliver webhook notifications to subscribers.
+	webhookClient *http.Client
+	// The connection pools are kept so that the healthcheck can ping the database. replica is nil if
+	// there is no read replica.
+	db      *sql.DB
+	replica *sql.DB
+	// The time at which the application started, used to report the uptime.
+	startTime time.Time
+	// Set to 1 once a shutdown signal is received. Accessed atomically.
+	draining int32
+	// Closed once the server has shut down, to stop the scheduled background tasks.
+	done chan struct{}
+	// Queue of the emails to send. It is closed once the server has shut down, and the workers stop after
+	// sending the remaining emails.
+	emails chan emailJob
+	// Guards closing the email queue. emailsClosed is set by closeEmails(), after which emails are dropped.
+	emailsMu     sync.RWMutex
+	emailsClosed bool
+	// The SMTP check of the healthcheck, whose result is reused for -smtp-health-interval.
+	mailerCheck *cachedCheck
 }
 
 func main() {
@@ -67,22 +215,101 @@ func main() {
 	psqlPass := os.Getenv("POSTGRESS_PASSWORD")
 	smtpUser := os.Getenv("SMTP_USER")
 	smtpPass := os.Getenv("SMTP_PASSWORD")
+	metricsUser := os.Getenv("METRICS_USER")
+	metricsPass := os.Getenv("METRICS_PASSWORD")
 
 	// Declare an instance of the config struct
 	var cfg config
 
 	// Read application configuration settings from command-line flags into the config struct
 	flag.IntVar(&cfg.port, "port", 4000, "API server port")
+	flag.StringVar(&cfg.socket, "socket", "", "Listen on a Unix domain socket at this path instead of the TCP port")
 	flag.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
+	flag.StringVar(&cfg.validationErrors, "validation-errors", "map", "Format of validation errors in responses (map|list)")
+	flag.IntVar(&cfg.deleteResponse, "delete-response", http.StatusOK, "Status code of a successful movie delete: 200 with a message, or 204 with no body (200|204)")
+	flag.StringVar(&cfg.tls.certFile, "tls-cert", "", "TLS certificate file (serves plain HTTP if not set)")
+	flag.StringVar(&cfg.tls.keyFile, "tls-key", "", "TLS private key file")
+	flag.StringVar(&cfg.tls.clientCA, "tls-client-ca", "", "PEM file of the CA certificates which client certificates are verified against")
+	flag.BoolVar(&cfg.tls.requireClientCert, "tls-require-client-cert", false, "Only accept clients presenting a certificate signed by -tls-client-ca (mutual TLS)")
+	flag.IntVar(&cfg.maxConns, "max-connections", 0, "Maximum number of open connections; more connections wait for one to close (0 for no limit)")
+	flag.IntVar(&cfg.maxConnsPerIP, "max-connections-per-ip", 0, "Maximum number of open connections from one IP address; more are closed straight away (0 for no limit)")
+	flag.IntVar(&cfg.maxURILength, "max-uri-length", 8192, "Maximum length in bytes of the request URI, longer ones get a 414 response (0 for no limit)")
+	flag.IntVar(&cfg.maxQueryParams, "max-query-params", 100, "Maximum number of query parameters in a request, more get a 400 response (0 for no limit)")
+	flag.BoolVar(&cfg.emailPreview, "email-preview", false, "Register GET /v1/dev/email-preview, which renders the email templates with sample data (for development)")
+	flag.BoolVar(&cfg.serverTiming, "server-timing", false, "Report the time spent handling each request in a Server-Timing response header")
+	flag.BoolVar(&cfg.trustProxy, "trust-proxy-header", false, "Trust X-Forwarded-Proto to redirect HTTP requests to HTTPS and send HSTS")
+	flag.DurationVar(&cfg.shutdownTimeout, "shutdown-timeout", 5*time.Second, "Graceful shutdown timeout")
+	flag.BoolVar(&cfg.skipSchemaCheck, "skip-schema-check", false, "Start even if the database migrations haven't all been applied")
+	// The default drain delay gives a load balancer polling GET /v1/readyz every couple of seconds time to notice.
+	flag.DurationVar(&cfg.drainDelay, "drain-delay", 5*time.Second, "Time to keep serving requests after failing the readiness check on shutdown (0 to shut down straight away)")
+
+	httpTimeoutFlags(flag.CommandLine, &cfg)
 
 	flag.StringVar(&cfg.db.dsn, "db-dsn", fmt.Sprintf("postgres://greenlight:%s@localhost/greenlight?sslmode=disable", psqlPass), "Postgres DSN")
+	flag.StringVar(&cfg.db.replicaDSN, "db-replica-dsn", "", "Postgres DSN of a read replica (reads use the primary if not set)")
 	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgresSQL max open connections")
 	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "Postgres SQL max idle connections")
 	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgresSQL max connection time")
+	flag.DurationVar(&cfg.db.timeout, "db-timeout", 3*time.Second, "PostgreSQL query timeout")
+	flag.IntVar(&cfg.db.retries, "db-serialization-retries", 3, "Number of retries of an update which fails with a serialization failure")
+	flag.IntVar(&cfg.db.connectRetries, "db-connect-retries", 5, "Number of times connecting to the database is retried at startup")
+	flag.DurationVar(&cfg.db.connectBackoff, "db-connect-backoff", time.Second, "Delay before the first database connection retry, doubled on each retry")
+	flag.DurationVar(&cfg.db.slowQuery, "slow-query-threshold", 0, "Log queries which take longer than this duration (0 disables it)")
+	flag.BoolVar(&cfg.db.warmup, "db-warmup", false, "Open db-max-idle-conns connections at startup")
 
 	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
 	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
 	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
+	flag.Float64Var(&cfg.limiter.authRPS, "limiter-auth-rps", 0.2, "Rate limiter maximum requests per second for the account and authentication routes")
+	flag.IntVar(&cfg.limiter.authBurst, "limiter-auth-burst", 3, "Rate limiter maximum burst for the account and authentication routes")
+
+	flag.StringVar(&cfg.limiter.privilegedPermission, "limiter-privileged-permission", "ratelimit:exempt", "Permission which gives a user a rate limit keyed on their user ID instead of their IP address (empty to disable)")
+	flag.Float64Var(&cfg.limiter.privilegedRPS, "limiter-privileged-rps", 0, "Rate limiter maximum requests per second for privileged users (0 for no limit)")
+	flag.IntVar(&cfg.limiter.privilegedBurst, "limiter-privileged-burst", 20, "Rate limiter maximum burst for privileged users")
+	flag.DurationVar(&cfg.limiter.cleanupInterval, "limiter-cleanup-interval", time.Minute, "Rate limiter interval between removals of idle clients")
+	flag.DurationVar(&cfg.limiter.idleTTL, "limiter-idle-ttl", 3*time.Minute, "Rate limiter time after which an unseen client is removed")
+
+	// Load balancers poll the health checks often, from a single IP address, so they aren't rate limited.
+	cfg.limiter.exempt = []string{"GET /v1/healthcheck", "GET /v1/readyz"}
+	flag.Func("limiter-exempt", "Comma-separated list of routes which aren't rate limited, as \"<METHOD> <path>\" (default \"GET /v1/healthcheck,GET /v1/readyz\")", func(val string) error {
+		cfg.limiter.exempt = routePatterns(val)
+		return nil
+	})
+
+	// EventSource and plain download links can't set an Authorization header, so routes used that way can
+	// opt in to reading the token from the query string. No route does by default.
+	flag.Func("query-token-routes", "Comma-separated list of routes which accept the token in the access_token query parameter, as \"<METHOD> <path>\"", func(val string) error {
+		cfg.queryTokenRoutes = routePatterns(val)
+		return nil
+	})
+
+	// The IP filter only applies to the metrics and admin routes, which are selected in routes().
+	flag.Func("ip-allow", "Comma-separated list of the networks (in CIDR notation) allowed to reach the metrics and admin routes (default all)", func(val string) error {
+		var err error
+		cfg.ipAllow, err = parseCIDRs(val)
+		return err
+	})
+	flag.Func("trusted-proxies", "Comma-separated list of the networks (in CIDR notation) of the proxies whose X-Forwarded-For header gives the client IP address (default none)", func(val string) error {
+		var err error
+		cfg.trustedProxies, err = parseCIDRs(val)
+		return err
+	})
+	flag.Func("ip-deny", "Comma-separated list of the networks (in CIDR notation) denied from the metrics and admin routes", func(val string) error {
+		var err error
+		cfg.ipDeny, err = parseCIDRs(val)
+		return err
+	})
+
+	flag.StringVar(&cfg.log.level, "log-level", "info", "Minimum level of the log entries which are written (debug|info|warn|error)")
+	flag.StringVar(&cfg.log.output, "log-output", "stdout", "Log output (stdout|stderr|file)")
+	flag.StringVar(&cfg.log.file, "log-file", "greenlight.log", "Log file path, used with -log-output=file")
+	flag.IntVar(&cfg.log.maxSize, "log-max-size", 100, "Size in megabytes at which the log file is rotated")
+	flag.IntVar(&cfg.log.maxBackups, "log-max-backups", 3, "Number of rotated log files to keep (0 keeps all)")
+	flag.IntVar(&cfg.log.maxAge, "log-max-age", 28, "Number of days to keep rotated log files (0 keeps all)")
+
+	flag.BoolVar(&cfg.metrics.expose, "expose-metrics", false, "Expose /debug/vars in production, behind basic authentication")
+	flag.StringVar(&cfg.metrics.username, "metrics-username", metricsUser, "Basic authentication username for /debug/vars in production")
+	flag.StringVar(&cfg.metrics.password, "metrics-password", metricsPass, "Basic authentication password for /debug/vars in production")
 
 	flag.StringVar(&cfg.smtp.host, "smtp-host", "smtp.mailtrap.io", "SMTP host")
 	flag.IntVar(&cfg.smtp.port, "smtp-port", 25, "SMTP port (25|465|587|2525)")
@@ -90,15 +317,248 @@ func main() {
 	flag.StringVar(&cfg.smtp.password, "smtp-password", smtpPass, "SMTP password")
 	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "Greenlight <no-reply@greenlight.net>", "SMTP sender")
 
+	// Each kind of email can be sent from its own address, with an optional Reply-To address.
+	cfg.smtp.senders = map[string]*mailer.Sender{}
+	for _, kind := range []string{mailer.KindTransactional, mailer.KindSystem} {
+		sender := new(mailer.Sender)
+		cfg.smtp.senders[kind] = sender
+
+		flag.StringVar(&sender.From, "smtp-"+kind+"-sender", "", "SMTP sender of "+kind+" emails (defaults to -smtp-sender)")
+		flag.StringVar(&sender.ReplyTo, "smtp-"+kind+"-reply-to", "", "Reply-To address of "+kind+" emails (optional)")
+	}
+
+	flag.StringVar(&cfg.smtp.tlsMode, "smtp-tls-mode", mailer.TLSModeStartTLS, "SMTP TLS mode (starttls|implicit|none)")
+	flag.IntVar(&cfg.smtp.perRecipientDaily, "smtp-per-recipient-daily", 10, "Maximum number of emails sent to a recipient in 24 hours (0 for no limit)")
+	flag.IntVar(&cfg.smtp.workers, "smtp-workers", 2, "Number of background workers sending emails")
+	flag.IntVar(&cfg.smtp.queueSize, "smtp-queue-size", 100, "Number of emails which can wait to be sent")
+	flag.DurationVar(&cfg.smtp.queueTimeout, "smtp-queue-timeout", 100*time.Millisecond, "Time to wait for space in a full email queue before sending the email inline")
+	flag.DurationVar(&cfg.smtp.sendTimeout, "smtp-send-timeout", 30*time.Second, "Deadline for sending an email, including the retries")
+	flag.DurationVar(&cfg.smtp.healthInterval, "smtp-health-interval", time.Minute, "How long the healthcheck reuses the result of the SMTP check (0 to check on every request)")
+	flag.BoolVar(&cfg.smtp.insecure, "smtp-insecure", false, "Skip verifying the SMTP server certificate (local testing only)")
+
+	flag.BoolVar(&cfg.gzip.enabled, "gzip-enabled", true, "Enable gzip compression of responses")
+	flag.IntVar(&cfg.gzip.minSize, "gzip-min-size", 1024, "Minimum response body size in bytes to compress")
+
+	// Use flag.Func() to split the comma-separated list of content types into a slice.
+	cfg.gzip.contentTypes = []string{"application/json", "application/x-ndjson", "text/plain", "text/csv", "text/html"}
+	flag.Func("gzip-content-types", "Comma-separated list of content types to compress (default \"application/json,application/x-ndjson,text/plain,text/csv,text/html\")", func(val string) error {
+		cfg.gzip.contentTypes = strings.Split(val, ",")
+		return nil
+	})
+
+	flag.IntVar(&cfg.maxOffset, "max-offset", 100_000, "Maximum number of rows skipped to reach a page (0 disables the check)")
+	flag.IntVar(&cfg.defaultPageSize, "default-page-size", 20, "Page size used when a request doesn't set page_size")
+	flag.IntVar(&cfg.maxPageSize, "max-page-size", 100, "Maximum page size accepted in a request")
+	flag.IntVar(&cfg.minGenres, "min-genres", 1, "Minimum number of genres of a movie (1-5)")
+	flag.IntVar(&cfg.maxGenres, "max-genres", 5, "Maximum number of genres of a movie (1-5)")
+	flag.BoolVar(&cfg.uniqueTitleYear, "movies-unique-title-year", false, "Reject a movie with the same title and year as another movie")
+	flag.IntVar(&cfg.listMaxGenres, "list-max-genres", 0, "Maximum number of genres of each movie in GET /v1/movies responses (0 for no limit)")
+	flag.IntVar(&cfg.maxIDs, "max-ids", 100, "Maximum number of IDs accepted in a single request")
+
+	cfg.movieSortFields = data.MovieSortColumns
+	flag.Func("movie-sort-fields", "Comma-separated list of the fields which movie lists can be sorted by, including id (default \"id,title,year,runtime\")", func(val string) error {
+		cfg.movieSortFields = strings.Split(val, ",")
+		return nil
+	})
+	flag.StringVar(&cfg.jsonNaming, "json-naming", namingTags, "Naming of the JSON response fields (tags|snake_case)")
+	flag.BoolVar(&cfg.jsonPretty, "json-pretty", false, "Indent JSON responses (default true in development)")
+	flag.StringVar(&cfg.publicIDs, "public-ids", "int", "Form of the movie IDs in the public API (int|hashid)")
+	flag.StringVar(&cfg.publicIDSalt, "public-id-salt", "", "Secret salt used to generate hashid movie IDs")
+	flag.IntVar(&cfg.maxSessions, "max-sessions", 10, "Maximum number of concurrent authentication tokens per user (0 for no limit)")
+	flag.IntVar(&cfg.passwordMinScore, "password-min-score", 0, "Lowest strength score accepted for a new password, from 0 (any) to 4 (very unguessable)")
+	flag.IntVar(&cfg.tokenEntropy, "token-entropy", 16, "Number of random bytes used to generate tokens (16|32)")
+
+	flag.BoolVar(&cfg.openapi.dump, "dump-openapi", false, "Write the OpenAPI document describing the API and exit")
+	flag.StringVar(&cfg.openapi.output, "openapi-output", "", "File to write the OpenAPI document to (default stdout)")
+
+	flag.StringVar(&cfg.poster.dir, "poster-dir", "./posters", "Directory in which uploaded movie posters are stored")
+	flag.StringVar(&cfg.poster.urlPrefix, "poster-url-prefix", "", "URL prefix of the stored posters, e.g. a CDN (served at /v1/posters/ if not set)")
+	flag.Int64Var(&cfg.poster.maxBytes, "poster-max-bytes", 5<<20, "Maximum size of a poster upload request in bytes")
+
+	flag.DurationVar(&cfg.movieCache.ttl, "movie-cache-ttl", 0, "Time for which movies are cached in memory by ID (0 disables the cache)")
+	flag.IntVar(&cfg.movieCache.size, "movie-cache-size", 1000, "Maximum number of movies in the in-memory cache")
+	flag.DurationVar(&cfg.softDelete.retention, "soft-delete-retention", 30*24*time.Hour, "Time for which deleted movies are kept before they are purged")
+	flag.DurationVar(&cfg.softDelete.purgeInterval, "soft-delete-purge-interval", 0, "Interval at which deleted movies are purged in the background (0 disables it)")
+
+	flag.BoolVar(&cfg.admin.create, "create-admin", false, "Create an activated admin user and exit")
+	flag.StringVar(&cfg.admin.name, "admin-name", "Admin", "Name of the admin user created with -create-admin")
+	flag.StringVar(&cfg.admin.email, "admin-email", "", "Email address of the admin user created with -create-admin")
+	flag.StringVar(&cfg.admin.password, "admin-password", "", "Password of the admin user created with -create-admin")
+
+	flag.BoolVar(&cfg.seed.enabled, "seed", false, "Seed the database with development data and exit")
+	flag.IntVar(&cfg.seed.count, "seed-count", 100, "Number of movies inserted with -seed")
+	flag.BoolVar(&cfg.seed.force, "seed-force", false, "Seed the database even if it already contains movies")
+
 	flag.Parse()
 
-	// Initialize a new jsonlog.Logger which writes any messages *at or above* the INFO
-	// severity level to the standard output stream
-	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
+	// Unless -json-pretty was set explicitly, only indent JSON responses in development.
+	// Compact JSON saves bandwidth in the other environments.
+	jsonPrettySet := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "json-pretty" {
+			jsonPrettySet = true
+		}
+	})
+	if !jsonPrettySet {
+		cfg.jsonPretty = cfg.env == "development"
+	}
+
+	// Open the destination for the log entries. The logger isn't available yet, so use the standard logger
+	// to report a problem.
+	logOutput, err := openLogOutput(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	logLevel, err := jsonlog.ParseLevel(cfg.log.level)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Initialize a new jsonlog.Logger which writes any messages *at or above* the -log-level
+	// severity level (INFO by default) to the log output (the standard output stream by default)
+	logger := jsonlog.New(logOutput, logLevel)
+
+	// time.Sleep() returns immediately for a non-positive duration, which would make the cleanup goroutine spin.
+	if cfg.limiter.cleanupInterval <= 0 || cfg.limiter.idleTTL <= 0 {
+		logger.PrintFatal(errors.New("rate limiter cleanup interval and idle TTL must be greater than zero"), nil)
+	}
+	if cfg.maxConns < 0 || cfg.maxConnsPerIP < 0 {
+		logger.PrintFatal(errors.New("connection limits must not be negative"), nil)
+	}
+	if cfg.maxURILength < 0 || cfg.maxQueryParams < 0 {
+		logger.PrintFatal(errors.New("URI length and query parameter limits must not be negative"), nil)
+	}
+	if cfg.limiter.privilegedRPS < 0 || cfg.limiter.privilegedBurst < 0 {
+		logger.PrintFatal(errors.New("rate limiter privileged rps and burst must not be negative"), nil)
+	}
+
+	if cfg.jsonNaming != namingTags && cfg.jsonNaming != namingSnakeCase {
+		logger.PrintFatal(errors.New("json naming must be tags or snake_case"), nil)
+	}
+
+	if cfg.validationErrors != "map" && cfg.validationErrors != "list" {
+		logger.PrintFatal(errors.New("validation errors format must be map or list"), nil)
+	}
+
+	if cfg.deleteResponse != http.StatusOK && cfg.deleteResponse != http.StatusNoContent {
+		logger.PrintFatal(errors.New("delete response must be 200 or 204"), nil)
+	}
+
+	if cfg.passwordMinScore < 0 || cfg.passwordMinScore > 4 {
+		logger.PrintFatal(errors.New("password min score must be between 0 and 4"), nil)
+	}
+
+	// Anything below 128 bits of token entropy is too easy to guess.
+	if cfg.tokenEntropy < 16 {
+		logger.PrintFatal(errors.New("token entropy must be at least 16 bytes"), nil)
+	}
+
+	// Switch the public movie IDs to hashids if requested. The salt must stay the same between deployments,
+	// otherwise the IDs held by clients stop working.
+	switch cfg.publicIDs {
+	case "int":
+	case "hashid":
+		if cfg.publicIDSalt == "" {
+			logger.PrintFatal(errors.New("a salt must be provided with -public-id-salt when -public-ids=hashid"), nil)
+		}
+
+		data.MovieIDs, err = data.NewHashPublicIDs(cfg.publicIDSalt)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+	default:
+		logger.PrintFatal(errors.New("public IDs must be int or hashid"), nil)
+	}
+
+	if cfg.maxOffset < 0 {
+		logger.PrintFatal(errors.New("max offset must not be negative"), nil)
+	}
+
+	if cfg.db.retries < 0 {
+		logger.PrintFatal(errors.New("db serialization retries must not be negative"), nil)
+	}
+
+	if cfg.env == "production" && cfg.metrics.expose && (cfg.metrics.username == "" || cfg.metrics.password == "") {
+		logger.PrintFatal(errors.New("a username and password must be provided with -metrics-username and -metrics-password when -expose-metrics is set"), nil)
+	}
+
+	if (cfg.tls.certFile == "") != (cfg.tls.keyFile == "") {
+		logger.PrintFatal(errors.New("-tls-cert and -tls-key must be provided together"), nil)
+	}
+	if cfg.tls.clientCA != "" && cfg.tls.certFile == "" {
+		logger.PrintFatal(errors.New("-tls-client-ca requires -tls-cert and -tls-key"), nil)
+	}
+	if cfg.tls.requireClientCert && cfg.tls.clientCA == "" {
+		logger.PrintFatal(errors.New("-tls-require-client-cert requires -tls-client-ca"), nil)
+	}
+
+	if cfg.softDelete.retention < 0 || cfg.softDelete.purgeInterval < 0 {
+		logger.PrintFatal(errors.New("soft delete retention and purge interval must not be negative"), nil)
+	}
+
+	if cfg.movieCache.ttl < 0 || cfg.movieCache.size < 1 {
+		logger.PrintFatal(errors.New("movie cache TTL must not be negative, and its size must be greater than zero"), nil)
+	}
+
+	if cfg.db.slowQuery < 0 {
+		logger.PrintFatal(errors.New("slow query threshold must not be negative"), nil)
+	}
+
+	if cfg.defaultPageSize < 1 || cfg.maxPageSize < 1 {
+		logger.PrintFatal(errors.New("page sizes must be greater than zero"), nil)
+	}
+	if cfg.defaultPageSize > cfg.maxPageSize {
+		logger.PrintFatal(errors.New("default page size must not be greater than the max page size"), nil)
+	}
+
+	// The genres_length_check constraint of the movies table only allows 1 to 5 genres.
+	if cfg.minGenres < 1 || cfg.maxGenres > 5 || cfg.minGenres > cfg.maxGenres {
+		logger.PrintFatal(errors.New("genre counts must satisfy 1 <= -min-genres <= -max-genres <= 5"), nil)
+	}
+
+	// The sort fields are used as column names in the SQL queries, so only the known sortable columns are allowed.
+	// id is the default sort, so it can't be left out.
+	if !validator.In("id", cfg.movieSortFields...) {
+		logger.PrintFatal(errors.New("movie sort fields must include id"), nil)
+	}
+	for _, field := range cfg.movieSortFields {
+		if !validator.In(field, data.MovieSortColumns...) {
+			logger.PrintFatal(fmt.Errorf("movie sort fields must be among %s", strings.Join(data.MovieSortColumns, ", ")), nil)
+		}
+	}
+
+	if cfg.listMaxGenres < 0 {
+		logger.PrintFatal(errors.New("list max genres must not be negative"), nil)
+	}
+
+	// If the -dump-openapi flag is set, write the OpenAPI document and exit. This doesn't need a database.
+	if cfg.openapi.dump {
+		out := os.Stdout
+
+		if cfg.openapi.output != "" {
+			out, err = os.Create(cfg.openapi.output)
+			if err != nil {
+				logger.PrintFatal(err, nil)
+			}
+			defer out.Close()
+		}
+
+		err = writeOpenAPISpec(out)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		return
+	}
+
+	if cfg.db.connectRetries < 0 || cfg.db.connectBackoff < 0 {
+		logger.PrintFatal(errors.New("db connect retries and backoff must not be negative"), nil)
+	}
 
 	// Call openDB() to create the connection pool, passing in the config struct.
-	// If it returns an error, we log it and exit immediately.
-	db, err := openDB(cfg)
+	// The database may still be starting up (e.g. when both are started by a container orchestrator),
+	// so failed attempts are retried with a backoff. If the last attempt fails, we log it and exit.
+	db, err := openDBWithRetry(cfg, logger)
 	if err != nil {
 		logger.PrintFatal(err, nil)
 	}
@@ -109,12 +569,168 @@ func main() {
 
 	logger.PrintInfo("database connection pool established", nil)
 
+	// Refuse to start if migrations are missing, rather than failing the requests which use the missing tables
+	// and columns. The check can be skipped with -skip-schema-check, e.g. when the migrations are applied by
+	// another tool which doesn't record them in the schema_migrations table.
+	if !cfg.skipSchemaCheck {
+		err = checkSchema(db)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+	}
+
+	// If a replica DSN is set, open a second pool with the same settings for the read-only queries.
+	var replica *sql.DB
+	if cfg.db.replicaDSN != "" {
+		replicaCfg := cfg
+		replicaCfg.db.dsn = cfg.db.replicaDSN
+
+		replica, err = openDBWithRetry(replicaCfg, logger)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+
+		defer replica.Close()
+
+		logger.PrintInfo("database replica connection pool established", nil)
+	}
+
+	// If the -db-warmup flag is set, fill the pool before serving, so that the first requests don't have to
+	// wait for new connections. The pool still works if this fails, so the error isn't fatal.
+	if cfg.db.warmup {
+		n, duration, err := warmupDB(db, cfg)
+		if err != nil {
+			logger.PrintError(err, nil)
+		} else {
+			logger.PrintInfo("database connection pool warmed up", map[string]string{
+				"connections": strconv.Itoa(n),
+				"duration":    duration.String(),
+			})
+		}
+	}
+
+	// If the -create-admin flag is set, create the admin user and exit without starting the server.
+	if cfg.admin.create {
+		user, err := createAdmin(data.NewModels(db, cfg.db.timeout), cfg.admin.name, cfg.admin.email, cfg.admin.password, cfg.passwordMinScore)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+
+		logger.PrintInfo("admin user created", map[string]string{
+			"id":    strconv.FormatInt(user.ID, 10),
+			"email": user.Email,
+		})
+		return
+	}
+
+	// If the -seed flag is set, populate the database with development data and exit.
+	if cfg.seed.enabled {
+		inserted, err := seed(db, data.NewModels(db, cfg.db.timeout), cfg.seed.count, cfg.seed.force)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+
+		if inserted == 0 {
+			logger.PrintInfo("database already contains movies, skipping seed (use -seed-force to seed anyway)", nil)
+		} else {
+			logger.PrintInfo("database seeded", map[string]string{
+				"movies": strconv.Itoa(inserted),
+			})
+		}
+		return
+	}
+
+	// Initialize the mailer. This fails if the SMTP TLS mode is not recognised.
+	smtpMailer, err := mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender, cfg.smtp.tlsMode, cfg.smtp.insecure)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	if cfg.smtp.workers < 1 || cfg.smtp.queueSize < 0 || cfg.smtp.queueTimeout < 0 {
+		logger.PrintFatal(errors.New("SMTP workers must be greater than zero, and the queue size and timeout must not be negative"), nil)
+	}
+	if cfg.smtp.sendTimeout <= 0 {
+		logger.PrintFatal(errors.New("SMTP send timeout must be greater than zero"), nil)
+	}
+	if cfg.smtp.healthInterval < 0 {
+		logger.PrintFatal(errors.New("SMTP health interval must not be negative"), nil)
+	}
+	if cfg.drainDelay < 0 {
+		logger.PrintFatal(errors.New("drain delay must not be negative"), nil)
+	}
+
+	for kind, sender := range cfg.smtp.senders {
+		// A kind with only a Reply-To address is sent from the default sender.
+		if sender.From == "" && sender.ReplyTo != "" {
+			sender.From = cfg.smtp.sender
+		}
+		smtpMailer = smtpMailer.WithSender(kind, *sender)
+	}
+
+	// Create the poster directory, if it doesn't exist yet, so that the first upload doesn't fail.
+	if cfg.poster.maxBytes < 1 {
+		logger.PrintFatal(errors.New("poster max bytes must be greater than zero"), nil)
+	}
+	if cfg.poster.urlPrefix != "" && !validator.ValidURL(cfg.poster.urlPrefix) {
+		logger.PrintFatal(errors.New("poster URL prefix must be an absolute http or https URL"), nil)
+	}
+
+	err = os.MkdirAll(cfg.poster.dir, 0755)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	models := data.NewModelsWithReplica(db, replica, cfg.db.timeout)
+
+	// Put the cache in front of the movie lookups by ID if -movie-cache-ttl is set, and publish its hit and miss
+	// counts in the metrics. The counts stay at 0 without a cache.
+	if cfg.movieCache.ttl > 0 {
+		models.Movies.Cache = data.NewMovieCache(cfg.movieCache.ttl, cfg.movieCache.size)
+	}
+	metrics.Set("movie_cache_hits", expvar.Func(func() interface{} { return models.Movies.Cache.Hits() }))
+	metrics.Set("movie_cache_misses", expvar.Func(func() interface{} { return models.Movies.Cache.Misses() }))
+
 	// Declare an instance of the application struct, containing the config struct and the logger.
 	app := &application{
 		config: cfg,
 		logger: logger,
-		models: data.NewModels(db), // Add database models as application dependency
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		models: models, // Add database models as application dependency
+		mailer: smtpMailer.WithDailyLimit(cfg.smtp.perRecipientDaily),
+		// Use a 5-second timeout so that an unresponsive subscriber can't tie up a background routine.
+		webhookClient: newWebhookClient(5 * time.Second),
+		db:            db,
+		replica:       replica,
+		startTime:     time.Now(),
+		done:          make(chan struct{}),
+		emails:        make(chan emailJob, cfg.smtp.queueSize),
+	}
+
+	app.mailerCheck = newCachedCheck(app.mailer.Ping, cfg.smtp.healthInterval)
+
+	// Start the workers which send the queued emails.
+	app.startEmailWorkers(cfg.smtp.workers)
+
+	// If the -soft-delete-purge-interval flag is set, purge the deleted movies past the retention period
+	// in the background, as well as on request.
+	if cfg.softDelete.purgeInterval > 0 {
+		app.schedulePurge()
+	}
+
+	// Generate the tokens with -token-entropy random bytes, retry the movie and user updates which fail with a
+	// serialization failure up to -db-serialization-retries times, and reject duplicate movies if
+	// -movies-unique-title-year is set.
+	app.models.Tokens.Entropy = cfg.tokenEntropy
+	app.models.Movies.SerializationRetries = cfg.db.retries
+	app.models.Movies.UniqueTitleYear = cfg.uniqueTitleYear
+	app.models.Users.SerializationRetries = cfg.db.retries
+
+	// Log every query at the DEBUG level, with the number of rows it touched where the model method reports it, and
+	// the queries which take longer than -slow-query-threshold at the WARN level. Only the operation name is logged,
+	// never the query arguments. The queries made on behalf of a request use the models returned by modelsFor(),
+	// which add the trace ID of the request. These models are used by the rest, such as background jobs. The query
+	// log is left unset otherwise, so that the queries don't pay for building log entries which would be dropped.
+	if logger.Enabled(jsonlog.LevelDebug) || cfg.db.slowQuery > 0 {
+		app.models = app.models.WithQueryLog(queryLogger(logger, cfg.db.slowQuery, ""))
 	}
 
 	// Start the server
@@ -124,6 +740,50 @@ func main() {
 	}
 }
 
+// routePatterns() splits a comma-separated list of "<METHOD> <path>" route patterns, as used by matchRoute().
+func routePatterns(val string) []string {
+	var patterns []string
+	for _, pattern := range strings.Split(val, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+// httpTimeoutFlags() defines the -http-*-timeout flags of the HTTP server on the flag set.
+// flag.DurationVar() rejects values which don't parse as durations (e.g. "30s", "1m") at startup.
+func httpTimeoutFlags(fs *flag.FlagSet, cfg *config) {
+	fs.DurationVar(&cfg.http.idleTimeout, "http-idle-timeout", time.Minute, "HTTP server idle timeout")
+	fs.DurationVar(&cfg.http.readTimeout, "http-read-timeout", 10*time.Second, "HTTP server read timeout")
+	fs.DurationVar(&cfg.http.writeTimeout, "http-write-timeout", 30*time.Second, "HTTP server write timeout")
+}
+
+// openLogOutput() returns the writer for the log entries selected with the -log-output flag.
+// For file output, the log file is rotated once it reaches the maximum size. The rotated files are renamed
+// with a timestamp, and the oldest are removed according to the -log-max-backups and -log-max-age flags.
+func openLogOutput(cfg config) (io.Writer, error) {
+	switch cfg.log.output {
+	case "stdout":
+		return os.Stdout, nil
+	case "stderr":
+		return os.Stderr, nil
+	case "file":
+		if cfg.log.file == "" {
+			return nil, errors.New("a log file must be provided with -log-output=file")
+		}
+
+		return &lumberjack.Logger{
+			Filename:   cfg.log.file,
+			MaxSize:    cfg.log.maxSize,
+			MaxBackups: cfg.log.maxBackups,
+			MaxAge:     cfg.log.maxAge,
+		}, nil
+	default:
+		return nil, fmt.Errorf("invalid log output %q", cfg.log.output)
+	}
+}
+
 // openDB() returns a sql.DB connection pool.
 func openDB(cfg config) (*sql.DB, error) {
 	// Use sql.Open() to create an empty connection pool, using the DSN from the config struct.
@@ -160,9 +820,133 @@ func openDB(cfg config) (*sql.DB, error) {
 	// this will return an error.
 	err = db.PingContext(ctx)
 	if err != nil {
+		db.Close()
 		return nil, err
 	}
 
 	// Return the sql.DB connection pool
 	return db, nil
 }
+
+// openDBWithRetry() calls openDB(), and calls it again after a backoff if it fails, up to -db-connect-retries
+// times. Each failed attempt is logged with the delay before the next one.
+func openDBWithRetry(cfg config, logger *jsonlog.Logger) (*sql.DB, error) {
+	var db *sql.DB
+
+	err := retryWithBackoff(cfg.db.connectRetries, cfg.db.connectBackoff, func() error {
+		var err error
+		db, err = openDB(cfg)
+		return err
+	}, func(attempt int, err error, delay time.Duration) {
+		logger.PrintWarn("unable to connect to the database, retrying", map[string]string{
+			"attempt": strconv.Itoa(attempt),
+			"error":   err.Error(),
+			"delay":   delay.String(),
+		})
+	})
+
+	return db, err
+}
+
+// retryWithBackoff() calls fn until it succeeds, retrying up to retries times. The first retry waits for
+// backoff, and each following one waits twice as long as the previous one. onRetry is called with the number
+// of the failed attempt (starting at 1), its error and the delay before the next attempt. The error of the last
+// attempt is returned if every attempt fails.
+func retryWithBackoff(retries int, backoff time.Duration, fn func() error, onRetry func(attempt int, err error, delay time.Duration)) error {
+	delay := backoff
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt > retries {
+			return err
+		}
+
+		onRetry(attempt, err, delay)
+
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// warmupDB() opens the number of connections the pool keeps idle, concurrently, and returns them to the pool
+// so that they are ready for the first requests. It is bounded by the maximum number of open connections.
+// It returns the number of connections opened and how long it took.
+func warmupDB(db *sql.DB, cfg config) (int, time.Duration, error) {
+	n := cfg.db.maxIdleConns
+	if cfg.db.maxOpenConns > 0 && n > cfg.db.maxOpenConns {
+		n = cfg.db.maxOpenConns
+	}
+	if n <= 0 {
+		return 0, 0, nil
+	}
+
+	// Like the ping in openDB(), give up after 5 seconds.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	start := time.Now()
+
+	// Every connection is held until all of them are open. Otherwise a connection returned early would be
+	// reused by the next db.Conn() call instead of a new one being opened.
+	conns := make([]*sql.Conn, n)
+	errs := make([]error, n)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			conn, err := db.Conn(ctx)
+			if err == nil {
+				// Ping the connection to make sure that it is usable.
+				err = conn.PingContext(ctx)
+			}
+
+			conns[i], errs[i] = conn, err
+		}(i)
+	}
+
+	wg.Wait()
+
+	// Closing a sql.Conn returns it to the pool as an idle connection.
+	for _, conn := range conns {
+		if conn != nil {
+			conn.Close()
+		}
+	}
+
+	for _, err := range errs {
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	return n, time.Since(start), nil
+}
+
+// queryLogger() returns the data.QueryLogFunc which logs a query with its operation name, duration and, when the
+// model method reports it, the number of rows it returned or affected. A query which took longer than the threshold
+// is logged at the WARN level, and the others at the DEBUG level. A threshold of 0 disables the WARN entries. The
+// trace ID of the request which made the query is added when it isn't empty.
+func queryLogger(logger *jsonlog.Logger, threshold time.Duration, traceID string) data.QueryLogFunc {
+	return func(operation string, rows int64, duration time.Duration) {
+		properties := map[string]string{
+			"operation": operation,
+			"duration":  duration.String(),
+		}
+		if rows >= 0 {
+			properties["rows_affected"] = strconv.FormatInt(rows, 10)
+		}
+		if traceID != "" {
+			properties["trace_id"] = traceID
+		}
+
+		if threshold > 0 && duration > threshold {
+			logger.PrintWarn("slow query", properties)
+			return
+		}
+		logger.PrintDebug("query", properties)
+	}
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jseow5177/greenlight/internal/jsonlog"
+)
+
+func TestOpenLogOutputRotation(t *testing.T) {
+	dir := t.TempDir()
+
+	var cfg config
+	cfg.log.output = "file"
+	cfg.log.file = filepath.Join(dir, "api.log")
+	cfg.log.maxSize = 1 // The smallest size lumberjack supports, in megabytes
+	cfg.log.maxBackups = 3
+
+	out, err := openLogOutput(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := jsonlog.New(out, jsonlog.LevelInfo)
+
+	// Write a little more than 1 MB of log entries.
+	padding := strings.Repeat("x", 1024)
+	for i := 0; i < 1100; i++ {
+		logger.PrintInfo("request", map[string]string{"padding": padding})
+	}
+
+	err = logger.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	backups := 0
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+		if entry.Name() != "api.log" && strings.HasPrefix(entry.Name(), "api-") {
+			backups++
+		}
+	}
+
+	if backups == 0 {
+		t.Errorf("got files %v; want a backup of api.log", names)
+	}
+	if _, err := os.Stat(cfg.log.file); err != nil {
+		t.Errorf("the current log file is missing: %v", err)
+	}
+}
+
+func TestOpenLogOutput(t *testing.T) {
+	tests := []struct {
+		output  string
+		want    *os.File
+		wantErr bool
+	}{
+		{"stdout", os.Stdout, false},
+		{"stderr", os.Stderr, false},
+		{"file", nil, true},
+		{"syslog", nil, true},
+	}
+
+	for _, tt := range tests {
+		var cfg config
+		cfg.log.output = tt.output
+
+		out, err := openLogOutput(cfg)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got error %v; want an error %t", tt.output, err, tt.wantErr)
+			continue
+		}
+		if tt.want != nil && out != tt.want {
+			t.Errorf("%s: got output %v; want %v", tt.output, out, tt.want)
+		}
+	}
+}
+
+// countingConnector is a connection pool whose connections can only be pinged. It counts the connections opened,
+// and can be made to fail.
+type countingConnector struct {
+	mu     sync.Mutex
+	opened int
+	err    error
+}
+
+func (c *countingConnector) Connect(context.Context) (driver.Conn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return nil, c.err
+	}
+	c.opened++
+	return pingConn{}, nil
+}
+
+func (c *countingConnector) Driver() driver.Driver { return nil }
+
+type pingConn struct{}
+
+func (pingConn) Ping(context.Context) error          { return nil }
+func (pingConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (pingConn) Close() error                        { return nil }
+func (pingConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func TestWarmupDB(t *testing.T) {
+	tests := []struct {
+		name                       string
+		maxOpenConns, maxIdleConns int
+		want                       int
+	}{
+		{"idle connections", 25, 10, 10},
+		{"bounded by the open connections", 4, 10, 4},
+		{"no idle connections", 25, 0, 0},
+	}
+
+	for _, tt := range tests {
+		connector := &countingConnector{}
+
+		db := sql.OpenDB(connector)
+		defer db.Close()
+
+		var cfg config
+		cfg.db.maxOpenConns = tt.maxOpenConns
+		cfg.db.maxIdleConns = tt.maxIdleConns
+
+		// As in openDB()
+		db.SetMaxOpenConns(cfg.db.maxOpenConns)
+		db.SetMaxIdleConns(cfg.db.maxIdleConns)
+
+		n, _, err := warmupDB(db, cfg)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		if n != tt.want || connector.opened != tt.want {
+			t.Errorf("%s: got %d connections (%d opened); want %d", tt.name, n, connector.opened, tt.want)
+		}
+		if idle := db.Stats().Idle; idle != tt.want {
+			t.Errorf("%s: got %d idle connections after warmup; want %d", tt.name, idle, tt.want)
+		}
+	}
+}
+
+func TestWarmupDBError(t *testing.T) {
+	connector := &countingConnector{err: errors.New("connection refused")}
+
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	var cfg config
+	cfg.db.maxIdleConns = 3
+	db.SetMaxIdleConns(cfg.db.maxIdleConns)
+
+	_, _, err := warmupDB(db, cfg)
+	if !errors.Is(err, connector.err) {
+		t.Errorf("got error %v; want %v", err, connector.err)
+	}
+}
+
+func TestRetryWithBackoff(t *testing.T) {
+	const failures = 3
+	errUnavailable := errors.New("database unavailable")
+
+	calls := 0
+	var attempts []int
+	var delays []time.Duration
+
+	// The stub fails N-1 times, then succeeds.
+	err := retryWithBackoff(5, time.Millisecond, func() error {
+		calls++
+		if calls <= failures {
+			return errUnavailable
+		}
+		return nil
+	}, func(attempt int, err error, delay time.Duration) {
+		if !errors.Is(err, errUnavailable) {
+			t.Errorf("attempt %d: got error %v; want %v", attempt, err, errUnavailable)
+		}
+		attempts = append(attempts, attempt)
+		delays = append(delays, delay)
+	})
+	if err != nil {
+		t.Fatalf("got error %v; want nil", err)
+	}
+
+	if calls != failures+1 {
+		t.Errorf("got %d calls; want %d", calls, failures+1)
+	}
+	if want := []int{1, 2, 3}; !reflect.DeepEqual(attempts, want) {
+		t.Errorf("got retried attempts %v; want %v", attempts, want)
+	}
+	if want := []time.Duration{time.Millisecond, 2 * time.Millisecond, 4 * time.Millisecond}; !reflect.DeepEqual(delays, want) {
+		t.Errorf("got delays %v; want %v", delays, want)
+	}
+}
+
+func TestRetryWithBackoffGivesUp(t *testing.T) {
+	errUnavailable := errors.New("database unavailable")
+
+	calls := 0
+	err := retryWithBackoff(2, time.Millisecond, func() error {
+		calls++
+		return errUnavailable
+	}, func(int, error, time.Duration) {})
+
+	// The first attempt and 2 retries.
+	if !errors.Is(err, errUnavailable) || calls != 3 {
+		t.Errorf("got error %v after %d calls; want %v after 3", err, calls, errUnavailable)
+	}
+}
+
+func TestOpenDBWithRetryLogsAttempts(t *testing.T) {
+	var cfg config
+	cfg.db.dsn = "postgres://greenlight@localhost/greenlight"
+	cfg.db.maxIdleTime = "not a duration" // Makes every attempt fail before connecting
+	cfg.db.connectRetries = 2
+	cfg.db.connectBackoff = time.Millisecond
+
+	var buf bytes.Buffer
+	logger := jsonlog.New(&buf, jsonlog.LevelInfo)
+
+	_, err := openDBWithRetry(cfg, logger)
+	if err == nil {
+		t.Fatal("got no error")
+	}
+
+	if n := strings.Count(buf.String(), "unable to connect to the database, retrying"); n != 2 {
+		t.Errorf("got %d retries logged; want 2:\n%s", n, buf.String())
+	}
+}
+
+func TestQueryLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := jsonlog.New(&buf, jsonlog.LevelDebug)
+
+	queryLogger(logger, 0, "4bf92f3577b34da6a3ce929d0e0e4736")("movies.update", 1, 3*time.Millisecond)
+	queryLogger(logger, 0, "")("movies.get", -1, time.Millisecond)
+
+	type logEntry struct {
+		Level      string            `json:"level"`
+		Message    string            `json:"message"`
+		Properties map[string]string `json:"properties"`
+	}
+
+	var entries []logEntry
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var entry logEntry
+		if err := dec.Decode(&entry); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+
+	if len(entries) != 2 {
+		t.Fatalf("got %d log entries; want 2", len(entries))
+	}
+
+	update := entries[0]
+	if update.Level != "DEBUG" || update.Message != "query" {
+		t.Errorf("got a %s entry %q; want a DEBUG entry \"query\"", update.Level, update.Message)
+	}
+	want := map[string]string{"operation": "movies.update", "rows_affected": "1", "duration": "3ms", "trace_id": "4bf92f3577b34da6a3ce929d0e0e4736"}
+	if !reflect.DeepEqual(update.Properties, want) {
+		t.Errorf("got properties %v; want %v", update.Properties, want)
+	}
+
+	// A method which doesn't report its row count is logged without one, and a query made outside of a request
+	// without a trace ID.
+	if _, ok := entries[1].Properties["rows_affected"]; ok {
+		t.Errorf("got rows_affected %q for movies.get; want none", entries[1].Properties["rows_affected"])
+	}
+	if _, ok := entries[1].Properties["trace_id"]; ok {
+		t.Errorf("got trace_id %q for movies.get; want none", entries[1].Properties["trace_id"])
+	}
+}
+
+func TestModelsForRequest(t *testing.T) {
+	var buf bytes.Buffer
+
+	app := newTestApplication(t)
+	r := app.contextSetTraceID(httptest.NewRequest(http.MethodGet, "/v1/movies/1", nil), "4bf92f3577b34da6a3ce929d0e0e4736")
+
+	// The query log is only set at the DEBUG level.
+	if log := app.modelsFor(r).Movies.QueryLog; log != nil {
+		t.Error("got a query log at the INFO level; want none")
+	}
+
+	app.logger = jsonlog.New(&buf, jsonlog.LevelDebug)
+	app.modelsFor(r).Movies.QueryLog("movies.get", -1, time.Millisecond)
+
+	var entry struct {
+		Properties map[string]string `json:"properties"`
+	}
+	err := json.Unmarshal(buf.Bytes(), &entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := entry.Properties["trace_id"]; got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("got trace_id %q; want the trace ID of the request", got)
+	}
+
+	// The models of the application are left alone.
+	if app.models.Movies.QueryLog != nil {
+		t.Error("modelsFor() changed the models of the application")
+	}
+
+	// The slow queries are logged at the other levels too.
+	app.logger = jsonlog.New(&buf, jsonlog.LevelInfo)
+	app.config.db.slowQuery = time.Millisecond
+	if log := app.modelsFor(r).Movies.QueryLog; log == nil {
+		t.Error("got no query log with a slow query threshold; want one")
+	}
+}
+
+func TestQueryLoggerSlowQuery(t *testing.T) {
+	var buf bytes.Buffer
+	log := queryLogger(jsonlog.New(&buf, jsonlog.LevelInfo), 10*time.Millisecond, "")
+
+	log("movies.get", -1, time.Millisecond)
+	log("movies.get_all", -1, 15*time.Millisecond)
+
+	// Without the DEBUG level, only the query which took longer than the threshold is logged.
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("got %d log entries; want 1:\n%s", len(lines), buf.String())
+	}
+	for _, want := range []string{`"level":"WARN"`, `"message":"slow query"`, `"operation":"movies.get_all"`, `"duration":"15ms"`} {
+		if !strings.Contains(lines[0], want) {
+			t.Errorf("the log entry doesn't contain %s: %s", want, lines[0])
+		}
+	}
+}
@@ -1,100 +1,470 @@
 package main
 
 import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
 	"fmt"
-	"net"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/jseow5177/greenlight/internal/data"
+	"github.com/jseow5177/greenlight/internal/validator"
 	"golang.org/x/time/rate"
 )
 
-// rateLimit() middleware creates a new rate limiter and uses it for every request that it
-// subsequently handles.
-func (app *application) rateLimit(next http.Handler) http.Handler {
+// Define a routeLimit struct to hold the rate limiter settings for a route or group of routes.
+type routeLimit struct {
+	rps   float64 // Request per second limiter
+	burst int     // Burst value for limiter
+}
 
-	// Define a client struct to hold the rate limiter and last seen time of each client
-	type client struct {
-		limiter *rate.Limiter
-		lastSeen time.Time
-	}
+// Define a client struct to hold the rate limiter and last seen time of each client
+type client struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// privilegeTTL is how long rateLimiter remembers whether a token belongs to a privileged user. Granting or
+// revoking the -limiter-privileged-permission permission takes up to this long to apply to existing tokens.
+const privilegeTTL = time.Minute
+
+// Define a privilege struct to hold whether a token belongs to a privileged user, until the expiry time.
+type privilege struct {
+	privileged bool
+	expiry     time.Time
+}
 
-	var (
-		mu sync.Mutex
-		clients = make(map[string]*client)
-	)
+// Define a rateLimiter struct to hold the limiters of the clients, shared by the rateLimit() middleware, which
+// limits clients by IP address before they are authenticated, and the rateLimitPrivileged() middleware, which
+// limits privileged users by user ID after they are authenticated.
+// The privileges map remembers which bearer tokens belong to privileged users, keyed by the SHA-256 hash of the
+// token (as in the tokens table), so that rateLimit() can let their requests through without a database query.
+type rateLimiter struct {
+	mu         sync.Mutex
+	clients    map[string]*client
+	privileges map[[sha256.Size]byte]privilege
+}
+
+// newRateLimiter() returns an empty rateLimiter, and launches a background goroutine that removes old entries
+// from its maps once every cleanup interval (1 minute by default). This is to prevent the maps from growing
+// indefinitely.
+func (app *application) newRateLimiter() *rateLimiter {
+	rl := &rateLimiter{
+		clients:    make(map[string]*client),
+		privileges: make(map[[sha256.Size]byte]privilege),
+	}
 
-	// Launch a background goroutine that removes old entries from the clients map once
-	// every minute. This is to prevent the clients map from growing indefinitely.
 	go func() {
 		for {
-			time.Sleep(time.Minute)
+			time.Sleep(app.config.limiter.cleanupInterval)
 
 			// Lock the mutex to prevent any rate limiter checks from happening while
 			// the cleanup is taking place
-			mu.Lock()
+			rl.mu.Lock()
 
-			// Loop through all the clients. If they haven't been seen in the last 3 minutes,
+			// Loop through all the clients. If they haven't been seen within the idle TTL (3 minutes by default),
 			// delete the corresponding entry from the map.
-			for ip, client := range(clients) {
-				if time.Since(client.lastSeen) > 3 * time.Minute {
-					delete(clients, ip)
+			for key, client := range rl.clients {
+				if time.Since(client.lastSeen) > app.config.limiter.idleTTL {
+					delete(rl.clients, key)
+				}
+			}
+
+			for hash, p := range rl.privileges {
+				if time.Now().After(p.expiry) {
+					delete(rl.privileges, hash)
 				}
 			}
 
 			// Unlock the mutex when the cleanup is complete
-			mu.Unlock()
+			rl.mu.Unlock()
 		}
 	}()
 
-	// The function returned closes over the initialized limiter.
+	return rl
+}
+
+// reserve() takes a token from the limiter of the key, creating the limiter with the given limit if the key
+// hasn't been seen before. It reports whether the request is allowed, and if not, how long the client should
+// wait before retrying (0 if the limit can never be satisfied, e.g. with a burst of 0).
+func (rl *rateLimiter) reserve(key string, limit routeLimit) (bool, time.Duration) {
+	// Lock the mutex to prevent the following code from being executed concurrently
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	// Check if the key already exists in the map.
+	// If it doesn't, then initialize a new rate limiter and add the limiter to the map.
+	if _, found := rl.clients[key]; !found {
+		rl.clients[key] = &client{
+			limiter: rate.NewLimiter(rate.Limit(limit.rps), limit.burst),
+		}
+	}
+
+	// Update last seen
+	rl.clients[key].lastSeen = time.Now()
+
+	// limiter.Reserve() reserves one token for an event (request), and reports how long the event
+	// would have to wait for it. A zero delay means that the request can happen now, like Allow().
+	// Otherwise the reservation is cancelled, so that the rejected request doesn't use up a future token,
+	// and the delay tells the client how long to back off.
+	reservation := rl.clients[key].limiter.Reserve()
+	if !reservation.OK() {
+		return false, 0
+	}
+
+	if delay := reservation.Delay(); delay > 0 {
+		reservation.Cancel()
+		return false, delay
+	}
+
+	return true, 0
+}
+
+// cachedPrivilege() reports whether the token is known to belong to a privileged user, and whether the answer
+// was found in the cache at all.
+func (rl *rateLimiter) cachedPrivilege(token string) (privileged, found bool) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	p, found := rl.privileges[sha256.Sum256([]byte(token))]
+	if !found || time.Now().After(p.expiry) {
+		return false, false
+	}
+
+	return p.privileged, true
+}
+
+// cachePrivilege() remembers whether the token belongs to a privileged user for privilegeTTL.
+func (rl *rateLimiter) cachePrivilege(token string, privileged bool) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.privileges[sha256.Sum256([]byte(token))] = privilege{privileged: privileged, expiry: time.Now().Add(privilegeTTL)}
+}
+
+// bearerToken() returns the token of an "Authorization: Bearer <token>" header, or an empty string.
+func bearerToken(r *http.Request) string {
+	headerParts := strings.Split(r.Header.Get("Authorization"), " ")
+	if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+		return ""
+	}
+	return headerParts[1]
+}
+
+// rateLimit() middleware limits each client by IP address, using the limiters of rl.
+// The overrides map holds stricter (or looser) limits for specific routes, keyed by a route pattern in the
+// format "<METHOD> <path>" (e.g. "POST /v1/users"). Each client gets a separate limiter per overridden route,
+// while all the other routes share a single limiter per client using the global rps and burst from app config.
+// It runs before authenticate(), so that requests with made-up tokens are limited before they reach the
+// database. Requests with a token which rateLimitPrivileged() has found to belong to a privileged user skip the
+// IP-based limit, since they are limited by user ID instead.
+func (app *application) rateLimit(rl *rateLimiter, next http.Handler, overrides map[string]routeLimit) http.Handler {
+	// The function returned closes over the limiters of rl.
 	return http.HandlerFunc(func (w http.ResponseWriter, r *http.Request) {
-		// Only carry out the check if rate limiting is enabled
-		if app.config.limiter.enabled {
+		// Only carry out the check if rate limiting is enabled, and the route isn't exempt (like the health checks)
+		if app.config.limiter.enabled && !app.rateLimitExempt(r) {
+			if token := bearerToken(r); token != "" {
+				if privileged, _ := rl.cachedPrivilege(token); privileged {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
 			// Extract the client's IP address from the request
-			ip, _, err := net.SplitHostPort(r.RemoteAddr)
+			ip := app.clientIP(r)
+
+			// Fall back to the global limiter settings, unless the request matches a route with an override.
+			// The matched pattern is added to the map key so that the overridden routes don't share a
+			// limiter with the rest of the API.
+			key := ip
+			limit := routeLimit{rps: app.config.limiter.rps, burst: app.config.limiter.burst}
+
+			for pattern, override := range overrides {
+				if matchRoute(pattern, r) {
+					key = ip + " " + pattern
+					limit = override
+					break
+				}
+			}
+
+			if ok, delay := rl.reserve(key, limit); !ok {
+				app.rateLimitExceededResponse(w, r, delay)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimitPrivileged() middleware gives users with the -limiter-privileged-permission permission (such as
+// admins doing bulk operations) a limiter keyed on their user ID, with the privileged limit (or no limit at all).
+// It runs after authenticate(). The permissions it loads are kept in the request context for requirePermission(),
+// and whether the token is privileged is cached in rl, so that the following requests with the same token neither
+// trip the limit of their IP address nor use it up for the other clients behind it, and don't query the
+// permissions again for privilegeTTL.
+func (app *application) rateLimitPrivileged(rl *rateLimiter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func (w http.ResponseWriter, r *http.Request) {
+		if !app.config.limiter.enabled || app.rateLimitExempt(r) || app.config.limiter.privilegedPermission == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user := app.contextGetUser(r)
+		if user.IsAnonymous() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Tokens sent in the access_token query parameter have already been removed from the URL by
+		// authenticate(), so only bearer tokens in the Authorization header are cached.
+		token := bearerToken(r)
+
+		privileged, found := rl.cachedPrivilege(token)
+		if !found {
+			permissions, err := app.userPermissions(r)
 			if err != nil {
 				app.serverErrorResponse(w, r, err)
 				return
 			}
 
-			// Lock the mutex to prevent the following code from being executed concurrently
-			mu.Lock()
+			r = app.contextSetPermissions(r, permissions)
 
-			// Check if IP address already exists in the map.
-			// If it doesn't, then initialize a new rate limiter and add the limiter to the map 
-			// with the IP address as the key.
-			if _, found := clients[ip]; !found {
-				clients[ip] = &client{
-					// Use limiter rps and burst from app config
-					limiter: rate.NewLimiter(rate.Limit(app.config.limiter.rps), app.config.limiter.burst),
-				}
+			privileged = permissions.Include(app.config.limiter.privilegedPermission)
+			if token != "" {
+				rl.cachePrivilege(token, privileged)
+			}
+		}
+
+		if privileged && app.config.limiter.privilegedRPS > 0 {
+			key := fmt.Sprintf("user:%d", user.ID)
+			limit := routeLimit{rps: app.config.limiter.privilegedRPS, burst: app.config.limiter.privilegedBurst}
+
+			if ok, delay := rl.reserve(key, limit); !ok {
+				app.rateLimitExceededResponse(w, r, delay)
+				return
 			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimitExempt() reports whether the request matches one of the routes exempt from rate limiting.
+func (app *application) rateLimitExempt(r *http.Request) bool {
+	for _, pattern := range app.config.limiter.exempt {
+		if matchRoute(pattern, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchRoute() reports whether a request matches a route pattern in the format "<METHOD> <path>".
+// Path segments starting with ":" are named parameters and match any single segment, like in httprouter.
+func matchRoute(pattern string, r *http.Request) bool {
+	parts := strings.SplitN(pattern, " ", 2)
+	if len(parts) != 2 || parts[0] != r.Method {
+		return false
+	}
+
+	patternSegments := strings.Split(parts[1], "/")
+	pathSegments := strings.Split(r.URL.Path, "/")
+
+	if len(patternSegments) != len(pathSegments) {
+		return false
+	}
+
+	for i := range patternSegments {
+		if !strings.HasPrefix(patternSegments[i], ":") && patternSegments[i] != pathSegments[i] {
+			return false
+		}
+	}
+
+	return true
+}
 
-			// Update last seen
-			clients[ip].lastSeen = time.Now()
+// authenticate() middleware reads the bearer token from the Authorization header and adds the
+// corresponding user to the request context. Requests without an Authorization header are given the
+// AnonymousUser, while requests with an invalid or expired token get a 401 Unauthorized response.
+// On the routes listed with -query-token-routes, the token can be sent in the access_token query parameter
+// instead. The Authorization header takes precedence if both are sent.
+func (app *application) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The response varies depending on the value of the Authorization header, so tell any caches
+		// between us and the client.
+		addVary(w, "Authorization")
 
-			// limiter.Allow() checks if one event (request) can happen now.
-			// It consumes one token.
-			// If no token is available, it returns false.
-			// Note that Allow() is protected by a mutex and safe for concurrent use.
-			if !clients[ip].limiter.Allow() {
-				mu.Unlock() // Unlock the mutex
-				app.rateLimitExceededResponse(w, r)
+		authorizationHeader := r.Header.Get("Authorization")
+
+		// Take the token out of the URL straight away, so that it never ends up in a log entry, even if it
+		// isn't used.
+		if queryToken, ok := removeQueryToken(r); ok && authorizationHeader == "" {
+			if !app.queryTokenAllowed(r) {
+				app.invalidAuthenticationTokenResponse(w, r)
 				return
 			}
+			authorizationHeader = "Bearer " + queryToken
+		}
+
+		if authorizationHeader == "" {
+			r = app.contextSetUser(r, data.AnonymousUser)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// The header is expected to be in the format "Bearer <token>".
+		headerParts := strings.Split(authorizationHeader, " ")
+
+		// Basic credentials are checked by requireBasicAuth() on the routes which use them, so the user
+		// is left anonymous.
+		if len(headerParts) == 2 && headerParts[0] == "Basic" {
+			r = app.contextSetUser(r, data.AnonymousUser)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		token := headerParts[1]
+
+		v := validator.New()
+
+		if data.ValidateTokenPlaintext(v, token, app.config.tokenEntropy); !v.Valid() {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		user, err := app.modelsFor(r).Users.GetForToken(data.ScopeAuthentication, token)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.invalidAuthenticationTokenResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		r = app.contextSetUser(r, user)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireBasicAuth() middleware sends a 401 Unauthorized response unless the request carries the
+// -metrics-username and -metrics-password as basic authentication credentials. The credentials are hashed
+// before being compared in constant time, so the comparison doesn't leak their length or content.
+func (app *application) requireBasicAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok {
+			app.basicAuthRequiredResponse(w, r)
+			return
+		}
+
+		usernameHash := sha256.Sum256([]byte(username))
+		passwordHash := sha256.Sum256([]byte(password))
+		expectedUsernameHash := sha256.Sum256([]byte(app.config.metrics.username))
+		expectedPasswordHash := sha256.Sum256([]byte(app.config.metrics.password))
+
+		usernameMatch := subtle.ConstantTimeCompare(usernameHash[:], expectedUsernameHash[:]) == 1
+		passwordMatch := subtle.ConstantTimeCompare(passwordHash[:], expectedPasswordHash[:]) == 1
+
+		if !usernameMatch || !passwordMatch {
+			app.basicAuthRequiredResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// queryTokenParam is the query parameter which carries the token on the routes listed with -query-token-routes.
+const queryTokenParam = "access_token"
+
+// removeQueryToken() removes the access_token parameter from the request URL, and returns its value and
+// whether it was present.
+func removeQueryToken(r *http.Request) (string, bool) {
+	qs := r.URL.Query()
+	if _, ok := qs[queryTokenParam]; !ok {
+		return "", false
+	}
+
+	token := qs.Get(queryTokenParam)
+	qs.Del(queryTokenParam)
+
+	u := *r.URL
+	u.RawQuery = qs.Encode()
+	r.URL = &u
+	r.RequestURI = u.RequestURI()
 
-			// Unlock the mutex before calling the next handler in the chain.
-			// Importantly, DO NOT defer the unlock of mutex.
-			// Else, the mutex will not be unlocked until all the downstream handlers of this middleware have returned.
-			mu.Unlock()
+	return token, true
+}
+
+// queryTokenAllowed() reports whether the request matches one of the routes listed with -query-token-routes.
+func (app *application) queryTokenAllowed(r *http.Request) bool {
+	for _, pattern := range app.config.queryTokenRoutes {
+		if matchRoute(pattern, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// requireAuthenticatedUser() middleware sends a 401 Unauthorized response to anonymous clients.
+func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+
+		if user.IsAnonymous() {
+			app.authenticationRequiredResponse(w, r)
+			return
 		}
 
 		next.ServeHTTP(w, r)
 	})
 }
 
+// userPermissions() returns the permissions of the user in the request context, reusing the ones loaded by an
+// earlier middleware if there are any.
+func (app *application) userPermissions(r *http.Request) (data.Permissions, error) {
+	if permissions, ok := app.contextGetPermissions(r); ok {
+		return permissions, nil
+	}
+
+	return app.modelsFor(r).Permissions.GetAllForUser(app.contextGetUser(r).ID)
+}
+
+// requirePermission() middleware checks that the authenticated user has the given permission code
+// (e.g. "movies:write"). Anonymous users get a 401 Unauthorized response, and users without the
+// permission get a 403 Forbidden response.
+func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		permissions, err := app.userPermissions(r)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !permissions.Include(code) {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+
+	// Wrap with requireAuthenticatedUser() so that the user is checked before their permissions are looked up.
+	return app.requireAuthenticatedUser(fn)
+}
+
 // recoverPanic() middleware recovers a panic in a go routine to
 // return a 500 Internal Server Error response to the client
 func (app *application) recoverPanic(next http.Handler) http.Handler {
@@ -107,12 +477,67 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 				// This acts as a trigger to make Go's HTTP server automatically close the current connection
 				// after a response has been sent.
 				w.Header().Set("Connection", "close")
-				// Use fmt.Errorf() to normalize err into an error and call the serverErrorResponse() helper.
-				// This will log the error at the ERROR level and send the client a 500 Internal Server Error.
-				app.serverErrorResponse(w, r, fmt.Errorf("%s", err))
+				// Call the panicResponse() helper, which logs the recovered value and its type at the ERROR level
+				// and sends the client a 500 Internal Server Error.
+				app.panicResponse(w, r, err)
 			}
 		}()
 
 		next.ServeHTTP(w, r)
 	})
-}
\ No newline at end of file
+}
+
+// limitURI() middleware rejects requests with a very long URI, or with too many query parameters, before the
+// query string is parsed by a handler. Huge ids= lists or genres filters would otherwise cost parsing time and
+// make expensive database queries.
+// Go's HTTP server already limits the size of the request line and headers together (1MB by default), so this
+// is a tighter limit on the URI alone. The parameters are counted without parsing the query string, by counting
+// the separators, so a repeated key such as ids=1&ids=2 counts once for each value.
+func (app *application) limitURI(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.config.maxURILength > 0 && len(r.RequestURI) > app.config.maxURILength {
+			metrics.Add("uri_rejected", 1)
+			app.uriTooLongResponse(w, r)
+			return
+		}
+
+		if app.config.maxQueryParams > 0 && r.URL.RawQuery != "" {
+			if strings.Count(r.URL.RawQuery, "&")+1 > app.config.maxQueryParams {
+				metrics.Add("uri_rejected", 1)
+				app.tooManyQueryParamsResponse(w, r)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// hstsMaxAge is the number of seconds (2 years) for which clients should only use HTTPS to reach the API.
+const hstsMaxAge = 63072000
+
+// enforceHTTPS() middleware is for deployments which terminate TLS at a proxy. The proxy tells us the
+// scheme of the original request with the X-Forwarded-Proto header. Plain HTTP requests are redirected to
+// the HTTPS URL, and HTTPS responses get a Strict-Transport-Security header.
+// The header can be forged by clients connecting directly, so it is only trusted with -trust-proxy-header.
+// Without the flag, direct-HTTP development setups are left untouched.
+func (app *application) enforceHTTPS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.config.trustProxy {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		switch strings.ToLower(r.Header.Get("X-Forwarded-Proto")) {
+		case "http":
+			// Use a 308 Permanent Redirect rather than a 301, so that the method and body are kept.
+			target := "https://" + r.Host + r.URL.RequestURI()
+			http.Redirect(w, r, target, http.StatusPermanentRedirect)
+			return
+		case "https":
+			w.Header().Set("Strict-Transport-Security", fmt.Sprintf("max-age=%d; includeSubDomains", hstsMaxAge))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jseow5177/greenlight/internal/jsonlog"
+)
+
+func TestMatchRoute(t *testing.T) {
+	tests := []struct {
+		pattern string
+		method  string
+		path    string
+		want    bool
+	}{
+		{"POST /v1/users", http.MethodPost, "/v1/users", true},
+		{"POST /v1/users", http.MethodGet, "/v1/users", false},
+		{"GET /v1/movies/:id", http.MethodGet, "/v1/movies/42", true},
+		{"GET /v1/movies/:id", http.MethodGet, "/v1/movies/42/reviews", false},
+		{"GET /v1/movies/:id", http.MethodGet, "/v1/movies", false},
+		{"GET", http.MethodGet, "/v1/movies", false},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		if got := matchRoute(tt.pattern, r); got != tt.want {
+			t.Errorf("matchRoute(%q, %s %s) = %t; want %t", tt.pattern, tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestRateLimitRunsBeforeAuthentication(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.limiter.enabled = true
+	h := app.routes()
+
+	// Made-up tokens must use up the limit of the IP address, like any other request, before authenticate()
+	// gets to reject them.
+	for i := 0; i < app.config.limiter.burst; i++ {
+		rr := do(t, h, http.MethodGet, "/v1/webhooks", "made-up", nil)
+		if rr.Code != http.StatusUnauthorized {
+			t.Fatalf("request %d: got status %d; want %d", i+1, rr.Code, http.StatusUnauthorized)
+		}
+	}
+
+	rr := do(t, h, http.MethodGet, "/v1/webhooks", "made-up", nil)
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("got status %d; want %d", rr.Code, http.StatusTooManyRequests)
+	}
+}
+
+// allowedRequests() sends n requests back to back, and returns the number which weren't rate limited.
+func allowedRequests(t *testing.T, h http.Handler, method, url string, n int) int {
+	t.Helper()
+
+	allowed := 0
+	for i := 0; i < n; i++ {
+		rr := do(t, h, method, url, "", nil)
+		if rr.Code != http.StatusTooManyRequests {
+			allowed++
+		}
+	}
+
+	return allowed
+}
+
+func TestRateLimitRouteOverrides(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.limiter.enabled = true
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	h := app.rateLimit(app.newRateLimiter(), next, map[string]routeLimit{
+		"POST /v1/tokens/authentication": {rps: 0.2, burst: 2},
+	})
+
+	if got := allowedRequests(t, h, http.MethodPost, "/v1/tokens/authentication", 10); got != 2 {
+		t.Errorf("auth route: got %d allowed requests; want 2", got)
+	}
+
+	// The overridden route has a limiter of its own, so the other routes still get the whole global burst.
+	if got := allowedRequests(t, h, http.MethodGet, "/v1/movies", 10); got != app.config.limiter.burst {
+		t.Errorf("read route: got %d allowed requests; want %d", got, app.config.limiter.burst)
+	}
+}
+
+func TestRateLimitAuthRoutes(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.limiter.enabled = true
+	app.config.limiter.authBurst = 1
+	h := app.routes()
+
+	// At the same request rate, the authentication route is rejected sooner than the read route.
+	auth := allowedRequests(t, h, http.MethodPost, "/v1/tokens/authentication", 10)
+	read := allowedRequests(t, h, http.MethodGet, "/v1/webhooks", 10)
+
+	if auth != app.config.limiter.authBurst {
+		t.Errorf("auth route: got %d allowed requests; want %d", auth, app.config.limiter.authBurst)
+	}
+	// The email availability check could be used to enumerate the registered addresses, so it gets the
+	// stricter limit too.
+	if got := allowedRequests(t, h, http.MethodGet, "/v1/users/email-available", 10); got != app.config.limiter.authBurst {
+		t.Errorf("email availability route: got %d allowed requests; want %d", got, app.config.limiter.authBurst)
+	}
+	if read != app.config.limiter.burst {
+		t.Errorf("read route: got %d allowed requests; want %d", read, app.config.limiter.burst)
+	}
+}
+
+func TestRateLimiterPrivilegeCache(t *testing.T) {
+	app := newTestApplication(t)
+	rl := app.newRateLimiter()
+
+	if _, found := rl.cachedPrivilege("token"); found {
+		t.Fatal("unknown token found in the cache")
+	}
+
+	rl.cachePrivilege("token", true)
+	if privileged, found := rl.cachedPrivilege("token"); !found || !privileged {
+		t.Errorf("got privileged %t, found %t; want true, true", privileged, found)
+	}
+
+	rl.cachePrivilege("other", false)
+	if privileged, found := rl.cachedPrivilege("other"); !found || privileged {
+		t.Errorf("got privileged %t, found %t; want false, true", privileged, found)
+	}
+}
+
+func TestRateLimitPrivilegedUsers(t *testing.T) {
+	app := newTestApplicationWithDB(t)
+	app.config.limiter.enabled = true
+	h := app.routes()
+
+	_, adminToken := newTestUser(t, app, "admin@example.com", "webhooks:read", "ratelimit:exempt")
+	_, userToken := newTestUser(t, app, "user@example.com", "webhooks:read")
+
+	// The admin's first request is limited by IP address, and uses a token of it. After that, the token is known
+	// to be privileged, and isn't limited at all by default.
+	for i := 0; i < 3*app.config.limiter.burst; i++ {
+		rr := do(t, h, http.MethodGet, "/v1/webhooks", adminToken, nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("privileged request %d: got status %d; want %d", i+1, rr.Code, http.StatusOK)
+		}
+	}
+
+	for i := 0; i < app.config.limiter.burst-1; i++ {
+		rr := do(t, h, http.MethodGet, "/v1/webhooks", userToken, nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("request %d: got status %d; want %d", i+1, rr.Code, http.StatusOK)
+		}
+	}
+
+	rr := do(t, h, http.MethodGet, "/v1/webhooks", userToken, nil)
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("got status %d; want %d", rr.Code, http.StatusTooManyRequests)
+	}
+}
+
+func TestLimitURI(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.maxURILength = 64
+	app.config.maxQueryParams = 3
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	h := app.limitURI(next)
+
+	tests := []struct {
+		name string
+		url  string
+		want int
+	}{
+		{"normal", "/v1/movies?title=moana&page=2", http.StatusOK},
+		{"no query", "/v1/movies", http.StatusOK},
+		{"at the parameter limit", "/v1/movies?a=1&b=2&c=3", http.StatusOK},
+		{"too many parameters", "/v1/movies?a=1&b=2&c=3&d=4", http.StatusBadRequest},
+		{"over-long URI", "/v1/movies?ids=" + strings.Repeat("1,", 30), http.StatusRequestURITooLong},
+	}
+
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tt.url, nil))
+
+		if rr.Code != tt.want {
+			t.Errorf("%s: got status %d; want %d", tt.name, rr.Code, tt.want)
+		}
+	}
+}
+
+func TestEnforceHTTPS(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name       string
+		trustProxy bool
+		proto      string
+		wantStatus int
+		wantHSTS   bool
+	}{
+		{"redirect on http", true, "http", http.StatusPermanentRedirect, false},
+		{"no redirect on https", true, "https", http.StatusOK, true},
+		{"no header", true, "", http.StatusOK, false},
+		{"header not trusted", false, "http", http.StatusOK, false},
+	}
+
+	for _, tt := range tests {
+		app := newTestApplication(t)
+		app.config.trustProxy = tt.trustProxy
+
+		r := httptest.NewRequest(http.MethodPost, "http://api.example.com/v1/movies?page=2", nil)
+		if tt.proto != "" {
+			r.Header.Set("X-Forwarded-Proto", tt.proto)
+		}
+		rr := httptest.NewRecorder()
+		app.enforceHTTPS(next).ServeHTTP(rr, r)
+
+		if rr.Code != tt.wantStatus {
+			t.Errorf("%s: got status %d; want %d", tt.name, rr.Code, tt.wantStatus)
+		}
+		if tt.wantStatus == http.StatusPermanentRedirect {
+			if got, want := rr.Header().Get("Location"), "https://api.example.com/v1/movies?page=2"; got != want {
+				t.Errorf("%s: got Location %q; want %q", tt.name, got, want)
+			}
+		}
+		if hsts := rr.Header().Get("Strict-Transport-Security") != ""; hsts != tt.wantHSTS {
+			t.Errorf("%s: got HSTS %t; want %t", tt.name, hsts, tt.wantHSTS)
+		}
+	}
+}
+
+func TestRateLimiterCleanup(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.limiter.cleanupInterval = 10 * time.Millisecond
+	app.config.limiter.idleTTL = 50 * time.Millisecond
+
+	rl := app.newRateLimiter()
+	limit := routeLimit{rps: 1000, burst: 1000}
+
+	has := func(key string) bool {
+		rl.mu.Lock()
+		defer rl.mu.Unlock()
+		_, ok := rl.clients[key]
+		return ok
+	}
+
+	rl.reserve("stale", limit)
+	start := time.Now()
+
+	// Keep the active client busy until the stale one is evicted.
+	deadline := time.Now().Add(5 * time.Second)
+	for has("stale") {
+		if time.Now().After(deadline) {
+			t.Fatal("the stale client wasn't evicted")
+		}
+		rl.reserve("active", limit)
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if elapsed := time.Since(start); elapsed < app.config.limiter.idleTTL {
+		t.Errorf("the stale client was evicted after %s; want at least the idle TTL of %s", elapsed, app.config.limiter.idleTTL)
+	}
+	if !has("active") {
+		t.Error("the active client was evicted")
+	}
+}
+
+func TestRateLimitRetryAfter(t *testing.T) {
+	for _, rps := range []float64{2, 0.2} {
+		app := newTestApplication(t)
+		app.config.limiter.enabled = true
+		app.config.limiter.rps = rps
+		h := app.routes()
+
+		// Drain the bucket.
+		allowedRequests(t, h, http.MethodGet, "/v1/webhooks", app.config.limiter.burst)
+
+		rr := do(t, h, http.MethodGet, "/v1/webhooks", "", nil)
+		if rr.Code != http.StatusTooManyRequests {
+			t.Fatalf("rps %g: got status %d; want %d", rps, rr.Code, http.StatusTooManyRequests)
+		}
+
+		// The next token is available within 1/rps seconds, rounded up.
+		seconds, err := strconv.Atoi(rr.Header().Get("Retry-After"))
+		if max := int(math.Ceil(1 / rps)); err != nil || seconds < 1 || seconds > max {
+			t.Errorf("rps %g: got Retry-After %q; want 1 to %d", rps, rr.Header().Get("Retry-After"), max)
+		}
+	}
+}
+
+func TestRateLimiterReserveCancels(t *testing.T) {
+	app := newTestApplication(t)
+	rl := app.newRateLimiter()
+	limit := routeLimit{rps: 1, burst: 1}
+
+	if allowed, _ := rl.reserve("key", limit); !allowed {
+		t.Fatal("the first request was rejected")
+	}
+
+	// Rejected requests don't use up future tokens, so the delay doesn't grow with each of them.
+	var delays []time.Duration
+	for i := 0; i < 5; i++ {
+		allowed, delay := rl.reserve("key", limit)
+		if allowed {
+			t.Fatalf("request %d was allowed", i+2)
+		}
+		delays = append(delays, delay)
+	}
+
+	if first, last := delays[0], delays[len(delays)-1]; last <= 0 || last > first {
+		t.Errorf("got delays %v; want them positive and not growing", delays)
+	}
+
+	// A limit which can never be satisfied has no delay to suggest.
+	if allowed, delay := rl.reserve("zero", routeLimit{rps: 1, burst: 0}); allowed || delay != 0 {
+		t.Errorf("burst 0: got allowed %t, delay %s; want false, 0", allowed, delay)
+	}
+}
+
+func TestRateLimitExempt(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.limiter.enabled = true
+	app.config.limiter.exempt = routePatterns("GET /v1/healthcheck, GET /v1/readyz")
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	h := app.rateLimit(app.newRateLimiter(), next, nil)
+
+	// Every request comes from the same IP address, as from a load balancer.
+	for _, url := range []string{"/v1/healthcheck", "/v1/readyz"} {
+		if got := allowedRequests(t, h, http.MethodGet, url, 50); got != 50 {
+			t.Errorf("%s: got %d allowed requests; want 50", url, got)
+		}
+	}
+
+	if got := allowedRequests(t, h, http.MethodGet, "/v1/movies", 50); got != app.config.limiter.burst {
+		t.Errorf("/v1/movies: got %d allowed requests; want %d", got, app.config.limiter.burst)
+	}
+}
+
+// testPanic is a custom type to panic with, so that its type name can be found in the log.
+type testPanic struct {
+	code int
+}
+
+func TestRecoverPanic(t *testing.T) {
+	app := newTestApplication(t)
+
+	var buf bytes.Buffer
+	app.logger = jsonlog.New(&buf, jsonlog.LevelInfo)
+
+	h := app.recoverPanic(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(testPanic{code: 42})
+	}))
+
+	rr := do(t, h, http.MethodGet, "/v1/movies", "", nil)
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d; want %d", rr.Code, http.StatusInternalServerError)
+	}
+	if got := rr.Header().Get("Connection"); got != "close" {
+		t.Errorf("got Connection %q; want %q", got, "close")
+	}
+
+	var entry struct {
+		Level      string            `json:"level"`
+		Properties map[string]string `json:"properties"`
+		Trace      string            `json:"trace"`
+	}
+	err := json.Unmarshal(buf.Bytes(), &entry)
+	if err != nil {
+		t.Fatalf("%v: %s", err, buf.Bytes())
+	}
+
+	if entry.Level != "ERROR" {
+		t.Errorf("got level %q; want ERROR", entry.Level)
+	}
+	if got := entry.Properties["panic_type"]; got != "main.testPanic" {
+		t.Errorf("got panic_type %q; want %q", got, "main.testPanic")
+	}
+	if got := entry.Properties["panic_value"]; got != "{42}" {
+		t.Errorf("got panic_value %q; want %q", got, "{42}")
+	}
+	// The trace is taken while the panic is recovered, so it includes the handler which panicked.
+	if !strings.Contains(entry.Trace, "TestRecoverPanic") {
+		t.Errorf("the trace doesn't include the panicking handler:\n%s", entry.Trace)
+	}
+}
+
+func TestRemoveQueryToken(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/v1/movies?access_token=ABCDEFGHIJKLMNOPQRSTUVWXYZ&page=2", nil)
+
+	token, ok := removeQueryToken(r)
+	if !ok || token != "ABCDEFGHIJKLMNOPQRSTUVWXYZ" {
+		t.Errorf("got token %q, %t; want the access_token", token, ok)
+	}
+
+	// The token is gone from everything which could be logged.
+	if r.URL.String() != "/v1/movies?page=2" || r.RequestURI != "/v1/movies?page=2" {
+		t.Errorf("got URL %q and request URI %q; want the token removed", r.URL, r.RequestURI)
+	}
+
+	if _, ok := removeQueryToken(httptest.NewRequest(http.MethodGet, "/v1/movies?page=2", nil)); ok {
+		t.Error("found a token in a URL without one")
+	}
+}
+
+func TestQueryTokenNotAllowed(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.queryTokenRoutes = []string{"GET /v1/users/me"}
+
+	// A well-formed token is rejected before it is looked up, since the route doesn't accept query tokens.
+	rr := do(t, app.routes(), http.MethodGet, "/v1/movies?access_token="+strings.Repeat("A", 26), "", nil)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("got status %d; want %d", rr.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestQueryTokenAuthentication(t *testing.T) {
+	app := newTestApplicationWithDB(t)
+	app.config.queryTokenRoutes = []string{"GET /v1/users/me"}
+	h := app.routes()
+
+	_, token := newTestUser(t, app, "alice@example.com")
+
+	tests := []struct {
+		name  string
+		url   string
+		token string // Sent in the Authorization header
+		want  int
+	}{
+		{"header", "/v1/users/me", token, http.StatusOK},
+		{"query on an allowed route", "/v1/users/me?access_token=" + token, "", http.StatusOK},
+		{"query on another route", "/v1/users/me/movies?access_token=" + token, "", http.StatusUnauthorized},
+		{"header takes precedence", "/v1/users/me?access_token=" + strings.Repeat("A", 26), token, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		rr := do(t, h, http.MethodGet, tt.url, tt.token, nil)
+		if rr.Code != tt.want {
+			t.Errorf("%s: got status %d; want %d: %s", tt.name, rr.Code, tt.want, rr.Body)
+		}
+	}
+}
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/csv"
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/jseow5177/greenlight/internal/data"
 	"github.com/jseow5177/greenlight/internal/validator"
@@ -16,6 +19,8 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	var input struct {
 		Title string
 		Genres []string
+		Tags []string
+		Quality data.QualityFilters
 		data.Filters
 	}
 
@@ -25,6 +30,12 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	// To get the url.Values map containing the query string data
 	qs := r.URL.Query()
 
+	// If the client asks for specific movies with the ids parameter, fetch just those movies.
+	if qs.Get("ids") != "" {
+		app.listManyMoviesHandler(w, r)
+		return
+	}
+
 	// Extract title from query string value
 	// Defaults to empty string
 	input.Title = app.readString(qs, "title", "")
@@ -37,84 +48,269 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	// Defaults to empty slice
 	input.Genres = app.readCSV(qs, "genres", []string{})
 
+	// Extract tags from query string value
+	// Defaults to empty slice
+	input.Tags = app.readCSV(qs, "tags", []string{})
+
+	// Extract the data-quality filters, which find movies with incomplete data
+	// Neither filters anything by default
+	input.Quality.HasPoster = app.readBool(qs, "has_poster", v)
+	input.Quality.MinGenres = app.readInt(qs, "min_genres", 0, v)
+
 	// Extract page and page_size from query string values as integers
-	// page defaults to 1, while page_size defaults to 20
+	// page defaults to 1, while page_size defaults to -default-page-size (20 unless changed)
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
-	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", app.config.defaultPageSize, v)
+	input.Filters.MaxPageSize = app.config.maxPageSize
+	input.Filters.MaxOffset = app.config.maxOffset
 
 	// Add the supported sort values for this endpoint to sort safelist
-	input.Filters.SortSafeList = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+	// They are listed by OPTIONS /v1/movies, and can be narrowed with -movie-sort-fields
+	input.Filters = input.Filters.WithSortSafeList(data.MovieSortSafeList(app.config.movieSortFields)...)
 
 	// Execute the validation checks on the Filters struct and send a response
 	// containing the errors if necessary
+	data.ValidateQualityFilters(v, input.Quality)
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	// Clients which accept newline-delimited JSON or CSV get every matching movie streamed to them.
+	// The representation depends on the Accept header, so caches must key on it.
+	addVary(w, "Accept")
+	switch {
+	case accepts(r, "application/x-ndjson"):
+		app.exportMovies(w, r, input.Title, input.Genres, input.Tags, input.Quality, input.Filters, &ndjsonMovieEncoder{app: app, w: w})
+		return
+	case accepts(r, "text/csv"):
+		app.exportMovies(w, r, input.Title, input.Genres, input.Tags, input.Quality, input.Filters, &csvMovieEncoder{w: csv.NewWriter(w)})
 		return
 	}
 
 	// Call the GetAll() method to retrieve the movies, passing in the various filter parameters
-	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	// An unsafe sort value is a client error, so send a 422 Unprocessable Entity response
+	movies, metadata, err := app.modelsFor(r).Movies.GetAll(input.Title, input.Genres, input.Tags, input.Quality, input.Filters)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInvalidSort):
+			v.AddError("sort", "invalid sort value")
+			app.failedValidationResponse(w, r, v)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Bound the size of the response by truncating the genres of each movie with -list-max-genres.
+	// GET /v1/movies/:id still returns every genre. This happens before the ETag is computed, so that changing the
+	// limit changes the ETag of the pages it affects.
+	for _, movie := range movies {
+		movie.TruncateGenres(app.config.listMaxGenres)
+	}
+
+	// Send a 304 Not Modified response, without a body, if the client already has this page.
+	// This makes polling the list cheap when nothing has changed.
+	// The JSON:API document is a different representation of the same page, so it gets a different ETag.
+	etag := moviesETag(movies, metadata, wantsJSONAPI(r))
+	w.Header().Set("ETag", etag)
+
+	if ifNoneMatch(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	// Clients which accept JSON:API get the page as a JSON:API document, with the pagination in "meta" and "links".
+	if wantsJSONAPI(r) {
+		err = app.writeJSONAPIMovies(w, r, movies, metadata)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Send a JSON response containing the movies data.
+	// The list can be large, so it is streamed to the client rather than buffered in memory.
+	// By the time an encoding error occurs the status code has already been sent, so we can only log it.
+	err = app.writeJSONStream(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// listManyMoviesHandler() handles "GET /v1/movies?ids=1,3,7" requests, which fetch several movies by ID
+// in a single call. IDs which don't match a movie are omitted from the movies, and reported in the warnings.
+func (app *application) listManyMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	ids := app.readIDs(r.URL.Query(), "ids", data.MovieIDs, v)
+
+	v.Checkf(len(ids) <= app.config.maxIDs, "ids", "must not contain more than %d values", app.config.maxIDs)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	movies, err := app.modelsFor(r).Movies.GetMany(ids)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	// Send a JSON response containing the movies data
-	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+	found := make(map[int64]bool, len(movies))
+	for _, movie := range movies {
+		found[movie.ID] = true
+	}
+
+	var ws warnings
+	for _, id := range ids {
+		if !found[id] {
+			ws.Add("movie %v not found", data.MovieIDs.Encode(id))
+			// Only report each missing ID once, even if it was requested more than once.
+			found[id] = true
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, app.withWarnings(w, r, envelope{"movies": movies}, ws), nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
-// Add a createMovieHandler for "POST /v1/movies"
-func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Request) {
-	// Declare an anonymous struct to hold the information that we expect to be in the HTTP request body.
-	// This struct will be our *target decode destination*.
-	// The struct fields must start with a capital letter so that they are exported.
-	var input struct {
-		Title string `json:"title"`
-		Year int32 `json:"year"`
-		Runtime data.Runtime `json:"runtime"`
-		Genres []string `json:"genres"`
+// movieFilters describes the query parameters which filter GET /v1/movies, for the OPTIONS response.
+var movieFilters = []envelope{
+	{"name": "title", "type": "string", "description": "Full-text search on the title"},
+	{"name": "genres", "type": "string", "description": "Comma-separated genres which the movies must all have"},
+	{"name": "tags", "type": "string", "description": "Comma-separated tags which the movies must all have"},
+	{"name": "has_poster", "type": "boolean", "description": "Only movies with (true) or without (false) a poster"},
+	{"name": "min_genres", "type": "integer", "description": "Only movies with fewer genres than this"},
+	{"name": "ids", "type": "string", "description": "Comma-separated IDs of the movies to fetch, instead of filtering"},
+}
+
+// Add a movieOptionsHandler for "OPTIONS /v1/movies"
+// It describes how GET /v1/movies can be sorted, filtered and paginated, so that clients can discover it
+// instead of hard-coding it. The values come from the same settings which the list handler checks.
+func (app *application) movieOptionsHandler(w http.ResponseWriter, r *http.Request) {
+	headers := make(http.Header)
+	headers.Set("Allow", "GET, POST, OPTIONS")
+
+	options := envelope{
+		"sort":         data.MovieSortSafeList(app.config.movieSortFields),
+		"default_sort": "id",
+		"filters":      movieFilters,
+		"pagination": envelope{
+			"default_page_size": app.config.defaultPageSize,
+			"max_page_size":     app.config.maxPageSize,
+			"max_offset":        app.config.maxOffset,
+		},
 	}
 
-	// Initialize a new json.Decoder instance which reads from the request body.
-	// Use the Decode() method to decode the body contents into the input struct.
-	// A pointer to the input struct is passed into Decode().
-	err := app.readJSON(w, r, &input)
+	err := app.writeJSON(w, http.StatusOK, envelope{"options": options}, headers)
 	if err != nil {
-		app.badRequestResponse(w, r, err)
-		return
+		app.serverErrorResponse(w, r, err)
 	}
+}
+
+// genreLimits() returns the bounds on the number of genres of a movie set with -min-genres and -max-genres.
+func (app *application) genreLimits() data.GenreLimits {
+	return data.GenreLimits{Min: app.config.minGenres, Max: app.config.maxGenres}
+}
 
-	// Copy the values from the input struct to the new Movie struct
-	movie := &data.Movie{
-		Title: input.Title,
-		Year: input.Year,
+// Declare a createMovieInput struct to hold the information that we expect to be in the HTTP request body
+// of "POST /v1/movies". This struct is our *target decode destination*.
+// The struct fields must start with a capital letter so that they are exported.
+type createMovieInput struct {
+	Title   string       `json:"title"`
+	Year    int32        `json:"year"`
+	Runtime data.Runtime `json:"runtime"`
+	Genres  []string     `json:"genres"`
+	Tags    []string     `json:"tags"`
+
+	genreLimits data.GenreLimits // Set by the handler, since they come from the configuration
+}
+
+// movie() copies the values from the input struct to a new Movie struct
+func (input *createMovieInput) movie() *data.Movie {
+	return &data.Movie{
+		Title:   input.Title,
+		Year:    input.Year,
 		Runtime: input.Runtime,
-		Genres: input.Genres,
+		Genres:  input.Genres,
+		Tags:    input.Tags,
 	}
+}
 
-	// Initialize a new Validator instance
-	v := validator.New()
+// Validate() runs the movie validation checks, so that the input satisfies the validatable interface.
+// ValidateMovie() normalizes the genres and tags, which are copied back so that the movie created from the
+// input afterwards has them too.
+func (input *createMovieInput) Validate(v *validator.Validator) {
+	movie := input.movie()
+	data.ValidateMovie(v, movie, input.genreLimits)
+	input.Genres, input.Tags = movie.Genres, movie.Tags
+}
+
+// Add a createMovieHandler for "POST /v1/movies"
+func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Request) {
+	input := createMovieInput{genreLimits: app.genreLimits()}
 
-	// Use the Valid() method to see if any of the checks failed.
-	if data.ValidateMovie(v, movie); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+	// Decode the request body into the input struct and validate it in one call.
+	// Sends a 400 Bad Request if the body can't be decoded, or a 422 Unprocessable Entity if it is invalid.
+	err := app.decodeAndValidate(w, r, &input)
+	if err != nil {
+		app.decodeAndValidateErrorResponse(w, r, err)
 		return
 	}
 
+	movie := input.movie()
+
+	// Record the authenticated user as the creator of the movie, so that it is listed by GET /v1/users/me/movies.
+	// Anonymous users have an ID of 0, which is stored as no creator.
+	movie.CreatedBy = app.contextGetUser(r).ID
+
 	// Call the Insert() method on the movies model.
 	// This creates a record in the database and updates the movie struct with system-generated info.
-	err = app.models.Movies.Insert(movie)
+	// A movie with the same title and year as an existing one is only rejected with -movies-unique-title-year,
+	// or if the client sent the "X-On-Duplicate: error" header. With "X-On-Duplicate: return", the existing movie
+	// is sent back with a 200 OK instead of a duplicate being created. Importers use it to re-run a batch safely.
+	created := true
+
+	switch strings.ToLower(r.Header.Get("X-On-Duplicate")) {
+	case "":
+		err = app.modelsFor(r).Movies.Insert(movie)
+	case "error":
+		err = app.modelsFor(r).Movies.InsertUnique(movie)
+	case "return":
+		created, err = app.modelsFor(r).Movies.InsertOrGet(movie)
+	default:
+		app.badRequestResponse(w, r, errors.New("the X-On-Duplicate header must be error or return"))
+		return
+	}
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrDuplicateMovie):
+			app.duplicateMovieResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
-	// Add a Location header to let the client know which URL they can find the newly-created resource at.
 	headers := make(http.Header)
-	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
+	headers.Set("Location", fmt.Sprintf("/v1/movies/%v", data.MovieIDs.Encode(movie.ID)))
+
+	// Nothing has changed when the existing movie is returned, so there is nothing to audit or notify.
+	if !created {
+		err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, headers)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Record the change in the audit log, and notify any subscribed webhooks that the movie has been created.
+	app.recordAudit(r, data.AuditActionCreate, "movie", movie.ID, nil, movie)
+	app.notifyWebhooks(data.EventMovieCreated, envelope{"movie": movie})
 
 	// Write a JSON response with a 201 Created status code
 	err = app.writeJSON(w, http.StatusCreated, envelope{"movie": movie}, headers)
@@ -126,7 +322,7 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 // Add a showMovieHandler for "GET /v1/movies/:id"
 func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request) {
 	// Read id parameter from request url
-	id, err := app.readIDParam(r)
+	id, err := app.readMovieIDParam(r)
 	if err != nil {
 		app.notFoundResponse(w, r)
 		return
@@ -134,7 +330,7 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 
 	// Check if error returned is data.ErrRecordNotFound
 	// If yes, return a 404 Not Found response to the client
-	movie, err := app.models.Movies.Get(id)
+	movie, err := app.modelsFor(r).Movies.Get(id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -145,7 +341,29 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	// Set the Last-Modified header so that caches and clients can make conditional requests.
+	// HTTP dates have a precision of one second, which matches the precision of the updated_at column.
+	headers := make(http.Header)
+	headers.Set("Last-Modified", movie.UpdatedAt.UTC().Format(http.TimeFormat))
+
+	// The representation depends on the Accept header, so caches must key on it. This applies to a 304 Not
+	// Modified too, since it updates the cached response for the same request headers.
+	addVary(w, "Accept")
+
+	// If the client's copy is at least as recent as the movie, send a 304 Not Modified without a body.
+	// An If-Modified-Since value which can't be parsed is ignored, as required by RFC 7232.
+	if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !movie.UpdatedAt.After(since) {
+		w.Header().Set("Last-Modified", headers.Get("Last-Modified"))
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	// Clients which accept JSON:API get the movie as a JSON:API resource object.
+	if wantsJSONAPI(r) {
+		err = app.writeJSONAPIMovie(w, http.StatusOK, movie, headers)
+	} else {
+		err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, headers)
+	}
 
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -153,18 +371,79 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+// Add a randomMovieHandler for "GET /v1/movies/random"
+func (app *application) randomMovieHandler(w http.ResponseWriter, r *http.Request) {
+	// Send a 404 Not Found response to the client if there are no movies to pick from
+	movie, err := app.modelsFor(r).Movies.GetRandom()
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Add a listRelatedMoviesHandler for "GET /v1/movies/:id/related"
+func (app *application) listRelatedMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readMovieIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	// Extract the number of related movies to return from the query string.
+	// Defaults to 5, up to a maximum of 20.
+	limit := app.readInt(r.URL.Query(), "limit", 5, v)
+
+	v.Check(limit > 0, "limit", "must be greater than zero")
+	v.Checkf(limit <= 20, "limit", "must be a maximum of %d", 20)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	// Send a 404 Not Found response if the source movie doesn't exist.
+	// An empty list is returned if no other movie shares a genre with it.
+	movies, err := app.modelsFor(r).Movies.GetRelated(id, limit)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
 // Add a updateMovieHandler for "PUT /v1/movies/:id"
 func (app *application) updateMovieHandler (w http.ResponseWriter, r *http.Request) {
 	// Extract the movie ID from URL
-	id, err := app.readIDParam(r)
+	id, err := app.readMovieIDParam(r)
 	if err != nil {
 		app.notFoundResponse(w, r)
 		return
 	}
 
-	// Fetch the existing movie record from the database
+	// Fetch the existing movie record from the primary database, since it's about to be changed
 	// Send a 404 Not Found response to the client if we couldn't find a matching record
-	movie, err := app.models.Movies.Get(id)
+	movie, err := app.modelsFor(r).Movies.GetForUpdate(id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -175,6 +454,9 @@ func (app *application) updateMovieHandler (w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	// Keep a copy of the movie as it was before the update, for the audit log.
+	original := *movie
+
 	// Declare an input struct to hold the expected data from the client.
 	// Fields in struct are pointers. Pointers have a zero-value of nil.
 	// This makes it easy to differentiate between zero-value (which returns validation error)
@@ -184,6 +466,7 @@ func (app *application) updateMovieHandler (w http.ResponseWriter, r *http.Reque
 		Year *int32 `json:"year"`
 		Runtime *data.Runtime `json:"runtime"`
 		Genres []string `json:"genres"`
+		Tags []string `json:"tags"`
 	}
  
 	// Read the JSON request body data into the input struct
@@ -207,26 +490,35 @@ func (app *application) updateMovieHandler (w http.ResponseWriter, r *http.Reque
 	if input.Genres != nil {
 		movie.Genres = input.Genres // No need to dereference a slice
 	}
+	if input.Tags != nil {
+		movie.Tags = input.Tags // An empty array removes every tag
+	}
 
 	// Validate the updated movie, sending the client a 422 Unprocessable Entity if any checks fail
 	v := validator.New()
-	if data.ValidateMovie(v, movie); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+	if data.ValidateMovie(v, movie, app.genreLimits()); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
 	// Pass the updated movie to the Update() method
-	err = app.models.Movies.Update(movie)
+	err = app.modelsFor(r).Movies.Update(movie)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict): // Intercept conflict in data race
 			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateMovie):
+			app.duplicateMovieResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
+	// Record the change in the audit log, and notify any subscribed webhooks that the movie has been updated.
+	app.recordAudit(r, data.AuditActionUpdate, "movie", movie.ID, &original, movie)
+	app.notifyWebhooks(data.EventMovieUpdated, envelope{"movie": movie})
+
 	// Write the updated movie into JSON response
 	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
 	if err != nil {
@@ -234,30 +526,232 @@ func (app *application) updateMovieHandler (w http.ResponseWriter, r *http.Reque
 	}
 }
 
+// Add a touchMovieHandler for "PATCH /v1/movies/:id/touch"
+// It increments the version of the movie without changing it, so that caches keyed on the version fetch it again
+// after an out-of-band fix. An optional If-Match header carries the version the client expects to touch;
+// without it, the version which was just read is used, so a concurrent update still causes an edit conflict.
+func (app *application) touchMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readMovieIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	version, err := app.readIfMatchVersion(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	movie, err := app.modelsFor(r).Movies.GetForUpdate(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if version == 0 {
+		version = movie.Version
+	}
+
+	// Keep a copy of the movie as it was before the touch, for the audit log.
+	original := *movie
+
+	movie.Version, err = app.modelsFor(r).Movies.Touch(id, version)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Only the version has changed, but the subscribed webhooks are notified like for any update, since they
+	// may be the ones maintaining the caches.
+	app.recordAudit(r, data.AuditActionUpdate, "movie", movie.ID, &original, movie)
+	app.notifyWebhooks(data.EventMovieUpdated, envelope{"movie": movie})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
 // Add a deleteMovieHandler for "DELETE /v1/movies/:id"
 func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Request) {
 	// Extract movie ID from URL
-	id, err := app.readIDParam(r)
+	id, err := app.readMovieIDParam(r)
 	if err != nil {
 		app.notFoundResponse(w, r)
 		return
 	}
 
-	err = app.models.Movies.Delete(id)
+	// An optional If-Match header carries the version the client expects to delete.
+	// Without it, the movie is deleted unconditionally.
+	version, err := app.readIfMatchVersion(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Fetch the movie for the audit log. This is best-effort: if it fails, the entry is recorded without
+	// the before state, and a missing movie is reported by Delete() below.
+	before, err := app.modelsFor(r).Movies.GetForUpdate(id)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.logError(r, err)
+	}
+
+	err = app.modelsFor(r).Movies.Delete(id, version)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	// Return a 200 OK status code along with status message
-	// Optionally, can send a 204 No Content with an empty response body
+	// Record the change in the audit log, and notify any subscribed webhooks that the movie has been deleted.
+	app.recordAudit(r, data.AuditActionDelete, "movie", id, auditState(before), nil)
+	app.notifyWebhooks(data.EventMovieDeleted, envelope{"movie": envelope{"id": data.MovieIDs.Encode(id)}})
+
+	// Send a 204 No Content with an empty response body if the deployment chose it with -delete-response=204
+	if app.config.deleteResponse == http.StatusNoContent {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// Otherwise, return a 200 OK status code along with status message
 	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
-}
\ No newline at end of file
+}
+
+// Add a showMovieStatsHandler for "GET /v1/movies/stats"
+func (app *application) showMovieStatsHandler(w http.ResponseWriter, r *http.Request) {
+	stats, err := app.modelsFor(r).Movies.GetStats()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"stats": stats}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Add a deleteManyMoviesHandler for "DELETE /v1/movies/batch"
+// The IDs of the movies to delete are read from the request body ({"ids": [...]}), or from the ids query
+// parameter if there is no body. The response has the number of movies deleted and the IDs which weren't found,
+// which are also reported in the warnings.
+func (app *application) deleteManyMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		IDs publicIDList `json:"ids"`
+	}
+
+	v := validator.New()
+
+	var ids []int64
+
+	if r.ContentLength != 0 {
+		err := app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		ids = app.decodeIDs(input.IDs, "ids", data.MovieIDs, v)
+	} else {
+		ids = app.readIDs(r.URL.Query(), "ids", data.MovieIDs, v)
+	}
+
+	v.Check(len(ids) > 0, "ids", "must be provided")
+	v.Checkf(len(ids) <= app.config.maxIDs, "ids", "must not contain more than %d values", app.config.maxIDs)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	// Fetch the movies for the audit log. This is best-effort, like for a single delete.
+	existing := make(map[int64]*data.Movie)
+
+	movies, err := app.modelsFor(r).Movies.GetMany(ids)
+	if err != nil {
+		app.logError(r, err)
+	}
+	for _, movie := range movies {
+		existing[movie.ID] = movie
+	}
+
+	deleted, err := app.modelsFor(r).Movies.DeleteMany(ids)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Work out which of the requested IDs weren't deleted, and record and notify the webhooks of the ones that were.
+	found := make(map[int64]bool, len(deleted))
+	for _, id := range deleted {
+		found[id] = true
+		app.recordAudit(r, data.AuditActionDelete, "movie", id, auditState(existing[id]), nil)
+		app.notifyWebhooks(data.EventMovieDeleted, envelope{"movie": envelope{"id": data.MovieIDs.Encode(id)}})
+	}
+
+	notFound := []interface{}{}
+	var ws warnings
+	for _, id := range ids {
+		if !found[id] {
+			notFound = append(notFound, data.MovieIDs.Encode(id))
+			ws.Add("movie %v not found", data.MovieIDs.Encode(id))
+			// Only report each missing ID once, even if it was requested more than once.
+			found[id] = true
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, app.withWarnings(w, r, envelope{"deleted": len(deleted), "not_found": notFound}, ws), nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// auditState() converts a possibly nil movie to the state recorded in the audit log. A nil *data.Movie must not
+// be passed as an interface{} directly, because it would be encoded as null rather than leaving the state empty.
+func auditState(movie *data.Movie) interface{} {
+	if movie == nil {
+		return nil
+	}
+	return movie
+}
+
+// moviesETag() computes a weak entity tag for a page of movies. It is a hash of the ID and version of each movie,
+// which change whenever a movie is created, updated or deleted, of the number of genres left out by
+// TruncateGenres(), and of the pagination metadata. It is weak because it doesn't cover the exact bytes of the
+// response (e.g. the indentation), only the data.
+func moviesETag(movies []*data.Movie, metadata data.Metadata, jsonAPI bool) string {
+	h := sha256.New()
+
+	if jsonAPI {
+		fmt.Fprint(h, jsonAPIMediaType+";")
+	}
+
+	for _, movie := range movies {
+		fmt.Fprintf(h, "%d:%d:%d,", movie.ID, movie.Version, movie.MoreGenres)
+	}
+	fmt.Fprintf(h, "%+v", metadata)
+
+	return fmt.Sprintf(`W/"%x"`, h.Sum(nil)[:16])
+}
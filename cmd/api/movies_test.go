@@ -0,0 +1,817 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jseow5177/greenlight/internal/data"
+)
+
+// createTestMovie() creates a movie through POST /v1/movies with the given headers, and returns the response.
+func createTestMovie(t *testing.T, h http.Handler, token, body string, headers map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	r := httptest.NewRequest(http.MethodPost, "/v1/movies", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	for key, value := range headers {
+		r.Header.Set(key, value)
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+
+	return rr
+}
+
+// movieID() returns the ID of the movie in a response body.
+func movieID(t *testing.T, rr *httptest.ResponseRecorder) int64 {
+	t.Helper()
+
+	var body struct {
+		Movie struct {
+			ID int64 `json:"id"`
+		} `json:"movie"`
+	}
+
+	err := json.Unmarshal(rr.Body.Bytes(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return body.Movie.ID
+}
+
+const moanaJSON = `{"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": ["animation"]}`
+
+func TestCreateMovieOnDuplicate(t *testing.T) {
+	app := newTestApplicationWithDB(t)
+	h := app.routes()
+
+	_, token := newTestUser(t, app, "editor@example.com", "movies:read", "movies:write")
+
+	rr := createTestMovie(t, h, token, moanaJSON, nil)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("first create: got status %d; want %d: %s", rr.Code, http.StatusCreated, rr.Body)
+	}
+	id := movieID(t, rr)
+
+	rr = createTestMovie(t, h, token, moanaJSON, map[string]string{"X-On-Duplicate": "return"})
+	if rr.Code != http.StatusOK || movieID(t, rr) != id {
+		t.Errorf("X-On-Duplicate: return: got status %d, ID %d; want %d, %d", rr.Code, movieID(t, rr), http.StatusOK, id)
+	}
+
+	rr = createTestMovie(t, h, token, moanaJSON, map[string]string{"X-On-Duplicate": "error"})
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("X-On-Duplicate: error: got status %d; want %d", rr.Code, http.StatusUnprocessableEntity)
+	}
+
+	// Without the header, duplicates are allowed unless -movies-unique-title-year is set.
+	rr = createTestMovie(t, h, token, moanaJSON, nil)
+	if rr.Code != http.StatusCreated || movieID(t, rr) == id {
+		t.Errorf("no header: got status %d, ID %d; want %d and a new ID", rr.Code, movieID(t, rr), http.StatusCreated)
+	}
+
+	rr = createTestMovie(t, h, token, moanaJSON, map[string]string{"X-On-Duplicate": "ignore"})
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("X-On-Duplicate: ignore: got status %d; want %d", rr.Code, http.StatusBadRequest)
+	}
+}
+
+func TestRandomMovie(t *testing.T) {
+	app := newTestApplicationWithDB(t)
+	h := app.routes()
+
+	rr := do(t, h, http.MethodGet, "/v1/movies/random", "", nil)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("empty catalogue: got status %d; want %d", rr.Code, http.StatusNotFound)
+	}
+
+	ids := map[int64]bool{}
+	for _, title := range []string{"Moana", "Deadpool", "Frozen"} {
+		movie := &data.Movie{Title: title, Year: 2016, Runtime: 100, Genres: []string{"animation"}}
+		err := app.models.Movies.Insert(movie)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[movie.ID] = true
+	}
+
+	for i := 0; i < 5; i++ {
+		rr = do(t, h, http.MethodGet, "/v1/movies/random", "", nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+		}
+		if id := movieID(t, rr); !ids[id] {
+			t.Errorf("got movie %d; want one of the inserted movies", id)
+		}
+	}
+}
+
+func TestShowMovieLastModified(t *testing.T) {
+	app := newTestApplicationWithDB(t)
+	h := app.routes()
+
+	_, token := newTestUser(t, app, "editor@example.com", "movies:read", "movies:write")
+	url := fmt.Sprintf("/v1/movies/%d", movieID(t, createTestMovie(t, h, token, moanaJSON, nil)))
+
+	rr := do(t, h, http.MethodGet, url, "", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d; want %d", rr.Code, http.StatusOK)
+	}
+
+	lastModified, err := http.ParseTime(rr.Header().Get("Last-Modified"))
+	if err != nil {
+		t.Fatalf("invalid Last-Modified %q: %v", rr.Header().Get("Last-Modified"), err)
+	}
+
+	tests := []struct {
+		name  string
+		since string
+		want  int
+	}{
+		{"same time", lastModified.Format(http.TimeFormat), http.StatusNotModified},
+		{"later", lastModified.Add(time.Hour).Format(http.TimeFormat), http.StatusNotModified},
+		{"earlier", lastModified.Add(-time.Hour).Format(http.TimeFormat), http.StatusOK},
+		{"invalid", "yesterday", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, url, nil)
+		r.Header.Set("If-Modified-Since", tt.since)
+
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, r)
+
+		if rr.Code != tt.want {
+			t.Errorf("%s: got status %d; want %d", tt.name, rr.Code, tt.want)
+		}
+		if tt.want == http.StatusNotModified && rr.Body.Len() != 0 {
+			t.Errorf("%s: 304 response has a body: %s", tt.name, rr.Body)
+		}
+		if rr.Header().Get("Last-Modified") == "" {
+			t.Errorf("%s: no Last-Modified header", tt.name)
+		}
+	}
+}
+
+func TestListManyMoviesValidation(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.maxIDs = 3
+	h := app.routes()
+
+	tests := []struct {
+		name string
+		ids  string
+	}{
+		{"too many", "1,2,3,4"},
+		{"not a number", "1,two"},
+		{"not positive", "0"},
+	}
+
+	for _, tt := range tests {
+		rr := do(t, h, http.MethodGet, "/v1/movies?ids="+tt.ids, "", nil)
+		if rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("%s: got status %d; want %d", tt.name, rr.Code, http.StatusUnprocessableEntity)
+		}
+	}
+}
+
+func TestListMoviesMaxOffset(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.maxOffset = 1000
+	h := app.routes()
+
+	rr := do(t, h, http.MethodGet, "/v1/movies?page=12&page_size=100", "", nil)
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("got status %d; want %d", rr.Code, http.StatusUnprocessableEntity)
+	}
+	if !strings.Contains(rr.Body.String(), "too deep") {
+		t.Errorf("got body %s; want the page error", rr.Body.String())
+	}
+}
+
+func TestListManyMovies(t *testing.T) {
+	app := newTestApplicationWithDB(t)
+	h := app.routes()
+
+	_, token := newTestUser(t, app, "editor@example.com", "movies:read", "movies:write")
+	id := movieID(t, createTestMovie(t, h, token, moanaJSON, nil))
+
+	rr := do(t, h, http.MethodGet, fmt.Sprintf("/v1/movies?ids=%d,1000000", id), "", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+
+	var body struct {
+		Movies []struct {
+			ID int64 `json:"id"`
+		} `json:"movies"`
+	}
+	err := json.Unmarshal(rr.Body.Bytes(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(body.Movies) != 1 || body.Movies[0].ID != id {
+		t.Errorf("got movies %v; want only %d", body.Movies, id)
+	}
+}
+
+func TestDeleteMovieIfMatch(t *testing.T) {
+	app := newTestApplicationWithDB(t)
+	h := app.routes()
+
+	_, token := newTestUser(t, app, "editor@example.com", "movies:read", "movies:write")
+
+	deleteMovie := func(id int64, ifMatch string) int {
+		t.Helper()
+
+		r := httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/v1/movies/%d", id), nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		if ifMatch != "" {
+			r.Header.Set("If-Match", ifMatch)
+		}
+
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, r)
+		return rr.Code
+	}
+
+	id := movieID(t, createTestMovie(t, h, token, moanaJSON, nil))
+
+	if code := deleteMovie(id, "not-a-version"); code != http.StatusBadRequest {
+		t.Errorf("invalid version: got status %d; want %d", code, http.StatusBadRequest)
+	}
+	if code := deleteMovie(id, `"2"`); code != http.StatusConflict {
+		t.Errorf("stale version: got status %d; want %d", code, http.StatusConflict)
+	}
+	if code := deleteMovie(id, `"1"`); code != http.StatusOK {
+		t.Errorf("matching version: got status %d; want %d", code, http.StatusOK)
+	}
+	if code := deleteMovie(id, `"1"`); code != http.StatusNotFound {
+		t.Errorf("already deleted: got status %d; want %d", code, http.StatusNotFound)
+	}
+
+	// Without the header, the movie is deleted whatever its version.
+	id = movieID(t, createTestMovie(t, h, token, moanaJSON, nil))
+
+	rr := do(t, h, http.MethodPatch, fmt.Sprintf("/v1/movies/%d", id), token, []byte(`{"title": "Moana 2"}`))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("update: got status %d; want %d", rr.Code, http.StatusOK)
+	}
+
+	if code := deleteMovie(id, ""); code != http.StatusOK {
+		t.Errorf("no header: got status %d; want %d", code, http.StatusOK)
+	}
+}
+
+func TestShowMovieInvalidPublicID(t *testing.T) {
+	defer func(ids data.PublicIDs) { data.MovieIDs = ids }(data.MovieIDs)
+
+	app := newTestApplication(t)
+	h := app.routes()
+
+	hashed, err := data.NewHashPublicIDs("test salt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ids := range []data.PublicIDs{{}, hashed} {
+		data.MovieIDs = ids
+
+		for _, id := range []string{"not-an-id", "0", "-1"} {
+			rr := do(t, h, http.MethodGet, "/v1/movies/"+id, "", nil)
+			if rr.Code != http.StatusNotFound {
+				t.Errorf("GET /v1/movies/%s: got status %d; want %d", id, rr.Code, http.StatusNotFound)
+			}
+		}
+	}
+}
+
+func TestDeleteManyMoviesValidation(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.maxIDs = 3
+
+	tests := []struct {
+		name string
+		url  string
+		body string
+	}{
+		{"oversized body", "/v1/movies/batch", `{"ids": [1, 2, 3, 4]}`},
+		{"oversized query", "/v1/movies/batch?ids=1,2,3,4", ""},
+		{"no IDs", "/v1/movies/batch", `{"ids": []}`},
+		{"invalid ID", "/v1/movies/batch", `{"ids": [1, 0]}`},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodDelete, tt.url, strings.NewReader(tt.body))
+		if tt.body != "" {
+			r.Header.Set("Content-Type", "application/json")
+		}
+		rr := httptest.NewRecorder()
+
+		// The IDs are validated before the database is used.
+		app.deleteManyMoviesHandler(rr, r)
+
+		if rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("%s: got status %d; want %d", tt.name, rr.Code, http.StatusUnprocessableEntity)
+		}
+	}
+}
+
+func TestDeleteManyMovies(t *testing.T) {
+	app := newTestApplicationWithDB(t)
+	h := app.routes()
+
+	_, token := newTestUser(t, app, "editor@example.com", "movies:read", "movies:write")
+	first := movieID(t, createTestMovie(t, h, token, moanaJSON, nil))
+	second := movieID(t, createTestMovie(t, h, token, moanaJSON, nil))
+
+	body := fmt.Sprintf(`{"ids": [%d, 1000000, %d]}`, first, second)
+	rr := do(t, h, http.MethodDelete, "/v1/movies/batch", token, []byte(body))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+
+	var resp struct {
+		Deleted  int      `json:"deleted"`
+		NotFound []int64  `json:"not_found"`
+		Warnings []string `json:"warnings"`
+	}
+	err := json.Unmarshal(rr.Body.Bytes(), &resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Deleted != 2 || len(resp.NotFound) != 1 || resp.NotFound[0] != 1000000 {
+		t.Errorf("got deleted %d, not found %v; want 2 and [1000000]", resp.Deleted, resp.NotFound)
+	}
+	if want := []string{"movie 1000000 not found"}; !reflect.DeepEqual(resp.Warnings, want) {
+		t.Errorf("got warnings %q; want %q", resp.Warnings, want)
+	}
+
+	rr = do(t, h, http.MethodGet, fmt.Sprintf("/v1/movies/%d", first), "", nil)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("deleted movie: got status %d; want %d", rr.Code, http.StatusNotFound)
+	}
+}
+
+// hasVary() reports whether the Vary header of the response lists the header name.
+func hasVary(rr *httptest.ResponseRecorder, name string) bool {
+	for _, value := range rr.Header().Values("Vary") {
+		for _, field := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestListMoviesMaxPageSize(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.maxPageSize = 5
+
+	// The filters are validated before the database is used.
+	rr := do(t, app.routes(), http.MethodGet, "/v1/movies?page_size=6", "", nil)
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("got status %d; want %d", rr.Code, http.StatusUnprocessableEntity)
+	}
+	if !strings.Contains(rr.Body.String(), "must be a maximum of 5") {
+		t.Errorf("the response doesn't report the configured max: %s", rr.Body)
+	}
+}
+
+func TestListMoviesDefaultPageSize(t *testing.T) {
+	app := newTestApplicationWithDB(t)
+	app.config.defaultPageSize = 2
+	h := app.routes()
+
+	_, token := newTestUser(t, app, "editor@example.com", "movies:read", "movies:write")
+	for i := 0; i < 3; i++ {
+		createTestMovie(t, h, token, moanaJSON, nil)
+	}
+
+	rr := do(t, h, http.MethodGet, "/v1/movies", "", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+
+	var resp struct {
+		Movies   []json.RawMessage `json:"movies"`
+		Metadata data.Metadata     `json:"metadata"`
+	}
+	err := json.Unmarshal(rr.Body.Bytes(), &resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(resp.Movies) != 2 || resp.Metadata.PageSize != 2 {
+		t.Errorf("got %d movies and page size %d; want 2 and 2", len(resp.Movies), resp.Metadata.PageSize)
+	}
+}
+
+func TestMoviesETag(t *testing.T) {
+	movies := []*data.Movie{{ID: 1, Version: 1}, {ID: 2, Version: 3}}
+	metadata := data.Metadata{CurrentPage: 1, PageSize: 20, FirstPage: 1, LastPage: 1, TotalRecords: 2}
+
+	etag := moviesETag(movies, metadata, false)
+	if !strings.HasPrefix(etag, `W/"`) {
+		t.Errorf("got ETag %s; want a weak ETag", etag)
+	}
+	if again := moviesETag(movies, metadata, false); again != etag {
+		t.Errorf("got ETag %s for the same page; want %s", again, etag)
+	}
+
+	changed := []*data.Movie{{ID: 1, Version: 1}, {ID: 2, Version: 4}}
+	truncated := []*data.Movie{{ID: 1, Version: 1}, {ID: 2, Version: 3, MoreGenres: 1}}
+	otherPage := metadata
+	otherPage.TotalRecords = 3
+
+	for name, other := range map[string]string{
+		"changed version": moviesETag(changed, metadata, false),
+		"truncated":       moviesETag(truncated, metadata, false),
+		"changed total":   moviesETag(movies, otherPage, false),
+		"JSON:API":        moviesETag(movies, metadata, true),
+	} {
+		if other == etag {
+			t.Errorf("%s: got the same ETag %s", name, etag)
+		}
+	}
+}
+
+func TestListMoviesETag(t *testing.T) {
+	app := newTestApplicationWithDB(t)
+	h := app.routes()
+
+	_, token := newTestUser(t, app, "editor@example.com", "movies:read", "movies:write")
+	id := movieID(t, createTestMovie(t, h, token, moanaJSON, nil))
+
+	list := func(etag string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+		if etag != "" {
+			r.Header.Set("If-None-Match", etag)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, r)
+		return rr
+	}
+
+	rr := list("")
+	etag := rr.Header().Get("ETag")
+	if rr.Code != http.StatusOK || etag == "" {
+		t.Fatalf("got status %d and ETag %q; want %d and an ETag", rr.Code, etag, http.StatusOK)
+	}
+
+	rr = list(etag)
+	if rr.Code != http.StatusNotModified || rr.Body.Len() != 0 {
+		t.Fatalf("unchanged list: got status %d and %d bytes; want %d and no body", rr.Code, rr.Body.Len(), http.StatusNotModified)
+	}
+
+	rr = do(t, h, http.MethodPatch, fmt.Sprintf("/v1/movies/%d", id), token, []byte(`{"title": "Moana 2"}`))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("update: got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+
+	rr = list(etag)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("changed list: got status %d; want %d", rr.Code, http.StatusOK)
+	}
+	if changed := rr.Header().Get("ETag"); changed == etag {
+		t.Errorf("got the same ETag %s after the update", etag)
+	}
+}
+
+func TestListMoviesQualityFiltersValidation(t *testing.T) {
+	app := newTestApplication(t)
+
+	tests := []struct {
+		query string
+		field string
+	}{
+		{"has_poster=maybe", "has_poster"},
+		{"min_genres=-1", "min_genres"},
+	}
+
+	// The filters are validated before the database is used.
+	for _, tt := range tests {
+		rr := do(t, app.routes(), http.MethodGet, "/v1/movies?"+tt.query, "", nil)
+		if rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("%s: got status %d; want %d", tt.query, rr.Code, http.StatusUnprocessableEntity)
+			continue
+		}
+
+		var body struct {
+			Error map[string]string `json:"error"`
+		}
+		err := json.Unmarshal(rr.Body.Bytes(), &body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := body.Error[tt.field]; !ok {
+			t.Errorf("%s: got errors %v; want one for %q", tt.query, body.Error, tt.field)
+		}
+	}
+}
+
+func TestListMoviesTruncatesGenres(t *testing.T) {
+	app := newTestApplicationWithDB(t)
+	app.config.listMaxGenres = 2
+	h := app.routes()
+
+	_, token := newTestUser(t, app, "editor@example.com", "movies:read", "movies:write")
+	body := `{"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": ["animation", "adventure", "comedy", "family"]}`
+	id := movieID(t, createTestMovie(t, h, token, body, nil))
+
+	var list struct {
+		Movies []struct {
+			Genres     []string `json:"genres"`
+			MoreGenres int      `json:"more_genres"`
+		} `json:"movies"`
+	}
+	rr := do(t, h, http.MethodGet, "/v1/movies", "", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("list: got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+	etag := rr.Header().Get("ETag")
+	err := json.Unmarshal(rr.Body.Bytes(), &list)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list.Movies) != 1 {
+		t.Fatalf("list: got %d movies; want 1", len(list.Movies))
+	}
+	if got := list.Movies[0]; !reflect.DeepEqual(got.Genres, []string{"animation", "adventure"}) || got.MoreGenres != 2 {
+		t.Errorf("list: got genres %q and %d more; want the first 2 and 2 more", got.Genres, got.MoreGenres)
+	}
+
+	// The detail endpoint returns every genre, and the stored movie is left alone.
+	var detail struct {
+		Movie map[string]interface{} `json:"movie"`
+	}
+	rr = do(t, h, http.MethodGet, fmt.Sprintf("/v1/movies/%d", id), "", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("detail: got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+	err = json.Unmarshal(rr.Body.Bytes(), &detail)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if genres, _ := detail.Movie["genres"].([]interface{}); len(genres) != 4 {
+		t.Errorf("detail: got genres %v; want all 4", detail.Movie["genres"])
+	}
+	if _, ok := detail.Movie["more_genres"]; ok {
+		t.Errorf("detail: got more_genres %v; want none", detail.Movie["more_genres"])
+	}
+
+	// Raising the limit changes the body of the list, so the old ETag no longer matches.
+	r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+	r.Header.Set("If-None-Match", etag)
+	app.config.listMaxGenres = 3
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+	if rr.Code != http.StatusOK {
+		t.Errorf("list with a new limit: got status %d; want %d", rr.Code, http.StatusOK)
+	}
+}
+
+func TestCreateMovieStoresNormalizedGenres(t *testing.T) {
+	app := newTestApplicationWithDB(t)
+	h := app.routes()
+
+	_, token := newTestUser(t, app, "editor@example.com", "movies:read", "movies:write")
+
+	rr := createTestMovie(t, h, token, `{"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": [" animation "], "tags": ["disney "]}`, nil)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("got status %d; want %d: %s", rr.Code, http.StatusCreated, rr.Body)
+	}
+
+	movie, err := app.models.Movies.Get(movieID(t, rr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(movie.Genres) != 1 || movie.Genres[0] != "animation" || len(movie.Tags) != 1 || movie.Tags[0] != "disney" {
+		t.Errorf("got genres %q, tags %q; want them trimmed", movie.Genres, movie.Tags)
+	}
+}
+
+func TestDeleteMovieResponse(t *testing.T) {
+	tests := []struct {
+		deleteResponse int
+		body           string
+	}{
+		{http.StatusOK, `{"message":"movie successfully deleted"}` + "\n"},
+		{http.StatusNoContent, ""},
+	}
+
+	for _, tt := range tests {
+		app := newTestApplicationWithDB(t)
+		app.config.deleteResponse = tt.deleteResponse
+		h := app.routes()
+
+		_, token := newTestUser(t, app, "editor@example.com", "movies:read", "movies:write")
+		id := movieID(t, createTestMovie(t, h, token, moanaJSON, nil))
+
+		rr := do(t, h, http.MethodDelete, fmt.Sprintf("/v1/movies/%d", id), token, nil)
+		if rr.Code != tt.deleteResponse {
+			t.Errorf("-delete-response=%d: got status %d; want %d", tt.deleteResponse, rr.Code, tt.deleteResponse)
+		}
+		if rr.Body.String() != tt.body {
+			t.Errorf("-delete-response=%d: got body %q; want %q", tt.deleteResponse, rr.Body, tt.body)
+		}
+		if ct := rr.Header().Get("Content-Type"); tt.body == "" && ct != "" {
+			t.Errorf("-delete-response=%d: got Content-Type %q for an empty body", tt.deleteResponse, ct)
+		}
+
+		// Either way, the movie is deleted.
+		rr = do(t, h, http.MethodGet, fmt.Sprintf("/v1/movies/%d", id), "", nil)
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("-delete-response=%d: got status %d after the delete; want %d", tt.deleteResponse, rr.Code, http.StatusNotFound)
+		}
+	}
+}
+
+func TestShowMovieNotModifiedVary(t *testing.T) {
+	app := newTestApplicationWithDB(t)
+	h := app.routes()
+
+	_, token := newTestUser(t, app, "editor@example.com", "movies:read", "movies:write")
+	id := movieID(t, createTestMovie(t, h, token, moanaJSON, nil))
+
+	for _, since := range []string{"", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)} {
+		r := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/v1/movies/%d", id), nil)
+		if since != "" {
+			r.Header.Set("If-Modified-Since", since)
+		}
+
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, r)
+
+		if since != "" && rr.Code != http.StatusNotModified {
+			t.Errorf("If-Modified-Since %q: got status %d; want %d", since, rr.Code, http.StatusNotModified)
+		}
+		if !hasVary(rr, "Accept") {
+			t.Errorf("If-Modified-Since %q: got Vary %q; want it to include Accept", since, rr.Header().Values("Vary"))
+		}
+	}
+}
+
+func TestMovieOptions(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.movieSortFields = []string{"id", "title", "year"}
+	app.config.maxPageSize = 50
+	h := app.routes()
+
+	rr := do(t, h, http.MethodOptions, "/v1/movies", "", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+	if got := rr.Header().Get("Allow"); got != "GET, POST, OPTIONS" {
+		t.Errorf("got Allow %q; want \"GET, POST, OPTIONS\"", got)
+	}
+
+	var body struct {
+		Options struct {
+			Sort        []string `json:"sort"`
+			DefaultSort string   `json:"default_sort"`
+			Filters     []struct {
+				Name string `json:"name"`
+			} `json:"filters"`
+			Pagination map[string]int `json:"pagination"`
+		} `json:"options"`
+	}
+	err := json.Unmarshal(rr.Body.Bytes(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options := body.Options
+
+	wantSort := []string{"id", "title", "year", "-id", "-title", "-year"}
+	if !reflect.DeepEqual(options.Sort, wantSort) || options.DefaultSort != "id" {
+		t.Errorf("got sort %q (default %q); want %q (default \"id\")", options.Sort, options.DefaultSort, wantSort)
+	}
+
+	var filters []string
+	for _, f := range options.Filters {
+		filters = append(filters, f.Name)
+	}
+	if want := []string{"title", "genres", "tags", "has_poster", "min_genres", "ids"}; !reflect.DeepEqual(filters, want) {
+		t.Errorf("got filters %q; want %q", filters, want)
+	}
+
+	if options.Pagination["max_page_size"] != 50 || options.Pagination["default_page_size"] != app.config.defaultPageSize {
+		t.Errorf("got pagination %v; want the configured page sizes", options.Pagination)
+	}
+
+	// The listed sort values are the ones which the list accepts: one which isn't listed is rejected.
+	rr = do(t, h, http.MethodGet, "/v1/movies?sort=runtime", "", nil)
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("unlisted sort: got status %d; want %d", rr.Code, http.StatusUnprocessableEntity)
+	}
+}
+
+func TestListMoviesByIDsWarnings(t *testing.T) {
+	app := newTestApplicationWithDB(t)
+	h := app.routes()
+
+	_, token := newTestUser(t, app, "editor@example.com", "movies:read", "movies:write")
+	id := movieID(t, createTestMovie(t, h, token, moanaJSON, nil))
+
+	list := func(url string) ([]int64, []string) {
+		t.Helper()
+
+		rr := do(t, h, http.MethodGet, url, "", nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: got status %d; want %d: %s", url, rr.Code, http.StatusOK, rr.Body)
+		}
+
+		var body struct {
+			Movies []struct {
+				ID int64 `json:"id"`
+			} `json:"movies"`
+			Warnings []string `json:"warnings"`
+		}
+		err := json.Unmarshal(rr.Body.Bytes(), &body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var ids []int64
+		for _, movie := range body.Movies {
+			ids = append(ids, movie.ID)
+		}
+		return ids, body.Warnings
+	}
+
+	// The movies which were found are returned, with a warning for each missing one.
+	ids, ws := list(fmt.Sprintf("/v1/movies?ids=%d,1000000", id))
+	if !reflect.DeepEqual(ids, []int64{id}) {
+		t.Errorf("partial: got movies %v; want [%d]", ids, id)
+	}
+	if want := []string{"movie 1000000 not found"}; !reflect.DeepEqual(ws, want) {
+		t.Errorf("partial: got warnings %q; want %q", ws, want)
+	}
+
+	// A response which fully succeeded has no warnings key.
+	ids, ws = list(fmt.Sprintf("/v1/movies?ids=%d", id))
+	if !reflect.DeepEqual(ids, []int64{id}) || ws != nil {
+		t.Errorf("full: got movies %v and warnings %q; want [%d] and none", ids, ws, id)
+	}
+}
+
+func TestTouchMovie(t *testing.T) {
+	app := newTestApplicationWithDB(t)
+	h := app.routes()
+
+	_, token := newTestUser(t, app, "editor@example.com", "movies:read", "movies:write")
+	_, readOnly := newTestUser(t, app, "reader@example.com", "movies:read")
+	url := fmt.Sprintf("/v1/movies/%d/touch", movieID(t, createTestMovie(t, h, token, moanaJSON, nil)))
+
+	if rr := do(t, h, http.MethodPatch, url, "", nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous: got status %d; want %d", rr.Code, http.StatusUnauthorized)
+	}
+	if rr := do(t, h, http.MethodPatch, url, readOnly, nil); rr.Code != http.StatusForbidden {
+		t.Errorf("without movies:write: got status %d; want %d", rr.Code, http.StatusForbidden)
+	}
+
+	rr := do(t, h, http.MethodPatch, url, token, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+
+	var body struct {
+		Movie struct {
+			Title   string `json:"title"`
+			Version int32  `json:"version"`
+		} `json:"movie"`
+	}
+	err := json.Unmarshal(rr.Body.Bytes(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body.Movie.Version != 2 || body.Movie.Title != "Moana" {
+		t.Errorf("got %+v; want Moana at version 2", body.Movie)
+	}
+
+	// A stale If-Match version is an edit conflict.
+	r := httptest.NewRequest(http.MethodPatch, url, nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	r.Header.Set("If-Match", `"1"`)
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("stale version: got status %d; want %d", rr.Code, http.StatusConflict)
+	}
+}
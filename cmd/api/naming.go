@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// Define the JSON field naming strategies selected with the -json-naming flag.
+const (
+	namingTags      = "tags"       // Use the field names from the struct tags, as encoding/json does
+	namingSnakeCase = "snake_case" // Rewrite every object key to snake_case
+)
+
+// applyNaming() rewrites the object keys of the encoded JSON according to the -json-naming flag.
+// The keys are rewritten after encoding, so custom MarshalJSON() methods (like the one of data.Runtime)
+// work as usual and only their keys are affected. The order of the keys is preserved.
+func (app *application) applyNaming(js []byte) ([]byte, error) {
+	if app.config.jsonNaming != namingSnakeCase {
+		return js, nil
+	}
+
+	return renameKeys(js, snakeCase)
+}
+
+// renameKeys() re-encodes compact JSON with every object key passed through the rename function.
+// It works on the token stream, so that the order of the keys and the exact representation of the
+// numbers are kept.
+func renameKeys(js []byte, rename func(string) string) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+
+	// Each frame counts the keys and values written so far in an enclosing object or array.
+	type frame struct {
+		object bool
+		n      int
+	}
+
+	var (
+		out   bytes.Buffer
+		stack []frame
+	)
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return out.Bytes(), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// The end of an object or array.
+		if delim, ok := tok.(json.Delim); ok && (delim == '}' || delim == ']') {
+			stack = stack[:len(stack)-1]
+			out.WriteRune(rune(delim))
+			continue
+		}
+
+		if len(stack) > 0 {
+			top := &stack[len(stack)-1]
+
+			// In an object, keys and values alternate, and the keys are always strings.
+			if top.object && top.n%2 == 0 {
+				if top.n > 0 {
+					out.WriteByte(',')
+				}
+				key, _ := json.Marshal(rename(tok.(string)))
+				out.Write(key)
+				out.WriteByte(':')
+				top.n++
+				continue
+			}
+
+			if !top.object && top.n > 0 {
+				out.WriteByte(',')
+			}
+			top.n++
+		}
+
+		switch v := tok.(type) {
+		case json.Delim:
+			out.WriteRune(rune(v))
+			stack = append(stack, frame{object: v == '{'})
+		case json.Number:
+			out.WriteString(v.String())
+		default:
+			value, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			out.Write(value)
+		}
+	}
+}
+
+// snakeCase() converts a field name to snake_case: "CreatedAt" and "createdAt" become "created_at", and
+// "HTTPStatus" becomes "http_status". Spaces and hyphens become underscores, and names which are already in
+// snake_case are unchanged.
+func snakeCase(name string) string {
+	runes := []rune(name)
+
+	var b strings.Builder
+
+	for i, r := range runes {
+		switch {
+		case r == ' ' || r == '-':
+			b.WriteByte('_')
+		case unicode.IsUpper(r):
+			// Start a new word at a lower-to-upper change ("createdAt"), or at the last capital of an
+			// acronym which is followed by a lower case letter ("HTTPStatus").
+			if i > 0 && runes[i-1] != '_' && runes[i-1] != ' ' && runes[i-1] != '-' {
+				prev := runes[i-1]
+				nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+
+				if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+					b.WriteByte('_')
+				}
+			}
+			b.WriteRune(unicode.ToLower(r))
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jseow5177/greenlight/internal/data"
+)
+
+func TestSnakeCase(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"created_at", "created_at"},
+		{"createdAt", "created_at"},
+		{"CreatedAt", "created_at"},
+		{"HTTPStatus", "http_status"},
+		{"userID", "user_id"},
+		{"page2Size", "page2_size"},
+		{"X-Total-Records", "x_total_records"},
+		{"total records", "total_records"},
+		{"version", "version"},
+	}
+
+	for _, tt := range tests {
+		if got := snakeCase(tt.name); got != tt.want {
+			t.Errorf("snakeCase(%q) = %q; want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRenameKeys(t *testing.T) {
+	// The order of the keys, nested values and the representation of the numbers are kept.
+	js := `{"zetaKey":1.50,"alphaKey":[{"innerKey":null},"valueNotKey",true],"emptyObject":{},"big":12345678901234567890}`
+	want := `{"zeta_key":1.50,"alpha_key":[{"inner_key":null},"valueNotKey",true],"empty_object":{},"big":12345678901234567890}`
+
+	got, err := renameKeys([]byte(js), snakeCase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("got %s; want %s", got, want)
+	}
+}
+
+func TestWriteJSONNaming(t *testing.T) {
+	movie := &data.Movie{ID: 1, Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation"}, Version: 1}
+	metadata := data.Metadata{CurrentPage: 1, PageSize: 20, FirstPage: 1, LastPage: 1, TotalRecords: 1, TotalPages: 1}
+
+	tests := []struct {
+		name string
+		env  envelope
+		tags string
+		// The output with -json-naming=snake_case
+		snakeCase string
+	}{
+		{
+			"movie",
+			envelope{"movie": movie},
+			`{"movie":{"id":1,"title":"Moana","year":2016,"runtime":"107 mins","genres":["animation"],"version":1}}`,
+			`{"movie":{"id":1,"title":"Moana","year":2016,"runtime":"107 mins","genres":["animation"],"version":1}}`,
+		},
+		{
+			"metadata",
+			envelope{"metadata": metadata},
+			`{"metadata":{"current_page":1,"page_size":20,"first_page":1,"last_page":1,"total_records":1,"total_pages":1,"has_next_page":false,"has_previous_page":false}}`,
+			`{"metadata":{"current_page":1,"page_size":20,"first_page":1,"last_page":1,"total_records":1,"total_pages":1,"has_next_page":false,"has_previous_page":false}}`,
+		},
+		{
+			"keys not in snake_case",
+			envelope{"movieCount": 1, "lastMovie": map[string]int{"ID": 1}},
+			`{"lastMovie":{"ID":1},"movieCount":1}`,
+			`{"last_movie":{"id":1},"movie_count":1}`,
+		},
+	}
+
+	for _, tt := range tests {
+		for naming, want := range map[string]string{namingTags: tt.tags, namingSnakeCase: tt.snakeCase} {
+			app := newTestApplication(t)
+			app.config.jsonNaming = naming
+
+			rr := httptest.NewRecorder()
+			err := app.writeJSON(rr, http.StatusOK, tt.env, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := rr.Body.String(); got != want {
+				t.Errorf("%s, %s: got %s; want %s", tt.name, naming, got, want)
+			}
+		}
+	}
+}
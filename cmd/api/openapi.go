@@ -0,0 +1,639 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/jseow5177/greenlight/internal/data"
+)
+
+// Types used to check struct fields while generating the schemas.
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+// schemaFor() derives an OpenAPI schema from a Go type via reflection, following the same rules as
+// encoding/json: fields are named by their json struct tag and fields tagged "-" are skipped.
+// Non-struct types with a custom MarshalJSON() method (like data.Runtime) are described as strings.
+func schemaFor(t reflect.Type) map[string]interface{} {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t.Implements(marshalerType) && t.Kind() != reflect.Struct:
+		return map[string]interface{}{"type": "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case reflect.Int64, reflect.Uint, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaFor(t.Elem())}
+	case reflect.Struct:
+		properties := map[string]interface{}{}
+
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+
+			// Skip unexported fields, which encoding/json ignores too.
+			if field.PkgPath != "" {
+				continue
+			}
+
+			name := field.Name
+			if tag := field.Tag.Get("json"); tag != "" {
+				tagName := strings.Split(tag, ",")[0]
+				if tagName == "-" {
+					continue
+				}
+				if tagName != "" {
+					name = tagName
+				}
+			}
+
+			properties[name] = schemaFor(field.Type)
+		}
+
+		return map[string]interface{}{"type": "object", "properties": properties}
+	}
+
+	return map[string]interface{}{}
+}
+
+// ref() returns a reference to a schema in the components section of the document.
+func ref(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+// jsonResponse() describes a JSON response whose body is an object with the given properties.
+func jsonResponse(description string, properties map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"description": description,
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{
+				"schema": map[string]interface{}{"type": "object", "properties": properties},
+			},
+		},
+	}
+}
+
+// exportAlternatives() adds the application/x-ndjson representation of a response, in which each line is
+// an object with the given schema, and the text/csv representation. Both have an X-Total-Records header.
+func exportAlternatives(response map[string]interface{}, line map[string]interface{}) map[string]interface{} {
+	content := response["content"].(map[string]interface{})
+	content["application/x-ndjson"] = map[string]interface{}{"schema": line}
+	content["text/csv"] = map[string]interface{}{"schema": map[string]interface{}{"type": "string"}}
+
+	response["headers"] = map[string]interface{}{
+		"X-Total-Records": map[string]interface{}{
+			"description": "The approximate number of exported movies (newline-delimited JSON and CSV only)",
+			"schema":      map[string]interface{}{"type": "integer"},
+		},
+	}
+	return response
+}
+
+// jsonAPIAlternative() adds the application/vnd.api+json representation of a response, a JSON:API document
+// whose data is a movie resource object, or an array of them when many is true.
+func jsonAPIAlternative(response map[string]interface{}, many bool) map[string]interface{} {
+	resource := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"type":       map[string]interface{}{"type": "string"},
+			"id":         map[string]interface{}{"type": "string"},
+			"attributes": map[string]interface{}{"type": "object"},
+		},
+	}
+
+	properties := map[string]interface{}{"data": resource}
+	if many {
+		properties = map[string]interface{}{
+			"data":  map[string]interface{}{"type": "array", "items": resource},
+			"links": map[string]interface{}{"type": "object"},
+			"meta":  map[string]interface{}{"type": "object"},
+		}
+	}
+
+	content := response["content"].(map[string]interface{})
+	content[jsonAPIMediaType] = map[string]interface{}{
+		"schema": map[string]interface{}{"type": "object", "properties": properties},
+	}
+	return response
+}
+
+// jsonBody() describes a required JSON request body whose schema has the given properties.
+func jsonBody(properties map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"required": true,
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{
+				"schema": map[string]interface{}{"type": "object", "properties": properties},
+			},
+		},
+	}
+}
+
+// openAPISpec() builds an OpenAPI 3.0 document describing the API routes.
+// The paths are written by hand to match routes(), while the schemas of the resources are derived from
+// the structs in the data package, so they stay in sync with the JSON the API actually sends.
+func openAPISpec() map[string]interface{} {
+	str := map[string]interface{}{"type": "string"}
+	integer := map[string]interface{}{"type": "integer"}
+	boolean := map[string]interface{}{"type": "boolean"}
+	stringArray := map[string]interface{}{"type": "array", "items": str}
+
+	idParam := map[string]interface{}{"name": "id", "in": "path", "required": true, "schema": integer}
+
+	errorResponse := func(description string) map[string]interface{} {
+		return map[string]interface{}{
+			"description": description,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": ref("Error")},
+			},
+		}
+	}
+
+	queryParam := func(name string, schema map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{"name": name, "in": "query", "schema": schema}
+	}
+
+	movieInput := map[string]interface{}{"title": str, "year": integer, "runtime": str, "genres": stringArray, "tags": stringArray}
+
+	paths := map[string]interface{}{
+		"/v1/healthcheck": map[string]interface{}{
+			"get": map[string]interface{}{
+				"summary": "Show application health and version information",
+				"responses": map[string]interface{}{
+					"200": jsonResponse("The application is healthy or degraded", map[string]interface{}{"status": str, "components": map[string]interface{}{"type": "object", "additionalProperties": str}, "database": str}),
+					"503": jsonResponse("The application is unhealthy", map[string]interface{}{"status": str, "components": map[string]interface{}{"type": "object", "additionalProperties": str}, "database": str}),
+				},
+			},
+		},
+		"/v1/readyz": map[string]interface{}{
+			"get": map[string]interface{}{
+				"summary": "Show whether the application is ready to receive traffic",
+				"responses": map[string]interface{}{
+					"200": jsonResponse("The application is ready", map[string]interface{}{"status": str}),
+					"503": jsonResponse("The application is shutting down", map[string]interface{}{"status": str}),
+				},
+			},
+		},
+		"/v1/movies": map[string]interface{}{
+			"get": map[string]interface{}{
+				"summary": "Show the details of all movies",
+				"parameters": []interface{}{
+					queryParam("title", str),
+					queryParam("genres", str),
+					queryParam("tags", str),
+					queryParam("has_poster", boolean),
+					queryParam("min_genres", integer),
+					queryParam("ids", str),
+					queryParam("page", integer),
+					queryParam("page_size", integer),
+					queryParam("sort", str),
+				},
+				"responses": map[string]interface{}{
+					"200": jsonAPIAlternative(exportAlternatives(jsonResponse("A page of movies, or every movie as newline-delimited JSON or CSV. With ids, the movies found, and warnings for the IDs which weren't", map[string]interface{}{
+						"movies":   map[string]interface{}{"type": "array", "items": ref("Movie")},
+						"metadata": ref("Metadata"),
+						"warnings": stringArray,
+					}), ref("Movie")), true),
+					"304": map[string]interface{}{"description": "The page matches the ETag sent in If-None-Match"},
+					"422": errorResponse("Invalid query parameters"),
+				},
+			},
+			"options": map[string]interface{}{
+				"summary": "Describe the sort values, filters and pagination limits of the movie list",
+				"responses": map[string]interface{}{
+					"200": jsonResponse("The list options, with an Allow header", map[string]interface{}{
+						"options": map[string]interface{}{
+							"type": "object",
+							"properties": map[string]interface{}{
+								"sort":         map[string]interface{}{"type": "array", "items": str},
+								"default_sort": str,
+								"filters":      map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "object"}},
+								"pagination":   map[string]interface{}{"type": "object"},
+							},
+						},
+					}),
+				},
+			},
+			"post": map[string]interface{}{
+				"summary": "Create a new movie",
+				"parameters": []interface{}{
+					map[string]interface{}{"name": "X-On-Duplicate", "in": "header", "schema": map[string]interface{}{"type": "string", "enum": []string{"error", "return"}}, "description": "Whether a movie with the same title and year as an existing one is rejected or the existing movie is returned. Without the header, duplicates are only rejected if the server runs with -movies-unique-title-year"},
+				},
+				"requestBody": jsonBody(movieInput),
+				"responses": map[string]interface{}{
+					"200": jsonResponse("The existing movie with the same title and year (X-On-Duplicate: return only)", map[string]interface{}{"movie": ref("Movie")}),
+					"201": jsonResponse("The created movie", map[string]interface{}{"movie": ref("Movie")}),
+					"400": errorResponse("Malformed request body"),
+					"422": errorResponse("Failed validation"),
+				},
+			},
+		},
+		"/v1/movies/random": map[string]interface{}{
+			"get": map[string]interface{}{
+				"summary": "Show the details of a randomly selected movie",
+				"responses": map[string]interface{}{
+					"200": jsonResponse("A movie", map[string]interface{}{"movie": ref("Movie")}),
+					"404": errorResponse("There are no movies"),
+				},
+			},
+		},
+		"/v1/movies/batch": map[string]interface{}{
+			"delete": map[string]interface{}{
+				"summary":    "Delete many movies at once (requires the movies:write permission)",
+				"parameters": []interface{}{queryParam("ids", str)},
+				"requestBody": map[string]interface{}{
+					"content": map[string]interface{}{
+						"application/json": map[string]interface{}{
+							"schema": map[string]interface{}{"type": "object", "properties": map[string]interface{}{"ids": map[string]interface{}{"type": "array", "items": map[string]interface{}{}}}},
+						},
+					},
+				},
+				"responses": map[string]interface{}{
+					"200": jsonResponse("The number of deleted movies and the IDs which weren't found", map[string]interface{}{
+						"deleted":   integer,
+						"not_found": map[string]interface{}{"type": "array", "items": map[string]interface{}{}},
+						"warnings":  stringArray,
+					}),
+					"401": errorResponse("Authentication required"),
+					"403": errorResponse("Missing permission"),
+					"422": errorResponse("Failed validation"),
+				},
+			},
+		},
+		"/v1/movies/purge": map[string]interface{}{
+			"delete": map[string]interface{}{
+				"summary": "Permanently delete the movies deleted longer than the retention period ago (requires the movies:write permission)",
+				"responses": map[string]interface{}{
+					"200": jsonResponse("The number of purged movies", map[string]interface{}{"purged": integer}),
+					"401": errorResponse("Authentication required"),
+					"403": errorResponse("Missing permission"),
+				},
+			},
+		},
+		"/v1/movies/sync": map[string]interface{}{
+			"get": map[string]interface{}{
+				"summary": "Show the movies created, updated or deleted since a given time",
+				"parameters": []interface{}{
+					queryParam("since", map[string]interface{}{"type": "string", "format": "date-time"}),
+					queryParam("cursor", str),
+					queryParam("page_size", integer),
+				},
+				"responses": map[string]interface{}{
+					"200": jsonResponse("A page of changed movies, with tombstones for the deleted ones", map[string]interface{}{
+						"movies": map[string]interface{}{"type": "array", "items": map[string]interface{}{"oneOf": []interface{}{
+							ref("Movie"),
+							map[string]interface{}{"type": "object", "properties": map[string]interface{}{"id": str, "deleted": boolean}},
+						}}},
+						"next_cursor": str,
+						"server_time": map[string]interface{}{"type": "string", "format": "date-time"},
+					}),
+					"422": errorResponse("Invalid query parameters"),
+				},
+			},
+		},
+		"/v1/movies/stats": map[string]interface{}{
+			"get": map[string]interface{}{
+				"summary": "Show statistics of the movie catalogue",
+				"responses": map[string]interface{}{
+					"200": jsonResponse("Catalogue statistics", map[string]interface{}{"stats": ref("MovieStats")}),
+				},
+			},
+		},
+		"/v1/movies/{id}": map[string]interface{}{
+			"parameters": []interface{}{idParam},
+			"get": map[string]interface{}{
+				"summary": "Show the details of a specific movie",
+				"responses": map[string]interface{}{
+					"200": jsonAPIAlternative(jsonResponse("The movie", map[string]interface{}{"movie": ref("Movie")}), false),
+					"304": map[string]interface{}{"description": "The movie has not been modified"},
+					"404": errorResponse("Movie not found"),
+				},
+			},
+			"patch": map[string]interface{}{
+				"summary":     "Update the details of a specific movie",
+				"requestBody": jsonBody(movieInput),
+				"responses": map[string]interface{}{
+					"200": jsonResponse("The updated movie", map[string]interface{}{"movie": ref("Movie")}),
+					"404": errorResponse("Movie not found"),
+					"409": errorResponse("Edit conflict"),
+					"422": errorResponse("Failed validation"),
+				},
+			},
+			"delete": map[string]interface{}{
+				"summary": "Delete a specific movie",
+				"parameters": []interface{}{
+					map[string]interface{}{"name": "If-Match", "in": "header", "schema": str, "description": "Only delete the movie if it has this version"},
+				},
+				"responses": map[string]interface{}{
+					"200": jsonResponse("Confirmation message", map[string]interface{}{"message": str}),
+					"204": map[string]interface{}{"description": "Movie deleted, with -delete-response=204"},
+					"400": errorResponse("Invalid If-Match header"),
+					"404": errorResponse("Movie not found"),
+					"409": errorResponse("Edit conflict"),
+				},
+			},
+		},
+		"/v1/movies/{id}/related": map[string]interface{}{
+			"parameters": []interface{}{idParam},
+			"get": map[string]interface{}{
+				"summary":    "Show the movies sharing the most genres with a specific movie",
+				"parameters": []interface{}{queryParam("limit", integer)},
+				"responses": map[string]interface{}{
+					"200": jsonResponse("Related movies", map[string]interface{}{
+						"movies": map[string]interface{}{"type": "array", "items": ref("Movie")},
+					}),
+					"404": errorResponse("Movie not found"),
+				},
+			},
+		},
+		"/v1/movies/{id}/reviews": map[string]interface{}{
+			"parameters": []interface{}{idParam},
+			"get": map[string]interface{}{
+				"summary": "Show the reviews of a specific movie",
+				"parameters": []interface{}{
+					queryParam("min_rating", integer),
+					queryParam("page", integer),
+					queryParam("page_size", integer),
+					queryParam("sort", str),
+				},
+				"responses": map[string]interface{}{
+					"200": jsonResponse("A page of reviews", map[string]interface{}{
+						"reviews":  map[string]interface{}{"type": "array", "items": ref("Review")},
+						"metadata": ref("Metadata"),
+					}),
+					"404": errorResponse("Movie not found"),
+					"422": errorResponse("Invalid query parameters"),
+				},
+			},
+		},
+		"/v1/movies/{id}/poster": map[string]interface{}{
+			"parameters": []interface{}{idParam},
+			"post": map[string]interface{}{
+				"summary": "Upload the poster of a specific movie (requires the movies:write permission)",
+				"requestBody": map[string]interface{}{
+					"required": true,
+					"content": map[string]interface{}{
+						"multipart/form-data": map[string]interface{}{
+							"schema": map[string]interface{}{
+								"type":       "object",
+								"properties": map[string]interface{}{"poster": map[string]interface{}{"type": "string", "format": "binary"}},
+							},
+						},
+					},
+				},
+				"responses": map[string]interface{}{
+					"200": jsonResponse("The updated movie", map[string]interface{}{"movie": ref("Movie")}),
+					"400": errorResponse("Malformed or too large request body"),
+					"403": errorResponse("Missing permission"),
+					"404": errorResponse("Movie not found"),
+					"409": errorResponse("Edit conflict"),
+					"422": errorResponse("The poster is missing or isn't a JPEG or PNG image"),
+				},
+			},
+		},
+		"/v1/movies/{id}/touch": map[string]interface{}{
+			"parameters": []interface{}{idParam},
+			"patch": map[string]interface{}{
+				"summary": "Increment the version of a specific movie without changing it (requires the movies:write permission)",
+				"parameters": []interface{}{
+					map[string]interface{}{"name": "If-Match", "in": "header", "schema": str, "description": "Only touch the movie if it has this version"},
+				},
+				"responses": map[string]interface{}{
+					"200": jsonResponse("The movie with its new version", map[string]interface{}{"movie": ref("Movie")}),
+					"400": errorResponse("Invalid If-Match header"),
+					"401": errorResponse("Authentication required"),
+					"403": errorResponse("Missing permission"),
+					"404": errorResponse("Movie not found"),
+					"409": errorResponse("Edit conflict"),
+				},
+			},
+		},
+		"/v1/posters/{name}": map[string]interface{}{
+			"get": map[string]interface{}{
+				"summary":    "Show an uploaded movie poster",
+				"parameters": []interface{}{map[string]interface{}{"name": "name", "in": "path", "required": true, "schema": str}},
+				"responses": map[string]interface{}{
+					"200": map[string]interface{}{
+						"description": "The poster image",
+						"content": map[string]interface{}{
+							"image/jpeg": map[string]interface{}{"schema": map[string]interface{}{"type": "string", "format": "binary"}},
+							"image/png":  map[string]interface{}{"schema": map[string]interface{}{"type": "string", "format": "binary"}},
+						},
+					},
+					"404": errorResponse("Poster not found"),
+				},
+			},
+		},
+		"/v1/users": map[string]interface{}{
+			"post": map[string]interface{}{
+				"summary":     "Register a new user",
+				"requestBody": jsonBody(map[string]interface{}{"name": str, "email": str, "password": str}),
+				"responses": map[string]interface{}{
+					"202": jsonResponse("The registered user", map[string]interface{}{"user": ref("User")}),
+					"422": errorResponse("Failed validation"),
+				},
+			},
+		},
+		"/v1/users/me": map[string]interface{}{
+			"get": map[string]interface{}{
+				"summary": "Show the profile of the current user",
+				"responses": map[string]interface{}{
+					"200": jsonResponse("The current user", map[string]interface{}{"user": ref("User")}),
+					"401": errorResponse("Authentication required"),
+				},
+			},
+		},
+		"/v1/users/email-available": map[string]interface{}{
+			"get": map[string]interface{}{
+				"summary":    "Show whether an email address is available for registration",
+				"parameters": []interface{}{queryParam("email", str)},
+				"responses": map[string]interface{}{
+					"200": jsonResponse("Whether the email address is available", map[string]interface{}{"available": map[string]interface{}{"type": "boolean"}}),
+					"422": errorResponse("Invalid email address"),
+					"429": errorResponse("Rate limit exceeded"),
+				},
+			},
+		},
+		"/v1/users/me/movies": map[string]interface{}{
+			"get": map[string]interface{}{
+				"summary": "Show the movies created by the current user",
+				"parameters": []interface{}{
+					queryParam("page", integer),
+					queryParam("page_size", integer),
+					queryParam("sort", str),
+				},
+				"responses": map[string]interface{}{
+					"200": jsonResponse("A page of movies", map[string]interface{}{
+						"movies":   map[string]interface{}{"type": "array", "items": ref("Movie")},
+						"metadata": ref("Metadata"),
+					}),
+					"401": errorResponse("Authentication required"),
+					"422": errorResponse("Invalid query parameters"),
+				},
+			},
+		},
+		"/v1/tokens/authentication": map[string]interface{}{
+			"post": map[string]interface{}{
+				"summary":     "Generate a new authentication token",
+				"requestBody": jsonBody(map[string]interface{}{"email": str, "password": str}),
+				"responses": map[string]interface{}{
+					"201": jsonResponse("The authentication token", map[string]interface{}{"authentication_token": ref("Token")}),
+					"401": errorResponse("Invalid credentials"),
+				},
+			},
+			"delete": map[string]interface{}{
+				"summary": "Delete the authentication tokens of the current user",
+				"responses": map[string]interface{}{
+					"200": jsonResponse("Confirmation message", map[string]interface{}{"message": str}),
+					"401": errorResponse("Authentication required"),
+				},
+			},
+		},
+		"/v1/tokens/introspect": map[string]interface{}{
+			"post": map[string]interface{}{
+				"summary":     "Check an authentication token (requires the tokens:introspect permission)",
+				"requestBody": jsonBody(map[string]interface{}{"token": str}),
+				"responses": map[string]interface{}{
+					"200": jsonResponse("Whether the token is active, with its user and expiry if it is", map[string]interface{}{
+						"active":    map[string]interface{}{"type": "boolean"},
+						"user_id":   integer,
+						"activated": map[string]interface{}{"type": "boolean"},
+						"expiry":    map[string]interface{}{"type": "string", "format": "date-time"},
+					}),
+					"401": errorResponse("Authentication required"),
+					"403": errorResponse("Missing permission, or source IP address not allowed"),
+					"422": errorResponse("Failed validation"),
+				},
+			},
+		},
+		"/v1/tokens/sessions": map[string]interface{}{
+			"get": map[string]interface{}{
+				"summary": "Show the active sessions of the current user",
+				"responses": map[string]interface{}{
+					"200": jsonResponse("Active sessions", map[string]interface{}{
+						"sessions": map[string]interface{}{"type": "array", "items": ref("Session")},
+					}),
+					"401": errorResponse("Authentication required"),
+				},
+			},
+		},
+		"/v1/audit": map[string]interface{}{
+			"get": map[string]interface{}{
+				"summary": "Show the audit log of movie changes (requires the audit:read permission)",
+				"parameters": []interface{}{
+					queryParam("page", integer),
+					queryParam("page_size", integer),
+					queryParam("sort", str),
+				},
+				"responses": map[string]interface{}{
+					"200": jsonResponse("A page of audit log entries", map[string]interface{}{
+						"audit":    map[string]interface{}{"type": "array", "items": ref("AuditEntry")},
+						"metadata": ref("Metadata"),
+					}),
+					"401": errorResponse("Authentication required"),
+					"403": errorResponse("Missing permission, or source IP address not allowed"),
+				},
+			},
+		},
+		"/v1/webhooks": map[string]interface{}{
+			"get": map[string]interface{}{
+				"summary": "Show the details of the webhook subscriptions of the current user (requires the webhooks:read permission)",
+				"responses": map[string]interface{}{
+					"200": jsonResponse("Webhook subscriptions", map[string]interface{}{
+						"webhooks": map[string]interface{}{"type": "array", "items": ref("Webhook")},
+					}),
+					"401": errorResponse("Authentication required"),
+					"403": errorResponse("Missing permission"),
+				},
+			},
+			"post": map[string]interface{}{
+				"summary":     "Register a new webhook subscription (requires the webhooks:write permission)",
+				"requestBody": jsonBody(map[string]interface{}{"url": str, "events": stringArray}),
+				"responses": map[string]interface{}{
+					"201": jsonResponse("The webhook subscription and its secret", map[string]interface{}{
+						"webhook": ref("Webhook"),
+						"secret":  str,
+					}),
+					"401": errorResponse("Authentication required"),
+					"403": errorResponse("Missing permission"),
+					"422": errorResponse("Failed validation"),
+				},
+			},
+		},
+		"/v1/webhooks/{id}": map[string]interface{}{
+			"parameters": []interface{}{idParam},
+			"delete": map[string]interface{}{
+				"summary": "Delete a specific webhook subscription of the current user (requires the webhooks:write permission)",
+				"responses": map[string]interface{}{
+					"200": jsonResponse("Confirmation message", map[string]interface{}{"message": str}),
+					"401": errorResponse("Authentication required"),
+					"403": errorResponse("Missing permission"),
+					"404": errorResponse("Webhook not found"),
+				},
+			},
+		},
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "Greenlight API",
+			"version": version,
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": map[string]interface{}{
+				"AuditEntry": schemaFor(reflect.TypeOf(data.AuditEntry{})),
+				"Movie":      schemaFor(reflect.TypeOf(data.Movie{})),
+				"User":       schemaFor(reflect.TypeOf(data.User{})),
+				"Token":      schemaFor(reflect.TypeOf(data.Token{})),
+				"Webhook":    schemaFor(reflect.TypeOf(data.Webhook{})),
+				"Metadata":   schemaFor(reflect.TypeOf(data.Metadata{})),
+				"MovieStats": schemaFor(reflect.TypeOf(data.MovieStats{})),
+				"Review":     schemaFor(reflect.TypeOf(data.Review{})),
+				"Session":    schemaFor(reflect.TypeOf(data.Session{})),
+				// The error envelope holds either a message, or a map of field names to validation errors.
+				"Error": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"error": map[string]interface{}{
+							"oneOf": []interface{}{str, map[string]interface{}{"type": "object", "additionalProperties": str}},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// writeOpenAPISpec() writes the OpenAPI document as indented JSON to the destination.
+func writeOpenAPISpec(w io.Writer) error {
+	js, err := json.MarshalIndent(openAPISpec(), "", "\t")
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(append(js, '\n'))
+	return err
+}
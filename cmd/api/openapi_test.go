@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// collectRefs() appends every $ref found anywhere in the decoded JSON value.
+func collectRefs(v interface{}, refs []string) []string {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if s, ok := value.(string); ok && key == "$ref" {
+				refs = append(refs, s)
+				continue
+			}
+			refs = collectRefs(value, refs)
+		}
+	case []interface{}:
+		for _, value := range v {
+			refs = collectRefs(value, refs)
+		}
+	}
+	return refs
+}
+
+func TestWriteOpenAPISpec(t *testing.T) {
+	var buf bytes.Buffer
+
+	err := writeOpenAPISpec(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !json.Valid(buf.Bytes()) {
+		t.Fatal("the OpenAPI document isn't valid JSON")
+	}
+
+	var spec struct {
+		OpenAPI    string                            `json:"openapi"`
+		Paths      map[string]map[string]interface{} `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]interface{} `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+
+	err = json.Unmarshal(buf.Bytes(), &spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(spec.OpenAPI, "3.0") {
+		t.Errorf("got openapi %q; want 3.0.x", spec.OpenAPI)
+	}
+
+	paths := map[string][]string{
+		"/v1/movies":      {"get", "post"},
+		"/v1/movies/{id}": {"get", "patch", "delete"},
+		"/v1/users":       {"post"},
+	}
+	for path, methods := range paths {
+		operations, ok := spec.Paths[path]
+		if !ok {
+			t.Errorf("no path %s", path)
+			continue
+		}
+		for _, method := range methods {
+			if _, ok := operations[method]; !ok {
+				t.Errorf("no %s operation on %s", method, path)
+			}
+		}
+	}
+
+	// The schemas are derived from the struct tags: fields tagged "-" (like the password hash) are left out.
+	movie := spec.Components.Schemas["Movie"].Properties
+	for _, name := range []string{"id", "title", "year", "runtime", "genres", "version"} {
+		if _, ok := movie[name]; !ok {
+			t.Errorf("the Movie schema has no %s property", name)
+		}
+	}
+	if _, ok := spec.Components.Schemas["User"].Properties["password"]; ok {
+		t.Error("the User schema includes the password")
+	}
+
+	var raw interface{}
+	err = json.Unmarshal(buf.Bytes(), &raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ref := range collectRefs(raw, nil) {
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+		if _, ok := spec.Components.Schemas[name]; !ok || name == ref {
+			t.Errorf("$ref %q doesn't point to a schema of the document", ref)
+		}
+	}
+}
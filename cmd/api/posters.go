@@ -0,0 +1,182 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/jseow5177/greenlight/internal/data"
+	"github.com/jseow5177/greenlight/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+// posterExtensions maps the accepted poster content types to the extension of the stored file.
+var posterExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+}
+
+// Add an uploadMoviePosterHandler for "POST /v1/movies/:id/poster".
+// The poster is sent as the "poster" file of a multipart/form-data body. It is stored in the poster directory
+// under a random name, and its URL is recorded on the movie.
+func (app *application) uploadMoviePosterHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readMovieIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// Read the movie from the primary database, since its poster is about to be changed.
+	movie, err := app.modelsFor(r).Movies.GetForUpdate(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	original := *movie
+
+	// Limit the size of the whole request body, so that a huge upload is rejected before it is buffered.
+	body := limitBody(w, r, app.config.poster.maxBytes)
+
+	file, _, err := r.FormFile("poster")
+	if err != nil {
+		switch {
+		case errors.Is(err, http.ErrMissingFile):
+			v := validator.New()
+			v.AddError("poster", "must be provided")
+			app.failedValidationResponse(w, r, v)
+		case errors.Is(err, http.ErrNotMultipart):
+			app.badRequestResponse(w, r, errors.New("body must be multipart/form-data"))
+		// The multipart parser doesn't wrap the error from the body with %w, so ask the body instead.
+		case body.exceeded:
+			app.badRequestResponse(w, r, &bodyTooLargeError{limit: body.limit})
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+	defer file.Close()
+
+	image, err := io.ReadAll(file)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Don't trust the Content-Type of the part: detect the type from the content itself.
+	ext, ok := posterExtensions[http.DetectContentType(image)]
+	if !ok {
+		v := validator.New()
+		v.AddError("poster", "must be a JPEG or PNG image")
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	name, err := posterName(ext)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = os.WriteFile(filepath.Join(app.config.poster.dir, name), image, 0644)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	movie.Poster = app.posterURL(name)
+
+	err = app.modelsFor(r).Movies.UpdatePoster(movie)
+	if err != nil {
+		// The movie doesn't point to the new file, so don't leave it behind.
+		app.removePoster(movie.Poster)
+
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// The previous poster, if any, is no longer used.
+	app.removePoster(original.Poster)
+
+	app.recordAudit(r, data.AuditActionUpdate, "movie", movie.ID, &original, movie)
+	app.notifyWebhooks(data.EventMovieUpdated, envelope{"movie": movie})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Add a showPosterHandler for "GET /v1/posters/:name", which serves the stored posters when no -poster-url-prefix
+// is set. Only plain file names are accepted, so files outside the poster directory can't be reached.
+func (app *application) showPosterHandler(w http.ResponseWriter, r *http.Request) {
+	name := httprouter.ParamsFromContext(r.Context()).ByName("name")
+	if name == "" || name != path.Base(name) || strings.HasPrefix(name, ".") {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if _, err := os.Stat(filepath.Join(app.config.poster.dir, name)); err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	http.ServeFile(w, r, filepath.Join(app.config.poster.dir, name))
+}
+
+// posterName() returns a random file name with the given extension. Random names can't be guessed from the
+// movie ID, and a new upload never overwrites a file which may still be cached.
+func posterName(ext string) (string, error) {
+	b := make([]byte, 16)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b) + ext, nil
+}
+
+// posterURL() returns the URL at which a stored poster is served: under the -poster-url-prefix if one is set
+// (e.g. a CDN or object store which the poster directory is synced to), or by the application otherwise.
+func (app *application) posterURL(name string) string {
+	if app.config.poster.urlPrefix != "" {
+		return strings.TrimSuffix(app.config.poster.urlPrefix, "/") + "/" + name
+	}
+	return "/v1/posters/" + name
+}
+
+// removePoster() deletes the file of a poster stored by this application, given its URL. Errors are only logged,
+// because a leftover file doesn't affect the API.
+func (app *application) removePoster(url string) {
+	if url == "" {
+		return
+	}
+
+	name := path.Base(url)
+	if app.posterURL(name) != url {
+		// The poster wasn't stored under the current URL prefix, so it isn't ours to delete.
+		return
+	}
+
+	err := os.Remove(filepath.Join(app.config.poster.dir, name))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		app.logger.PrintError(err, map[string]string{"poster": name})
+	}
+}
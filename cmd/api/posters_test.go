@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// uploadPoster() uploads the content as the poster of the movie, and returns the response.
+func uploadPoster(t *testing.T, h http.Handler, token string, id int64, content []byte) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, err := mw.CreateFormFile("poster", "poster.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(content)
+	mw.Close()
+
+	r := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/v1/movies/%d/poster", id), &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	r.Header.Set("Authorization", "Bearer "+token)
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+
+	return rr
+}
+
+// testPNG() returns a 1x1 PNG image.
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 1, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestUploadPoster(t *testing.T) {
+	app := newTestApplicationWithDB(t)
+	h := app.routes()
+
+	_, token := newTestUser(t, app, "editor@example.com", "movies:read", "movies:write")
+	id := movieID(t, createTestMovie(t, h, token, moanaJSON, nil))
+
+	content := testPNG(t)
+
+	// The part claims to be a JPEG, but the type is detected from the content.
+	rr := uploadPoster(t, h, token, id, content)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+
+	var resp struct {
+		Movie struct {
+			Poster string `json:"poster"`
+		} `json:"movie"`
+	}
+	err := json.Unmarshal(rr.Body.Bytes(), &resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(resp.Movie.Poster, "/v1/posters/") || !strings.HasSuffix(resp.Movie.Poster, ".png") {
+		t.Fatalf("got poster URL %q; want a PNG under /v1/posters/", resp.Movie.Poster)
+	}
+
+	// The poster is served back, and is in the movie JSON.
+	rr = do(t, h, http.MethodGet, resp.Movie.Poster, "", nil)
+	if rr.Code != http.StatusOK || !bytes.Equal(rr.Body.Bytes(), content) {
+		t.Errorf("GET %s: got status %d and %d bytes; want %d and the uploaded %d bytes", resp.Movie.Poster, rr.Code, rr.Body.Len(), http.StatusOK, len(content))
+	}
+
+	rr = do(t, h, http.MethodGet, fmt.Sprintf("/v1/movies/%d", id), "", nil)
+	if !strings.Contains(rr.Body.String(), resp.Movie.Poster) {
+		t.Errorf("the movie doesn't have the poster URL: %s", rr.Body)
+	}
+}
+
+func TestUploadPosterNotAnImage(t *testing.T) {
+	app := newTestApplicationWithDB(t)
+	h := app.routes()
+
+	_, token := newTestUser(t, app, "editor@example.com", "movies:read", "movies:write")
+	id := movieID(t, createTestMovie(t, h, token, moanaJSON, nil))
+
+	rr := uploadPoster(t, h, token, id, []byte("<html><body>not a poster</body></html>"))
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("got status %d; want %d: %s", rr.Code, http.StatusUnprocessableEntity, rr.Body)
+	}
+
+	// Nothing was stored.
+	entries, err := os.ReadDir(app.config.poster.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("got %d files in the poster directory; want 0", len(entries))
+	}
+}
+
+func TestUploadPosterTooLarge(t *testing.T) {
+	app := newTestApplicationWithDB(t)
+	app.config.poster.maxBytes = 1024
+	h := app.routes()
+
+	_, token := newTestUser(t, app, "editor@example.com", "movies:read", "movies:write")
+	id := movieID(t, createTestMovie(t, h, token, moanaJSON, nil))
+
+	rr := uploadPoster(t, h, token, id, bytes.Repeat([]byte{0xff}, 4096))
+
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("got status %d; want %d: %s", rr.Code, http.StatusBadRequest, rr.Body)
+	}
+
+	var resp struct {
+		Error string `json:"error"`
+	}
+	err := json.Unmarshal(rr.Body.Bytes(), &resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "body must not be larger than 1024 bytes"; resp.Error != want {
+		t.Errorf("got error %q; want %q", resp.Error, want)
+	}
+}
+
+func TestShowPosterInvalidName(t *testing.T) {
+	app := newTestApplication(t)
+	h := app.routes()
+
+	err := os.WriteFile(filepath.Join(app.config.poster.dir, ".hidden"), []byte("secret"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, url := range []string{"/v1/posters/.hidden", "/v1/posters/missing.png", "/v1/posters/..%2Ftestutils_test.go"} {
+		rr := do(t, h, http.MethodGet, url, "", nil)
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("GET %s: got status %d; want %d", url, rr.Code, http.StatusNotFound)
+		}
+	}
+}
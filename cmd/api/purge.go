@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Add a purgeMoviesHandler for "DELETE /v1/movies/purge"
+// It permanently deletes the movies which were soft deleted longer than -soft-delete-retention ago, and
+// responds with the number of movies purged.
+func (app *application) purgeMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	purged, err := app.purgeMovies()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"purged": purged}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// purgeMovies() permanently deletes the soft deleted movies past the retention period, removes their poster
+// files and returns the number of movies purged.
+func (app *application) purgeMovies() (int, error) {
+	movies, err := app.models.Movies.Purge(app.config.softDelete.retention)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, movie := range movies {
+		app.removePoster(movie.Poster)
+	}
+
+	return len(movies), nil
+}
+
+// schedulePurge() runs purgeMovies() in a background routine every -soft-delete-purge-interval, until the
+// server shuts down. The routine is tracked by the WaitGroup, so a purge which is under way when the shutdown
+// signal arrives is allowed to complete.
+func (app *application) schedulePurge() {
+	app.runBackground(func() {
+		ticker := time.NewTicker(app.config.softDelete.purgeInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-app.done:
+				return
+			case <-ticker.C:
+				purged, err := app.purgeMovies()
+				if err != nil {
+					app.logger.PrintError(err, nil)
+					continue
+				}
+
+				app.logger.PrintInfo("purged deleted movies", map[string]string{
+					"purged": strconv.Itoa(purged),
+				})
+			}
+		}
+	})
+}
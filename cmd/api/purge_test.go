@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPurgeMovies(t *testing.T) {
+	app := newTestApplicationWithDB(t)
+	h := app.routes()
+
+	_, reader := newTestUser(t, app, "reader@example.com", "movies:read")
+	_, editor := newTestUser(t, app, "editor@example.com", "movies:read", "movies:write")
+
+	id := movieID(t, createTestMovie(t, h, editor, moanaJSON, nil))
+
+	rr := do(t, h, http.MethodDelete, fmt.Sprintf("/v1/movies/%d", id), editor, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("delete: got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+
+	// Make the movie deleted past the retention period, with a stored poster.
+	poster := filepath.Join(app.config.poster.dir, "purged.png")
+	err := os.WriteFile(poster, []byte("poster"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = app.db.Exec("UPDATE movies SET deleted_at = NOW() - $1 * INTERVAL '1 second' - INTERVAL '1 hour', poster = '/v1/posters/purged.png' WHERE id = $2",
+		app.config.softDelete.retention.Seconds(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr = do(t, h, http.MethodDelete, "/v1/movies/purge", reader, nil)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("without movies:write: got status %d; want %d", rr.Code, http.StatusForbidden)
+	}
+
+	rr = do(t, h, http.MethodDelete, "/v1/movies/purge", editor, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("purge: got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+
+	var resp struct {
+		Purged int `json:"purged"`
+	}
+	err = json.Unmarshal(rr.Body.Bytes(), &resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Purged != 1 {
+		t.Errorf("got %d movies purged; want 1", resp.Purged)
+	}
+
+	if _, err := os.Stat(poster); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("the poster of the purged movie wasn't removed: %v", err)
+	}
+}
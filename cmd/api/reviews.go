@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/jseow5177/greenlight/internal/data"
+	"github.com/jseow5177/greenlight/internal/validator"
+)
+
+// Add a listMovieReviewsHandler for "GET /v1/movies/:id/reviews"
+// The reviews are paginated and sorted with the same Filters as the movies listing.
+func (app *application) listMovieReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readMovieIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		MinRating int
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	// min_rating defaults to 0, which includes every review
+	input.MinRating = app.readInt(qs, "min_rating", 0, v)
+
+	// Show the most recent reviews first by default
+	input.Filters.Sort = app.readString(qs, "sort", "-created_at")
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", app.config.defaultPageSize, v)
+	input.Filters.MaxPageSize = app.config.maxPageSize
+	input.Filters.MaxOffset = app.config.maxOffset
+
+	// Add the supported sort values for this endpoint to sort safelist
+	input.Filters = input.Filters.WithSortSafeList("created_at", "rating", "-created_at", "-rating")
+
+	v.Check(input.MinRating >= 0, "min_rating", "must not be negative")
+	v.Checkf(input.MinRating <= 5, "min_rating", "must be a maximum of %d", 5)
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	// Send a 404 Not Found response if the movie doesn't exist, rather than an empty list
+	_, err = app.modelsFor(r).Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	reviews, metadata, err := app.modelsFor(r).Reviews.GetAllForMovie(id, input.MinRating, input.Filters)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInvalidSort):
+			v.AddError("sort", "invalid sort value")
+			app.failedValidationResponse(w, r, v)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"reviews": reviews, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestListMovieReviewsValidation(t *testing.T) {
+	app := newTestApplication(t)
+	h := app.routes()
+
+	tests := []struct {
+		query string
+		field string
+	}{
+		{"min_rating=6", "min_rating"},
+		{"min_rating=-1", "min_rating"},
+		{"sort=title", "sort"},
+		{"page=0", "page"},
+		{"page_size=1000", "page_size"},
+	}
+
+	for _, tt := range tests {
+		rr := do(t, h, http.MethodGet, "/v1/movies/1/reviews?"+tt.query, "", nil)
+		if rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("%s: got status %d; want %d", tt.query, rr.Code, http.StatusUnprocessableEntity)
+			continue
+		}
+		if !strings.Contains(rr.Body.String(), `"`+tt.field+`"`) {
+			t.Errorf("%s: the response doesn't mention %s: %s", tt.query, tt.field, rr.Body)
+		}
+	}
+}
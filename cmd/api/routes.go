@@ -1,6 +1,7 @@
 package main
 
 import (
+	"expvar"
 	"net/http"
 
 	"github.com/julienschmidt/httprouter"
@@ -24,12 +25,86 @@ func (app *application) routes() http.Handler {
 
 	// Register the relevant methods, URL patterns and handler functions for the endpoints using the HandlerFunc() method.
 	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/readyz", app.readinessHandler)
 	router.HandlerFunc(http.MethodGet, "/v1/movies", app.listMoviesHandler)
 	router.HandlerFunc(http.MethodPost, "/v1/movies", app.createMovieHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.showMovieHandler)
+	router.HandlerFunc(http.MethodOptions, "/v1/movies", app.movieOptionsHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.namedRoutes(app.showMovieHandler, map[string]http.HandlerFunc{
+		"random": app.randomMovieHandler,
+		"stats":  app.showMovieStatsHandler,
+		"sync":   app.syncMoviesHandler,
+	}))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/related", app.listRelatedMoviesHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/reviews", app.listMovieReviewsHandler)
 	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.updateMovieHandler)
-	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.deleteMovieHandler)
+	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id/touch", app.requirePermission("movies:write", app.touchMovieHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/poster", app.requirePermission("movies:write", app.uploadMoviePosterHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/posters/:name", app.showPosterHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.namedRoutes(app.deleteMovieHandler, map[string]http.HandlerFunc{
+		"batch": app.requirePermission("movies:write", app.deleteManyMoviesHandler),
+		"purge": app.requirePermission("movies:write", app.purgeMoviesHandler),
+	}))
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/users/me", app.requireAuthenticatedUser(app.showCurrentUserHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/email-available", app.checkEmailAvailableHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/movies", app.requireAuthenticatedUser(app.listCurrentUserMoviesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication", app.requireAuthenticatedUser(app.deleteAuthenticationTokensHandler))
+	// Token introspection, the audit log and the metrics are for internal services and operators, so ipFilter()
+	// restricts them to the networks given with -ip-allow and -ip-deny.
+	router.Handler(http.MethodPost, "/v1/tokens/introspect", app.ipFilter(app.requirePermission("tokens:introspect", app.introspectTokenHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/tokens/sessions", app.requireAuthenticatedUser(app.listSessionsHandler))
+	router.Handler(http.MethodGet, "/v1/audit", app.ipFilter(app.requirePermission("audit:read", app.listAuditHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/webhooks", app.requirePermission("webhooks:read", app.listWebhooksHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/webhooks", app.requirePermission("webhooks:write", app.registerWebhookHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/webhooks/:id", app.requirePermission("webhooks:write", app.deleteWebhookHandler))
 
-	return app.recoverPanic(app.rateLimit(router))
-}
\ No newline at end of file
+	// The email preview renders templates with sample data, which is only useful while developing them. It is
+	// opt-in with -email-preview, rather than tied to the environment, so that it is never exposed by accident.
+	if app.config.emailPreview {
+		router.HandlerFunc(http.MethodGet, "/v1/dev/email-preview", app.emailPreviewHandler)
+	}
+
+	// Register the expvar handler, which displays the application metrics in JSON.
+	// The metrics give away details of the deployment, so in production they are only registered with the
+	// -expose-metrics flag, and require basic authentication.
+	switch {
+	case app.config.env != "production":
+		router.Handler(http.MethodGet, "/debug/vars", app.ipFilter(expvar.Handler()))
+	case app.config.metrics.expose:
+		router.Handler(http.MethodGet, "/debug/vars", app.ipFilter(app.requireBasicAuth(expvar.Handler())))
+	}
+
+	// Apply a stricter rate limit to the routes which create accounts and credentials, as they are
+	// expensive (bcrypt hashing, sending emails) and are the usual targets of abuse. The email availability
+	// check gets it too, since it could otherwise be used to enumerate the registered email addresses.
+	authLimit := routeLimit{rps: app.config.limiter.authRPS, burst: app.config.limiter.authBurst}
+
+	limits := map[string]routeLimit{
+		"POST /v1/users":                  authLimit,
+		"GET /v1/users/email-available":   authLimit,
+		"POST /v1/tokens/authentication": authLimit,
+	}
+
+	// Clients are limited by IP address before they are authenticated, and privileged users by user ID after.
+	rl := app.newRateLimiter()
+
+	return app.trace(app.recoverPanic(app.serverTiming(app.limitURI(app.enforceHTTPS(app.compress(app.rateLimit(rl, app.authenticate(app.rateLimitPrivileged(rl, router)), limits)))))))
+}
+
+// httprouter does not allow a static path segment in the same position as a named parameter, so routes
+// such as "/v1/movies/random" cannot be registered alongside "/v1/movies/:id".
+// namedRoutes() works around this by dispatching to the handler registered for a name when the :id
+// parameter matches it exactly, and to the next handler otherwise.
+func (app *application) namedRoutes(next http.HandlerFunc, routes map[string]http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := httprouter.ParamsFromContext(r.Context())
+
+		if handler, found := routes[params.ByName("id")]; found {
+			handler(w, r)
+			return
+		}
+
+		next(w, r)
+	}
+}
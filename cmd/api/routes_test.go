@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDebugVarsEnvironment(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      string
+		expose   bool
+		username string // Sent as basic authentication credentials if not empty
+		want     int
+	}{
+		{"development", "development", false, "", http.StatusOK},
+		{"staging", "staging", false, "", http.StatusOK},
+		{"production", "production", false, "", http.StatusNotFound},
+		{"production exposed without credentials", "production", true, "", http.StatusUnauthorized},
+		{"production exposed with wrong credentials", "production", true, "mallory", http.StatusUnauthorized},
+		{"production exposed with credentials", "production", true, "metrics", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		app := newTestApplication(t)
+		app.config.env = tt.env
+		app.config.metrics.expose = tt.expose
+		app.config.metrics.username = "metrics"
+		app.config.metrics.password = "metrics-password"
+
+		r := httptest.NewRequest(http.MethodGet, "/debug/vars", nil)
+		if tt.username != "" {
+			r.SetBasicAuth(tt.username, "metrics-password")
+		}
+		rr := httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, r)
+
+		if rr.Code != tt.want {
+			t.Errorf("%s: got status %d; want %d", tt.name, rr.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/jseow5177/greenlight/internal/migrate"
+	"github.com/jseow5177/greenlight/migrations"
+)
+
+// checkSchema() makes sure that the database schema is up to date with the migrations embedded in the binary,
+// so that the server doesn't start answering every request with a 500 Internal Server Error because a table or
+// column is missing. The returned error lists the migrations which haven't been applied.
+// A database which is ahead of the binary (e.g. during a rolling deployment) passes the check.
+func checkSchema(db *sql.DB) error {
+	migrator, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		return err
+	}
+
+	pending, dirty, err := migrator.Pending()
+	if err != nil {
+		return fmt.Errorf("checking the database schema: %w", err)
+	}
+
+	if dirty {
+		return migrate.ErrDirty
+	}
+
+	if len(pending) > 0 {
+		return fmt.Errorf("the database schema is missing %d migrations (%s): apply them with \"go run ./cmd/migrate up\", or start with -skip-schema-check",
+			len(pending), strings.Join(pending, ", "))
+	}
+
+	return nil
+}
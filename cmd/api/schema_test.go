@@ -0,0 +1,84 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/jseow5177/greenlight/internal/migrate"
+	"github.com/jseow5177/greenlight/internal/testdb"
+	"github.com/jseow5177/greenlight/migrations"
+)
+
+func TestCheckSchemaMissingTables(t *testing.T) {
+	// A database to which no migration has been applied has no movies table.
+	db := testdb.OpenEmpty(t)
+
+	err := checkSchema(db)
+	if err == nil {
+		t.Fatal("the check passed without the movies table")
+	}
+
+	for _, want := range []string{"000001_create_movies_table", "go run ./cmd/migrate up", "-skip-schema-check"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("got error %q; want it to mention %q", err, want)
+		}
+	}
+
+	// The check doesn't create the schema_migrations table as a side effect.
+	var exists bool
+	err = db.QueryRow(`SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&exists)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Error("the check created the schema_migrations table")
+	}
+}
+
+func TestCheckSchema(t *testing.T) {
+	db := testdb.Open(t)
+
+	err := checkSchema(db)
+	if err != nil {
+		t.Fatalf("got error %v for an up-to-date schema", err)
+	}
+
+	m, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the migrations which haven't been applied are listed.
+	err = m.Down(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = checkSchema(db)
+	if err == nil || !strings.Contains(err.Error(), "missing 1 migrations") || strings.Contains(err.Error(), "000001_") {
+		t.Errorf("got error %v; want only the last migration to be missing", err)
+	}
+
+	// A migration which failed part way through leaves the schema in an unknown state.
+	_, err = db.Exec("UPDATE schema_migrations SET dirty = true")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = checkSchema(db)
+	if !errors.Is(err, migrate.ErrDirty) {
+		t.Errorf("got error %v; want %v", err, migrate.ErrDirty)
+	}
+}
+
+func TestCheckSchemaUnreachable(t *testing.T) {
+	db := sql.OpenDB(&countingConnector{err: errors.New("connection refused")})
+	defer db.Close()
+
+	err := checkSchema(db)
+	if err == nil || !strings.Contains(err.Error(), "checking the database schema") {
+		t.Errorf("got error %v; want it to say that the schema couldn't be checked", err)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/jseow5177/greenlight/internal/data"
+	"github.com/jseow5177/greenlight/internal/validator"
+)
+
+// Word lists used to generate realistic looking movie titles.
+var (
+	seedAdjectives = []string{"Silent", "Last", "Hidden", "Broken", "Golden", "Midnight", "Crimson", "Forgotten", "Wild", "Electric", "Distant", "Little"}
+	seedNouns      = []string{"River", "Empire", "Garden", "Voyage", "Kingdom", "Signal", "Harbor", "Storm", "Echo", "Frontier", "Letter", "Machine"}
+	seedGenres     = []string{"action", "adventure", "animation", "comedy", "crime", "drama", "fantasy", "horror", "romance", "sci-fi", "thriller", "western"}
+)
+
+// The users inserted by the seed command. They all share the same development password.
+var seedUsers = []struct {
+	name  string
+	email string
+}{
+	{"Alice Smith", "alice@example.com"},
+	{"Bob Jones", "bob@example.com"},
+}
+
+const seedPassword = "pa55word"
+
+// seed() populates the database with count randomly generated movies and a couple of test users.
+// Everything is inserted in a single transaction, so either all of the seed data is added or none of it.
+// If the movies table already contains data the seed is skipped, unless force is true.
+func seed(db *sql.DB, models data.Models, count int, force bool) (int, error) {
+	if count < 1 {
+		return 0, errors.New("seed count must be greater than zero")
+	}
+
+	// Use the existing GetAll() method to find out whether any movies exist.
+	_, metadata, err := models.Movies.GetAll("", []string{}, []string{}, data.QualityFilters{}, data.Filters{
+		Page:     1,
+		PageSize: 1,
+		Sort:     "id",
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if metadata.TotalRecords > 0 && !force {
+		return 0, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	// Rollback() is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	// The title and year of the seeded movies are kept unique, so a generated movie which matches an existing one is
+	// skipped and another one is generated. InsertOrGetTx() doesn't fail on a duplicate, which would abort the
+	// transaction. Give up after a generous number of attempts, in case the word lists run out of combinations.
+	for i, attempts := 0, 0; i < count; attempts++ {
+		if attempts == 10*count {
+			return 0, fmt.Errorf("could not generate %d distinct movies", count)
+		}
+
+		movie := randomMovie(rng)
+
+		v := validator.New()
+		if data.ValidateMovie(v, movie, data.GenreLimits{}); !v.Valid() {
+			return 0, fmt.Errorf("generated an invalid movie: %v", v.Errors)
+		}
+
+		created, err := models.Movies.InsertOrGetTx(tx, movie)
+		if err != nil {
+			return 0, err
+		}
+		if created {
+			i++
+		}
+	}
+
+	for _, u := range seedUsers {
+		// Skip any test user which already exists, so that the seed can be re-run with -seed-force.
+		_, err := models.Users.GetByEmail(u.email)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			return 0, err
+		}
+
+		user := &data.User{
+			Name:      u.name,
+			Email:     u.email,
+			Activated: true,
+		}
+
+		err = user.Password.Set(seedPassword)
+		if err != nil {
+			return 0, err
+		}
+
+		err = models.Users.InsertTx(tx, user)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// randomMovie() generates a movie with a title from the word lists, a release year between 1900 and
+// last year, a runtime between 80 and 180 minutes and 1 to 3 distinct genres.
+func randomMovie(rng *rand.Rand) *data.Movie {
+	title := fmt.Sprintf("The %s %s", seedAdjectives[rng.Intn(len(seedAdjectives))], seedNouns[rng.Intn(len(seedNouns))])
+
+	// ValidateMovie() does not accept movies released in the current year.
+	lastYear := time.Now().Year() - 1
+
+	// Pick 1 to 3 distinct genres by taking a prefix of a random permutation of the list.
+	genres := []string{}
+	for _, i := range rng.Perm(len(seedGenres))[:1+rng.Intn(3)] {
+		genres = append(genres, seedGenres[i])
+	}
+
+	return &data.Movie{
+		Title:   title,
+		Year:    int32(1900 + rng.Intn(lastYear-1900+1)),
+		Runtime: data.Runtime(80 + rng.Intn(101)),
+		Genres:  genres,
+	}
+}
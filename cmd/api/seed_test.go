@@ -0,0 +1,77 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/jseow5177/greenlight/internal/data"
+	"github.com/jseow5177/greenlight/internal/testdb"
+	"github.com/jseow5177/greenlight/internal/validator"
+)
+
+func TestSeed(t *testing.T) {
+	db := testdb.Open(t)
+	models := data.NewModels(db, 0)
+
+	n, err := seed(db, models, 10, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 10 {
+		t.Errorf("seeded %d movies; want 10", n)
+	}
+
+	_, metadata, err := models.Movies.GetAll("", []string{}, nil, data.QualityFilters{}, data.Filters{Page: 1, PageSize: 20, Sort: "id", SortSafeList: []string{"id"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.TotalRecords != 10 {
+		t.Errorf("got %d movies; want 10", metadata.TotalRecords)
+	}
+
+	for _, u := range seedUsers {
+		user, err := models.Users.GetByEmail(u.email)
+		if err != nil {
+			t.Fatalf("test user %s: %v", u.email, err)
+		}
+		if !user.Activated {
+			t.Errorf("test user %s isn't activated", u.email)
+		}
+	}
+
+	// The movies table isn't empty any more, so the seed is skipped without -seed-force.
+	n, err = seed(db, models, 10, false)
+	if err != nil || n != 0 {
+		t.Errorf("second seed: got %d, %v; want 0, nil", n, err)
+	}
+
+	// With -seed-force, more movies are added, and the existing test users are kept.
+	n, err = seed(db, models, 5, true)
+	if err != nil || n != 5 {
+		t.Errorf("forced seed: got %d, %v; want 5, nil", n, err)
+	}
+}
+
+func TestSeedCount(t *testing.T) {
+	_, err := seed(nil, data.Models{}, 0, false)
+	if err == nil {
+		t.Error("seed() with a count of 0: got nil error")
+	}
+}
+
+func TestRandomMovieIsValid(t *testing.T) {
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	for i := 0; i < 1000; i++ {
+		movie := randomMovie(rng)
+
+		v := validator.New()
+		if data.ValidateMovie(v, movie, data.GenreLimits{}); !v.Valid() {
+			t.Fatalf("generated an invalid movie %+v: %v", movie, v.Errors)
+		}
+		if len(movie.Genres) > 3 || movie.Year < 1900 {
+			t.Fatalf("generated movie %+v is outside the seed ranges", movie)
+		}
+	}
+}
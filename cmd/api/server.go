@@ -2,12 +2,17 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
@@ -17,64 +22,75 @@ import (
 // indefinitely for connections to return to idle and then shutdown.
 
 // Basically, it instructs our server to stop receiving new HTTP requests. Our server also gives any in-flight requests
-// a 5-second period to complete before the application is terminated.
+// a period to complete before the application is terminated. The period is set with the -shutdown-timeout flag
+// and defaults to 5 seconds.
 
 func (app *application) serve() error {
-	// Declare a HTTP server
-	// The server listens on the port provided in the config struct and uses the ServeMux
-	// created above as the handler.
-	srv := &http.Server{
-		Addr:    fmt.Sprintf(":%d", app.config.port),
-		Handler: app.routes(),
-		// Go enables persistent HTTP connections by default to reduce latency.
-		// By default, Go closes persistent connections after 3 minutes.
-		// We can reduce this default with the IdleTimeout setting.
-		IdleTimeout: time.Minute,
-		// ReadTimeout covers the time from when request is accepted to when the request body is fully read
-		// (If no body, until the end of headers)
-		ReadTimeout: 10 * time.Second,
-		// WriteTimeout covers the time from the end of the request header read to the end of the
-		// response write (for HTTP).
-		// For HTTPS, it covers the time from when request is accepted to the end of response write.
-		WriteTimeout: 30 * time.Second,
-		// Create a new Go log.logger instance with the log.New() function, passing our own logger as the underlying
-		// io.Writer. The "" and 0 indicate that the log.logger instance should not use any prefix or flags.
-		ErrorLog: log.New(app.logger, "", 0),
+	srv := app.newServer()
+
+	// Configure client certificate verification when the server terminates TLS itself.
+	tlsConfig, err := app.tlsConfig()
+	if err != nil {
+		return err
+	}
+	srv.TLSConfig = tlsConfig
+
+	// When listening on a Unix socket, the TCP address is unused. Show the socket path in the logs instead.
+	if app.config.socket != "" {
+		srv.Addr = "unix:" + app.config.socket
 	}
 
 	// Create a shutdownError channel. This is used to receive any errors returned by the graceful Shutdown() method.
 	shutdownError := make(chan error)
 
-	go func() {
-		// Create a quit channel which carries os.Signal.
-		// Use a buffered channel with size 1.
-		// A buffered channel is required because signal.Notify() does not wait for a receiver
-		// to be available when sending a signal to the quit channel. If an unbuffered channel is used,
-		// the signal could be missed if the quit channel is not ready to receive at the exact moment the signal is sent.
-		// See the empty default case: https://github.com/golang/go/blob/bc7e4d9257693413d57ad467814ab71f1585a155/src/os/signal/signal.go#L243
-		quit := make(chan os.Signal, 1)
-
-		// Use signal.Notify() to listen for incoming SIGINT and SIGTERM signals and relay them to the
-		// quit channel. Any other signals will not be caught by signal.Notify() and will retain their default
-		// behavior.
-		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	// Create a quit channel which carries os.Signal.
+	// Use a buffered channel with size 1.
+	// A buffered channel is required because signal.Notify() does not wait for a receiver
+	// to be available when sending a signal to the quit channel. If an unbuffered channel is used,
+	// the signal could be missed if the quit channel is not ready to receive at the exact moment the signal is sent.
+	// See the empty default case: https://github.com/golang/go/blob/bc7e4d9257693413d57ad467814ab71f1585a155/src/os/signal/signal.go#L243
+	quit := make(chan os.Signal, 1)
 
+	// Use signal.Notify() to listen for incoming SIGINT and SIGTERM signals and relay them to the
+	// quit channel. Any other signals will not be caught by signal.Notify() and will retain their default
+	// behavior. It is called before the server starts listening, so that a signal sent once the server accepts
+	// connections is always caught rather than terminating the process.
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
 		// Read the signal from the quit channel. This code will block until a signal is received.
 		s := <-quit
 
-		// Log a message to say that the signal has been caught.
-		app.logger.PrintInfo("shutting down server", map[string]string{
+		// Mark the application as draining, so that GET /v1/readyz starts failing straight away and the
+		// load balancer stops sending new traffic here. The server keeps serving requests during the drain
+		// delay, giving the load balancer time to notice before the listeners are closed. A second signal
+		// (e.g. pressing Ctrl+C again in development) cuts the delay short.
+		atomic.StoreInt32(&app.draining, 1)
+
+		app.logger.PrintInfo("draining server", map[string]string{
 			"signal": s.String(),
+			"delay":  app.config.drainDelay.String(),
 		})
 
-		// Create a 5-second timeout context
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		select {
+		case <-time.After(app.config.drainDelay):
+		case <-quit:
+		}
+
+		// Log a message to say that the server is shutting down.
+		app.logger.PrintInfo("shutting down server", map[string]string{
+			"signal":  s.String(),
+			"timeout": app.config.shutdownTimeout.String(),
+		})
+
+		// Create a context with the configured shutdown timeout
+		ctx, cancel := context.WithTimeout(context.Background(), app.config.shutdownTimeout)
 		defer cancel()
 
-		// Call Shutdown() on our server, passing in the 5-second timeout context.
+		// Call Shutdown() on our server, passing in the timeout context.
 		// Shutdown() returns nil if the graceful shutdown was successful.
 		// An error may happen if there is a problem of closing the listeners, or because
-		// the shutdown didn't complete before the 5-second context deadline is hit.
+		// the shutdown didn't complete before the context deadline is hit.
 		// The error is relayed to the shutdownError channel.
 		err := srv.Shutdown(ctx)
 		if err != nil {
@@ -87,6 +103,13 @@ func (app *application) serve() error {
 			"addr": srv.Addr,
 		})
 
+		// Stop the scheduled background tasks, such as the purge of deleted movies, and close the email queue:
+		// the workers send the remaining emails and stop. If Shutdown() failed, some handlers may still be
+		// running, so closeEmails() makes any email they enqueue from now on be dropped and logged, rather than
+		// panic on the closed channel.
+		close(app.done)
+		app.closeEmails()
+
 		// Call Wait() to block until the WaitGroup counter is zero -- essentially blocking until
 		// the background routines have finished. Then we return nil on the shutdownError channel, to indicate that
 		// the shutdown completed without any issues.
@@ -94,15 +117,10 @@ func (app *application) serve() error {
 		shutdownError <- nil
 	}()
 
-	app.logger.PrintInfo("starting server", map[string]string{
-		"addr": srv.Addr,
-		"env":  app.config.env,
-	})
-
-	// Calling Shutdown() causes ListenAndServe to immediately return a http.ErrServerClosed error.
+	// Calling Shutdown() causes ListenAndServe (and Serve) to immediately return a http.ErrServerClosed error.
 	// This error indicates that the graceful shutdown has started (a good thing).
 	// We return any error that is NOT ErrServerClosed.
-	err := srv.ListenAndServe()
+	err = app.listenAndServe(srv)
 	if !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
@@ -119,5 +137,145 @@ func (app *application) serve() error {
 		"addr": srv.Addr,
 	})
 
+	// The background tasks have finished, so nothing will send emails or write logs any more.
+	app.cleanup()
+
 	return nil
 }
+
+// newServer() declares the HTTP server. It listens on the port provided in the config struct and uses the
+// router returned by routes() as the handler.
+func (app *application) newServer() *http.Server {
+	return &http.Server{
+		Addr:    fmt.Sprintf(":%d", app.config.port),
+		Handler: app.routes(),
+		// Go enables persistent HTTP connections by default to reduce latency.
+		// By default, Go closes persistent connections after 3 minutes.
+		// We can reduce this default with the IdleTimeout setting.
+		// The timeouts are configured with the -http-*-timeout flags.
+		IdleTimeout: app.config.http.idleTimeout,
+		// ReadTimeout covers the time from when request is accepted to when the request body is fully read
+		// (If no body, until the end of headers)
+		ReadTimeout: app.config.http.readTimeout,
+		// WriteTimeout covers the time from the end of the request header read to the end of the
+		// response write (for HTTP).
+		// For HTTPS, it covers the time from when request is accepted to the end of response write.
+		WriteTimeout: app.config.http.writeTimeout,
+		// Create a new Go log.logger instance with the log.New() function, passing our own logger as the underlying
+		// io.Writer. The "" and 0 indicate that the log.logger instance should not use any prefix or flags.
+		ErrorLog: log.New(app.logger, "", 0),
+	}
+}
+
+// cleanup() releases the dependencies which support it, once the server has stopped and the background tasks
+// have completed: the mailer first, so that its error can still be logged, then the logger, which flushes and
+// closes the log file if there is one. Errors are logged, or printed to stderr if the logger can't be used.
+func (app *application) cleanup() {
+	if closer, ok := interface{}(app.mailer).(io.Closer); ok {
+		err := closer.Close()
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	}
+
+	if flusher, ok := interface{}(app.logger).(interface{ Flush() error }); ok {
+		err := flusher.Flush()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+
+	if closer, ok := interface{}(app.logger).(io.Closer); ok {
+		err := closer.Close()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+}
+
+// listenAndServe() starts accepting connections on either the TCP port, or on a Unix domain socket if the
+// -socket flag is set. A Unix socket avoids the overhead of TCP when the API sits behind a local proxy.
+// The number of open connections is bounded with -max-connections and -max-connections-per-ip.
+func (app *application) listenAndServe(srv *http.Server) error {
+	var listener net.Listener
+
+	if app.config.socket == "" {
+		var err error
+		listener, err = net.Listen("tcp", srv.Addr)
+		if err != nil {
+			return err
+		}
+	} else {
+		// A socket file left behind by a process which didn't shut down cleanly would make net.Listen() fail
+		// with "address already in use", so remove it first.
+		err := os.Remove(app.config.socket)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+
+		listener, err = net.Listen("unix", app.config.socket)
+		if err != nil {
+			return err
+		}
+
+		// Remove the socket file once the server stops. Shutdown() closes the listener, which Serve() is
+		// waiting on, so this runs after the graceful shutdown has started.
+		defer os.Remove(app.config.socket)
+	}
+
+	listener = limitListener(listener, app.config.maxConns, app.config.maxConnsPerIP)
+
+	app.logger.PrintInfo("starting server", map[string]string{
+		"addr": srv.Addr,
+		"env":  app.config.env,
+		"tls":  tlsMode(srv.TLSConfig, app.config.tls.certFile),
+	})
+
+	if app.config.tls.certFile != "" {
+		return srv.ServeTLS(listener, app.config.tls.certFile, app.config.tls.keyFile)
+	}
+	return srv.Serve(listener)
+}
+
+// tlsConfig() returns the TLS configuration of the server, or nil if the server doesn't terminate TLS or doesn't
+// verify client certificates. With -tls-client-ca, client certificates are verified against the CA certificates
+// in the file. With -tls-require-client-cert as well, clients without a valid certificate are rejected during
+// the handshake (mutual TLS), so only the internal callers holding a certificate can reach the API.
+func (app *application) tlsConfig() (*tls.Config, error) {
+	if app.config.tls.certFile == "" || app.config.tls.clientCA == "" {
+		return nil, nil
+	}
+
+	pem, err := os.ReadFile(app.config.tls.clientCA)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in TLS client CA file %q", app.config.tls.clientCA)
+	}
+
+	// Without -tls-require-client-cert, a client certificate is optional, but it must be valid if one is sent.
+	clientAuth := tls.VerifyClientCertIfGiven
+	if app.config.tls.requireClientCert {
+		clientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return &tls.Config{
+		ClientCAs:  pool,
+		ClientAuth: clientAuth,
+	}, nil
+}
+
+// tlsMode() describes how the server handles TLS, for the startup log.
+func tlsMode(cfg *tls.Config, certFile string) string {
+	switch {
+	case certFile == "":
+		return "off"
+	case cfg != nil && cfg.ClientAuth == tls.RequireAndVerifyClientCert:
+		return "mutual"
+	default:
+		return "on"
+	}
+}
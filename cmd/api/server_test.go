@@ -0,0 +1,424 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jseow5177/greenlight/internal/jsonlog"
+	"github.com/jseow5177/greenlight/internal/mailer"
+)
+
+// startServer() runs serve() on a Unix socket, in a temporary directory unless the socket is already configured,
+// and returns the socket path along with a channel receiving the return value of serve(). It waits until the
+// server accepts connections.
+func startServer(t *testing.T, app *application) (string, <-chan error) {
+	t.Helper()
+
+	if app.config.socket == "" {
+		app.config.socket = filepath.Join(t.TempDir(), "api.sock")
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- app.serve()
+	}()
+
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		conn, err := net.Dial("unix", app.config.socket)
+		if err == nil {
+			conn.Close()
+			return app.config.socket, errs
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server didn't start: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// stopServer() sends SIGTERM to the process, which serve() handles, and returns the return value of serve().
+func stopServer(t *testing.T, errs <-chan error) error {
+	t.Helper()
+
+	err := syscall.Kill(os.Getpid(), syscall.SIGTERM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-errs:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve() didn't return")
+		return nil
+	}
+}
+
+func TestServeShutdownTimeout(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.shutdownTimeout = 100 * time.Millisecond
+
+	socket, errs := startServer(t, app)
+
+	// Send a request whose body is shorter than its Content-Length, so that the handler keeps reading it for
+	// longer than the shutdown timeout.
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	body := `{"name": "Alice"`
+	_, err = fmt.Fprintf(conn, "POST /v1/users HTTP/1.1\r\nHost: localhost\r\nContent-Type: application/json\r\nContent-Length: %d\r\n\r\n%s", len(body)+100, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Give the server time to start handling the request.
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+
+	err = stopServer(t, errs)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v; want %v", err, context.DeadlineExceeded)
+	}
+
+	if elapsed := time.Since(start); elapsed < app.config.shutdownTimeout {
+		t.Errorf("serve() returned after %s; want at least the shutdown timeout of %s", elapsed, app.config.shutdownTimeout)
+	}
+}
+
+func TestServeGracefulShutdown(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.shutdownTimeout = time.Second
+
+	_, errs := startServer(t, app)
+
+	err := stopServer(t, errs)
+	if err != nil {
+		t.Fatalf("got error %v; want nil", err)
+	}
+}
+
+// closeCounter is a log output which discards the entries, and counts the calls to Close().
+type closeCounter struct {
+	closed int32
+}
+
+func (c *closeCounter) Write(p []byte) (int, error) { return len(p), nil }
+
+func (c *closeCounter) Close() error {
+	atomic.AddInt32(&c.closed, 1)
+	return nil
+}
+
+func TestServeCleanup(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.shutdownTimeout = time.Second
+
+	out := new(closeCounter)
+	app.logger = jsonlog.New(out, jsonlog.LevelInfo)
+
+	var err error
+	app.mailer, err = mailer.New("localhost", 1, "", "", "Greenlight <no-reply@greenlight.example.com>", mailer.TLSModeNone, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, errs := startServer(t, app)
+
+	err = stopServer(t, errs)
+	if err != nil {
+		t.Fatalf("got error %v; want nil", err)
+	}
+
+	if closed := atomic.LoadInt32(&out.closed); closed != 1 {
+		t.Errorf("the log output was closed %d times; want 1", closed)
+	}
+
+	err = app.mailer.Send("alice@example.com", "user_welcome.html", nil)
+	if !errors.Is(err, mailer.ErrClosed) {
+		t.Errorf("got error %v from the mailer after shutdown; want %v", err, mailer.ErrClosed)
+	}
+}
+
+// unixClient() returns a HTTP client which sends every request to the Unix socket, whatever the host of the URL.
+func unixClient(socket string) *http.Client {
+	return &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+}
+
+func TestServeUnixSocket(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.shutdownTimeout = time.Second
+	app.config.socket = filepath.Join(t.TempDir(), "api.sock")
+
+	// A socket file left behind by a previous process is replaced.
+	err := os.WriteFile(app.config.socket, nil, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	socket, errs := startServer(t, app)
+
+	resp, err := unixClient(socket).Get("http://unix/v1/readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("got status %d; want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	err = stopServer(t, errs)
+	if err != nil {
+		t.Fatalf("got error %v; want nil", err)
+	}
+
+	if _, err := os.Stat(socket); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("the socket file wasn't removed on shutdown: %v", err)
+	}
+}
+
+func TestServeUnixSocketHealthcheck(t *testing.T) {
+	app := newTestApplicationWithDB(t)
+	app.config.shutdownTimeout = time.Second
+
+	socket, errs := startServer(t, app)
+	defer stopServer(t, errs)
+
+	resp, err := unixClient(socket).Get("http://unix/v1/healthcheck")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d; want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	var body struct {
+		Status string `json:"status"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body.Status == "" {
+		t.Error("the healthcheck response has no status")
+	}
+}
+
+func TestHTTPTimeoutFlags(t *testing.T) {
+	tests := []struct {
+		args              []string
+		idle, read, write time.Duration
+	}{
+		{nil, time.Minute, 10 * time.Second, 30 * time.Second},
+		{[]string{"-http-idle-timeout=2m", "-http-read-timeout=15s", "-http-write-timeout=1m30s"}, 2 * time.Minute, 15 * time.Second, 90 * time.Second},
+	}
+
+	for _, tt := range tests {
+		var cfg config
+
+		fs := flag.NewFlagSet("api", flag.ContinueOnError)
+		httpTimeoutFlags(fs, &cfg)
+
+		err := fs.Parse(tt.args)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		app := newTestApplication(t)
+		app.config.http = cfg.http
+
+		srv := app.newServer()
+		if srv.IdleTimeout != tt.idle || srv.ReadTimeout != tt.read || srv.WriteTimeout != tt.write {
+			t.Errorf("%v: got idle %s, read %s, write %s; want %s, %s, %s", tt.args,
+				srv.IdleTimeout, srv.ReadTimeout, srv.WriteTimeout, tt.idle, tt.read, tt.write)
+		}
+	}
+}
+
+func TestHTTPTimeoutFlagsInvalid(t *testing.T) {
+	var cfg config
+
+	fs := flag.NewFlagSet("api", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	httpTimeoutFlags(fs, &cfg)
+
+	err := fs.Parse([]string{"-http-read-timeout=10"})
+	if err == nil {
+		t.Error("a timeout without a unit was accepted")
+	}
+}
+
+// newTestCert() returns a certificate for the template, signed by the parent certificate and key, or self-signed
+// if the parent is nil, along with its key.
+func newTestCert(t *testing.T, template, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, key
+}
+
+// newTestClientCert() returns a client certificate signed by the parent, or self-signed if the parent is nil.
+func newTestClientCert(t *testing.T, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) tls.Certificate {
+	t.Helper()
+
+	cert, key := newTestCert(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "internal-client"},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, parent, parentKey)
+
+	return tls.Certificate{Certificate: [][]byte{cert.Raw}, PrivateKey: key}
+}
+
+func TestTLSClientCertificates(t *testing.T) {
+	ca, caKey := newTestCert(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Greenlight Test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed := newTestClientCert(t, ca, caKey)
+	unsigned := newTestClientCert(t, nil, nil)
+
+	tests := []struct {
+		name    string
+		require bool
+		cert    *tls.Certificate
+		ok      bool
+	}{
+		{"required, signed", true, &signed, true},
+		{"required, unsigned", true, &unsigned, false},
+		{"required, none", true, nil, false},
+		{"optional, signed", false, &signed, true},
+		{"optional, unsigned", false, &unsigned, false},
+		{"optional, none", false, nil, true},
+	}
+
+	for _, tt := range tests {
+		app := newTestApplication(t)
+		app.config.tls.certFile = "server.pem" // Only checked for being set: httptest provides the certificate
+		app.config.tls.clientCA = caFile
+		app.config.tls.requireClientCert = tt.require
+
+		cfg, err := app.tlsConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		srv.TLS = cfg
+		srv.StartTLS()
+
+		client := srv.Client()
+		if tt.cert != nil {
+			// Send the certificate whatever CAs the server asks for. The client would otherwise leave out a
+			// certificate which isn't signed by one of them, rather than have it rejected.
+			cert := tt.cert
+			client.Transport.(*http.Transport).TLSClientConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+				return cert, nil
+			}
+		}
+
+		resp, err := client.Get(srv.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+
+		if ok := err == nil; ok != tt.ok {
+			t.Errorf("%s: got error %v; want success %t", tt.name, err, tt.ok)
+		}
+
+		srv.Close()
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	app := newTestApplication(t)
+
+	// Client certificates aren't verified without a CA, or when the server doesn't terminate TLS.
+	for _, tt := range []struct{ certFile, clientCA string }{{"", ""}, {"server.pem", ""}, {"", "ca.pem"}} {
+		app.config.tls.certFile, app.config.tls.clientCA = tt.certFile, tt.clientCA
+
+		cfg, err := app.tlsConfig()
+		if cfg != nil || err != nil {
+			t.Errorf("%+v: got config %v and error %v; want none", tt, cfg, err)
+		}
+	}
+
+	// A CA file without any certificate is rejected.
+	app.config.tls.certFile = "server.pem"
+	app.config.tls.clientCA = filepath.Join(t.TempDir(), "empty.pem")
+	err := os.WriteFile(app.config.tls.clientCA, []byte("not a certificate"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := app.tlsConfig(); err == nil {
+		t.Error("a CA file without certificates was accepted")
+	}
+}
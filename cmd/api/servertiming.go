@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// serverTimingResponseWriter wraps a http.ResponseWriter and adds a Server-Timing header with the time spent
+// handling the request just before the headers are written. Headers can't be changed once they are sent, so
+// the duration covers the work done until then, which is the whole handler for the usual JSON responses.
+type serverTimingResponseWriter struct {
+	http.ResponseWriter
+	start       time.Time
+	wroteHeader bool
+}
+
+func (sw *serverTimingResponseWriter) WriteHeader(status int) {
+	if !sw.wroteHeader {
+		sw.wroteHeader = true
+
+		// Server-Timing durations are in milliseconds.
+		duration := float64(time.Since(sw.start)) / float64(time.Millisecond)
+		sw.Header().Add("Server-Timing", fmt.Sprintf("app;dur=%.1f", duration))
+	}
+
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *serverTimingResponseWriter) Write(b []byte) (int, error) {
+	if !sw.wroteHeader {
+		sw.WriteHeader(http.StatusOK)
+	}
+
+	return sw.ResponseWriter.Write(b)
+}
+
+// Flush() sends any buffered data to the client, so that streaming handlers keep working.
+func (sw *serverTimingResponseWriter) Flush() {
+	if !sw.wroteHeader {
+		sw.WriteHeader(http.StatusOK)
+	}
+
+	if flusher, ok := sw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// serverTiming() middleware reports the server-side time of each request in a Server-Timing header
+// (e.g. "app;dur=12.3"), which browsers show in their developer tools. It is enabled with the -server-timing flag.
+// The header tells clients how long requests take, so it can be left off in production.
+func (app *application) serverTiming(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.config.serverTiming {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		next.ServeHTTP(&serverTimingResponseWriter{ResponseWriter: w, start: time.Now()}, r)
+	})
+}
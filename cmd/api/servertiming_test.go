@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServerTiming(t *testing.T) {
+	app := newTestApplication(t)
+
+	h := app.serverTiming(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(5 * time.Millisecond)
+		w.Write([]byte("OK"))
+	}))
+
+	for _, enabled := range []bool{true, false} {
+		app.config.serverTiming = enabled
+
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/movies", nil))
+
+		header := rr.Header().Get("Server-Timing")
+		if !enabled {
+			if header != "" {
+				t.Errorf("disabled: got Server-Timing %q; want none", header)
+			}
+			continue
+		}
+
+		if !strings.HasPrefix(header, "app;dur=") {
+			t.Fatalf("got Server-Timing %q; want app;dur=<ms>", header)
+		}
+		duration, err := strconv.ParseFloat(strings.TrimPrefix(header, "app;dur="), 64)
+		if err != nil || duration < 5 {
+			t.Errorf("got Server-Timing %q; want a duration of at least 5ms", header)
+		}
+	}
+}
+
+func TestServerTimingStreaming(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.serverTiming = true
+
+	h := app.serverTiming(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.(http.Flusher).Flush()
+		w.WriteHeader(http.StatusTeapot) // Too late, the headers have been sent
+	}))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/movies", nil))
+
+	// Flushing sends the headers, with a single Server-Timing entry.
+	if !rr.Flushed || rr.Code != http.StatusOK {
+		t.Errorf("got flushed %t and status %d; want true and %d", rr.Flushed, rr.Code, http.StatusOK)
+	}
+	if n := len(rr.Header().Values("Server-Timing")); n != 1 {
+		t.Errorf("got %d Server-Timing headers; want 1", n)
+	}
+}
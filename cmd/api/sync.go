@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/jseow5177/greenlight/internal/data"
+	"github.com/jseow5177/greenlight/internal/validator"
+)
+
+// syncOverlap is how far server_time is set back from the current time. updated_at only has a precision of a
+// second, and changes which are being committed while a sync runs can get a slightly earlier timestamp than the
+// sync itself, so the next sync starts a little early. Clients may then get a movie twice, and should upsert.
+const syncOverlap = 2 * time.Second
+
+// Add a syncMoviesHandler for "GET /v1/movies/sync?since=<rfc3339>", which lets clients (such as mobile apps) fetch
+// only the movies created, updated or deleted since their last sync. Deleted movies are included as tombstones,
+// with only their ID and "deleted": true. The results are paginated with an opaque cursor: while next_cursor is
+// set, the client requests the next page with the same since and cursor=<next_cursor>. The server_time of the
+// last page is the since value of the next sync.
+func (app *application) syncMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	// Take the time before reading the movies, so that changes made during the sync are picked up next time.
+	serverTime := time.Now().UTC().Truncate(time.Second).Add(-syncOverlap)
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	since, err := time.Parse(time.RFC3339, app.readString(qs, "since", ""))
+	if err != nil {
+		v.AddError("since", "must be an RFC 3339 timestamp")
+	}
+
+	var cursor *data.SyncCursor
+	if s := app.readString(qs, "cursor", ""); s != "" {
+		c, err := data.DecodeSyncCursor(s)
+		if err != nil {
+			v.AddError("cursor", "invalid cursor")
+		}
+		cursor = &c
+	}
+
+	pageSize := app.readInt(qs, "page_size", app.config.defaultPageSize, v)
+	v.Check(pageSize > 0, "page_size", "must be greater than zero")
+	v.Checkf(pageSize <= app.config.maxPageSize, "page_size", "must be a maximum of %d", app.config.maxPageSize)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	// Read one movie more than the page size, to find out whether there is a next page.
+	movies, err := app.modelsFor(r).Movies.GetUpdatedSince(since, cursor, pageSize+1)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var nextCursor interface{}
+	if len(movies) > pageSize {
+		movies = movies[:pageSize]
+
+		last := movies[len(movies)-1]
+		nextCursor = data.EncodeSyncCursor(data.SyncCursor{UpdatedAt: last.UpdatedAt, ID: last.ID})
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{
+		"movies":      movies,
+		"next_cursor": nextCursor,
+		"server_time": serverTime,
+	}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
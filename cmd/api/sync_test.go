@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSyncMoviesTombstones(t *testing.T) {
+	app := newTestApplicationWithDB(t)
+	h := app.routes()
+
+	_, token := newTestUser(t, app, "editor@example.com", "movies:read", "movies:write")
+
+	kept := movieID(t, createTestMovie(t, h, token, moanaJSON, nil))
+	deleted := movieID(t, createTestMovie(t, h, token, `{"title": "Deadpool", "year": 2016, "runtime": "108 mins", "genres": ["action"]}`, nil))
+
+	rr := do(t, h, http.MethodDelete, fmt.Sprintf("/v1/movies/%d", deleted), token, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("delete: got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+
+	rr = do(t, h, http.MethodGet, "/v1/movies/sync?since=2000-01-01T00:00:00Z", token, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("sync: got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+
+	var body struct {
+		Movies []map[string]interface{} `json:"movies"`
+	}
+	err := json.Unmarshal(rr.Body.Bytes(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(body.Movies) != 2 {
+		t.Fatalf("got %d movies; want 2: %s", len(body.Movies), rr.Body)
+	}
+
+	for _, movie := range body.Movies {
+		switch int64(movie["id"].(float64)) {
+		case kept:
+			if movie["title"] != "Moana" || movie["deleted"] != nil {
+				t.Errorf("kept movie: got %v; want the whole movie", movie)
+			}
+		case deleted:
+			if len(movie) != 2 || movie["deleted"] != true {
+				t.Errorf("deleted movie: got %v; want only the id and \"deleted\": true", movie)
+			}
+		default:
+			t.Errorf("unexpected movie %v", movie)
+		}
+	}
+}
+
+func TestSyncMoviesValidation(t *testing.T) {
+	app := newTestApplication(t)
+
+	tests := []struct {
+		query string
+		field string
+	}{
+		{"", "since"},
+		{"since=yesterday", "since"},
+		{"since=2021-06-01T00:00:00Z&cursor=not-a-cursor", "cursor"},
+		{"since=2021-06-01T00:00:00Z&page_size=0", "page_size"},
+	}
+
+	// The parameters are validated before the database is used.
+	for _, tt := range tests {
+		rr := do(t, app.routes(), http.MethodGet, "/v1/movies/sync?"+tt.query, "", nil)
+		if rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("%q: got status %d; want %d: %s", tt.query, rr.Code, http.StatusUnprocessableEntity, rr.Body)
+			continue
+		}
+
+		var body struct {
+			Error map[string]string `json:"error"`
+		}
+		err := json.Unmarshal(rr.Body.Bytes(), &body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := body.Error[tt.field]; !ok {
+			t.Errorf("%q: got errors %v; want one for %q", tt.query, body.Error, tt.field)
+		}
+	}
+}
+
+func TestSyncMoviesPages(t *testing.T) {
+	app := newTestApplicationWithDB(t)
+	h := app.routes()
+
+	_, token := newTestUser(t, app, "editor@example.com", "movies:read", "movies:write")
+	for _, title := range []string{"Moana", "Deadpool", "Frozen"} {
+		createTestMovie(t, h, token, fmt.Sprintf(`{"title": %q, "year": 2016, "runtime": "100 mins", "genres": ["drama"]}`, title), nil)
+	}
+
+	var titles []string
+	var serverTime time.Time
+	url := "/v1/movies/sync?since=2000-01-01T00:00:00Z&page_size=2"
+	for page := 0; url != ""; page++ {
+		if page == 3 {
+			t.Fatal("the pages don't end")
+		}
+
+		rr := do(t, h, http.MethodGet, url, token, nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("page %d: got status %d; want %d: %s", page, rr.Code, http.StatusOK, rr.Body)
+		}
+
+		var body struct {
+			Movies []struct {
+				Title string `json:"title"`
+			} `json:"movies"`
+			NextCursor string    `json:"next_cursor"`
+			ServerTime time.Time `json:"server_time"`
+		}
+		err := json.Unmarshal(rr.Body.Bytes(), &body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, movie := range body.Movies {
+			titles = append(titles, movie.Title)
+		}
+		serverTime = body.ServerTime
+
+		url = ""
+		if body.NextCursor != "" {
+			url = "/v1/movies/sync?since=2000-01-01T00:00:00Z&page_size=2&cursor=" + body.NextCursor
+		}
+	}
+
+	if !reflect.DeepEqual(titles, []string{"Moana", "Deadpool", "Frozen"}) {
+		t.Errorf("got titles %q; want the 3 movies in order of creation", titles)
+	}
+
+	// server_time is set back a little, so that changes committed during the sync are picked up by the next one.
+	if d := time.Since(serverTime); d < syncOverlap || d > syncOverlap+time.Minute {
+		t.Errorf("got server_time %s ago; want about %s ago", d, syncOverlap)
+	}
+}
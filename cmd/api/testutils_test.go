@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jseow5177/greenlight/internal/data"
+	"github.com/jseow5177/greenlight/internal/jsonlog"
+	"github.com/jseow5177/greenlight/internal/testdb"
+)
+
+// newTestApplication() returns an application with the default configuration of the command-line flags and no
+// database. The rate limiter is disabled, so that tests can send as many requests as they need.
+func newTestApplication(t testing.TB) *application {
+	t.Helper()
+
+	var cfg config
+	cfg.env = "development"
+	cfg.validationErrors = "map"
+	cfg.deleteResponse = http.StatusOK
+	cfg.jsonNaming = namingTags
+	cfg.publicIDs = "int"
+	cfg.maxSessions = 10
+	cfg.tokenEntropy = 16
+	cfg.maxOffset = 100000
+	cfg.defaultPageSize = 20
+	cfg.maxPageSize = 100
+	cfg.minGenres = 1
+	cfg.maxGenres = 5
+	cfg.maxIDs = 100
+	cfg.maxURILength = 8192
+	cfg.maxQueryParams = 100
+	cfg.movieSortFields = data.MovieSortColumns
+	cfg.db.timeout = 3 * time.Second
+	cfg.limiter.rps = 2
+	cfg.limiter.burst = 4
+	cfg.limiter.authRPS = 0.2
+	cfg.limiter.authBurst = 3
+	cfg.limiter.privilegedPermission = "ratelimit:exempt"
+	cfg.limiter.privilegedBurst = 20
+	cfg.limiter.cleanupInterval = time.Minute
+	cfg.limiter.idleTTL = 3 * time.Minute
+	cfg.poster.dir = t.TempDir()
+	cfg.poster.maxBytes = 5 << 20
+	cfg.softDelete.retention = 720 * time.Hour
+	cfg.smtp.queueSize = 100
+
+	app := &application{
+		config:        cfg,
+		logger:        jsonlog.New(io.Discard, jsonlog.LevelInfo),
+		webhookClient: newWebhookClient(5 * time.Second),
+		startTime:     time.Now(),
+		done:          make(chan struct{}),
+		emails:        make(chan emailJob, cfg.smtp.queueSize),
+	}
+	app.mailerCheck = newCachedCheck(app.mailer.Ping, time.Minute)
+
+	return app
+}
+
+// newTestApplicationWithDB() is like newTestApplication(), but the application uses a migrated test database.
+// The test is skipped if no test database is configured.
+func newTestApplicationWithDB(t *testing.T) *application {
+	t.Helper()
+
+	app := newTestApplication(t)
+	app.db = testdb.Open(t)
+	app.models = data.NewModels(app.db, app.config.db.timeout)
+
+	return app
+}
+
+// newTestUser() inserts an activated user with the given permissions and returns it, along with the plaintext
+// of an authentication token for the user.
+func newTestUser(t *testing.T, app *application, email string, permissions ...string) (*data.User, string) {
+	t.Helper()
+
+	user := &data.User{Name: "Test User", Email: email, Activated: true}
+
+	err := user.Password.Set("pa55word-for-tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.models.Users.Insert(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(permissions) > 0 {
+		err = app.models.Permissions.AddForUser(user.ID, permissions...)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	token, err := app.models.Tokens.New(user.ID, time.Hour, data.ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user, token.Plaintext
+}
+
+// do() sends a request through the application's full handler chain and returns the recorded response. The
+// token is sent as a bearer token if it isn't empty.
+func do(t *testing.T, h http.Handler, method, url, token string, body []byte) *httptest.ResponseRecorder {
+	t.Helper()
+
+	r := httptest.NewRequest(method, url, bytes.NewReader(body))
+	if body != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+
+	return rr
+}
+
+// newTestSMTPServer() starts a minimal SMTP server on a random local port, which accepts every email without
+// authentication or TLS. The recipient of each email it receives is sent on the returned channel.
+func newTestSMTPServer(t *testing.T) (host string, port int, recipients <-chan string) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	received := make(chan string, 100)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestSMTP(conn, received)
+		}
+	}()
+
+	addr := listener.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, received
+}
+
+// serveTestSMTP() answers the commands of one SMTP connection with success replies.
+func serveTestSMTP(conn net.Conn, received chan<- string) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+
+	reply("220 localhost ESMTP")
+
+	var recipient string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		command := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			recipient = strings.Trim(strings.TrimSpace(line)[len("RCPT TO:"):], "<>")
+			reply("250 OK")
+		case command == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+			}
+			received <- recipient
+			reply("250 OK")
+		case command == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
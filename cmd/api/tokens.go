@@ -0,0 +1,229 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/jseow5177/greenlight/internal/data"
+	"github.com/jseow5177/greenlight/internal/validator"
+)
+
+// Add a createAuthenticationTokenHandler for "POST /v1/tokens/authentication"
+func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidateEmail(v, input.Email)
+	data.ValidatePassword(v, input.Password)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	// Look up the user by email address. If there is no match, send a 401 Unauthorized response
+	// rather than a 404, so that the client can't tell whether the email address is registered.
+	user, err := app.modelsFor(r).Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	match, err := user.Password.Matches(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !match {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	// Generate a new authentication token with a 24-hour expiry time.
+	// The client's user agent and IP address are stored with it, so that the user can recognise the session later.
+	token, err := app.modelsFor(r).Tokens.NewForClient(user.ID, 24*time.Hour, data.ScopeAuthentication, r.UserAgent(), app.clientIP(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Cap the number of concurrent sessions. If the user now has more authentication tokens than allowed,
+	// the oldest are deleted, so that forgotten (or stolen) sessions don't pile up. The new token is always kept.
+	if app.config.maxSessions > 0 {
+		count, err := app.modelsFor(r).Tokens.CountForUser(data.ScopeAuthentication, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if count > app.config.maxSessions {
+			err = app.modelsFor(r).Tokens.DeleteOldestForUser(data.ScopeAuthentication, user.ID, app.config.maxSessions, token.Hash)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Add a deleteAuthenticationTokensHandler for "DELETE /v1/tokens/authentication"
+// By default, all the authentication tokens of the current user are deleted (logging out every session).
+// If the request body contains a token, only that token is deleted.
+func (app *application) deleteAuthenticationTokensHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Token string `json:"token"`
+	}
+
+	// The request body is optional, so only read it if the client sent one.
+	if r.ContentLength != 0 {
+		err := app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+	}
+
+	user := app.contextGetUser(r)
+
+	if input.Token == "" {
+		err := app.modelsFor(r).Tokens.DeleteAllForUser(data.ScopeAuthentication, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.writeJSON(w, http.StatusOK, envelope{"message": "all authentication tokens successfully deleted"}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.Token, app.config.tokenEntropy); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	err := app.modelsFor(r).Tokens.DeleteForUser(data.ScopeAuthentication, user.ID, input.Token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "authentication token successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Add a listSessionsHandler for "GET /v1/tokens/sessions"
+// It shows the current user their active authentication tokens (sessions), without revealing the tokens themselves.
+func (app *application) listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	sessions, err := app.modelsFor(r).Tokens.ListForUser(data.ScopeAuthentication, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"sessions": sessions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Add an introspectTokenHandler for "POST /v1/tokens/introspect", which lets a trusted caller (such as an API gateway)
+// check an authentication token without proxying the whole request. It requires the tokens:introspect permission.
+// Unknown and expired tokens are reported as inactive rather than as an error, and nothing else is said about them.
+func (app *application) introspectTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Token string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.Token != "", "token", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	inactive := envelope{"active": false}
+
+	user, err := app.modelsFor(r).Users.GetForToken(data.ScopeAuthentication, input.Token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.writeIntrospection(w, r, inactive)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// The token can expire (or be deleted) between the two queries, in which case it is inactive too.
+	expiry, err := app.modelsFor(r).Tokens.GetExpiry(data.ScopeAuthentication, input.Token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.writeIntrospection(w, r, inactive)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeIntrospection(w, r, envelope{
+		"active":    true,
+		"user_id":   user.ID,
+		"activated": user.Activated,
+		"expiry":    expiry,
+	})
+}
+
+// writeIntrospection() sends the result of a token introspection. It must not be cached, since the token can be
+// deleted at any time.
+func (app *application) writeIntrospection(w http.ResponseWriter, r *http.Request, result envelope) {
+	headers := make(http.Header)
+	headers.Set("Cache-Control", "no-store")
+
+	err := app.writeJSON(w, http.StatusOK, result, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jseow5177/greenlight/internal/data"
+)
+
+func TestListSessions(t *testing.T) {
+	app := newTestApplicationWithDB(t)
+	h := app.routes()
+
+	rr := do(t, h, http.MethodGet, "/v1/tokens/sessions", "", nil)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous: got status %d; want %d", rr.Code, http.StatusUnauthorized)
+	}
+
+	user, _ := newTestUser(t, app, "alice@example.com")
+
+	// Log in with an over-long User-Agent, which is stored truncated on a character boundary.
+	body := `{"email": "` + user.Email + `", "password": "pa55word-for-tests"}`
+	r := httptest.NewRequest(http.MethodPost, "/v1/tokens/authentication", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("User-Agent", strings.Repeat("é", 200))
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("login: got status %d; want %d: %s", rr.Code, http.StatusCreated, rr.Body)
+	}
+
+	var login struct {
+		Token struct {
+			Token string `json:"token"`
+		} `json:"authentication_token"`
+	}
+	err := json.Unmarshal(rr.Body.Bytes(), &login)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr = do(t, h, http.MethodGet, "/v1/tokens/sessions", login.Token.Token, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("list: got status %d; want %d", rr.Code, http.StatusOK)
+	}
+
+	var list struct {
+		Sessions []struct {
+			ID        string `json:"id"`
+			UserAgent string `json:"user_agent"`
+		} `json:"sessions"`
+	}
+	err = json.Unmarshal(rr.Body.Bytes(), &list)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := false
+	for _, session := range list.Sessions {
+		if strings.HasPrefix(session.UserAgent, "é") {
+			found = true
+			if session.UserAgent != strings.Repeat("é", 128) {
+				t.Errorf("got a user agent of %d bytes; want 256", len(session.UserAgent))
+			}
+		}
+		if strings.Contains(rr.Body.String(), login.Token.Token) {
+			t.Error("the session list reveals the token")
+		}
+	}
+	if !found {
+		t.Error("the session of the login isn't listed")
+	}
+}
+
+func TestDeleteAuthenticationTokens(t *testing.T) {
+	app := newTestApplicationWithDB(t)
+	h := app.routes()
+
+	rr := do(t, h, http.MethodDelete, "/v1/tokens/authentication", "", nil)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous: got status %d; want %d", rr.Code, http.StatusUnauthorized)
+	}
+
+	user, first := newTestUser(t, app, "alice@example.com")
+	_, other := newTestUser(t, app, "bob@example.com")
+
+	second, err := app.models.Tokens.New(user.ID, time.Hour, data.ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authenticates := func(token string) bool {
+		t.Helper()
+		return do(t, h, http.MethodGet, "/v1/tokens/sessions", token, nil).Code == http.StatusOK
+	}
+
+	// The token of another user can't be deleted.
+	rr = do(t, h, http.MethodDelete, "/v1/tokens/authentication", first, []byte(`{"token": "`+other+`"}`))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("other user's token: got status %d; want %d", rr.Code, http.StatusNotFound)
+	}
+	if !authenticates(other) {
+		t.Error("the token of the other user was deleted")
+	}
+
+	// Logging out a single token leaves the other sessions of the user alone.
+	rr = do(t, h, http.MethodDelete, "/v1/tokens/authentication", first, []byte(`{"token": "`+second.Plaintext+`"}`))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("single token: got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+	if authenticates(second.Plaintext) {
+		t.Error("the deleted token still authenticates")
+	}
+	if !authenticates(first) {
+		t.Error("the token which wasn't deleted no longer authenticates")
+	}
+
+	// Without a body, every token of the user is deleted, including the one making the request.
+	rr = do(t, h, http.MethodDelete, "/v1/tokens/authentication", first, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("all tokens: got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+	if authenticates(first) {
+		t.Error("the token still authenticates after logging out every session")
+	}
+	if !authenticates(other) {
+		t.Error("logging out deleted the token of another user")
+	}
+}
+
+func TestCreateAuthenticationTokenSessionCap(t *testing.T) {
+	app := newTestApplicationWithDB(t)
+	app.config.maxSessions = 2
+	h := app.routes()
+
+	user := &data.User{Name: "Test User", Email: "alice@example.com", Activated: true}
+	err := user.Password.Set("pa55word-for-tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = app.models.Users.Insert(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := []byte(`{"email": "alice@example.com", "password": "pa55word-for-tests"}`)
+
+	for i := 1; i <= 4; i++ {
+		rr := do(t, h, http.MethodPost, "/v1/tokens/authentication", "", body)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("login %d: got status %d; want %d: %s", i, rr.Code, http.StatusCreated, rr.Body)
+		}
+
+		var login struct {
+			Token struct {
+				Token string `json:"token"`
+			} `json:"authentication_token"`
+		}
+		err := json.Unmarshal(rr.Body.Bytes(), &login)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The token just issued is never the one evicted.
+		if code := do(t, h, http.MethodGet, "/v1/tokens/sessions", login.Token.Token, nil).Code; code != http.StatusOK {
+			t.Errorf("login %d: the new token got status %d; want %d", i, code, http.StatusOK)
+		}
+
+		want := i
+		if want > app.config.maxSessions {
+			want = app.config.maxSessions
+		}
+
+		count, err := app.models.Tokens.CountForUser(data.ScopeAuthentication, user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != want {
+			t.Errorf("after login %d: got %d sessions; want %d", i, count, want)
+		}
+	}
+}
+
+func TestIntrospectToken(t *testing.T) {
+	app := newTestApplicationWithDB(t)
+	h := app.routes()
+
+	_, gateway := newTestUser(t, app, "gateway@example.com", "tokens:introspect")
+	alice, active := newTestUser(t, app, "alice@example.com")
+
+	expired, err := app.models.Tokens.New(alice.ID, -time.Hour, data.ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	introspect := func(caller, token string) *httptest.ResponseRecorder {
+		body, err := json.Marshal(map[string]string{"token": token})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return do(t, h, http.MethodPost, "/v1/tokens/introspect", caller, body)
+	}
+
+	// Only trusted callers can introspect tokens.
+	if rr := introspect(active, active); rr.Code != http.StatusForbidden {
+		t.Errorf("without tokens:introspect: got status %d; want %d", rr.Code, http.StatusForbidden)
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		active bool
+	}{
+		{"active", active, true},
+		{"expired", expired.Plaintext, false},
+		{"unknown", strings.Repeat("A", 26), false},
+	}
+
+	for _, tt := range tests {
+		rr := introspect(gateway, tt.token)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: got status %d; want %d: %s", tt.name, rr.Code, http.StatusOK, rr.Body)
+		}
+		if got := rr.Header().Get("Cache-Control"); got != "no-store" {
+			t.Errorf("%s: got Cache-Control %q; want no-store", tt.name, got)
+		}
+
+		var resp map[string]interface{}
+		err := json.Unmarshal(rr.Body.Bytes(), &resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp["active"] != tt.active {
+			t.Errorf("%s: got active %v; want %t", tt.name, resp["active"], tt.active)
+		}
+
+		// An inactive token gets nothing but the flag, and the token itself is never described.
+		want := []string{"active"}
+		if tt.active {
+			want = []string{"active", "activated", "expiry", "user_id"}
+		}
+		var keys []string
+		for key := range resp {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		if !reflect.DeepEqual(keys, want) {
+			t.Errorf("%s: got fields %v; want %v", tt.name, keys, want)
+		}
+
+		if tt.active && (resp["user_id"] != float64(alice.ID) || resp["activated"] != true) {
+			t.Errorf("%s: got user %v, activated %v; want %d, true", tt.name, resp["user_id"], resp["activated"], alice.ID)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// trace() middleware gives every request a trace ID, which clients can quote in support requests to find the
+// matching log entries. The ID is taken from the W3C traceparent header (https://www.w3.org/TR/trace-context/)
+// when a client or proxy sends a valid one, so that our log entries share the trace ID of the caller's trace,
+// and generated otherwise.
+// The ID is sent in the Trace-Id header of every response. It is set before the rest of the chain runs, so it is
+// included in error responses too, even those sent after a panic.
+func (app *application) trace(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceID, ok := parseTraceparent(r.Header.Get("traceparent"))
+		if !ok {
+			traceID = newTraceID()
+		}
+
+		w.Header().Set("Trace-Id", traceID)
+
+		next.ServeHTTP(w, app.contextSetTraceID(r, traceID))
+	})
+}
+
+// parseTraceparent() returns the trace ID of a traceparent header, whose format is
+// "<version>-<trace-id>-<parent-id>-<flags>", e.g. "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01".
+// The trace ID and parent ID are lowercase hex and must not be all zeros. Version ff is invalid, and future
+// versions may append fields, so only the first four fields are checked.
+func parseTraceparent(header string) (string, bool) {
+	fields := strings.Split(strings.TrimSpace(header), "-")
+	if len(fields) < 4 {
+		return "", false
+	}
+
+	version, traceID, parentID, flags := fields[0], fields[1], fields[2], fields[3]
+
+	if !isLowerHex(version, 2) || version == "ff" || (version == "00" && len(fields) != 4) {
+		return "", false
+	}
+	if !isLowerHex(traceID, 32) || traceID == strings.Repeat("0", 32) {
+		return "", false
+	}
+	if !isLowerHex(parentID, 16) || parentID == strings.Repeat("0", 16) {
+		return "", false
+	}
+	if !isLowerHex(flags, 2) {
+		return "", false
+	}
+
+	return traceID, true
+}
+
+// isLowerHex() reports whether s is made of n lowercase hexadecimal digits.
+func isLowerHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+
+	for _, c := range s {
+		if !(c >= '0' && c <= '9') && !(c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// newTraceID() returns a random trace ID in the traceparent format: 16 random bytes as lowercase hex.
+func newTraceID() string {
+	b := make([]byte, 16)
+
+	// crypto/rand only fails if the operating system's random source does. The ID is only used to correlate
+	// log entries, so fall back to an all-zero-but-valid ID rather than failing the request.
+	_, err := rand.Read(b)
+	if err != nil {
+		b[len(b)-1] = 1
+	}
+
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jseow5177/greenlight/internal/jsonlog"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		traceID string
+		ok      bool
+	}{
+		{"valid", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "4bf92f3577b34da6a3ce929d0e0e4736", true},
+		{"surrounding space", " 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00 ", "4bf92f3577b34da6a3ce929d0e0e4736", true},
+		{"future version with more fields", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", "4bf92f3577b34da6a3ce929d0e0e4736", true},
+		{"empty", "", "", false},
+		{"version 00 with more fields", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", "", false},
+		{"version ff", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "", false},
+		{"uppercase", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", "", false},
+		{"short trace ID", "00-4bf92f3577b34da6-00f067aa0ba902b7-01", "", false},
+		{"zero trace ID", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", "", false},
+		{"zero parent ID", "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", "", false},
+		{"invalid flags", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-1", "", false},
+	}
+
+	for _, tt := range tests {
+		traceID, ok := parseTraceparent(tt.header)
+		if traceID != tt.traceID || ok != tt.ok {
+			t.Errorf("%s: got %q, %t; want %q, %t", tt.name, traceID, ok, tt.traceID, tt.ok)
+		}
+	}
+}
+
+func TestNewTraceID(t *testing.T) {
+	seen := make(map[string]bool)
+
+	for i := 0; i < 100; i++ {
+		traceID := newTraceID()
+
+		// A generated ID is valid in a traceparent header, so that it can be propagated to other services.
+		if _, ok := parseTraceparent("00-" + traceID + "-00f067aa0ba902b7-01"); !ok {
+			t.Fatalf("generated trace ID %q is invalid", traceID)
+		}
+		if seen[traceID] {
+			t.Fatalf("trace ID %q was generated twice", traceID)
+		}
+		seen[traceID] = true
+	}
+}
+
+// loggedTraceID() returns the trace_id property of the single log entry in the buffer.
+func loggedTraceID(t *testing.T, buf *bytes.Buffer) string {
+	t.Helper()
+
+	var entry struct {
+		Properties map[string]string `json:"properties"`
+	}
+	err := json.Unmarshal(buf.Bytes(), &entry)
+	if err != nil {
+		t.Fatalf("%v: %s", err, buf.Bytes())
+	}
+
+	return entry.Properties["trace_id"]
+}
+
+func TestTrace(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		app := newTestApplication(t)
+
+		rr := do(t, app.routes(), http.MethodOptions, "/v1/movies", "", nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got status %d; want %d", rr.Code, http.StatusOK)
+		}
+		if _, ok := parseTraceparent("00-" + rr.Header().Get("Trace-Id") + "-00f067aa0ba902b7-01"); !ok {
+			t.Errorf("got Trace-Id %q; want a generated trace ID", rr.Header().Get("Trace-Id"))
+		}
+	})
+
+	t.Run("propagated", func(t *testing.T) {
+		app := newTestApplication(t)
+
+		r := httptest.NewRequest(http.MethodOptions, "/v1/movies", nil)
+		r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		rr := httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, r)
+
+		if got := rr.Header().Get("Trace-Id"); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("got Trace-Id %q; want the trace ID of the traceparent header", got)
+		}
+	})
+
+	t.Run("server error", func(t *testing.T) {
+		app := newSlowDBApplication(t)
+
+		var buf bytes.Buffer
+		app.logger = jsonlog.New(&buf, jsonlog.LevelInfo)
+
+		rr := do(t, app.routes(), http.MethodGet, "/v1/movies/1", "", nil)
+		if rr.Code != http.StatusInternalServerError {
+			t.Fatalf("got status %d; want %d", rr.Code, http.StatusInternalServerError)
+		}
+
+		traceID := rr.Header().Get("Trace-Id")
+		if traceID == "" || loggedTraceID(t, &buf) != traceID {
+			t.Errorf("got Trace-Id %q and logged trace_id %q; want the same ID", traceID, loggedTraceID(t, &buf))
+		}
+	})
+
+	t.Run("panic", func(t *testing.T) {
+		app := newTestApplication(t)
+
+		var buf bytes.Buffer
+		app.logger = jsonlog.New(&buf, jsonlog.LevelInfo)
+
+		// The same order of middleware as routes(): the trace ID is set before the handler panics.
+		h := app.trace(app.recoverPanic(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic("boom")
+		})))
+
+		rr := do(t, h, http.MethodGet, "/v1/movies", "", nil)
+		if rr.Code != http.StatusInternalServerError {
+			t.Fatalf("got status %d; want %d", rr.Code, http.StatusInternalServerError)
+		}
+
+		traceID := rr.Header().Get("Trace-Id")
+		if traceID == "" || loggedTraceID(t, &buf) != traceID {
+			t.Errorf("got Trace-Id %q and logged trace_id %q; want the same ID", traceID, loggedTraceID(t, &buf))
+		}
+	})
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"net/http"
+	"strings"
 
 	"github.com/jseow5177/greenlight/internal/data"
 	"github.com/jseow5177/greenlight/internal/validator"
@@ -39,32 +40,26 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 	v := validator.New()
 
 	// Validate user information
-	if data.ValidateUser(v, user); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+	if data.ValidateUser(v, user, app.config.passwordMinScore); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
-	err = app.models.Users.Insert(user)
+	err = app.modelsFor(r).Users.Insert(user)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrDuplicateEmail):
 			v.AddError("email", "a user with this email address already exists")
-			app.failedValidationResponse(w, r, v.Errors)
+			app.failedValidationResponse(w, r, v)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	// Launch a goroutine which runs an annonymous function that sends a welcome email
-	app.runBackground(func() {
-		// Call the Send() method on our Mailer, passing in the user's email address,
-		// name of the template file, and the User struct containing the new user's data.
-		err = app.mailer.Send(user.Email, "user_welcome.html", user)
-		if err != nil {
-			app.logger.PrintError(err, nil)
-		}
-	})
+	// Queue the welcome email, passing in the user's email address, name of the template file,
+	// and the User struct containing the new user's data. It is sent by a background worker.
+	app.enqueueEmail(emailJob{recipient: user.Email, templateFile: "user_welcome.html", data: user})
 
 	// Write a JSON response containing the newly added user.
 	// Notice that we send the client a 202 Accepted status code.
@@ -74,3 +69,94 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// Add a showCurrentUserHandler for "GET /v1/users/me", which returns the profile of the authenticated user.
+// The user in the request context was read when the request was authenticated, so the record is read again
+// to return its current state. The password hash and version are never included in the response.
+func (app *application) showCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.modelsFor(r).Users.GetByID(app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		// The user has been deleted since the request was authenticated, so the token is no longer valid.
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Add a listCurrentUserMoviesHandler for "GET /v1/users/me/movies", which lists the movies created by the
+// authenticated user. It is sorted and paginated with the same query parameters as GET /v1/movies.
+func (app *application) listCurrentUserMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", app.config.defaultPageSize, v)
+	input.Filters.MaxPageSize = app.config.maxPageSize
+	input.Filters.MaxOffset = app.config.maxOffset
+
+	input.Filters = input.Filters.WithSortSafeList(data.MovieSortSafeList(app.config.movieSortFields)...)
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	movies, metadata, err := app.modelsFor(r).Movies.GetAllForUser(app.contextGetUser(r).ID, input.Filters)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInvalidSort):
+			v.AddError("sort", "invalid sort value")
+			app.failedValidationResponse(w, r, v)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Add a checkEmailAvailableHandler for "GET /v1/users/email-available?email=...", which lets a registration form
+// warn that an email address is taken before it is submitted. It has a stricter rate limit, like registration
+// itself, so that it can't be used to enumerate the registered addresses quickly.
+func (app *application) checkEmailAvailableHandler(w http.ResponseWriter, r *http.Request) {
+	// Surrounding spaces are easily pasted in by accident. Case doesn't need normalizing, since email
+	// addresses are compared case-insensitively.
+	email := strings.TrimSpace(app.readString(r.URL.Query(), "email", ""))
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, email); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	exists, err := app.modelsFor(r).Users.EmailExists(email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"available": !exists}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
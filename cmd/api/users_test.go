@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestListCurrentUserMovies(t *testing.T) {
+	app := newTestApplicationWithDB(t)
+	h := app.routes()
+
+	rr := do(t, h, http.MethodGet, "/v1/users/me/movies", "", nil)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous: got status %d; want %d", rr.Code, http.StatusUnauthorized)
+	}
+
+	_, aliceToken := newTestUser(t, app, "alice@example.com", "movies:read", "movies:write")
+	_, bobToken := newTestUser(t, app, "bob@example.com", "movies:read", "movies:write")
+
+	for _, body := range []string{moanaJSON, `{"title": "Up", "year": 2009, "runtime": "96 mins", "genres": ["animation"]}`} {
+		rr := createTestMovie(t, h, aliceToken, body, nil)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("create: got status %d; want %d: %s", rr.Code, http.StatusCreated, rr.Body)
+		}
+	}
+
+	rr = createTestMovie(t, h, bobToken, `{"title": "Cars", "year": 2006, "runtime": "117 mins", "genres": ["animation"]}`, nil)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create: got status %d; want %d: %s", rr.Code, http.StatusCreated, rr.Body)
+	}
+
+	rr = do(t, h, http.MethodGet, "/v1/users/me/movies?page_size=1", aliceToken, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("list: got status %d; want %d", rr.Code, http.StatusOK)
+	}
+
+	var list struct {
+		Movies []struct {
+			Title string `json:"title"`
+		} `json:"movies"`
+		Metadata struct {
+			TotalRecords int `json:"total_records"`
+		} `json:"metadata"`
+	}
+	err := json.Unmarshal(rr.Body.Bytes(), &list)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if list.Metadata.TotalRecords != 2 || len(list.Movies) != 1 || list.Movies[0].Title != "Moana" {
+		t.Errorf("got %d movies of %d (%v); want the first of Alice's 2 movies", len(list.Movies), list.Metadata.TotalRecords, list.Movies)
+	}
+}
+
+func TestShowCurrentUser(t *testing.T) {
+	app := newTestApplicationWithDB(t)
+	h := app.routes()
+
+	rr := do(t, h, http.MethodGet, "/v1/users/me", "", nil)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous: got status %d; want %d", rr.Code, http.StatusUnauthorized)
+	}
+
+	user, token := newTestUser(t, app, "alice@example.com")
+
+	// The user is read from the database, rather than copied from the request context.
+	user.Name = "Alice Renamed"
+	err := app.models.Users.Update(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr = do(t, h, http.MethodGet, "/v1/users/me", token, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("authenticated: got status %d; want %d", rr.Code, http.StatusOK)
+	}
+
+	var resp struct {
+		User map[string]interface{} `json:"user"`
+	}
+	err = json.Unmarshal(rr.Body.Bytes(), &resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.User["name"] != "Alice Renamed" || resp.User["email"] != "alice@example.com" || resp.User["activated"] != true {
+		t.Errorf("got user %v; want Alice's current record", resp.User)
+	}
+	if _, ok := resp.User["created_at"]; !ok {
+		t.Errorf("the user has no created_at: %v", resp.User)
+	}
+	for _, key := range []string{"password", "password_hash", "version"} {
+		if _, ok := resp.User[key]; ok {
+			t.Errorf("the user has a %s: %v", key, resp.User)
+		}
+	}
+}
+
+func TestCheckEmailAvailableValidation(t *testing.T) {
+	app := newTestApplication(t)
+	h := app.routes()
+
+	// The email is validated before the database is used.
+	for _, url := range []string{"/v1/users/email-available", "/v1/users/email-available?email=not-an-email"} {
+		rr := do(t, h, http.MethodGet, url, "", nil)
+		if rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("%s: got status %d; want %d", url, rr.Code, http.StatusUnprocessableEntity)
+		}
+	}
+}
+
+func TestCheckEmailAvailable(t *testing.T) {
+	app := newTestApplicationWithDB(t)
+	h := app.routes()
+
+	newTestUser(t, app, "alice@example.com")
+
+	tests := []struct {
+		email string
+		want  bool
+	}{
+		{"alice@example.com", false},
+		{"%20ALICE@Example.com%20", false},
+		{"bob@example.com", true},
+	}
+
+	for _, tt := range tests {
+		rr := do(t, h, http.MethodGet, "/v1/users/email-available?email="+tt.email, "", nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: got status %d; want %d: %s", tt.email, rr.Code, http.StatusOK, rr.Body)
+		}
+
+		var resp struct {
+			Available bool `json:"available"`
+		}
+		err := json.Unmarshal(rr.Body.Bytes(), &resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Available != tt.want {
+			t.Errorf("%s: got available %t; want %t", tt.email, resp.Available, tt.want)
+		}
+	}
+}
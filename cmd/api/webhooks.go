@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/jseow5177/greenlight/internal/data"
+	"github.com/jseow5177/greenlight/internal/validator"
+)
+
+// The header carrying the HMAC-SHA256 signature of a webhook payload.
+const webhookSignatureHeader = "X-Greenlight-Signature"
+
+// errWebhookAddress is returned when a delivery would connect to a private, loopback or link-local address.
+var errWebhookAddress = errors.New("webhook address is not public")
+
+// newWebhookClient() returns the HTTP client used to deliver webhooks. Subscriber URLs are chosen by users, so
+// the client refuses to connect to anything but public addresses, to keep deliveries from reaching internal
+// services (server-side request forgery). The check runs on the resolved address of every connection, so a host
+// name which resolves to an internal address, or a redirect to one, is rejected too. Proxies from the environment
+// are not used, since the check would then only see the address of the proxy.
+func newWebhookClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+
+			if ip := net.ParseIP(host); ip == nil || !data.PublicIP(ip) {
+				return fmt.Errorf("%w: %s", errWebhookAddress, host)
+			}
+
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			ForceAttemptHTTP2:   true,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+			TLSHandshakeTimeout: timeout,
+		},
+	}
+}
+
+// Add a registerWebhookHandler for "POST /v1/webhooks"
+// The webhook belongs to the authenticated user, who is the only one able to list and delete it.
+func (app *application) registerWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	webhook := &data.Webhook{
+		URL:    input.URL,
+		Events: input.Events,
+		UserID: app.contextGetUser(r).ID,
+	}
+
+	v := validator.New()
+
+	if data.ValidateWebhook(v, webhook); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	// Insert() also generates the per-subscription secret used to sign deliveries.
+	err = app.modelsFor(r).Webhooks.Insert(webhook)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/webhooks/%d", webhook.ID))
+
+	// The secret is only ever sent to the client in this response, so that the subscriber
+	// can verify the signature of each delivery.
+	err = app.writeJSON(w, http.StatusCreated, envelope{"webhook": webhook, "secret": webhook.Secret}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Add a listWebhooksHandler for "GET /v1/webhooks"
+// Only the webhooks registered by the authenticated user are listed.
+func (app *application) listWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := app.modelsFor(r).Webhooks.GetAllForUser(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"webhooks": webhooks}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Add a deleteWebhookHandler for "DELETE /v1/webhooks/:id"
+// Users can only delete their own webhooks. Other users' webhooks get a 404 Not Found response.
+func (app *application) deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.modelsFor(r).Webhooks.Delete(id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "webhook successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// notifyWebhooks() delivers an event to every webhook subscribed to it.
+// The lookup and deliveries run in the background so that the handler which fired the event
+// does not wait on the subscribers.
+func (app *application) notifyWebhooks(event string, payload envelope) {
+	app.runBackground(func() {
+		webhooks, err := app.models.Webhooks.GetAllForEvent(event)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"event": event})
+			return
+		}
+
+		if len(webhooks) == 0 {
+			return
+		}
+
+		body, err := json.Marshal(envelope{
+			"event":     event,
+			"timestamp": time.Now().UTC().Format(time.RFC3339),
+			"data":      payload,
+		})
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"event": event})
+			return
+		}
+
+		for _, webhook := range webhooks {
+			// Each delivery runs in its own background routine so that a slow subscriber
+			// does not hold up the others.
+			webhook := webhook
+			app.runBackground(func() {
+				err := app.deliverWebhook(webhook, event, body)
+				if err != nil {
+					app.logger.PrintError(err, map[string]string{
+						"event":      event,
+						"webhook_id": strconv.FormatInt(webhook.ID, 10),
+						"url":        webhook.URL,
+					})
+				}
+			})
+		}
+	})
+}
+
+// deliverWebhook() POSTs the signed payload to the subscriber URL.
+// Similar to the mailer, we try up to three times before aborting and returning the final error,
+// sleeping for 500 milliseconds between attempts.
+func (app *application) deliverWebhook(webhook *data.Webhook, event string, body []byte) error {
+	var err error
+
+	for i := 1; i <= 3; i++ {
+		err = app.postWebhook(webhook, event, body)
+		if err == nil || errors.Is(err, errWebhookAddress) {
+			// A delivery to a non-public address would fail the same way again.
+			return err
+		}
+
+		// Wait before the next attempt, but not after the last one.
+		if i < 3 {
+			time.Sleep(500 * time.Millisecond)
+		}
+	}
+
+	return err
+}
+
+// postWebhook() makes a single delivery attempt. Any non-2xx response is treated as a failure.
+func (app *application) postWebhook(webhook *data.Webhook, event string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Greenlight-Event", event)
+	req.Header.Set(webhookSignatureHeader, "sha256="+webhook.Sign(body))
+
+	res, err := app.webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// Read the rest of the body before closing it, so that the connection can be reused for the next delivery.
+		// The read is capped, so that a subscriber can't keep the delivery busy with an endless response.
+		io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+		res.Body.Close()
+	}()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook delivery failed with status %d", res.StatusCode)
+	}
+
+	return nil
+}
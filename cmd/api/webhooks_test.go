@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/jseow5177/greenlight/internal/data"
+)
+
+func TestWebhookRoutesRequireAuthentication(t *testing.T) {
+	app := newTestApplication(t)
+	h := app.routes()
+
+	tests := []struct {
+		method string
+		url    string
+		body   []byte
+	}{
+		{http.MethodGet, "/v1/webhooks", nil},
+		{http.MethodPost, "/v1/webhooks", []byte(`{"url": "https://example.com/hook", "events": ["movie.created"]}`)},
+		{http.MethodDelete, "/v1/webhooks/1", nil},
+	}
+
+	for _, tt := range tests {
+		rr := do(t, h, tt.method, tt.url, "", tt.body)
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s: got status %d; want %d", tt.method, tt.url, rr.Code, http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestWebhookRoutesAreScopedToOwner(t *testing.T) {
+	app := newTestApplicationWithDB(t)
+	h := app.routes()
+
+	_, aliceToken := newTestUser(t, app, "alice@example.com", "webhooks:read", "webhooks:write")
+	_, bobToken := newTestUser(t, app, "bob@example.com", "webhooks:read", "webhooks:write")
+	_, readerToken := newTestUser(t, app, "reader@example.com", "webhooks:read")
+
+	body := []byte(`{"url": "https://example.com/hook", "events": ["movie.created"]}`)
+
+	rr := do(t, h, http.MethodPost, "/v1/webhooks", readerToken, body)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("register without webhooks:write: got status %d; want %d", rr.Code, http.StatusForbidden)
+	}
+
+	rr = do(t, h, http.MethodPost, "/v1/webhooks", aliceToken, body)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("register: got status %d; want %d: %s", rr.Code, http.StatusCreated, rr.Body)
+	}
+
+	var created struct {
+		Webhook struct {
+			ID int64 `json:"id"`
+		} `json:"webhook"`
+		Secret string `json:"secret"`
+	}
+	err := json.Unmarshal(rr.Body.Bytes(), &created)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Secret == "" {
+		t.Error("register: secret is missing from the response")
+	}
+
+	count := func(token string) int {
+		rr := do(t, h, http.MethodGet, "/v1/webhooks", token, nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("list: got status %d; want %d", rr.Code, http.StatusOK)
+		}
+
+		var list struct {
+			Webhooks []json.RawMessage `json:"webhooks"`
+		}
+		err := json.Unmarshal(rr.Body.Bytes(), &list)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(list.Webhooks)
+	}
+
+	if n := count(aliceToken); n != 1 {
+		t.Errorf("owner lists %d webhooks; want 1", n)
+	}
+	if n := count(bobToken); n != 0 {
+		t.Errorf("other user lists %d webhooks; want 0", n)
+	}
+
+	url := "/v1/webhooks/" + strconv.FormatInt(created.Webhook.ID, 10)
+
+	rr = do(t, h, http.MethodDelete, url, bobToken, nil)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("delete by another user: got status %d; want %d", rr.Code, http.StatusNotFound)
+	}
+
+	rr = do(t, h, http.MethodDelete, url, aliceToken, nil)
+	if rr.Code != http.StatusOK {
+		t.Errorf("delete by owner: got status %d; want %d", rr.Code, http.StatusOK)
+	}
+}
+
+func TestPostWebhookRejectsPrivateAddresses(t *testing.T) {
+	app := newTestApplication(t)
+
+	delivered := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delivered = true
+	}))
+	defer ts.Close()
+
+	// The test server listens on a loopback address, which a user could otherwise point a webhook at to reach
+	// internal services.
+	webhook := &data.Webhook{URL: ts.URL, Secret: "secret"}
+
+	err := app.deliverWebhook(webhook, data.EventMovieCreated, []byte(`{}`))
+	if !errors.Is(err, errWebhookAddress) {
+		t.Errorf("got error %v; want %v", err, errWebhookAddress)
+	}
+	if delivered {
+		t.Error("the webhook was delivered to a loopback address")
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/joho/godotenv"
+	"github.com/jseow5177/greenlight/internal/jsonlog"
+	"github.com/jseow5177/greenlight/internal/migrate"
+	"github.com/jseow5177/greenlight/migrations"
+	_ "github.com/lib/pq"
+)
+
+// The migrate command applies the embedded SQL migration files to the database.
+//
+// Usage:
+//
+//	go run ./cmd/migrate [flags] up
+//	go run ./cmd/migrate [flags] down [N]
+//	go run ./cmd/migrate [flags] version
+func main() {
+	// Load environment variables
+	err := godotenv.Load()
+	if err != nil {
+		log.Fatal("Error loading .env file")
+	}
+
+	psqlPass := os.Getenv("POSTGRESS_PASSWORD")
+
+	// Use the same -db-dsn flag and default value as the API server.
+	var dsn string
+	flag.StringVar(&dsn, "db-dsn", fmt.Sprintf("postgres://greenlight:%s@localhost/greenlight?sslmode=disable", psqlPass), "Postgres DSN")
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] up|down [N]|version\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+	defer db.Close()
+
+	migrator, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	switch flag.Arg(0) {
+	case "up":
+		err = migrator.Up()
+
+	case "down":
+		// Roll back a single migration unless a number of steps is given.
+		steps := 1
+		if flag.NArg() > 1 {
+			steps, err = strconv.Atoi(flag.Arg(1))
+			if err != nil || steps < 1 {
+				logger.PrintFatal(errors.New("number of down steps must be a positive integer"), nil)
+			}
+		}
+		err = migrator.Down(steps)
+
+	case "version":
+		version, dirty, err := migrator.Version()
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+
+		logger.PrintInfo("current schema version", map[string]string{
+			"version": strconv.FormatInt(version, 10),
+			"dirty":   strconv.FormatBool(dirty),
+		})
+		return
+
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	switch {
+	case errors.Is(err, migrate.ErrNoChange):
+		logger.PrintInfo("no change", nil)
+	case err != nil:
+		logger.PrintFatal(err, nil)
+	default:
+		version, _, err := migrator.Version()
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+
+		logger.PrintInfo("migrations applied", map[string]string{
+			"command": flag.Arg(0),
+			"version": strconv.FormatInt(version, 10),
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package data
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Define constants for the audited actions.
+const (
+	AuditActionCreate = "create"
+	AuditActionUpdate = "update"
+	AuditActionDelete = "delete"
+)
+
+// Define an AuditEntry struct to record a single change to a record: who made it, and the state of the
+// record before and after the change.
+type AuditEntry struct {
+	ID        int64           `json:"id"`
+	CreatedAt time.Time       `json:"created_at"`
+	UserID    *int64          `json:"user_id"` // nil for anonymous users
+	Action    string          `json:"action"`
+	Entity    string          `json:"entity"`
+	EntityID  int64           `json:"entity_id"`
+	Before    json.RawMessage `json:"before"`
+	After     json.RawMessage `json:"after"`
+}
+
+// Define the AuditModel struct
+type AuditModel struct {
+	DB       *sql.DB
+	ReadDB   *sql.DB       // Pool for read-only queries, e.g. a replica, defaults to DB if not set
+	Timeout  time.Duration // Timeout for each query, defaults to 3 seconds if not set
+	QueryLog QueryLogFunc  // Called after every query to log it, nil to disable
+}
+
+// Record() adds an entry to the audit log. A userID of 0 (the anonymous user) is stored as NULL.
+// The before state is nil for a created record, and the after state is nil for a deleted record.
+func (m AuditModel) Record(userID int64, action, entity string, entityID int64, before, after json.RawMessage) error {
+	defer observeQuery(m.QueryLog, "audit.record", time.Now())
+
+	query := `
+		INSERT INTO audit_log (user_id, action, entity, entity_id, before, after)
+		VALUES ($1, $2, $3, $4, $5, $6)`
+
+	var user sql.NullInt64
+	if userID > 0 {
+		user = sql.NullInt64{Int64: userID, Valid: true}
+	}
+
+	// A nil json.RawMessage would be sent as an empty string, which isn't valid jsonb, so send NULL instead.
+	args := []interface{}{user, action, entity, entityID, nullJSON(before), nullJSON(after)}
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// nullJSON() returns nil for an empty JSON value, so that it is stored as NULL.
+func nullJSON(js json.RawMessage) interface{} {
+	if len(js) == 0 {
+		return nil
+	}
+	return []byte(js)
+}
+
+// GetAll() returns a page of audit log entries, along with the pagination metadata.
+func (m AuditModel) GetAll(filters Filters) ([]*AuditEntry, Metadata, error) {
+	defer observeQuery(m.QueryLog, "audit.get_all", time.Now())
+
+	// The sort column is interpolated into the query, so it must come from the safelist.
+	sortColumn, err := filters.sortColumn()
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, user_id, action, entity, entity_id, before, after
+		FROM audit_log
+		ORDER BY %s %s, id ASC
+		LIMIT $1 OFFSET $2`, sortColumn, filters.sortDirection())
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	rows, err := readPool(m.DB, m.ReadDB).QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	entries := []*AuditEntry{}
+
+	for rows.Next() {
+		entry := new(AuditEntry)
+
+		var userID sql.NullInt64
+		var before, after []byte
+
+		err := rows.Scan(
+			&totalRecords,
+			&entry.ID,
+			&entry.CreatedAt,
+			&userID,
+			&entry.Action,
+			&entry.Entity,
+			&entry.EntityID,
+			&before,
+			&after,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		if userID.Valid {
+			entry.UserID = &userID.Int64
+		}
+		entry.Before = before
+		entry.After = after
+
+		entries = append(entries, entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return entries, metadata, nil
+}
@@ -1,6 +1,7 @@
 package data
 
 import (
+	"errors"
 	"math"
 	"strings"
 
@@ -13,14 +14,29 @@ type Metadata struct {
 	PageSize int `json:"page_size,omitempty"`
 	FirstPage int `json:"first_page,omitempty"`
 	LastPage int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	TotalRecords int `json:"total_records"` // Always set, 0 when no records match
+	TotalPages int `json:"total_pages"` // Same as LastPage, for clients which prefer a count. Always set, like TotalRecords
+	HasNextPage bool `json:"has_next_page"`
+	HasPreviousPage bool `json:"has_previous_page"`
 }
 
+// ErrInvalidSort is returned when the Sort field does not match one of the entries in the sort safelist.
+var ErrInvalidSort = errors.New("invalid sort value")
+
+// DefaultSortSafeList is used when a Filters struct is not given a sort safelist of its own.
+// It only allows sorting by ID, so a handler which forgets to set the safelist fails safe.
+var DefaultSortSafeList = []string{"id", "-id"}
+
+// defaultMaxPageSize is the largest page_size accepted by a Filters struct which is not given a maximum of its own.
+const defaultMaxPageSize = 100
+
 type Filters struct {
 	Page         int
 	PageSize     int
 	Sort         string
 	SortSafeList []string
+	MaxPageSize  int // Largest page_size accepted (set from -max-page-size), defaults to 100 if not set
+	MaxOffset    int // Largest number of rows skipped to reach a page (set from -max-offset), 0 disables the check
 }
 
 // calculateMetadata() function calculates the appropriate pagination metadata values given the total number of records, 
@@ -29,32 +45,52 @@ type Filters struct {
 // the last page would be math.Ceil(12/5) = 3.
 func calculateMetadata(totalRecords, page, pageSize int) Metadata {
 	if totalRecords == 0 {
-		// Return an empty metadata if there are no records
+		// Return an empty metadata if there are no records. total_records and total_pages are still sent, as 0.
 		return Metadata{}
 	}
 	
+	lastPage := int(math.Ceil(float64(totalRecords) / float64(pageSize)))
+
+	// A page past the last one has no next page, but its previous page exists.
 	return Metadata{
 		CurrentPage: page,
 		PageSize: pageSize,
 		FirstPage: 1,
-		LastPage: int(math.Ceil(float64(totalRecords) / float64(pageSize))),
+		LastPage: lastPage,
 		TotalRecords: totalRecords,
+		TotalPages: lastPage,
+		HasNextPage: page < lastPage,
+		HasPreviousPage: page > 1,
+	}
+}
+
+// WithSortSafeList() returns a copy of the Filters struct with the sort safelist set to the given values.
+func (f Filters) WithSortSafeList(safeList ...string) Filters {
+	f.SortSafeList = safeList
+	return f
+}
+
+// sortSafeList() returns the sort safelist of the Filters struct, or DefaultSortSafeList if none is set.
+func (f Filters) sortSafeList() []string {
+	if len(f.SortSafeList) == 0 {
+		return DefaultSortSafeList
 	}
+	return f.SortSafeList
 }
 
 // Check that the client-provided Sort field matche one of the entries in our safelist.
 // If it does, extract the column name from the Sort field by stripping the leading hyphen character (if it exists).
-// sortColumn() is constructed to panic if the client-provided Sort value does not match one of the entries in the safelist.
+// sortColumn() returns ErrInvalidSort if the client-provided Sort value does not match one of the entries in the safelist.
 // Technically, this should not happen because the Sort value would have been checked by ValidateFilters().
 // But it is a sensible failsafe to stop SQL injection.
-func (f Filters) sortColumn() string {
-	for _, safeValue := range f.SortSafeList {
+func (f Filters) sortColumn() (string, error) {
+	for _, safeValue := range f.sortSafeList() {
 		if f.Sort == safeValue {
-			return strings.TrimPrefix(f.Sort, "-")
+			return strings.TrimPrefix(f.Sort, "-"), nil
 		}
 	}
 
-	panic("unsafe soft parameter: " + f.Sort)
+	return "", ErrInvalidSort
 }
 
 // Return the sort direction ("ASC" or "DESC") depending on the prefix character of the Sort field.
@@ -65,6 +101,14 @@ func (f Filters) sortDirection() string {
 	return "ASC"
 }
 
+// maxPageSize() returns the largest page size accepted by the Filters struct, or defaultMaxPageSize if none is set.
+func (f Filters) maxPageSize() int {
+	if f.MaxPageSize <= 0 {
+		return defaultMaxPageSize
+	}
+	return f.MaxPageSize
+}
+
 // Return the pagination page size
 func (f Filters) limit() int {
 	return f.PageSize
@@ -82,8 +126,13 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.Page > 0, "page", "must be greater than zero")
 	v.Check(f.Page <= 10_000_000, "page", "must be a maximum of 10 million")
 	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
-	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
+	v.Checkf(f.PageSize <= f.maxPageSize(), "page_size", "must be a maximum of %d", f.maxPageSize())
+
+	// Check that the page isn't so deep that it forces Postgres to scan a huge number of rows
+	if f.MaxOffset > 0 && f.Page > 0 && f.PageSize > 0 {
+		v.Check(f.offset() <= f.MaxOffset, "page", "too deep, narrow the results with filters or a different sort order")
+	}
 
 	// Check that the sort parameter matches a value in the safelist
-	v.Check(validator.In(f.Sort, f.SortSafeList...), "sort", "invalid sort value")
+	v.Check(validator.In(f.Sort, f.sortSafeList()...), "sort", "invalid sort value")
 }
\ No newline at end of file
@@ -0,0 +1,177 @@
+package data
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/jseow5177/greenlight/internal/validator"
+)
+
+func TestCalculateMetadata(t *testing.T) {
+	tests := []struct {
+		name                 string
+		totalRecords         int
+		page, pageSize       int
+		lastPage             int
+		hasNext, hasPrevious bool
+	}{
+		{"single page", 3, 1, 5, 1, false, false},
+		{"first of several", 12, 1, 5, 3, true, false},
+		{"middle", 12, 2, 5, 3, true, true},
+		{"last", 12, 3, 5, 3, false, true},
+		{"exact fit", 10, 2, 5, 2, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := calculateMetadata(tt.totalRecords, tt.page, tt.pageSize)
+
+			if m.LastPage != tt.lastPage || m.TotalPages != tt.lastPage {
+				t.Errorf("got last page %d, total pages %d; want %d", m.LastPage, m.TotalPages, tt.lastPage)
+			}
+			if m.HasNextPage != tt.hasNext || m.HasPreviousPage != tt.hasPrevious {
+				t.Errorf("got has next %t, has previous %t; want %t, %t", m.HasNextPage, m.HasPreviousPage, tt.hasNext, tt.hasPrevious)
+			}
+			if m.TotalRecords != tt.totalRecords || m.CurrentPage != tt.page || m.PageSize != tt.pageSize || m.FirstPage != 1 {
+				t.Errorf("got %+v", m)
+			}
+		})
+	}
+}
+
+func TestCalculateMetadataNoRecords(t *testing.T) {
+	js, err := json.Marshal(calculateMetadata(0, 1, 20))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got map[string]interface{}
+	err = json.Unmarshal(js, &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The totals must be present as 0, rather than left out.
+	for _, key := range []string{"total_records", "total_pages"} {
+		if v, ok := got[key]; !ok || v != float64(0) {
+			t.Errorf("%s: got %v; want 0 in %s", key, v, js)
+		}
+	}
+}
+
+func TestSortColumn(t *testing.T) {
+	tests := []struct {
+		name    string
+		filters Filters
+		column  string
+		err     error
+	}{
+		{"ascending", Filters{Sort: "title"}.WithSortSafeList("title", "-title"), "title", nil},
+		{"descending", Filters{Sort: "-title"}.WithSortSafeList("title", "-title"), "title", nil},
+		{"not in the safelist", Filters{Sort: "title; DROP TABLE movies"}.WithSortSafeList("title", "-title"), "", ErrInvalidSort},
+		{"missing safelist allows id", Filters{Sort: "-id"}, "id", nil},
+		{"missing safelist rejects other columns", Filters{Sort: "title"}, "", ErrInvalidSort},
+	}
+
+	for _, tt := range tests {
+		column, err := tt.filters.sortColumn()
+		if column != tt.column || !errors.Is(err, tt.err) {
+			t.Errorf("%s: got %q, %v; want %q, %v", tt.name, column, err, tt.column, tt.err)
+		}
+	}
+}
+
+func TestValidateFiltersSort(t *testing.T) {
+	tests := []struct {
+		name    string
+		filters Filters
+		valid   bool
+	}{
+		{"in the safelist", Filters{Sort: "-year"}.WithSortSafeList("year", "-year"), true},
+		{"not in the safelist", Filters{Sort: "runtime"}.WithSortSafeList("year", "-year"), false},
+		{"missing safelist", Filters{Sort: "id"}, true},
+		{"missing safelist, other column", Filters{Sort: "year"}, false},
+	}
+
+	for _, tt := range tests {
+		tt.filters.Page, tt.filters.PageSize = 1, 20
+
+		v := validator.New()
+		ValidateFilters(v, tt.filters)
+		if v.Valid() != tt.valid {
+			t.Errorf("%s: got valid %t; want %t (errors %v)", tt.name, v.Valid(), tt.valid, v.Errors)
+		}
+		if !tt.valid && v.Errors["sort"] == "" {
+			t.Errorf("%s: no error for sort: %v", tt.name, v.Errors)
+		}
+	}
+}
+
+func TestGetAllInvalidSort(t *testing.T) {
+	pool := new(stubPool)
+	m := NewModels(sql.OpenDB(pool), 0)
+
+	// A sort value which skipped ValidateFilters() is returned as an error, rather than panicking or reaching
+	// the database.
+	_, _, err := m.Movies.GetAll("", nil, nil, QualityFilters{}, Filters{Page: 1, PageSize: 20, Sort: "title"})
+	if !errors.Is(err, ErrInvalidSort) {
+		t.Errorf("got error %v; want %v", err, ErrInvalidSort)
+	}
+	if pool.count() != 0 {
+		t.Errorf("made %d queries; want 0", pool.count())
+	}
+}
+
+func TestValidateFiltersMaxOffset(t *testing.T) {
+	tests := []struct {
+		name      string
+		maxOffset int
+		page      int
+		pageSize  int
+		valid     bool
+	}{
+		{"first page", 1000, 1, 100, true},
+		{"at the cap", 1000, 11, 100, true},
+		{"beyond the cap", 1000, 12, 100, false},
+		{"beyond the cap with small pages", 1000, 1002, 1, false},
+		{"cap disabled", 0, 10_000_000, 100, true},
+	}
+
+	for _, tt := range tests {
+		v := validator.New()
+		ValidateFilters(v, Filters{Page: tt.page, PageSize: tt.pageSize, Sort: "id", MaxOffset: tt.maxOffset})
+
+		if v.Valid() != tt.valid {
+			t.Errorf("%s: got valid %t; want %t (errors %v)", tt.name, v.Valid(), tt.valid, v.Errors)
+		}
+		if !tt.valid && v.Errors["page"] == "" {
+			t.Errorf("%s: no error for page: %v", tt.name, v.Errors)
+		}
+	}
+}
+
+func TestValidateFiltersMaxPageSize(t *testing.T) {
+	tests := []struct {
+		pageSize int
+		valid    bool
+	}{
+		{1, true},
+		{50, true},
+		{51, false},
+		{100, false},
+	}
+
+	for _, tt := range tests {
+		v := validator.New()
+		ValidateFilters(v, Filters{Page: 1, PageSize: tt.pageSize, Sort: "id", MaxPageSize: 50})
+
+		if v.Valid() != tt.valid {
+			t.Errorf("page size %d: got valid %t; want %t (errors %v)", tt.pageSize, v.Valid(), tt.valid, v.Errors)
+		}
+		if !tt.valid && v.Errors["page_size"] != "must be a maximum of 50" {
+			t.Errorf("page size %d: got error %q for page_size", tt.pageSize, v.Errors["page_size"])
+		}
+	}
+}
@@ -1,8 +1,12 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"time"
+
+	"github.com/lib/pq"
 )
 
 var (
@@ -10,18 +14,161 @@ var (
 	ErrEditConflict   = errors.New("edit conflict")    // To deal with race condition
 )
 
+// queryer is satisfied by both *sql.DB and *sql.Tx. It allows model methods to run the same query
+// either directly against the connection pool or as part of a transaction.
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// readPool() returns the pool which read-only queries should use: the read pool if one is set,
+// or the primary pool otherwise.
+func readPool(db, readDB *sql.DB) *sql.DB {
+	if readDB != nil {
+		return readDB
+	}
+	return db
+}
+
+// defaultTimeout is the query timeout used by a model which has not been given one.
+const defaultTimeout = 3 * time.Second
+
+// queryContext() returns a context carrying the query timeout, or defaultTimeout if the timeout is not set.
+// The caller must call the returned CancelFunc once the query has completed.
+func queryContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// serializationBackoff is the delay before the first retry of a serialization failure. It doubles on each retry.
+const serializationBackoff = 10 * time.Millisecond
+
+// uniqueViolations maps the names of the UNIQUE constraints to the errors returned when a write violates them.
+// Add an entry here when a new UNIQUE constraint needs its own error.
+var uniqueViolations = map[string]error{
+	"users_email_key": ErrDuplicateEmail,
+}
+
+// pgError() converts a Postgres constraint violation into the typed error of the data package for that constraint,
+// and returns any other error unchanged. It branches on the SQLSTATE code and the constraint name of the *pq.Error,
+// rather than on the error message, which differs between Postgres versions and locales.
+func pgError(err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return err
+	}
+
+	switch pqErr.Code.Name() {
+	case "unique_violation":
+		if mapped, ok := uniqueViolations[pqErr.Constraint]; ok {
+			return mapped
+		}
+	}
+
+	return err
+}
+
+// isSerializationFailure() reports whether the error is a Postgres serialization_failure (SQLSTATE 40001).
+func isSerializationFailure(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "40001"
+}
+
+// retrySerializable() calls fn, and calls it again after a backoff if it fails with a serialization failure,
+// up to the given number of retries. Under SERIALIZABLE (or REPEATABLE READ) isolation, Postgres aborts a
+// transaction which conflicts with a concurrent one with SQLSTATE 40001, and the transaction can simply be run
+// again. Any other error (including ErrEditConflict, which means that the record really was changed by someone
+// else) is returned straight away.
+func retrySerializable(retries int, fn func() error) error {
+	backoff := serializationBackoff
+
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || !isSerializationFailure(err) || attempt >= retries {
+			return err
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// QueryLogFunc is called with the operation name, the number of rows it returned or affected, and the duration of
+// a query made by a model. The row count is -1 when the method doesn't report it. The data package has no logger of
+// its own, so the application gives the models one with Models.WithQueryLog().
+type QueryLogFunc func(operation string, rows int64, duration time.Duration)
+
+// observeQuery() reports the operation and the time it has been running for to the QueryLog of the model. It is
+// meant to be deferred at the start of a model method, with the time at which the method started:
+//
+//	defer observeQuery(m.QueryLog, "movies.get", time.Now())
+//
+// Only the operation name is reported, never the query arguments, which may hold personal data or secrets.
+func observeQuery(log QueryLogFunc, operation string, start time.Time) {
+	observeQueryRows(log, operation, start, nil)
+}
+
+// observeQueryRows() is observeQuery() for the methods which know how many rows their query returned or affected.
+// The count is read when the deferred call runs, so the method sets it before returning:
+//
+//	var rows int64
+//	defer observeQueryRows(m.QueryLog, "movies.update", time.Now(), &rows)
+func observeQueryRows(log QueryLogFunc, operation string, start time.Time, rows *int64) {
+	if log == nil {
+		return
+	}
+
+	n := int64(-1)
+	if rows != nil {
+		n = *rows
+	}
+	log(operation, n, time.Since(start))
+}
+
 // Create a Models struct that wraps all database models of this application.
 type Models struct {
-	Movies MovieModel
-	Users  UserModel
-	Tokens TokenModel
+	Audit       AuditModel
+	Movies      MovieModel
+	Permissions PermissionModel
+	Reviews     ReviewModel
+	Users       UserModel
+	Tokens      TokenModel
+	Webhooks    WebhookModel
 }
 
-// The New() method returns a newly initialized Models struct
-func NewModels(db *sql.DB) Models {
+// The New() method returns a newly initialized Models struct.
+// The timeout is applied to every query made by the models.
+func NewModels(db *sql.DB, timeout time.Duration) Models {
+	return NewModelsWithReplica(db, nil, timeout)
+}
+
+// NewModelsWithReplica() returns a Models struct like NewModels(), except that the reads of movies, reviews and
+// the audit log are sent to the replica pool. Writes, transactions and the reads of users, tokens, permissions
+// and webhooks always use the primary, so that authentication isn't affected by replication lag.
+// A nil replica sends everything to the primary.
+func NewModelsWithReplica(db, replica *sql.DB, timeout time.Duration) Models {
 	return Models{
-		Movies: MovieModel{DB: db},
-		Tokens: TokenModel{DB: db},
-		Users:  UserModel{DB: db},
+		Audit:       AuditModel{DB: db, ReadDB: replica, Timeout: timeout},
+		Movies:      MovieModel{DB: db, ReadDB: replica, Timeout: timeout},
+		Permissions: PermissionModel{DB: db, Timeout: timeout},
+		Reviews:     ReviewModel{DB: db, ReadDB: replica, Timeout: timeout},
+		Tokens:      TokenModel{DB: db, Timeout: timeout},
+		Users:       UserModel{DB: db, Timeout: timeout},
+		Webhooks:    WebhookModel{DB: db, Timeout: timeout},
 	}
 }
+
+// WithQueryLog() returns a copy of the models which report every query to log. The application uses it to give
+// the models of each request a logger carrying the trace ID of the request. A nil log disables the reporting.
+func (m Models) WithQueryLog(log QueryLogFunc) Models {
+	m.Audit.QueryLog = log
+	m.Movies.QueryLog = log
+	m.Permissions.QueryLog = log
+	m.Reviews.QueryLog = log
+	m.Tokens.QueryLog = log
+	m.Users.QueryLog = log
+	m.Webhooks.QueryLog = log
+	return m
+}
@@ -0,0 +1,360 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// stubPool is a connection pool whose connections fail every query, after counting it. It tells which pool a
+// model method used, without a database.
+type stubPool struct {
+	mu      sync.Mutex
+	queries int
+}
+
+func (p *stubPool) count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.queries
+}
+
+func (p *stubPool) Connect(context.Context) (driver.Conn, error) { return stubConn{p}, nil }
+func (p *stubPool) Driver() driver.Driver                        { return nil }
+
+type stubConn struct{ pool *stubPool }
+
+var errStub = errors.New("stub pool")
+
+func (c stubConn) QueryContext(context.Context, string, []driver.NamedValue) (driver.Rows, error) {
+	c.pool.mu.Lock()
+	c.pool.queries++
+	c.pool.mu.Unlock()
+	return nil, errStub
+}
+
+func (c stubConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	_, err := c.QueryContext(ctx, query, args)
+	return nil, err
+}
+
+func (c stubConn) Prepare(string) (driver.Stmt, error) { return nil, errStub }
+func (c stubConn) Close() error                        { return nil }
+func (c stubConn) Begin() (driver.Tx, error)           { return nil, errStub }
+
+func TestReadReplicaRouting(t *testing.T) {
+	primary, replica := new(stubPool), new(stubPool)
+
+	m := NewModelsWithReplica(sql.OpenDB(primary), sql.OpenDB(replica), 0)
+
+	tests := []struct {
+		name string
+		call func()
+		pool *stubPool
+	}{
+		{"Get", func() { m.Movies.Get(1) }, replica},
+		{"GetAll", func() {
+			m.Movies.GetAll("", nil, nil, QualityFilters{}, Filters{Page: 1, PageSize: 20, Sort: "id", SortSafeList: []string{"id"}})
+		}, replica},
+		{"GetForUpdate", func() { m.Movies.GetForUpdate(1) }, primary},
+		{"Insert", func() { m.Movies.Insert(newTestMovie("Moana", 2016)) }, primary},
+		{"Update", func() { m.Movies.Update(&Movie{ID: 1, Title: "Moana", Year: 2016, Version: 1}) }, primary},
+		{"Delete", func() { m.Movies.Delete(1, 0) }, primary},
+	}
+
+	for _, tt := range tests {
+		primaryBefore, replicaBefore := primary.count(), replica.count()
+
+		tt.call()
+
+		primaryUsed, replicaUsed := primary.count() > primaryBefore, replica.count() > replicaBefore
+
+		if tt.pool == replica && (!replicaUsed || primaryUsed) {
+			t.Errorf("%s: used the primary %t, the replica %t; want only the replica", tt.name, primaryUsed, replicaUsed)
+		}
+		if tt.pool == primary && (!primaryUsed || replicaUsed) {
+			t.Errorf("%s: used the primary %t, the replica %t; want only the primary", tt.name, primaryUsed, replicaUsed)
+		}
+	}
+}
+
+func TestReadReplicaFallback(t *testing.T) {
+	primary := new(stubPool)
+
+	m := NewModels(sql.OpenDB(primary), 0)
+
+	m.Movies.Get(1)
+	if primary.count() != 1 {
+		t.Errorf("Get() without a replica made %d queries on the primary; want 1", primary.count())
+	}
+}
+
+func TestNewModels(t *testing.T) {
+	db := sql.OpenDB(new(stubPool))
+	defer db.Close()
+
+	m := NewModels(db, time.Second)
+
+	if m.Tokens.DB != db {
+		t.Error("Tokens.DB isn't the connection pool passed to NewModels()")
+	}
+	if m.Tokens.Timeout != time.Second {
+		t.Errorf("got Tokens.Timeout %s; want %s", m.Tokens.Timeout, time.Second)
+	}
+	if m.Users.DB != db || m.Movies.DB != db || m.Permissions.DB != db {
+		t.Error("a model doesn't use the connection pool passed to NewModels()")
+	}
+}
+
+// flakyPool is a connection pool whose first queries fail with a serialization failure, like Postgres under
+// SERIALIZABLE isolation. The queries after them return the row, or no rows if it is nil.
+type flakyPool struct {
+	mu       sync.Mutex
+	failures int
+	queries  int
+	columns  []string
+	row      []driver.Value
+}
+
+func (p *flakyPool) count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.queries
+}
+
+func (p *flakyPool) Connect(context.Context) (driver.Conn, error) { return flakyConn{p}, nil }
+func (p *flakyPool) Driver() driver.Driver                        { return nil }
+
+type flakyConn struct{ pool *flakyPool }
+
+func (c flakyConn) QueryContext(context.Context, string, []driver.NamedValue) (driver.Rows, error) {
+	c.pool.mu.Lock()
+	defer c.pool.mu.Unlock()
+
+	c.pool.queries++
+	if c.pool.queries <= c.pool.failures {
+		return nil, &pq.Error{Code: "40001", Message: "could not serialize access due to concurrent update"}
+	}
+
+	rows := &valueRows{columns: c.pool.columns}
+	if c.pool.row != nil {
+		rows.values = [][]driver.Value{c.pool.row}
+	}
+	return rows, nil
+}
+
+func (c flakyConn) Prepare(string) (driver.Stmt, error) { return nil, errStub }
+func (c flakyConn) Close() error                        { return nil }
+func (c flakyConn) Begin() (driver.Tx, error)           { return nil, errStub }
+
+// valueRows are the rows of a query result, given as values.
+type valueRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *valueRows) Columns() []string { return r.columns }
+func (r *valueRows) Close() error      { return nil }
+
+func (r *valueRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+func TestUpdateSerializationRetries(t *testing.T) {
+	updatedAt := time.Now()
+
+	tests := []struct {
+		name     string
+		failures int
+		row      []driver.Value
+		queries  int
+		want     func(error) bool
+	}{
+		{"recovers", 2, []driver.Value{int64(2), updatedAt}, 3, func(err error) bool { return err == nil }},
+		{"retries exhausted", 4, []driver.Value{int64(2), updatedAt}, 4, isSerializationFailure},
+		{"edit conflict", 0, nil, 1, func(err error) bool { return errors.Is(err, ErrEditConflict) }},
+	}
+
+	for _, tt := range tests {
+		for _, model := range []string{"movies", "users"} {
+			pool := &flakyPool{failures: tt.failures, row: tt.row}
+
+			m := NewModels(sql.OpenDB(pool), 0)
+			m.Movies.SerializationRetries = 3
+			m.Users.SerializationRetries = 3
+
+			var err error
+			switch model {
+			case "movies":
+				pool.columns = []string{"version", "updated_at"}
+				err = m.Movies.Update(&Movie{ID: 1, Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation"}, Version: 1})
+			case "users":
+				pool.columns = []string{"version"}
+				if pool.row != nil {
+					pool.row = pool.row[:1]
+				}
+				err = m.Users.Update(&User{ID: 1, Name: "Alice", Email: "alice@example.com", Version: 1})
+			}
+
+			// A serialization failure is only surfaced once the retries are used up, and an edit conflict isn't
+			// retried.
+			if !tt.want(err) {
+				t.Errorf("%s, %s: got unexpected error %v", tt.name, model, err)
+			}
+
+			if pool.count() != tt.queries {
+				t.Errorf("%s, %s: made %d queries; want %d", tt.name, model, pool.count(), tt.queries)
+			}
+		}
+	}
+}
+
+func TestQueryLog(t *testing.T) {
+	type entry struct {
+		operation string
+		duration  time.Duration
+	}
+	var logged []entry
+
+	log := func(operation string, rows int64, duration time.Duration) {
+		logged = append(logged, entry{operation, duration})
+	}
+
+	// The queries of the slow pool only return once they time out, so the duration covers the whole query.
+	slow := NewModels(sql.OpenDB(slowPool{}), 30*time.Millisecond).WithQueryLog(log)
+	slow.Movies.Get(1)
+
+	if len(logged) != 1 {
+		t.Fatalf("got %d queries logged; want 1: %v", len(logged), logged)
+	}
+	if logged[0].operation != "movies.get" || logged[0].duration < 30*time.Millisecond {
+		t.Errorf("got %s after %s; want movies.get after at least 30ms", logged[0].operation, logged[0].duration)
+	}
+
+	// The models without a query log don't report their queries.
+	NewModels(sql.OpenDB(new(stubPool)), 0).Movies.Get(1)
+
+	if len(logged) != 1 {
+		t.Errorf("got %d queries logged without a query log; want 1", len(logged))
+	}
+}
+
+func TestPgError(t *testing.T) {
+	other := errors.New("connection reset")
+
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"email", &pq.Error{Code: "23505", Constraint: "users_email_key", Message: "llave duplicada viola restricción de unicidad"}, ErrDuplicateEmail},
+		{"wrapped", fmt.Errorf("insert: %w", &pq.Error{Code: "23505", Constraint: "users_email_key"}), ErrDuplicateEmail},
+		{"other constraint", &pq.Error{Code: "23505", Constraint: "tokens_pkey"}, nil},
+		{"other code", &pq.Error{Code: "23503", Constraint: "users_email_key"}, nil},
+		{"not a pq.Error", other, other},
+	}
+
+	for _, tt := range tests {
+		got := pgError(tt.err)
+
+		// Errors which aren't mapped are returned unchanged.
+		want := tt.want
+		if want == nil {
+			want = tt.err
+		}
+		if got != want {
+			t.Errorf("%s: got %v; want %v", tt.name, got, want)
+		}
+	}
+}
+
+// errorPool is a connection pool whose queries all fail with the error.
+type errorPool struct{ err error }
+
+func (p errorPool) Connect(context.Context) (driver.Conn, error) { return errorConn(p), nil }
+func (p errorPool) Driver() driver.Driver                        { return nil }
+
+type errorConn struct{ err error }
+
+func (c errorConn) QueryContext(context.Context, string, []driver.NamedValue) (driver.Rows, error) {
+	return nil, c.err
+}
+
+func (c errorConn) Prepare(string) (driver.Stmt, error) { return nil, errStub }
+func (c errorConn) Close() error                        { return nil }
+func (c errorConn) Begin() (driver.Tx, error)           { return nil, errStub }
+
+func TestUserModelInsertDuplicateEmail(t *testing.T) {
+	// The message is in another language, so only the code and the constraint can identify the violation.
+	pool := errorPool{&pq.Error{Code: "23505", Constraint: "users_email_key", Message: "doppelter Schlüsselwert verletzt Unique-Constraint"}}
+
+	m := NewModels(sql.OpenDB(pool), 0)
+
+	user := &User{Name: "Alice", Email: "alice@example.com"}
+	err := user.Password.Set("pa55word-for-tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Users.Insert(user)
+	if !errors.Is(err, ErrDuplicateEmail) {
+		t.Errorf("got error %v; want %v", err, ErrDuplicateEmail)
+	}
+}
+
+func TestQueryLogRows(t *testing.T) {
+	type entry struct {
+		operation string
+		rows      int64
+	}
+	var logged []entry
+
+	log := func(operation string, rows int64, duration time.Duration) {
+		logged = append(logged, entry{operation, rows})
+	}
+
+	tests := []struct {
+		name string
+		row  []driver.Value
+		want entry
+	}{
+		{"updated", []driver.Value{int64(2), time.Now()}, entry{"movies.update", 1}},
+		{"edit conflict", nil, entry{"movies.update", 0}},
+	}
+
+	for _, tt := range tests {
+		logged = nil
+
+		m := NewModels(sql.OpenDB(&flakyPool{columns: []string{"version", "updated_at"}, row: tt.row}), 0).WithQueryLog(log)
+		err := m.Movies.Update(&Movie{ID: 1, Title: "Moana", Genres: []string{"animation"}, Version: 1})
+		if (err == nil) != (tt.row != nil) {
+			t.Fatalf("%s: got unexpected error %v", tt.name, err)
+		}
+
+		if !reflect.DeepEqual(logged, []entry{tt.want}) {
+			t.Errorf("%s: got %v logged; want %v", tt.name, logged, tt.want)
+		}
+	}
+
+	// A method which doesn't report its row count logs -1.
+	logged = nil
+	NewModels(sql.OpenDB(new(stubPool)), 0).WithQueryLog(log).Movies.Get(1)
+
+	if want := []entry{{"movies.get", -1}}; !reflect.DeepEqual(logged, want) {
+		t.Errorf("got %v logged; want %v", logged, want)
+	}
+}
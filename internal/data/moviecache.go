@@ -0,0 +1,142 @@
+package data
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MovieCache is an in-memory cache of movies by ID, in front of MovieModel.Get(). Entries expire after a TTL, and
+// the least recently used entry is evicted once the cache is full. MovieModel invalidates the entry of a movie when
+// it is updated or deleted, but only in this process: other instances of the application serve their cached copy
+// until it expires. The cache stores copies of the movies, and returns copies, so that callers can't change the
+// cached state. All the methods are safe for concurrent use, and do nothing on a nil *MovieCache.
+type MovieCache struct {
+	ttl     time.Duration
+	maxSize int
+
+	mu      sync.Mutex
+	entries map[int64]*list.Element
+	lru     *list.List // Front is the most recently used
+	gen     uint64     // Incremented by every invalidation
+
+	hits   int64
+	misses int64
+}
+
+type movieCacheEntry struct {
+	movie   *Movie
+	expires time.Time
+}
+
+// NewMovieCache() returns a cache which keeps each movie for ttl, and holds at most maxSize movies.
+func NewMovieCache(ttl time.Duration, maxSize int) *MovieCache {
+	return &MovieCache{
+		ttl:     ttl,
+		maxSize: maxSize,
+		entries: make(map[int64]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// Hits() returns the number of lookups which found an unexpired movie.
+func (c *MovieCache) Hits() int64 {
+	if c == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&c.hits)
+}
+
+// Misses() returns the number of lookups which had to go to the database.
+func (c *MovieCache) Misses() int64 {
+	if c == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&c.misses)
+}
+
+// get() returns a copy of the cached movie with the ID, along with the current generation. The generation is
+// passed back to set(), so that a movie read from the database before an invalidation isn't cached after it.
+func (c *MovieCache) get(id int64) (*Movie, uint64, bool) {
+	if c == nil {
+		return nil, 0, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[id]; ok {
+		entry := el.Value.(*movieCacheEntry)
+		if time.Now().Before(entry.expires) {
+			c.lru.MoveToFront(el)
+			atomic.AddInt64(&c.hits, 1)
+			return copyMovie(entry.movie), c.gen, true
+		}
+
+		c.remove(el)
+	}
+
+	atomic.AddInt64(&c.misses, 1)
+	return nil, c.gen, false
+}
+
+// set() caches a copy of the movie, unless the cache has been invalidated since the generation was read.
+func (c *MovieCache) set(movie *Movie, gen uint64) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if gen != c.gen {
+		return
+	}
+
+	if el, ok := c.entries[movie.ID]; ok {
+		c.remove(el)
+	}
+
+	c.entries[movie.ID] = c.lru.PushFront(&movieCacheEntry{movie: copyMovie(movie), expires: time.Now().Add(c.ttl)})
+
+	for c.lru.Len() > c.maxSize {
+		c.remove(c.lru.Back())
+	}
+}
+
+// Invalidate() removes the movies with the given IDs from the cache.
+func (c *MovieCache) Invalidate(ids ...int64) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+
+	for _, id := range ids {
+		if el, ok := c.entries[id]; ok {
+			c.remove(el)
+		}
+	}
+}
+
+// remove() deletes an entry. The mutex must be held.
+func (c *MovieCache) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*movieCacheEntry).movie.ID)
+}
+
+// copyMovie() returns a copy of the movie which shares no state with it.
+func copyMovie(m *Movie) *Movie {
+	cp := *m
+	if m.Genres != nil {
+		cp.Genres = append([]string(nil), m.Genres...)
+	}
+	if m.Tags != nil {
+		cp.Tags = append([]string(nil), m.Tags...)
+	}
+	return &cp
+}
@@ -0,0 +1,187 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jseow5177/greenlight/internal/testdb"
+)
+
+func TestMovieCacheReturnsCopies(t *testing.T) {
+	c := NewMovieCache(time.Minute, 10)
+
+	movie := &Movie{ID: 1, Title: "Moana", Genres: []string{"animation"}, Tags: []string{"90s"}}
+	_, gen, _ := c.get(movie.ID)
+	c.set(movie, gen)
+
+	// Changing the movie after caching it must not change the cached copy.
+	movie.Genres[0] = "changed"
+	movie.Tags[0] = "changed"
+
+	cached, _, ok := c.get(1)
+	if !ok {
+		t.Fatal("movie not cached")
+	}
+	if !reflect.DeepEqual(cached.Genres, []string{"animation"}) {
+		t.Errorf("cached genres = %q; want [animation]", cached.Genres)
+	}
+	if !reflect.DeepEqual(cached.Tags, []string{"90s"}) {
+		t.Errorf("cached tags = %q; want [90s]", cached.Tags)
+	}
+
+	// Nor must changing a movie returned by the cache.
+	cached.Genres[0] = "changed"
+	cached.Tags[0] = "changed"
+
+	again, _, _ := c.get(1)
+	if again.Genres[0] != "animation" || again.Tags[0] != "90s" {
+		t.Errorf("cache shares state with a returned movie: genres %q, tags %q", again.Genres, again.Tags)
+	}
+}
+
+func TestMovieCacheInvalidate(t *testing.T) {
+	c := NewMovieCache(time.Minute, 10)
+
+	_, gen, _ := c.get(1)
+	c.Invalidate(1)
+	c.set(&Movie{ID: 1}, gen)
+
+	// The movie was read before the invalidation, so it must not be cached.
+	if _, _, ok := c.get(1); ok {
+		t.Error("movie read before an invalidation was cached")
+	}
+}
+
+func TestMovieCacheHitAndMiss(t *testing.T) {
+	c := NewMovieCache(time.Minute, 10)
+
+	// A miss returns the generation with which the movie read from the database is then cached.
+	_, gen, ok := c.get(1)
+	if ok {
+		t.Fatal("got a movie from an empty cache")
+	}
+	c.set(&Movie{ID: 1, Title: "Moana"}, gen)
+
+	cached, _, ok := c.get(1)
+	if !ok || cached.Title != "Moana" {
+		t.Fatalf("got %v, %t; want the cached movie", cached, ok)
+	}
+
+	if c.Hits() != 1 || c.Misses() != 1 {
+		t.Errorf("got %d hits and %d misses; want 1 and 1", c.Hits(), c.Misses())
+	}
+}
+
+func TestMovieCacheExpiry(t *testing.T) {
+	c := NewMovieCache(20*time.Millisecond, 10)
+
+	_, gen, _ := c.get(1)
+	c.set(&Movie{ID: 1}, gen)
+
+	time.Sleep(40 * time.Millisecond)
+
+	if _, _, ok := c.get(1); ok {
+		t.Error("got an expired movie")
+	}
+	if len(c.entries) != 0 || c.lru.Len() != 0 {
+		t.Errorf("the expired entry was kept: %d entries", len(c.entries))
+	}
+}
+
+func TestMovieCacheEviction(t *testing.T) {
+	c := NewMovieCache(time.Minute, 2)
+
+	for id := int64(1); id <= 2; id++ {
+		_, gen, _ := c.get(id)
+		c.set(&Movie{ID: id}, gen)
+	}
+
+	// Using movie 1 makes movie 2 the least recently used, so it's the one evicted by movie 3.
+	c.get(1)
+	_, gen, _ := c.get(3)
+	c.set(&Movie{ID: 3}, gen)
+
+	for id, want := range map[int64]bool{1: true, 2: false, 3: true} {
+		if _, _, ok := c.get(id); ok != want {
+			t.Errorf("movie %d: got cached %t; want %t", id, ok, want)
+		}
+	}
+}
+
+func TestMovieCacheNil(t *testing.T) {
+	var c *MovieCache
+
+	_, gen, ok := c.get(1)
+	c.set(&Movie{ID: 1}, gen)
+	c.Invalidate(1)
+
+	if ok || c.Hits() != 0 || c.Misses() != 0 {
+		t.Error("a nil cache isn't a no-op")
+	}
+}
+
+func TestMovieModelGetCached(t *testing.T) {
+	// Without a database, a movie can only be returned by Get() from the cache.
+	m := MovieModel{DB: sql.OpenDB(new(stubPool)), Cache: NewMovieCache(time.Minute, 10)}
+
+	_, gen, _ := m.Cache.get(1)
+	m.Cache.set(&Movie{ID: 1, Title: "Moana"}, gen)
+
+	movie, err := m.Get(1)
+	if err != nil || movie.Title != "Moana" {
+		t.Fatalf("got %v, error %v; want the cached movie", movie, err)
+	}
+
+	// Once invalidated, the movie is read from the database again.
+	m.Cache.Invalidate(1)
+
+	_, err = m.Get(1)
+	if !errors.Is(err, errStub) {
+		t.Errorf("got error %v; want %v", err, errStub)
+	}
+}
+
+func TestMovieModelCacheInvalidatedByUpdate(t *testing.T) {
+	m := NewModels(testdb.Open(t), 0)
+	m.Movies.Cache = NewMovieCache(time.Minute, 10)
+
+	movie := insertTestMovies(t, m, []string{"drama"})[0]
+
+	// The first Get() misses and caches the movie, and the second one hits.
+	for i := 0; i < 2; i++ {
+		_, err := m.Movies.Get(movie.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if hits, misses := m.Movies.Cache.Hits(), m.Movies.Cache.Misses(); hits != 1 || misses != 1 {
+		t.Errorf("got %d hits and %d misses; want 1 and 1", hits, misses)
+	}
+
+	movie.Title = "Updated"
+	err := m.Movies.Update(movie)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := m.Movies.Get(movie.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "Updated" || got.Version != movie.Version {
+		t.Errorf("got %q version %d; want the updated movie, version %d", got.Title, got.Version, movie.Version)
+	}
+
+	err = m.Movies.Delete(movie.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.Movies.Get(movie.ID)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("after the delete: got error %v; want %v", err, ErrRecordNotFound)
+	}
+}
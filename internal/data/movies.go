@@ -1,10 +1,12 @@
 package data
 
 import (
-	"context"
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/jseow5177/greenlight/internal/validator"
@@ -15,37 +17,231 @@ import (
 type Movie struct {
 	ID        int64 `json:"id"` // Unique integer ID for the movie
 	CreatedAt time.Time `json:"-"` // Timestamp for when the movie is added to our database
+	UpdatedAt time.Time `json:"-"` // Timestamp for when the movie was last changed
 	Title 		string `json:"title"` // Movie title
 	Year 			int32 `json:"year,omitempty"` // Movie release year
 	Runtime		Runtime `json:"runtime,omitempty"` // Movie runtime (in minutes)
 	Genres		[]string `json:"genres,omitempty"` // Slice of genres for the movie (romance, comedy, etc)
+	Tags		[]string `json:"tags,omitempty"` // Free-form tags of the movie ("90s", "rewatchable", etc), unlike the validated genres
 	Version 	int32 `json:"version"` // The version number starts at 1 and will be incremented each time the movie info is updated
+	Poster		string `json:"poster,omitempty"` // URL of the movie poster, empty if none has been uploaded
+	CreatedBy	int64 `json:"-"` // ID of the user who created the movie, 0 if unknown
+	Deleted		bool `json:"deleted,omitempty"` // Whether the movie has been deleted, only set in sync responses
+	MoreGenres	int `json:"more_genres,omitempty"` // Number of genres left out by TruncateGenres(), only set in list responses
 }
 
-func ValidateMovie(v *validator.Validator, movie *Movie) {
+// TruncateGenres() keeps only the first n genres of the movie and records how many were left out in MoreGenres,
+// to bound the size of list responses. It only changes the Movie struct, never the stored movie.
+// A limit of 0 or less keeps every genre.
+func (m *Movie) TruncateGenres(n int) {
+	if n <= 0 || len(m.Genres) <= n {
+		return
+	}
+
+	m.MoreGenres = len(m.Genres) - n
+	m.Genres = m.Genres[:n]
+}
+
+// MarshalJSON() encodes the movie with its public ID (see MovieIDs) in place of the primary key. A deleted movie is
+// encoded as a tombstone with only its ID and "deleted": true, since clients only need to know to remove it.
+func (m Movie) MarshalJSON() ([]byte, error) {
+	if m.Deleted {
+		return json.Marshal(struct {
+			ID      interface{} `json:"id"`
+			Deleted bool        `json:"deleted"`
+		}{
+			ID:      MovieIDs.Encode(m.ID),
+			Deleted: true,
+		})
+	}
+
+	// The alias type has the same fields but none of the methods, so encoding it doesn't call MarshalJSON() again.
+	// The ID field of the outer struct takes precedence over the embedded one with the same JSON name.
+	type movieAlias Movie
+
+	return json.Marshal(struct {
+		ID interface{} `json:"id"`
+		movieAlias
+	}{
+		ID:         MovieIDs.Encode(m.ID),
+		movieAlias: movieAlias(m),
+	})
+}
+
+// The limits on the title and on each genre of a movie, and on the tags of a movie. MaxTags matches the
+// tags_length_check constraint.
+const (
+	MaxTitleBytes = 500
+	MaxGenreBytes = 50
+	MaxTags       = 20
+	MaxTagBytes   = 50
+)
+
+// ErrDuplicateMovie is returned when a movie has the same title and year as another movie which hasn't been
+// deleted, and duplicates are rejected (see MovieModel.UniqueTitleYear and InsertUnique()).
+var ErrDuplicateMovie = errors.New("duplicate movie")
+
+// GenreLimits are the bounds on the number of genres of a movie. They can be narrowed with the -min-genres and
+// -max-genres flags, within the 1 to 5 genres allowed by the genres_length_check constraint. A bound which is
+// not set is the one of the constraint.
+type GenreLimits struct {
+	Min int
+	Max int
+}
+
+// bounds() returns the minimum and maximum number of genres, with the defaults in place of the unset bounds.
+func (l GenreLimits) bounds() (int, int) {
+	min, max := l.Min, l.Max
+	if min <= 0 {
+		min = 1
+	}
+	if max <= 0 {
+		max = 5
+	}
+	return min, max
+}
+
+// MovieSortColumns are the columns which movie lists can be sorted by. The fields offered to clients can be
+// narrowed to some of them with the -movie-sort-fields flag, and must include id, the default sort.
+var MovieSortColumns = []string{"id", "title", "year", "runtime"}
+
+// MovieSortSafeList() returns the sort values accepted by the movie lists which can be sorted by the fields: each
+// of the fields, in ascending order, followed by each of them in descending order (with a "-" prefix).
+func MovieSortSafeList(fields []string) []string {
+	safeList := make([]string, 0, 2*len(fields))
+
+	safeList = append(safeList, fields...)
+	for _, field := range fields {
+		safeList = append(safeList, "-"+field)
+	}
+
+	return safeList
+}
+
+// normalizeGenres() trims the surrounding whitespace of each genre, so that " comedy" and "comedy" are the same
+// genre when the genres are checked for duplicates and when they are stored. A nil slice is returned as nil.
+func normalizeGenres(genres []string) []string {
+	if genres == nil {
+		return nil
+	}
+
+	normalized := make([]string, len(genres))
+	for i, genre := range genres {
+		normalized[i] = strings.TrimSpace(genre)
+	}
+
+	return normalized
+}
+
+// normalizeTags() trims the surrounding whitespace of each tag, like normalizeGenres(). Tags are otherwise kept
+// as they were written. A nil slice is returned as nil.
+func normalizeTags(tags []string) []string {
+	return normalizeGenres(tags)
+}
+
+// tagsArg() returns the tags of the movie as a query argument. The tags column is NOT NULL, so a movie without
+// tags is stored with an empty array rather than a NULL one.
+func (m *Movie) tagsArg() interface{} {
+	if m.Tags == nil {
+		return pq.Array([]string{})
+	}
+	return pq.Array(m.Tags)
+}
+
+// ValidateMovie() normalizes the genres and tags of the movie in place (see normalizeGenres()) before checking it,
+// so that every caller checks and stores them the same way. The number of genres is checked against the limits.
+func ValidateMovie(v *validator.Validator, movie *Movie, limits GenreLimits) {
+	movie.Genres = normalizeGenres(movie.Genres)
+	movie.Tags = normalizeTags(movie.Tags)
+
 	v.Check(movie.Title != "", "title", "must be provided")
-	v.Check(len(movie.Title) < 500, "title", "must not be more than 500 bytes long")
+	v.Checkf(len(movie.Title) < MaxTitleBytes, "title", "must not be more than %d bytes long", MaxTitleBytes)
 
 	v.Check(movie.Year != 0, "year", "must be provided")
-	v.Check(movie.Year >= 1888, "year", "must be greater than 1888")
+	v.Checkf(movie.Year >= 1888, "year", "must be greater than %d", 1888)
 	v.Check(movie.Year < int32(time.Now().Year()), "year", "must not be in the future")
 
 	v.Check(movie.Runtime != 0, "runtime", "must be provided")
 	v.Check(movie.Runtime > 0, "runtime", "must be a positive integer")
 
 	v.Check(movie.Genres != nil, "genres", "must be provided")
-	v.Check(len(movie.Genres) >= 1, "genres", "must contain at least 1 genre")
-	v.Check(len(movie.Genres) <= 5, "genres", "must not contain more than 5 genres")
+	minGenres, maxGenres := limits.bounds()
+	if minGenres == 1 {
+		v.Check(len(movie.Genres) >= 1, "genres", "must contain at least 1 genre")
+	} else {
+		v.Checkf(len(movie.Genres) >= minGenres, "genres", "must contain at least %d genres", minGenres)
+	}
+	v.Checkf(len(movie.Genres) <= maxGenres, "genres", "must not contain more than %d genres", maxGenres)
 	v.Check(validator.Unique(movie.Genres), "genres", "must not contain duplicate values")
+
+	for _, genre := range movie.Genres {
+		v.Check(genre != "", "genres", "must not contain empty values")
+		v.Checkf(len(genre) <= MaxGenreBytes, "genres", "must not contain a genre more than %d bytes long", MaxGenreBytes)
+	}
+
+	// Tags are optional.
+	v.Checkf(len(movie.Tags) <= MaxTags, "tags", "must not contain more than %d tags", MaxTags)
+	v.Check(validator.Unique(movie.Tags), "tags", "must not contain duplicate values")
+
+	for _, tag := range movie.Tags {
+		v.Check(tag != "", "tags", "must not contain empty values")
+		v.Checkf(len(tag) <= MaxTagBytes, "tags", "must not contain a tag more than %d bytes long", MaxTagBytes)
+	}
+
+	// The poster is optional. Posters served by the application have a path on the API ("/v1/posters/..."), and
+	// the others an http or https URL (see -poster-url-prefix). Anything else, e.g. a javascript: URL or a
+	// protocol-relative "//host" URL, would be unsafe for clients to load.
+	if movie.Poster != "" {
+		v.Check(validator.ValidURL(movie.Poster) || validPosterPath(movie.Poster), "poster", "must be a valid http or https URL")
+	}
+}
+
+// validPosterPath() reports whether the poster is a path on the API, rather than a URL.
+func validPosterPath(poster string) bool {
+	return strings.HasPrefix(poster, "/") && !strings.HasPrefix(poster, "//")
 }
 
 // Define a MovieModel struct type which wraps a sql.DB connection pool.
 type MovieModel struct {
-	DB *sql.DB
+	DB                   *sql.DB
+	ReadDB               *sql.DB       // Pool for read-only queries, e.g. a replica, defaults to DB if not set
+	Timeout              time.Duration // Timeout for each query, defaults to 3 seconds if not set
+	SerializationRetries int           // Retries of an update which fails with a serialization failure, 0 disables them
+	UniqueTitleYear      bool          // Return ErrDuplicateMovie for a duplicate title and year on insert and update
+	Cache                *MovieCache   // Cache of the movies read by Get(), nil to disable
+	QueryLog             QueryLogFunc  // Called after every query to log it, nil to disable
+}
+
+// QualityFilters select the movies with incomplete data, for editors auditing the catalogue.
+// The zero value doesn't filter anything.
+type QualityFilters struct {
+	HasPoster *bool // Whether the movie has a poster. Nil to not filter on it.
+	MinGenres int   // Only return the movies with fewer genres than this. 0 to not filter on it.
+}
+
+// ValidateQualityFilters() checks the values of the data-quality filters.
+func ValidateQualityFilters(v *validator.Validator, q QualityFilters) {
+	v.Check(q.MinGenres >= 0, "min_genres", "must not be negative")
+}
+
+// args() returns the query arguments of the data-quality predicates, which follow the title and genres arguments:
+//
+//	AND ($n::boolean IS NULL OR (poster <> '') = $n)
+//	AND (cardinality(genres) < $m OR $m = 0)
+func (q QualityFilters) args() []interface{} {
+	hasPoster := sql.NullBool{}
+	if q.HasPoster != nil {
+		hasPoster = sql.NullBool{Bool: *q.HasPoster, Valid: true}
+	}
+
+	return []interface{}{hasPoster, q.MinGenres}
 }
 
 // List() gets a list of movies from the movies table.
-func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+// Like the genres, the tags filter only returns the movies which have all of the given tags.
+func (m MovieModel) GetAll(title string, genres, tags []string, quality QualityFilters, filters Filters) ([]*Movie, Metadata, error) {
+	defer observeQuery(m.QueryLog, "movies.get_all", time.Now())
+
 	// Construct the SQL query to retrieve all the movie records (supports basic full-text search).
 	// to_tsvector('english', title) applies the 'english' configuration to break title into normalized lexemes.
 	// The configuration includes a list of dictionaries. Tokens are normalised and stemmed. Stop words are removed.
@@ -54,23 +250,35 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 	// Also normalise and stem tokens. Stop words are removed.
 	// The result is a tsquery. The single tokens are separated by the & operator.
 	// The @@ operator is the matches operator. Used to check whether the query term matches the lexemes.
+	// The sort column is interpolated into the query, so it must come from the safelist.
+	sortColumn, err := filters.sortColumn()
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
 	query := fmt.Sprintf(`
-		SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version
+		SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, tags, version, poster
 		FROM movies
 		WHERE (to_tsvector('english', title) @@ plainto_tsquery('english', $1) OR $1 = '')
 		AND (genres @> $2 OR cardinality($2) = 0)
+		AND ($5::boolean IS NULL OR (poster <> '') = $5)
+		AND (cardinality(genres) < $6 OR $6 = 0)
+		AND (tags @> $7 OR cardinality($7) = 0)
+		AND deleted_at IS NULL
 		ORDER BY %s %s, id ASC
-		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection()) // Interpolate sort column and direction
+		LIMIT $3 OFFSET $4`, sortColumn, filters.sortDirection()) // Interpolate sort column and direction
 
-	// Create a context with a 3-second timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Create a context with the query timeout
+	ctx, cancel := queryContext(m.Timeout)
 	defer cancel()
 
 	args := []interface{}{title, pq.Array(genres), filters.limit(), filters.offset()}
+	args = append(args, quality.args()...)
+	args = append(args, pq.Array(tags))
 
 	// Use QueryContext() to execute the query
 	// This returns a sql.Rows resultset containing the results
-	rows, err := m.DB.QueryContext(ctx, query, args...)
+	rows, err := readPool(m.DB, m.ReadDB).QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, Metadata{}, err
 	}
@@ -99,7 +307,9 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 			&movie.Year,
 			&movie.Runtime,
 			pq.Array(&movie.Genres),
+			pq.Array(&movie.Tags),
 			&movie.Version,
+			&movie.Poster,
 		)
 
 		if err != nil {
@@ -123,162 +333,1102 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 	return movies, metadata, nil
 }
 
-// Insert() inserts a new record in the movies table.
-func (m MovieModel) Insert(movie *Movie) error {
-	// The SQL query for inserting a new record in the movies table and returning
-	// the system-generated data
-	query := `
-		INSERT INTO movies (title, year, runtime, genres)
-		VALUES ($1, $2, $3, $4)
-		RETURNING id, created_at, version`
+// GetAllForUser() gets a page of the movies created by a specific user, sorted and paginated with the filters.
+func (m MovieModel) GetAllForUser(userID int64, filters Filters) ([]*Movie, Metadata, error) {
+	defer observeQuery(m.QueryLog, "movies.get_all_for_user", time.Now())
 
-	// Create an args slice containing the values for the placeholder parameters.
-	// Declaring this slice immediately next to our SQL query helps to make it clear *what values are being 
-	// used where* in the query.
-	args := []interface{}{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
+	// The sort column is interpolated into the query, so it must come from the safelist.
+	sortColumn, err := filters.sortColumn()
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, tags, version, poster
+		FROM movies
+		WHERE created_by = $1 AND deleted_at IS NULL
+		ORDER BY %s %s, id ASC
+		LIMIT $2 OFFSET $3`, sortColumn, filters.sortDirection())
 
-	// Create a context with a 3-second timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(m.Timeout)
 	defer cancel()
 
-	// Use Queryow() method to execute the SQL query passing in the args slice as variadic parameter.
-	// Then, scan the system generated id, created_at and version values into the movie struct.
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
-}
+	rows, err := readPool(m.DB, m.ReadDB).QueryContext(ctx, query, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
 
-// Get() fetches a specific record from the movies table.
-func (m MovieModel) Get(id int64) (*Movie, error) {
-	// The bigserial type of primary key id is always positive (starts from 1)
-	// Do an early check on negative integers to avoid unnecessary database calls
-	if id < 1 {
-		return nil, ErrRecordNotFound
+	totalRecords := 0
+	movies := []*Movie{}
+
+	for rows.Next() {
+		movie := new(Movie)
+
+		err := rows.Scan(
+			&totalRecords,
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			pq.Array(&movie.Tags),
+			&movie.Version,
+			&movie.Poster,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		movie.CreatedBy = userID
+		movies = append(movies, movie)
 	}
 
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return movies, metadata, nil
+}
+
+// Count() returns the number of movies matching the title, genres, tags and data-quality filters, like the
+// total_records of GetAll().
+func (m MovieModel) Count(title string, genres, tags []string, quality QualityFilters) (int, error) {
+	defer observeQuery(m.QueryLog, "movies.count", time.Now())
 
-	// Declare the SQL query for retrieving a movie from the database
 	query := `
-		SELECT id, created_at, title, year, runtime, genres, version
+		SELECT count(*)
 		FROM movies
-		WHERE id = $1
-	`
+		WHERE (to_tsvector('english', title) @@ plainto_tsquery('english', $1) OR $1 = '')
+		AND (genres @> $2 OR cardinality($2) = 0)
+		AND ($3::boolean IS NULL OR (poster <> '') = $3)
+		AND (cardinality(genres) < $4 OR $4 = 0)
+		AND (tags @> $5 OR cardinality($5) = 0)
+		AND deleted_at IS NULL`
 
-	// Declare a pointer to the Movie struct to hold the data returned by the query
-	movie := new(Movie)
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
 
-	// Use context.WithTimeout() function to create a context.Context which carries a 
-	// 3-second timeout deadline. We use the empty context.Background() as the 'parent' context.
-	// The countdown begins from the moment the context is created. Any time spent executing code between
-	// creating the context and calling QueryRowContext() will count towards the timeout.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	var count int
 
-	// Make sure we cancel the context before the Get() method returns.
-	// Calling the CancelFunc cancels ctx and its children, removes the parent's reference to ctx, and stops any associated timers.
-	// This is important to prevent memory leak of childrent contexts.
-	// Without it, the resources won't be released until either the timeout is hit or the parent context (Background) is canceled.
-	defer cancel()
+	args := append([]interface{}{title, pq.Array(genres)}, quality.args()...)
+	args = append(args, pq.Array(tags))
 
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(
-		&[]byte{},
-		&movie.ID,
-		&movie.CreatedAt,
-		&movie.Title,
-		&movie.Year,
-		&movie.Runtime,
-		pq.Array(&movie.Genres), // Use pq.Array adapter to handler text[] array
-		&movie.Version,
-	)
+	err := readPool(m.DB, m.ReadDB).QueryRowContext(ctx, query, args...).Scan(&count)
+	return count, err
+}
 
+// streamBatchSize is the number of rows fetched from the cursor at a time by Stream().
+const streamBatchSize = 100
+
+// Stream() calls fn for every movie matching the title, genres, tags and data-quality filters, in the sort order of
+// the filters.
+// Unlike GetAll(), the results are not paginated. They are read through a server-side cursor in batches of
+// streamBatchSize rows, so neither Postgres nor the application holds the whole result set in memory, and each
+// batch is fetched with the usual query timeout. After each batch, flush is called (if not nil) so that the caller
+// can pass the movies on incrementally. Streaming stops at the first error returned by fn.
+func (m MovieModel) Stream(title string, genres, tags []string, quality QualityFilters, filters Filters, fn func(*Movie) error, flush func()) error {
+	// The sort column is interpolated into the query, so it must come from the safelist.
+	sortColumn, err := filters.sortColumn()
 	if err != nil {
-		switch {
-		// If no matching movie found, Scan() returns a sql.ErrNoRows error
-		case errors.Is(err, sql.ErrNoRows):
-				return nil, ErrRecordNotFound
-		default:
-			return nil, err
-		}
+		return err
 	}
 
-	return movie, nil
-}
+	// A cursor only exists inside a transaction. The transaction is read-only, so it is always rolled back.
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-// Update() updates a specific record in the movies table.
-func (m MovieModel) Update(movie *Movie) error {
+	query := fmt.Sprintf(`
+		DECLARE movies_cursor NO SCROLL CURSOR FOR
+		SELECT id, created_at, updated_at, title, year, runtime, genres, tags, version, poster
+		FROM movies
+		WHERE (to_tsvector('english', title) @@ plainto_tsquery('english', $1) OR $1 = '')
+		AND (genres @> $2 OR cardinality($2) = 0)
+		AND ($3::boolean IS NULL OR (poster <> '') = $3)
+		AND (cardinality(genres) < $4 OR $4 = 0)
+		AND (tags @> $5 OR cardinality($5) = 0)
+		AND deleted_at IS NULL
+		ORDER BY %s %s, id ASC`, sortColumn, filters.sortDirection())
 
-	// Declare the SQL query for updating the record and returning the new version number
-	// Filter by version to implement optimistic concurrency control
-	query := `
-		UPDATE movies
-		SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1
-		WHERE id = $5 AND version = $6
-		RETURNING version
-	`
+	start := time.Now()
+	ctx, cancel := queryContext(m.Timeout)
+	args := append([]interface{}{title, pq.Array(genres)}, quality.args()...)
+	args = append(args, pq.Array(tags))
+	_, err = tx.ExecContext(ctx, query, args...)
+	cancel()
+	observeQuery(m.QueryLog, "movies.stream", start)
+	if err != nil {
+		return err
+	}
 
-	// Create an args slice containing the value for the placeholder parameters
-	args := []interface{}{
-		movie.Title,
-		movie.Year,
-		movie.Runtime,
-		pq.Array(movie.Genres),
-		movie.ID,
-		movie.Version,
+	for {
+		n, err := m.fetch(tx, fn)
+		if err != nil {
+			return err
+		}
+
+		if flush != nil {
+			flush()
+		}
+
+		// A short batch means that the cursor is exhausted.
+		if n < streamBatchSize {
+			return nil
+		}
 	}
+}
 
-	// Create a context with a 3-second timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// fetch() reads the next batch of movies from the cursor declared by Stream(), calls fn for each of them and
+// returns the number of movies read.
+func (m MovieModel) fetch(tx *sql.Tx, fn func(*Movie) error) (int, error) {
+	ctx, cancel := queryContext(m.Timeout)
 	defer cancel()
 
-	// Use QueryRowContext() to execute the query, passing in the args slice as a variadic parameter
-	// Scan the new version value into the movie struct
-	// If an error is returned, we check if it is ErrNoRows. If it is, this means that the movie version
-	// has been changed (or the record is already deleted)
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+	// Only the FETCH itself is timed, not the calls to fn, which write to the client.
+	start := time.Now()
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("FETCH %d FROM movies_cursor", streamBatchSize))
+	observeQuery(m.QueryLog, "movies.fetch", start)
 	if err != nil {
-		switch {
-		case errors.Is(err, sql.ErrNoRows):
-			return ErrEditConflict
-		default:
-			return err
-		}	
+		return 0, err
 	}
+	defer rows.Close()
 
-	return nil
+	n := 0
+
+	for rows.Next() {
+		movie := new(Movie)
+
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.UpdatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			pq.Array(&movie.Tags),
+			&movie.Version,
+			&movie.Poster,
+		)
+		if err != nil {
+			return n, err
+		}
+
+		n++
+
+		err = fn(movie)
+		if err != nil {
+			return n, err
+		}
+	}
+
+	return n, rows.Err()
 }
 
-// Delete() deletes a specific record from the movies table.
-func (m MovieModel) Delete(id int64) error {
-	// Return an ErrRecordNotFound error if the movie ID is less than 1
-	if id < 1 {
-		return ErrRecordNotFound
+// Insert() inserts a new record in the movies table. If m.UniqueTitleYear is set, it is InsertUnique().
+func (m MovieModel) Insert(movie *Movie) error {
+	if m.UniqueTitleYear {
+		return m.InsertUnique(movie)
 	}
+	return m.insert(m.DB, movie)
+}
 
-	// Declare the SQL query to delete the record
-	query := `
-		DELETE FROM movies
-		WHERE id = $1
-	`
+// InsertTx() inserts a new record in the movies table as part of the provided transaction.
+func (m MovieModel) InsertTx(tx *sql.Tx, movie *Movie) error {
+	if m.UniqueTitleYear {
+		return m.insertUnique(tx, movie)
+	}
+	return m.insert(tx, movie)
+}
 
-	// Create a context with a 3-second timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+// InsertUnique() inserts a new record in the movies table, unless a movie with the same title and year already
+// exists, in which case it returns ErrDuplicateMovie. Unlike Insert(), it checks for a duplicate whether or not
+// m.UniqueTitleYear is set.
+func (m MovieModel) InsertUnique(movie *Movie) error {
+	return m.withTx(func(tx *sql.Tx) error {
+		return m.insertUnique(tx, movie)
+	})
+}
 
-	// Execute the query
-	// This returns a sql.Request object and an error (if any)
-	result, err := m.DB.ExecContext(ctx, query, id)
+func (m MovieModel) insertUnique(tx *sql.Tx, movie *Movie) error {
+	existing, err := m.lockDuplicate(tx, movie)
 	if err != nil {
 		return err
 	}
+	if existing != nil {
+		return ErrDuplicateMovie
+	}
 
-	// Get the numbr of rows affected by the query
-	rowsAffected, err := result.RowsAffected()
+	return m.insert(tx, movie)
+}
+
+// withTx() runs fn in a transaction on the primary pool, which is committed if fn returns nil and rolled back
+// otherwise.
+func (m MovieModel) withTx(fn func(tx *sql.Tx) error) error {
+	tx, err := m.DB.Begin()
 	if err != nil {
-		return nil
+		return err
 	}
 
-	// If no rows affected, we know the movie does not exist
-	// In that case, an ErrRecordNotFound is returned
-	if rowsAffected == 0 {
-		return ErrRecordNotFound
+	// Rollback() is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	err = fn(tx)
+	if err != nil {
+		return err
 	}
 
-	return nil
-}
\ No newline at end of file
+	return tx.Commit()
+}
+
+// lockDuplicate() returns the movie which isn't deleted and has the same title and year as the given movie (but
+// not its ID), or nil if there is none.
+// There is no UNIQUE index on the title and year, so the check is made safe against concurrent inserts and
+// updates by first taking a transaction-level advisory lock on the title and year: a second transaction checking
+// for the same movie waits until the first one has committed, and then sees its row. The lock is released when the
+// transaction ends.
+func (m MovieModel) lockDuplicate(tx *sql.Tx, movie *Movie) (*Movie, error) {
+	defer observeQuery(m.QueryLog, "movies.lock_duplicate", time.Now())
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	_, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1), $2)`, movie.Title, movie.Year)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT id, created_at, updated_at, title, year, runtime, genres, tags, version, poster, created_by
+		FROM movies
+		WHERE title = $1 AND year = $2 AND id <> $3 AND deleted_at IS NULL
+		ORDER BY id
+		LIMIT 1`
+
+	var existing Movie
+	var createdBy sql.NullInt64
+
+	err = tx.QueryRowContext(ctx, query, movie.Title, movie.Year, movie.ID).Scan(
+		&existing.ID,
+		&existing.CreatedAt,
+		&existing.UpdatedAt,
+		&existing.Title,
+		&existing.Year,
+		&existing.Runtime,
+		pq.Array(&existing.Genres),
+		pq.Array(&existing.Tags),
+		&existing.Version,
+		&existing.Poster,
+		&createdBy,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	existing.CreatedBy = createdBy.Int64
+
+	return &existing, nil
+}
+
+func (m MovieModel) insert(q queryer, movie *Movie) error {
+	var rows int64
+	defer observeQueryRows(m.QueryLog, "movies.insert", time.Now(), &rows)
+
+	// The SQL query for inserting a new record in the movies table and returning
+	// the system-generated data
+	query := `
+		INSERT INTO movies (title, year, runtime, genres, tags, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at, updated_at, version`
+
+	// Create an args slice containing the values for the placeholder parameters.
+	// Declaring this slice immediately next to our SQL query helps to make it clear *what values are being 
+	// used where* in the query.
+	// A movie without a creator (such as a seeded movie) is stored with a NULL created_by.
+	createdBy := sql.NullInt64{Int64: movie.CreatedBy, Valid: movie.CreatedBy > 0}
+	args := []interface{}{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres), movie.tagsArg(), createdBy}
+
+	// Create a context with the query timeout
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	// Use Queryow() method to execute the SQL query passing in the args slice as variadic parameter.
+	// Then, scan the system generated id, created_at and version values into the movie struct.
+	err := q.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.UpdatedAt, &movie.Version)
+	if err != nil {
+		return pgError(err)
+	}
+	rows = 1
+
+	return nil
+}
+
+// InsertOrGet() inserts a new record in the movies table, unless a movie with the same title and year already
+// exists, in which case the movie struct is filled with the existing record instead. It reports whether the
+// movie was created. This makes it safe for importers to re-run a batch without creating duplicates.
+func (m MovieModel) InsertOrGet(movie *Movie) (bool, error) {
+	var created bool
+
+	err := m.withTx(func(tx *sql.Tx) error {
+		var err error
+		created, err = m.insertOrGet(tx, movie)
+		return err
+	})
+
+	return created, err
+}
+
+// InsertOrGetTx() is InsertOrGet() as part of the provided transaction.
+func (m MovieModel) InsertOrGetTx(tx *sql.Tx, movie *Movie) (bool, error) {
+	return m.insertOrGet(tx, movie)
+}
+
+func (m MovieModel) insertOrGet(tx *sql.Tx, movie *Movie) (bool, error) {
+	existing, err := m.lockDuplicate(tx, movie)
+	if err != nil {
+		return false, err
+	}
+
+	if existing != nil {
+		*movie = *existing
+		return false, nil
+	}
+
+	err = m.insert(tx, movie)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Get() fetches a specific record from the movies table.
+func (m MovieModel) Get(id int64) (*Movie, error) {
+	defer observeQuery(m.QueryLog, "movies.get", time.Now())
+
+	// The bigserial type of primary key id is always positive (starts from 1)
+	// Do an early check on negative integers to avoid unnecessary database calls
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	// Serve the movie from the cache if it's there. Otherwise, it's cached once it has been read.
+	cached, gen, ok := m.Cache.get(id)
+	if ok {
+		return cached, nil
+	}
+
+	movie, err := m.get(readPool(m.DB, m.ReadDB), id)
+	if err != nil {
+		return nil, err
+	}
+
+	m.Cache.set(movie, gen)
+
+	return movie, nil
+}
+
+// GetForUpdate() fetches a specific record from the movies table like Get(), but always from the primary pool and
+// never from the cache. Use it to read a movie which is about to be changed: a replica (or the cache) can lag
+// behind the primary, and a stale version would only lead to an edit conflict, or to a wrong "before" state in
+// the audit log.
+func (m MovieModel) GetForUpdate(id int64) (*Movie, error) {
+	defer observeQuery(m.QueryLog, "movies.get_for_update", time.Now())
+
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	return m.get(m.DB, id)
+}
+
+// get() fetches a specific record from the movies table, using the given pool.
+func (m MovieModel) get(db *sql.DB, id int64) (*Movie, error) {
+	// Declare the SQL query for retrieving a movie from the database
+	query := `
+		SELECT id, created_at, updated_at, title, year, runtime, genres, tags, version, poster
+		FROM movies
+		WHERE id = $1 AND deleted_at IS NULL
+	`
+
+	// Declare a pointer to the Movie struct to hold the data returned by the query
+	movie := new(Movie)
+
+	// Use the queryContext() helper to create a context.Context which carries the query
+	// timeout deadline (3 seconds by default). The empty context.Background() is used as the 'parent' context.
+	// The countdown begins from the moment the context is created. Any time spent executing code between
+	// creating the context and calling QueryRowContext() will count towards the timeout.
+	ctx, cancel := queryContext(m.Timeout)
+
+	// Make sure we cancel the context before the get() method returns.
+	// Calling the CancelFunc cancels ctx and its children, removes the parent's reference to ctx, and stops any associated timers.
+	// This is important to prevent memory leak of childrent contexts.
+	// Without it, the resources won't be released until either the timeout is hit or the parent context (Background) is canceled.
+	defer cancel()
+
+	err := db.QueryRowContext(ctx, query, id).Scan(
+		&movie.ID,
+		&movie.CreatedAt,
+		&movie.UpdatedAt,
+		&movie.Title,
+		&movie.Year,
+		&movie.Runtime,
+		pq.Array(&movie.Genres), // Use pq.Array adapter to handler text[] array
+		pq.Array(&movie.Tags),
+		&movie.Version,
+		&movie.Poster,
+	)
+
+	if err != nil {
+		switch {
+		// If no matching movie found, Scan() returns a sql.ErrNoRows error
+		case errors.Is(err, sql.ErrNoRows):
+				return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return movie, nil
+}
+
+// GetMany() fetches the records with the given IDs from the movies table, ordered by ID.
+// IDs which don't match a record are silently omitted from the result.
+func (m MovieModel) GetMany(ids []int64) ([]*Movie, error) {
+	defer observeQuery(m.QueryLog, "movies.get_many", time.Now())
+
+	// The = ANY() operator checks the id against every element of the array parameter.
+	query := `
+		SELECT id, created_at, updated_at, title, year, runtime, genres, tags, version, poster
+		FROM movies
+		WHERE id = ANY($1) AND deleted_at IS NULL
+		ORDER BY id ASC`
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	rows, err := readPool(m.DB, m.ReadDB).QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	movies := []*Movie{}
+
+	for rows.Next() {
+		movie := new(Movie)
+
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.UpdatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			pq.Array(&movie.Tags),
+			&movie.Version,
+			&movie.Poster,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		movies = append(movies, movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return movies, nil
+}
+
+// GetRandom() fetches a single randomly selected record from the movies table.
+// ORDER BY random() assigns a random value to every row and sorts on it, which is a full table scan.
+// That is fine for a catalogue of a few thousand movies. For much larger tables, TABLESAMPLE SYSTEM_ROWS
+// (from the tsm_system_rows extension) or an OFFSET of floor(random() * count) are cheaper alternatives.
+// If the table is empty, ErrRecordNotFound is returned.
+func (m MovieModel) GetRandom() (*Movie, error) {
+	defer observeQuery(m.QueryLog, "movies.get_random", time.Now())
+
+	query := `
+		SELECT id, created_at, title, year, runtime, genres, tags, version, poster
+		FROM movies
+		WHERE deleted_at IS NULL
+		ORDER BY random()
+		LIMIT 1`
+
+	movie := new(Movie)
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	err := readPool(m.DB, m.ReadDB).QueryRowContext(ctx, query).Scan(
+		&movie.ID,
+		&movie.CreatedAt,
+		&movie.Title,
+		&movie.Year,
+		&movie.Runtime,
+		pq.Array(&movie.Genres),
+		pq.Array(&movie.Tags),
+		&movie.Version,
+		&movie.Poster,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return movie, nil
+}
+
+// GetRelated() fetches up to limit other movies which share at least one genre with the given movie.
+// The movies sharing the most genres come first. The size of the genre intersection is computed by
+// unnesting both genre arrays and intersecting them, and ties are broken by ascending movie ID.
+// ErrRecordNotFound is returned if the source movie does not exist. If no movie shares a genre,
+// an empty slice is returned.
+func (m MovieModel) GetRelated(id int64, limit int) ([]*Movie, error) {
+	defer observeQuery(m.QueryLog, "movies.get_related", time.Now())
+
+	movie, err := m.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// The && operator checks whether the two arrays have any elements in common, which lets
+	// Postgres use the GIN index on genres to discard movies with no overlap.
+	query := `
+		SELECT id, created_at, title, year, runtime, genres, tags, version, poster
+		FROM movies
+		WHERE id <> $1 AND genres && $2 AND deleted_at IS NULL
+		ORDER BY cardinality(ARRAY(SELECT unnest(genres) INTERSECT SELECT unnest($2::text[]))) DESC, id ASC
+		LIMIT $3`
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	rows, err := readPool(m.DB, m.ReadDB).QueryContext(ctx, query, movie.ID, pq.Array(movie.Genres), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	movies := []*Movie{}
+
+	for rows.Next() {
+		movie := new(Movie)
+
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			pq.Array(&movie.Tags),
+			&movie.Version,
+			&movie.Poster,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		movies = append(movies, movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return movies, nil
+}
+
+// Define a MovieStats struct to hold the aggregated statistics of the movie catalogue.
+type MovieStats struct {
+	TotalMovies    int          `json:"total_movies"`
+	AverageRuntime float64      `json:"average_runtime"` // Average runtime in minutes, rounded to 2 decimal places
+	ByYear         []YearCount  `json:"by_year"`
+	ByGenre        []GenreCount `json:"by_genre"`
+}
+
+// YearCount holds the number of movies released in a year.
+type YearCount struct {
+	Year  int32 `json:"year"`
+	Count int   `json:"count"`
+}
+
+// GenreCount holds the number of movies in a genre.
+type GenreCount struct {
+	Genre string `json:"genre"`
+	Count int    `json:"count"`
+}
+
+// GetStats() computes the catalogue statistics with SQL aggregations in a single round trip.
+// The grouped counts are built as JSON arrays by Postgres (json_agg), so that they can be returned in one
+// row alongside the totals. The genres are counted by expanding each genres array with unnest().
+func (m MovieModel) GetStats() (*MovieStats, error) {
+	defer observeQuery(m.QueryLog, "movies.get_stats", time.Now())
+
+	// Only the movies which haven't been deleted are counted.
+	query := `
+		WITH live AS (SELECT year, runtime, genres FROM movies WHERE deleted_at IS NULL)
+		SELECT
+			(SELECT count(*) FROM live),
+			(SELECT COALESCE(round(avg(runtime)::numeric, 2), 0) FROM live),
+			(SELECT COALESCE(json_agg(json_build_object('year', year, 'count', count) ORDER BY year), '[]')
+				FROM (SELECT year, count(*) FROM live GROUP BY year) AS years),
+			(SELECT COALESCE(json_agg(json_build_object('genre', genre, 'count', count) ORDER BY count DESC, genre), '[]')
+				FROM (SELECT unnest(genres) AS genre, count(*) FROM live GROUP BY genre) AS genres)`
+
+	var stats MovieStats
+	var byYear, byGenre []byte
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	err := readPool(m.DB, m.ReadDB).QueryRowContext(ctx, query).Scan(&stats.TotalMovies, &stats.AverageRuntime, &byYear, &byGenre)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(byYear, &stats.ByYear)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(byGenre, &stats.ByGenre)
+	if err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
+
+// Update() updates a specific record in the movies table.
+func (m MovieModel) Update(movie *Movie) error {
+	var rows int64
+	defer observeQueryRows(m.QueryLog, "movies.update", time.Now(), &rows)
+
+	// Invalidate the cached movie whether or not the update succeeds: an edit conflict means that it's stale.
+	defer m.Cache.Invalidate(movie.ID)
+
+
+	// Declare the SQL query for updating the record and returning the new version number and update time
+	// Filter by version to implement optimistic concurrency control
+	query := `
+		UPDATE movies
+		SET title = $1, year = $2, runtime = $3, genres = $4, tags = $5, version = version + 1, updated_at = NOW()
+		WHERE id = $6 AND version = $7 AND deleted_at IS NULL
+		RETURNING version, updated_at
+	`
+
+	// Create an args slice containing the value for the placeholder parameters
+	args := []interface{}{
+		movie.Title,
+		movie.Year,
+		movie.Runtime,
+		pq.Array(movie.Genres),
+		movie.tagsArg(),
+		movie.ID,
+		movie.Version,
+	}
+
+	// Use QueryRowContext() to execute the query, passing in the args slice as a variadic parameter
+	// Scan the new version and updated_at values into the movie struct
+	// A serialization failure is retried, each attempt with its own query timeout.
+	// If an error is returned, we check if it is ErrNoRows. If it is, this means that the movie version
+	// has been changed (or the record is already deleted)
+	// If m.UniqueTitleYear is set, changing the title or year to those of another movie returns ErrDuplicateMovie.
+	// The check and the update are made in one transaction, which is retried as a whole.
+	err := retrySerializable(m.SerializationRetries, func() error {
+		if !m.UniqueTitleYear {
+			ctx, cancel := queryContext(m.Timeout)
+			defer cancel()
+
+			return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version, &movie.UpdatedAt)
+		}
+
+		return m.withTx(func(tx *sql.Tx) error {
+			existing, err := m.lockDuplicate(tx, movie)
+			if err != nil {
+				return err
+			}
+			if existing != nil {
+				return ErrDuplicateMovie
+			}
+
+			ctx, cancel := queryContext(m.Timeout)
+			defer cancel()
+
+			return tx.QueryRowContext(ctx, query, args...).Scan(&movie.Version, &movie.UpdatedAt)
+		})
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return pgError(err)
+		}	
+	}
+	rows = 1
+
+	return nil
+}
+
+// UpdatePoster() records the poster URL of a movie. Like Update(), it increments the version number and
+// returns ErrEditConflict if the movie has been changed (or deleted) since it was read.
+func (m MovieModel) UpdatePoster(movie *Movie) error {
+	var rows int64
+	defer observeQueryRows(m.QueryLog, "movies.update_poster", time.Now(), &rows)
+	defer m.Cache.Invalidate(movie.ID)
+
+	query := `
+		UPDATE movies
+		SET poster = $1, version = version + 1, updated_at = NOW()
+		WHERE id = $2 AND version = $3 AND deleted_at IS NULL
+		RETURNING version, updated_at`
+
+	args := []interface{}{movie.Poster, movie.ID, movie.Version}
+
+	err := retrySerializable(m.SerializationRetries, func() error {
+		ctx, cancel := queryContext(m.Timeout)
+		defer cancel()
+
+		return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version, &movie.UpdatedAt)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	rows = 1
+
+	return nil
+}
+
+// Touch() increments the version (and updates updated_at) of a movie without changing any of its fields, and
+// returns the new version. Caches which key on the version can then be made to fetch the movie again, e.g. after
+// it was fixed directly in the database. Like Update(), it only succeeds if the movie still has the given version,
+// and returns ErrEditConflict otherwise, or ErrRecordNotFound if the movie doesn't exist.
+func (m MovieModel) Touch(id int64, version int32) (int32, error) {
+	var rows int64
+	defer observeQueryRows(m.QueryLog, "movies.touch", time.Now(), &rows)
+	defer m.Cache.Invalidate(id)
+
+	if id < 1 {
+		return 0, ErrRecordNotFound
+	}
+
+	query := `
+		UPDATE movies
+		SET version = version + 1, updated_at = NOW()
+		WHERE id = $1 AND version = $2 AND deleted_at IS NULL
+		RETURNING version`
+
+	var newVersion int32
+
+	err := retrySerializable(m.SerializationRetries, func() error {
+		ctx, cancel := queryContext(m.Timeout)
+		defer cancel()
+
+		return m.DB.QueryRowContext(ctx, query, id, version).Scan(&newVersion)
+	})
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return 0, err
+		}
+
+		// No rows means that either the movie doesn't exist or its version has changed.
+		ctx, cancel := queryContext(m.Timeout)
+		defer cancel()
+
+		var exists bool
+
+		err = m.DB.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM movies WHERE id = $1 AND deleted_at IS NULL)`, id).Scan(&exists)
+		if err != nil {
+			return 0, err
+		}
+
+		if !exists {
+			return 0, ErrRecordNotFound
+		}
+
+		return 0, ErrEditConflict
+	}
+	rows = 1
+
+	return newVersion, nil
+}
+
+// Delete() soft deletes a specific record from the movies table: the row is kept, with its deleted_at set,
+// and is hidden from every other method. Purge() removes it for good once the retention period has passed.
+func (m MovieModel) Delete(id int64, version int32) error {
+	var rows int64
+	defer observeQueryRows(m.QueryLog, "movies.delete", time.Now(), &rows)
+	defer m.Cache.Invalidate(id)
+
+	// Return an ErrRecordNotFound error if the movie ID is less than 1
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	// Declare the SQL query to delete the record
+	// When an expected version is given (greater than 0), the movie is only deleted if its version still
+	// matches, so that a delete can't race with a concurrent update.
+	query := `
+		UPDATE movies
+		SET deleted_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND ($2 = 0 OR version = $2) AND deleted_at IS NULL
+	`
+
+	// Create a context with the query timeout
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	// Execute the query
+	// This returns a sql.Request object and an error (if any)
+	result, err := m.DB.ExecContext(ctx, query, id, version)
+	if err != nil {
+		return err
+	}
+
+	// Get the numbr of rows affected by the query
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	rows = rowsAffected
+
+	// If no rows affected, either the movie does not exist or (with an expected version) the version has changed.
+	// An unconditional delete can only mean the former, so an ErrRecordNotFound is returned.
+	if rowsAffected == 0 {
+		if version == 0 {
+			return ErrRecordNotFound
+		}
+
+		var exists bool
+
+		err = m.DB.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM movies WHERE id = $1 AND deleted_at IS NULL)`, id).Scan(&exists)
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			return ErrRecordNotFound
+		}
+
+		return ErrEditConflict
+	}
+
+	return nil
+}
+
+// DeleteMany() soft deletes the movies with the given IDs and returns the IDs of the movies which were deleted.
+// IDs which don't match a movie (or match one which is already deleted) are ignored. The movies are deleted by a
+// single statement, so either all of them are deleted or (if there is an error) none of them.
+func (m MovieModel) DeleteMany(ids []int64) ([]int64, error) {
+	var n int64
+	defer observeQueryRows(m.QueryLog, "movies.delete_many", time.Now(), &n)
+	defer m.Cache.Invalidate(ids...)
+
+	query := `
+		UPDATE movies
+		SET deleted_at = NOW(), updated_at = NOW()
+		WHERE id = ANY($1) AND deleted_at IS NULL
+		RETURNING id`
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deleted := []int64{}
+
+	for rows.Next() {
+		var id int64
+
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+
+		deleted = append(deleted, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	n = int64(len(deleted))
+
+	return deleted, nil
+}
+
+// Purge() permanently deletes the movies which were soft deleted more than the retention period ago, along
+// with their reviews, and returns them. Only the ID and poster of each movie are set, so that the caller can
+// clean up the poster files.
+func (m MovieModel) Purge(retention time.Duration) ([]*Movie, error) {
+	var n int64
+	defer observeQueryRows(m.QueryLog, "movies.purge", time.Now(), &n)
+
+	query := `
+		DELETE FROM movies
+		WHERE deleted_at < NOW() - make_interval(secs => $1)
+		RETURNING id, poster`
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, retention.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	movies := []*Movie{}
+
+	for rows.Next() {
+		movie := new(Movie)
+
+		err := rows.Scan(&movie.ID, &movie.Poster)
+		if err != nil {
+			return nil, err
+		}
+
+		movies = append(movies, movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	n = int64(len(movies))
+
+	return movies, nil
+}
+
+// SyncCursor is the position in the results of GetUpdatedSince() after which the next page starts: the updated_at
+// time and the ID of the last movie of the previous page.
+type SyncCursor struct {
+	UpdatedAt time.Time
+	ID        int64
+}
+
+// ErrInvalidSyncCursor is returned by DecodeSyncCursor() for a cursor which wasn't returned by EncodeSyncCursor().
+var ErrInvalidSyncCursor = errors.New("invalid sync cursor")
+
+// EncodeSyncCursor() returns the opaque string sent to clients for a cursor. The movie ID is encoded as a public ID
+// (see MovieIDs), so that the cursor doesn't reveal the primary key.
+func EncodeSyncCursor(c SyncCursor) string {
+	raw := fmt.Sprintf("%s|%v", c.UpdatedAt.UTC().Format(time.RFC3339Nano), MovieIDs.Encode(c.ID))
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeSyncCursor() parses a cursor returned by EncodeSyncCursor().
+func DecodeSyncCursor(s string) (SyncCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return SyncCursor{}, ErrInvalidSyncCursor
+	}
+
+	parts := strings.SplitN(string(raw), "|", 2)
+	if len(parts) != 2 {
+		return SyncCursor{}, ErrInvalidSyncCursor
+	}
+
+	updatedAt, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return SyncCursor{}, ErrInvalidSyncCursor
+	}
+
+	id, err := MovieIDs.Decode(parts[1])
+	if err != nil {
+		return SyncCursor{}, ErrInvalidSyncCursor
+	}
+
+	return SyncCursor{UpdatedAt: updatedAt, ID: id}, nil
+}
+
+// GetUpdatedSince() returns up to limit movies which were created, updated or deleted after since, ordered by
+// updated_at and then ID, starting after the cursor if one is given. Deleted movies are included, with Deleted set,
+// so that clients can remove them (until they are purged), and are encoded as tombstones by MarshalJSON(). The
+// query goes to the primary rather than the replica, so that replication lag can't make a client skip changes.
+func (m MovieModel) GetUpdatedSince(since time.Time, after *SyncCursor, limit int) ([]*Movie, error) {
+	defer observeQuery(m.QueryLog, "movies.get_updated_since", time.Now())
+
+	query := `
+		SELECT id, created_at, updated_at, title, year, runtime, genres, tags, version, poster, deleted_at IS NOT NULL
+		FROM movies
+		WHERE updated_at > $1
+		AND ($2::timestamptz IS NULL OR (updated_at, id) > ($2, $3))
+		ORDER BY updated_at ASC, id ASC
+		LIMIT $4`
+
+	var afterTime sql.NullTime
+	var afterID int64
+	if after != nil {
+		afterTime = sql.NullTime{Time: after.UpdatedAt, Valid: true}
+		afterID = after.ID
+	}
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, since, afterTime, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	movies := []*Movie{}
+
+	for rows.Next() {
+		movie := new(Movie)
+
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.UpdatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			pq.Array(&movie.Tags),
+			&movie.Version,
+			&movie.Poster,
+			&movie.Deleted,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		movies = append(movies, movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return movies, nil
+}
@@ -0,0 +1,985 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jseow5177/greenlight/internal/migrate"
+	"github.com/jseow5177/greenlight/internal/testdb"
+	"github.com/jseow5177/greenlight/internal/validator"
+	"github.com/jseow5177/greenlight/migrations"
+)
+
+// newTestMovie() returns a valid movie which hasn't been inserted yet.
+func newTestMovie(title string, year int32) *Movie {
+	return &Movie{Title: title, Year: year, Runtime: 107, Genres: []string{"animation"}}
+}
+
+// insertTestMovies() inserts movies with the given genres, one for each list, and returns them.
+func TestMovieModelDuplicates(t *testing.T) {
+	m := NewModels(testdb.Open(t), 0)
+
+	original := newTestMovie("Moana", 2016)
+	err := m.Movies.Insert(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Duplicates are allowed by default.
+	err = m.Movies.Insert(newTestMovie("Moana", 2016))
+	if err != nil {
+		t.Fatalf("Insert() of a duplicate: got error %v", err)
+	}
+
+	err = m.Movies.InsertUnique(newTestMovie("Moana", 2016))
+	if !errors.Is(err, ErrDuplicateMovie) {
+		t.Errorf("InsertUnique() of a duplicate: got error %v; want ErrDuplicateMovie", err)
+	}
+
+	again := newTestMovie("Moana", 2016)
+	created, err := m.Movies.InsertOrGet(again)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created || again.ID != original.ID {
+		t.Errorf("InsertOrGet() of a duplicate: got created %t, ID %d; want false, %d", created, again.ID, original.ID)
+	}
+
+	created, err = m.Movies.InsertOrGet(newTestMovie("Moana", 2017))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created {
+		t.Error("InsertOrGet() of a new movie didn't create it")
+	}
+}
+
+func TestMovieModelUniqueTitleYear(t *testing.T) {
+	m := NewModels(testdb.Open(t), 0)
+
+	m.Movies.UniqueTitleYear = true
+
+	err := m.Movies.Insert(newTestMovie("Moana", 2016))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Movies.Insert(newTestMovie("Moana", 2016))
+	if !errors.Is(err, ErrDuplicateMovie) {
+		t.Errorf("Insert() of a duplicate: got error %v; want ErrDuplicateMovie", err)
+	}
+
+	other := newTestMovie("Moana", 2017)
+	err = m.Movies.Insert(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other.Year = 2016
+	err = m.Movies.Update(other)
+	if !errors.Is(err, ErrDuplicateMovie) {
+		t.Errorf("Update() to a duplicate: got error %v; want ErrDuplicateMovie", err)
+	}
+
+	// Updating a movie without changing its title and year isn't a duplicate of itself.
+	other.Year = 2017
+	other.Runtime = 110
+	err = m.Movies.Update(other)
+	if err != nil {
+		t.Errorf("Update() of the runtime: got error %v", err)
+	}
+}
+
+func TestTitleYearMigrationAllowsExistingDuplicates(t *testing.T) {
+	db := testdb.Open(t)
+	m := NewModels(db, 0)
+
+	migrator, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Roll back to the version before 000019, insert duplicates, and apply the migrations again.
+	version, _, err := migrator.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = migrator.Down(int(version - 18))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err = db.Exec(`INSERT INTO movies (title, year, runtime, genres) VALUES ('Moana', 2016, 107, '{animation}')`)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = migrator.Up()
+	if err != nil {
+		t.Fatalf("Up() with duplicate movies: got error %v", err)
+	}
+
+	err = m.Movies.Insert(newTestMovie("Moana", 2016))
+	if err != nil {
+		t.Errorf("Insert() of a third duplicate: got error %v", err)
+	}
+}
+
+func insertTestMovies(t *testing.T, m Models, genres ...[]string) []*Movie {
+	t.Helper()
+
+	movies := make([]*Movie, len(genres))
+	for i, g := range genres {
+		movies[i] = newTestMovie(fmt.Sprintf("Movie %d", i), 2000+int32(i))
+		movies[i].Genres = g
+
+		err := m.Movies.Insert(movies[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return movies
+}
+
+func TestMovieModelGetRelated(t *testing.T) {
+	m := NewModels(testdb.Open(t), 0)
+
+	movies := insertTestMovies(t, m,
+		[]string{"action", "comedy", "drama"}, // The source movie
+		[]string{"action"},                    // 1 shared genre
+		[]string{"horror"},                    // No shared genre
+		[]string{"action", "comedy", "drama"}, // 3 shared genres
+		[]string{"comedy", "drama"},           // 2 shared genres
+		[]string{"drama", "western"},          // 1 shared genre, after the other one by ID
+	)
+
+	related, err := m.Movies.GetRelated(movies[0].ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []int64
+	for _, movie := range related {
+		ids = append(ids, movie.ID)
+	}
+
+	want := []int64{movies[3].ID, movies[4].ID, movies[1].ID, movies[5].ID}
+	if !reflect.DeepEqual(ids, want) {
+		t.Errorf("got %v; want %v", ids, want)
+	}
+
+	related, err = m.Movies.GetRelated(movies[0].ID, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(related) != 2 {
+		t.Errorf("with a limit of 2: got %d movies", len(related))
+	}
+
+	related, err = m.Movies.GetRelated(movies[2].ID, 10)
+	if err != nil || len(related) != 0 {
+		t.Errorf("no shared genre: got %d movies, error %v; want none", len(related), err)
+	}
+
+	_, err = m.Movies.GetRelated(movies[5].ID+100, 10)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("missing movie: got error %v; want ErrRecordNotFound", err)
+	}
+}
+
+func TestMovieModelUpdatedAt(t *testing.T) {
+	db := testdb.Open(t)
+	m := NewModels(db, 0)
+
+	movie := insertTestMovies(t, m, []string{"drama"})[0]
+
+	got, err := m.Movies.Get(movie.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.UpdatedAt.Equal(got.CreatedAt) {
+		t.Errorf("new movie: got updated_at %s; want created_at %s", got.UpdatedAt, got.CreatedAt)
+	}
+
+	// Move updated_at back, so that the update is sure to change it despite the precision of the column.
+	_, err = db.Exec("UPDATE movies SET updated_at = updated_at - INTERVAL '1 hour' WHERE id = $1", movie.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err = m.Movies.Get(movie.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := got.UpdatedAt
+
+	got.Title = "Renamed"
+	err = m.Movies.Update(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.UpdatedAt.After(before) {
+		t.Errorf("Update() set updated_at to %s; want after %s", got.UpdatedAt, before)
+	}
+
+	reread, err := m.Movies.Get(movie.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reread.UpdatedAt.Equal(got.UpdatedAt) {
+		t.Errorf("Get() after Update(): got updated_at %s; want %s", reread.UpdatedAt, got.UpdatedAt)
+	}
+}
+
+func TestMovieModelGetMany(t *testing.T) {
+	m := NewModels(testdb.Open(t), 0)
+
+	movies := insertTestMovies(t, m, []string{"drama"}, []string{"drama"}, []string{"drama"}, []string{"drama"})
+
+	// A deleted movie is omitted, like an ID which never existed.
+	err := m.Movies.Delete(movies[3].ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := m.Movies.GetMany([]int64{movies[2].ID, 1_000_000, movies[0].ID, movies[3].ID, movies[0].ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []int64
+	for _, movie := range got {
+		ids = append(ids, movie.ID)
+	}
+
+	want := []int64{movies[0].ID, movies[2].ID}
+	if !reflect.DeepEqual(ids, want) {
+		t.Errorf("got %v; want %v", ids, want)
+	}
+
+	got, err = m.Movies.GetMany([]int64{1_000_000})
+	if err != nil || len(got) != 0 {
+		t.Errorf("only missing IDs: got %d movies, error %v; want none", len(got), err)
+	}
+}
+
+func TestMovieModelGetStats(t *testing.T) {
+	m := NewModels(testdb.Open(t), 0)
+
+	stats, err := m.Movies.GetStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.TotalMovies != 0 || stats.AverageRuntime != 0 || len(stats.ByYear) != 0 || len(stats.ByGenre) != 0 {
+		t.Errorf("no movies: got %+v; want empty statistics", stats)
+	}
+
+	movies := []*Movie{
+		{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation", "adventure"}},
+		{Title: "Frozen", Year: 2013, Runtime: 102, Genres: []string{"animation"}},
+		{Title: "Black Panther", Year: 2018, Runtime: 134, Genres: []string{"action", "adventure"}},
+		{Title: "Deadpool", Year: 2016, Runtime: 108, Genres: []string{"action", "comedy"}},
+		{Title: "Deleted", Year: 1999, Runtime: 500, Genres: []string{"drama"}},
+	}
+	for _, movie := range movies {
+		err := m.Movies.Insert(movie)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Deleted movies aren't counted.
+	err = m.Movies.Delete(movies[4].ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err = m.Movies.GetStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.TotalMovies != 4 {
+		t.Errorf("got %d movies; want 4", stats.TotalMovies)
+	}
+	if stats.AverageRuntime != 112.75 {
+		t.Errorf("got an average runtime of %v; want 112.75", stats.AverageRuntime)
+	}
+
+	wantYears := []YearCount{{2013, 1}, {2016, 2}, {2018, 1}}
+	if !reflect.DeepEqual(stats.ByYear, wantYears) {
+		t.Errorf("got by year %v; want %v", stats.ByYear, wantYears)
+	}
+
+	// Genres are sorted by count, then by name.
+	wantGenres := []GenreCount{{"action", 2}, {"adventure", 2}, {"animation", 2}, {"comedy", 1}}
+	if !reflect.DeepEqual(stats.ByGenre, wantGenres) {
+		t.Errorf("got by genre %v; want %v", stats.ByGenre, wantGenres)
+	}
+}
+
+func TestMovieModelDeleteMany(t *testing.T) {
+	m := NewModels(testdb.Open(t), 0)
+
+	movies := insertTestMovies(t, m, []string{"drama"}, []string{"drama"}, []string{"drama"}, []string{"drama"})
+
+	err := m.Movies.Delete(movies[1].ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Missing and already deleted movies are left out of the result.
+	deleted, err := m.Movies.DeleteMany([]int64{movies[0].ID, movies[1].ID, 1_000_000, movies[2].ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Slice(deleted, func(i, j int) bool { return deleted[i] < deleted[j] })
+
+	want := []int64{movies[0].ID, movies[2].ID}
+	if !reflect.DeepEqual(deleted, want) {
+		t.Errorf("got deleted %v; want %v", deleted, want)
+	}
+
+	for i, movie := range movies {
+		_, err := m.Movies.Get(movie.ID)
+		if gone := errors.Is(err, ErrRecordNotFound); gone != (i < 3) {
+			t.Errorf("movie %d: got deleted %t (error %v); want %t", i, gone, err, i < 3)
+		}
+	}
+}
+
+func TestMovieModelStream(t *testing.T) {
+	m := NewModels(testdb.Open(t), 0)
+
+	// One full batch and a short one.
+	for i := 0; i < streamBatchSize+5; i++ {
+		err := m.Movies.Insert(newTestMovie(fmt.Sprintf("Movie %d", i), 1900+int32(i%100)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	filters := Filters{Sort: "id"}.WithSortSafeList(MovieSortSafeList(MovieSortColumns)...)
+
+	var ids []int64
+	flushes := 0
+
+	err := m.Movies.Stream("", nil, nil, QualityFilters{}, filters, func(movie *Movie) error {
+		ids = append(ids, movie.ID)
+		return nil
+	}, func() {
+		flushes++
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ids) != streamBatchSize+5 || flushes != 2 {
+		t.Errorf("got %d movies and %d flushes; want %d and 2", len(ids), flushes, streamBatchSize+5)
+	}
+	if !sort.SliceIsSorted(ids, func(i, j int) bool { return ids[i] < ids[j] }) {
+		t.Error("the movies aren't sorted by ID")
+	}
+
+	// Streaming stops at the first error.
+	errStop := errors.New("stop")
+	n := 0
+	err = m.Movies.Stream("", nil, nil, QualityFilters{}, filters, func(*Movie) error {
+		n++
+		return errStop
+	}, nil)
+	if !errors.Is(err, errStop) || n != 1 {
+		t.Errorf("got error %v after %d movies; want %v after 1", err, n, errStop)
+	}
+}
+
+func TestMovieModelPurge(t *testing.T) {
+	db := testdb.Open(t)
+	m := NewModels(db, 0)
+
+	movies := insertTestMovies(t, m, []string{"drama"}, []string{"drama"}, []string{"drama"})
+	old, recent, live := movies[0], movies[1], movies[2]
+
+	for _, movie := range []*Movie{old, recent} {
+		err := m.Movies.Delete(movie.ID, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := db.Exec("UPDATE movies SET deleted_at = NOW() - INTERVAL '2 days', poster = '/v1/posters/old.png' WHERE id = $1", old.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	purged, err := m.Movies.Purge(24 * time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(purged) != 1 || purged[0].ID != old.ID || purged[0].Poster != "/v1/posters/old.png" {
+		t.Fatalf("got purged %v; want only movie %d with its poster", purged, old.ID)
+	}
+
+	// The recently deleted movie is kept, so that it can still be restored, and so is the live one.
+	var ids []int64
+	rows, err := db.Query("SELECT id FROM movies ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []int64{recent.ID, live.ID}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("got movies %v left; want %v", ids, want)
+	}
+}
+
+func TestValidateMoviePoster(t *testing.T) {
+	tests := []struct {
+		poster string
+		valid  bool
+	}{
+		{"", true},
+		{"/v1/posters/abc.jpg", true},
+		{"https://cdn.example.com/posters/abc.jpg", true},
+		{"http://cdn.example.com/abc.png", true},
+		{"javascript:alert(1)", false},
+		{"file:///etc/passwd", false},
+		{"//evil.example.com/abc.jpg", false},
+		{"posters/abc.jpg", false},
+		{"https://", false},
+	}
+
+	for _, tt := range tests {
+		movie := newTestMovie("Moana", 2016)
+		movie.Poster = tt.poster
+
+		v := validator.New()
+		ValidateMovie(v, movie, GenreLimits{})
+
+		_, invalid := v.Errors["poster"]
+		if invalid == tt.valid {
+			t.Errorf("poster %q: got error %q; want valid %t", tt.poster, v.Errors["poster"], tt.valid)
+		}
+	}
+}
+
+func TestValidateQualityFilters(t *testing.T) {
+	tests := []struct {
+		minGenres int
+		valid     bool
+	}{
+		{0, true},
+		{2, true},
+		{-1, false},
+	}
+
+	for _, tt := range tests {
+		v := validator.New()
+		ValidateQualityFilters(v, QualityFilters{MinGenres: tt.minGenres})
+		if v.Valid() != tt.valid {
+			t.Errorf("min genres %d: got valid %t; want %t", tt.minGenres, v.Valid(), tt.valid)
+		}
+	}
+}
+
+func TestMovieModelGetAllForUser(t *testing.T) {
+	m := NewModels(testdb.Open(t), 0)
+
+	user := &User{Name: "Test", Email: "alice@example.com", Activated: true}
+	err := user.Password.Set("pa55word-for-tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Users.Insert(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mine := newTestMovie("Moana", 2016)
+	mine.CreatedBy = user.ID
+	err = m.Movies.Insert(mine)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A movie without a creator, like a seeded one.
+	err = m.Movies.Insert(newTestMovie("Up", 2009))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	movies, metadata, err := m.Movies.GetAllForUser(user.ID, Filters{Page: 1, PageSize: 20, Sort: "id", SortSafeList: []string{"id"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(movies) != 1 || movies[0].ID != mine.ID || metadata.TotalRecords != 1 {
+		t.Errorf("got %d movies (total %d); want only the user's movie", len(movies), metadata.TotalRecords)
+	}
+}
+
+func TestValidateMovieTags(t *testing.T) {
+	tooMany := make([]string, MaxTags+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("tag%d", i)
+	}
+
+	tests := []struct {
+		name  string
+		tags  []string
+		valid bool
+	}{
+		{"none", nil, true},
+		{"empty list", []string{}, true},
+		{"some", []string{"90s", "rewatchable"}, true},
+		{"maximum", tooMany[:MaxTags], true},
+		{"too many", tooMany, false},
+		{"duplicate", []string{"90s", "90s"}, false},
+		{"empty tag", []string{""}, false},
+		{"longest tag", []string{strings.Repeat("a", MaxTagBytes)}, true},
+		{"too long tag", []string{strings.Repeat("a", MaxTagBytes+1)}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			movie := newTestMovie("Moana", 2016)
+			movie.Tags = tt.tags
+
+			v := validator.New()
+			ValidateMovie(v, movie, GenreLimits{})
+
+			_, invalid := v.Errors["tags"]
+			if invalid == tt.valid {
+				t.Errorf("got tags error %q; want valid %t", v.Errors["tags"], tt.valid)
+			}
+		})
+	}
+}
+
+func TestMovieModelTags(t *testing.T) {
+	m := NewModels(testdb.Open(t), 0)
+
+	moana := newTestMovie("Moana", 2016)
+	moana.Tags = []string{"disney", "rewatchable"}
+	deadpool := newTestMovie("Deadpool", 2016)
+	frozen := newTestMovie("Frozen", 2013)
+	for _, movie := range []*Movie{moana, deadpool, frozen} {
+		err := m.Movies.Insert(movie)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := m.Movies.Get(moana.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Tags, moana.Tags) {
+		t.Errorf("Get() tags = %q; want %q", got.Tags, moana.Tags)
+	}
+
+	filters := Filters{Page: 1, PageSize: 20, Sort: "id", SortSafeList: []string{"id"}}
+
+	tests := []struct {
+		tags []string
+		want []int64
+	}{
+		{nil, []int64{moana.ID, deadpool.ID, frozen.ID}},
+		{[]string{"disney"}, []int64{moana.ID}},
+		{[]string{"disney", "rewatchable"}, []int64{moana.ID}},
+		{[]string{"disney", "90s"}, nil},
+	}
+
+	for _, tt := range tests {
+		movies, _, err := m.Movies.GetAll("", nil, tt.tags, QualityFilters{}, filters)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var ids []int64
+		for _, movie := range movies {
+			ids = append(ids, movie.ID)
+		}
+		if !reflect.DeepEqual(ids, tt.want) {
+			t.Errorf("GetAll() with tags %q = %v; want %v", tt.tags, ids, tt.want)
+		}
+	}
+}
+
+func TestValidateMovieNormalizesGenresAndTags(t *testing.T) {
+	movie := newTestMovie("Moana", 2016)
+	movie.Genres = []string{" animation", "adventure "}
+	movie.Tags = []string{" disney\t"}
+
+	v := validator.New()
+	ValidateMovie(v, movie, GenreLimits{})
+
+	if !v.Valid() {
+		t.Fatalf("got errors %v", v.Errors)
+	}
+	if !reflect.DeepEqual(movie.Genres, []string{"animation", "adventure"}) || !reflect.DeepEqual(movie.Tags, []string{"disney"}) {
+		t.Errorf("got genres %q, tags %q; want them trimmed", movie.Genres, movie.Tags)
+	}
+
+	// Genres which only differ by whitespace are duplicates.
+	movie.Genres = []string{"comedy", " comedy"}
+
+	v = validator.New()
+	ValidateMovie(v, movie, GenreLimits{})
+
+	if _, ok := v.Errors["genres"]; !ok {
+		t.Error("got no genres error for genres which only differ by whitespace")
+	}
+}
+
+func TestValidateMovieGenres(t *testing.T) {
+	tests := []struct {
+		name     string
+		min, max int
+		genres   []string
+		valid    bool
+	}{
+		{"missing", 1, 5, nil, false},
+		{"empty", 1, 5, []string{}, false},
+		{"one", 1, 5, []string{"drama"}, true},
+		{"maximum", 1, 5, []string{"a", "b", "c", "d", "e"}, true},
+		{"too many", 1, 5, []string{"a", "b", "c", "d", "e", "f"}, false},
+		{"below a raised minimum", 2, 5, []string{"drama"}, false},
+		{"above a lowered maximum", 1, 2, []string{"a", "b", "c"}, false},
+		{"blank", 1, 5, []string{"  "}, false},
+		{"longest", 1, 5, []string{strings.Repeat("a", MaxGenreBytes)}, true},
+		{"too long", 1, 5, []string{strings.Repeat("a", MaxGenreBytes+1)}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			movie := newTestMovie("Moana", 2016)
+			movie.Genres = tt.genres
+
+			v := validator.New()
+			ValidateMovie(v, movie, GenreLimits{Min: tt.min, Max: tt.max})
+
+			_, invalid := v.Errors["genres"]
+			if invalid == tt.valid {
+				t.Errorf("got genres error %q; want valid %t", v.Errors["genres"], tt.valid)
+			}
+		})
+	}
+}
+
+func TestMovieModelGetAllQualityFilters(t *testing.T) {
+	m := NewModels(testdb.Open(t), 0)
+
+	movies := insertTestMovies(t, m,
+		[]string{"drama"},                     // No poster, 1 genre
+		[]string{"drama"},                     // Poster, 1 genre
+		[]string{"drama", "comedy"},           // No poster, 2 genres
+		[]string{"drama", "comedy", "action"}, // Poster, 3 genres
+	)
+	for _, movie := range []*Movie{movies[1], movies[3]} {
+		movie.Poster = "https://example.com/poster.png"
+		err := m.Movies.UpdatePoster(movie)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	yes, no := true, false
+	filters := Filters{Page: 1, PageSize: 20, Sort: "id", SortSafeList: []string{"id"}}
+
+	tests := []struct {
+		name    string
+		quality QualityFilters
+		want    []int64
+	}{
+		{"none", QualityFilters{}, []int64{movies[0].ID, movies[1].ID, movies[2].ID, movies[3].ID}},
+		{"with a poster", QualityFilters{HasPoster: &yes}, []int64{movies[1].ID, movies[3].ID}},
+		{"without a poster", QualityFilters{HasPoster: &no}, []int64{movies[0].ID, movies[2].ID}},
+		{"fewer than 2 genres", QualityFilters{MinGenres: 2}, []int64{movies[0].ID, movies[1].ID}},
+		{"fewer than 3 genres", QualityFilters{MinGenres: 3}, []int64{movies[0].ID, movies[1].ID, movies[2].ID}},
+		{"both", QualityFilters{HasPoster: &no, MinGenres: 2}, []int64{movies[0].ID}},
+	}
+
+	for _, tt := range tests {
+		got, metadata, err := m.Movies.GetAll("", nil, nil, tt.quality, filters)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var ids []int64
+		for _, movie := range got {
+			ids = append(ids, movie.ID)
+		}
+		if !reflect.DeepEqual(ids, tt.want) {
+			t.Errorf("%s: GetAll() = %v; want %v", tt.name, ids, tt.want)
+		}
+		if metadata.TotalRecords != len(tt.want) {
+			t.Errorf("%s: got %d total records; want %d", tt.name, metadata.TotalRecords, len(tt.want))
+		}
+
+		count, err := m.Movies.Count("", nil, nil, tt.quality)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != len(tt.want) {
+			t.Errorf("%s: Count() = %d; want %d", tt.name, count, len(tt.want))
+		}
+	}
+}
+
+func TestMovieTruncateGenres(t *testing.T) {
+	tests := []struct {
+		n          int
+		genres     []string
+		moreGenres int
+	}{
+		{0, []string{"action", "comedy", "drama"}, 0},
+		{2, []string{"action", "comedy"}, 1},
+		{3, []string{"action", "comedy", "drama"}, 0},
+		{5, []string{"action", "comedy", "drama"}, 0},
+	}
+
+	for _, tt := range tests {
+		movie := &Movie{Genres: []string{"action", "comedy", "drama"}}
+		movie.TruncateGenres(tt.n)
+
+		if !reflect.DeepEqual(movie.Genres, tt.genres) || movie.MoreGenres != tt.moreGenres {
+			t.Errorf("TruncateGenres(%d): got %q and %d more; want %q and %d more", tt.n, movie.Genres, movie.MoreGenres, tt.genres, tt.moreGenres)
+		}
+	}
+}
+
+func TestMovieMarshalJSONTombstone(t *testing.T) {
+	movie := newTestMovie("Moana", 2016)
+	movie.ID = 1
+	movie.Tags = []string{"disney"}
+	movie.Deleted = true
+
+	js, err := json.Marshal(movie)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got map[string]interface{}
+	err = json.Unmarshal(js, &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 2 || got["deleted"] != true || got["id"] == nil {
+		t.Errorf("got %s; want only the id and \"deleted\": true", js)
+	}
+
+	movie.Deleted = false
+
+	js, err = json.Marshal(movie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(js), `"title":"Moana"`) || strings.Contains(string(js), "deleted") {
+		t.Errorf("got %s; want the whole movie without \"deleted\"", js)
+	}
+}
+
+func TestSyncCursor(t *testing.T) {
+	c := SyncCursor{UpdatedAt: time.Date(2021, 6, 1, 12, 30, 0, 123456000, time.UTC), ID: 42}
+
+	s := EncodeSyncCursor(c)
+	if strings.Contains(s, "42") {
+		t.Errorf("cursor %q reveals the movie ID", s)
+	}
+
+	got, err := DecodeSyncCursor(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.UpdatedAt.Equal(c.UpdatedAt) || got.ID != c.ID {
+		t.Errorf("got cursor %+v; want %+v", got, c)
+	}
+
+	for _, invalid := range []string{"", "not base64!", "bm8tc2VwYXJhdG9y", s[:len(s)-4]} {
+		_, err := DecodeSyncCursor(invalid)
+		if !errors.Is(err, ErrInvalidSyncCursor) {
+			t.Errorf("DecodeSyncCursor(%q): got error %v; want %v", invalid, err, ErrInvalidSyncCursor)
+		}
+	}
+}
+
+func TestMovieModelGetUpdatedSince(t *testing.T) {
+	db := testdb.Open(t)
+	m := NewModels(db, 0)
+
+	movies := insertTestMovies(t, m, []string{"drama"}, []string{"drama"}, []string{"drama"})
+	unchanged, updated, deleted := movies[0], movies[1], movies[2]
+
+	// Move the creation of the movies an hour back, so that the later changes have clearly later timestamps.
+	_, err := db.Exec("UPDATE movies SET created_at = NOW() - INTERVAL '1 hour', updated_at = NOW() - INTERVAL '1 hour'")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := func(since time.Time) ([]int64, map[int64]*Movie) {
+		t.Helper()
+
+		got, err := m.Movies.GetUpdatedSince(since, nil, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var ids []int64
+		byID := make(map[int64]*Movie)
+		for _, movie := range got {
+			ids = append(ids, movie.ID)
+			byID[movie.ID] = movie
+		}
+		return ids, byID
+	}
+
+	// The creates appear after their timestamp, and nothing has changed since.
+	if got, _ := ids(time.Now().Add(-2 * time.Hour)); !reflect.DeepEqual(got, []int64{unchanged.ID, updated.ID, deleted.ID}) {
+		t.Errorf("creates: got %v; want all 3 movies", got)
+	}
+	checkpoint := time.Now().Add(-30 * time.Minute)
+	if got, _ := ids(checkpoint); got != nil {
+		t.Errorf("before the changes: got %v; want none", got)
+	}
+
+	// Then a movie is updated and another one deleted.
+	updated.Title = "Updated"
+	err = m.Movies.Update(updated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Movies.Delete(deleted.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, byID := ids(checkpoint)
+	if !reflect.DeepEqual(got, []int64{updated.ID, deleted.ID}) {
+		t.Fatalf("after the changes: got %v; want the updated and deleted movies", got)
+	}
+	if movie := byID[updated.ID]; movie.Title != "Updated" || movie.Deleted {
+		t.Errorf("got updated movie %q, deleted %t; want the new title", movie.Title, movie.Deleted)
+	}
+	if !byID[deleted.ID].Deleted {
+		t.Error("the deleted movie isn't a tombstone")
+	}
+
+	// Pages follow on from the cursor of the last movie of the previous page.
+	var paged []int64
+	var cursor *SyncCursor
+	for i := 0; i < 4; i++ {
+		page, err := m.Movies.GetUpdatedSince(time.Now().Add(-2*time.Hour), cursor, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) == 0 {
+			break
+		}
+
+		paged = append(paged, page[0].ID)
+		cursor = &SyncCursor{UpdatedAt: page[0].UpdatedAt, ID: page[0].ID}
+	}
+	if !reflect.DeepEqual(paged, []int64{unchanged.ID, updated.ID, deleted.ID}) {
+		t.Errorf("got pages %v; want the movies in order of update", paged)
+	}
+}
+
+func TestMovieSortSafeList(t *testing.T) {
+	want := []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+	if got := MovieSortSafeList(MovieSortColumns); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q; want %q", got, want)
+	}
+
+	// Narrowing the fields offered to clients narrows the safelist.
+	want = []string{"id", "year", "-id", "-year"}
+	if got := MovieSortSafeList([]string{"id", "year"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("narrowed: got %q; want %q", got, want)
+	}
+}
+
+func TestMovieModelTouch(t *testing.T) {
+	m := NewModels(testdb.Open(t), 0)
+
+	movies := insertTestMovies(t, m, []string{"drama"}, []string{"drama"})
+	movie, deleted := movies[0], movies[1]
+
+	version, err := m.Movies.Touch(movie.ID, movie.Version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != movie.Version+1 {
+		t.Errorf("got version %d; want %d", version, movie.Version+1)
+	}
+
+	// Only the version and updated_at change.
+	got, err := m.Movies.Get(movie.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != version || got.Title != movie.Title || !reflect.DeepEqual(got.Genres, movie.Genres) {
+		t.Errorf("got %+v; want the movie unchanged but for version %d", got, version)
+	}
+	if got.UpdatedAt.Before(movie.UpdatedAt) {
+		t.Errorf("got updated_at %s; want no earlier than %s", got.UpdatedAt, movie.UpdatedAt)
+	}
+
+	// Concurrent touches of the same version: only one of them wins, the other one is stale.
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := m.Movies.Touch(movie.ID, version)
+			errs <- err
+		}()
+	}
+
+	var succeeded, conflicts int
+	for i := 0; i < 2; i++ {
+		err := <-errs
+		switch {
+		case err == nil:
+			succeeded++
+		case errors.Is(err, ErrEditConflict):
+			conflicts++
+		default:
+			t.Fatal(err)
+		}
+	}
+	if succeeded != 1 || conflicts != 1 {
+		t.Errorf("got %d successes and %d edit conflicts; want 1 and 1", succeeded, conflicts)
+	}
+
+	// A missing or deleted movie isn't an edit conflict.
+	err = m.Movies.Delete(deleted.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []int64{deleted.ID, 1000000, 0} {
+		_, err := m.Movies.Touch(id, 1)
+		if !errors.Is(err, ErrRecordNotFound) {
+			t.Errorf("movie %d: got error %v; want %v", id, err, ErrRecordNotFound)
+		}
+	}
+}
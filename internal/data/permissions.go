@@ -0,0 +1,85 @@
+package data
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Define a Permissions slice to hold the permission codes (e.g. "movies:read", "movies:write")
+// for a single user.
+type Permissions []string
+
+// Include() checks whether the Permissions slice contains a specific permission code.
+func (p Permissions) Include(code string) bool {
+	for i := range p {
+		if code == p[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// Define the PermissionModel struct
+type PermissionModel struct {
+	DB       *sql.DB
+	Timeout  time.Duration // Timeout for each query, defaults to 3 seconds if not set
+	QueryLog QueryLogFunc  // Called after every query to log it, nil to disable
+}
+
+// GetAllForUser() returns all the permission codes for a specific user.
+func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
+	defer observeQuery(m.QueryLog, "permissions.get_all_for_user", time.Now())
+
+	query := `
+		SELECT permissions.code
+		FROM permissions
+		INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
+		WHERE users_permissions.user_id = $1`
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var permissions Permissions
+
+	for rows.Next() {
+		var permission string
+
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+
+		permissions = append(permissions, permission)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
+}
+
+// AddForUser() grants the provided permission codes to a specific user.
+// Codes which do not exist in the permissions table are ignored.
+func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
+	defer observeQuery(m.QueryLog, "permissions.add_for_user", time.Now())
+
+	query := `
+		INSERT INTO users_permissions
+		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+		ON CONFLICT DO NOTHING`
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	return err
+}
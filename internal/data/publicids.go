@@ -0,0 +1,79 @@
+package data
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/speps/go-hashids/v2"
+)
+
+// ErrInvalidPublicID is returned when a public ID can't be decoded to a primary key.
+var ErrInvalidPublicID = errors.New("invalid public id")
+
+// publicIDMinLength is the minimum length of a hashid, so that small IDs don't give away the catalogue size.
+const publicIDMinLength = 8
+
+// PublicIDs converts between the int64 primary keys used internally and the IDs shown in the public API.
+// The zero value uses the integer IDs unchanged. A PublicIDs created with NewHashPublicIDs() encodes them as
+// opaque hashids strings instead (e.g. 1 becomes "Mj3YzW9x"), which can't be enumerated without the salt.
+type PublicIDs struct {
+	hd *hashids.HashID
+}
+
+// MovieIDs holds the public form of the movie IDs. It can be switched to hashids at startup with the
+// -public-ids flag. Integer IDs are used by default, so that existing clients keep working.
+var MovieIDs PublicIDs
+
+// NewHashPublicIDs() returns a PublicIDs which encodes the IDs as hashids generated with the given salt.
+func NewHashPublicIDs(salt string) (PublicIDs, error) {
+	data := hashids.NewData()
+	data.Salt = salt
+	data.MinLength = publicIDMinLength
+
+	hd, err := hashids.NewWithData(data)
+	if err != nil {
+		return PublicIDs{}, err
+	}
+
+	return PublicIDs{hd: hd}, nil
+}
+
+// Encode() returns the public form of an ID: the ID itself, or a hashid string.
+func (p PublicIDs) Encode(id int64) interface{} {
+	if p.hd == nil {
+		return id
+	}
+
+	// EncodeInt64() only fails for negative numbers, which are never used as primary keys.
+	hash, err := p.hd.EncodeInt64([]int64{id})
+	if err != nil {
+		return id
+	}
+
+	return hash
+}
+
+// Decode() converts the public form of an ID back to the primary key.
+// ErrInvalidPublicID is returned if the value isn't a valid public ID.
+func (p PublicIDs) Decode(s string) (int64, error) {
+	if p.hd == nil {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || id < 1 {
+			return 0, ErrInvalidPublicID
+		}
+		return id, nil
+	}
+
+	if s == "" {
+		return 0, ErrInvalidPublicID
+	}
+
+	// DecodeInt64WithError() re-encodes the result and fails if it doesn't match the input,
+	// so a hashid generated with a different salt is rejected.
+	ids, err := p.hd.DecodeInt64WithError(s)
+	if err != nil || len(ids) != 1 || ids[0] < 1 {
+		return 0, ErrInvalidPublicID
+	}
+
+	return ids[0], nil
+}
@@ -0,0 +1,97 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestPublicIDsRoundTrip(t *testing.T) {
+	hashed, err := NewHashPublicIDs("test salt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []PublicIDs{{}, hashed} {
+		for _, id := range []int64{1, 2, 42, 1 << 40} {
+			public := fmt.Sprint(p.Encode(id))
+
+			got, err := p.Decode(public)
+			if err != nil || got != id {
+				t.Errorf("hashids %t: Decode(%q) = %d, %v; want %d", p.hd != nil, public, got, err, id)
+			}
+		}
+	}
+
+	// Integer IDs are unchanged, and hashids are opaque strings of at least the minimum length.
+	if got := (PublicIDs{}).Encode(42); got != int64(42) {
+		t.Errorf("integer IDs: got %v (%T); want 42", got, got)
+	}
+
+	public, ok := hashed.Encode(42).(string)
+	if !ok || len(public) < publicIDMinLength || strings.Contains(public, "42") {
+		t.Errorf("hashids: got %q; want an opaque string of at least %d characters", public, publicIDMinLength)
+	}
+}
+
+func TestPublicIDsDecodeInvalid(t *testing.T) {
+	hashed, err := NewHashPublicIDs("test salt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewHashPublicIDs("other salt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		p      PublicIDs
+		public string
+	}{
+		{"integer: empty", PublicIDs{}, ""},
+		{"integer: zero", PublicIDs{}, "0"},
+		{"integer: negative", PublicIDs{}, "-1"},
+		{"integer: not a number", PublicIDs{}, "abc"},
+		{"hashids: empty", hashed, ""},
+		{"hashids: integer", hashed, "42"},
+		{"hashids: garbage", hashed, "not-a-hashid"},
+		{"hashids: other salt", hashed, other.Encode(42).(string)},
+	}
+
+	for _, tt := range tests {
+		_, err := tt.p.Decode(tt.public)
+		if !errors.Is(err, ErrInvalidPublicID) {
+			t.Errorf("%s: got error %v; want %v", tt.name, err, ErrInvalidPublicID)
+		}
+	}
+}
+
+func TestMovieMarshalJSONPublicID(t *testing.T) {
+	defer func(ids PublicIDs) { MovieIDs = ids }(MovieIDs)
+
+	hashed, err := NewHashPublicIDs("test salt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	MovieIDs = hashed
+
+	js, err := json.Marshal(&Movie{ID: 42, Title: "Moana", Year: 2016})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got struct {
+		ID interface{} `json:"id"`
+	}
+	err = json.Unmarshal(js, &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.ID != hashed.Encode(42) {
+		t.Errorf("got id %v; want %v", got.ID, hashed.Encode(42))
+	}
+}
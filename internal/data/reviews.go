@@ -0,0 +1,99 @@
+package data
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Define a Review struct to hold a user's review of a movie.
+type Review struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	MovieID   int64     `json:"movie_id"`
+	UserID    int64     `json:"user_id"`
+	Rating    int       `json:"rating"` // Star rating from 1 to 5
+	Body      string    `json:"body"`
+	Version   int32     `json:"version"`
+}
+
+// MarshalJSON() encodes the review with the public ID of the movie (see MovieIDs).
+func (r Review) MarshalJSON() ([]byte, error) {
+	type reviewAlias Review
+
+	return json.Marshal(struct {
+		MovieID interface{} `json:"movie_id"`
+		reviewAlias
+	}{
+		MovieID:     MovieIDs.Encode(r.MovieID),
+		reviewAlias: reviewAlias(r),
+	})
+}
+
+// Define the ReviewModel struct
+type ReviewModel struct {
+	DB       *sql.DB
+	ReadDB   *sql.DB       // Pool for read-only queries, e.g. a replica, defaults to DB if not set
+	Timeout  time.Duration // Timeout for each query, defaults to 3 seconds if not set
+	QueryLog QueryLogFunc  // Called after every query to log it, nil to disable
+}
+
+// GetAllForMovie() returns a page of the reviews of a specific movie, along with the pagination metadata.
+// Only reviews with a rating of at least minRating are returned. A minRating of 0 returns every review.
+func (m ReviewModel) GetAllForMovie(movieID int64, minRating int, filters Filters) ([]*Review, Metadata, error) {
+	defer observeQuery(m.QueryLog, "reviews.get_all_for_movie", time.Now())
+
+	// The sort column is interpolated into the query, so it must come from the safelist.
+	sortColumn, err := filters.sortColumn()
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, movie_id, user_id, rating, body, version
+		FROM reviews
+		WHERE movie_id = $1 AND rating >= $2
+		ORDER BY %s %s, id ASC
+		LIMIT $3 OFFSET $4`, sortColumn, filters.sortDirection())
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	rows, err := readPool(m.DB, m.ReadDB).QueryContext(ctx, query, movieID, minRating, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	reviews := []*Review{}
+
+	for rows.Next() {
+		review := new(Review)
+
+		err := rows.Scan(
+			&totalRecords,
+			&review.ID,
+			&review.CreatedAt,
+			&review.MovieID,
+			&review.UserID,
+			&review.Rating,
+			&review.Body,
+			&review.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		reviews = append(reviews, review)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return reviews, metadata, nil
+}
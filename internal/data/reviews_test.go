@@ -0,0 +1,78 @@
+package data
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jseow5177/greenlight/internal/testdb"
+)
+
+func TestReviewModelGetAllForMovie(t *testing.T) {
+	db := testdb.Open(t)
+	m := NewModels(db, 0)
+
+	user := &User{Name: "Test", Email: "alice@example.com", Activated: true}
+	err := user.Password.Set("pa55word-for-tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Users.Insert(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	movies := insertTestMovies(t, m, []string{"drama"}, []string{"drama"})
+
+	// There is no model method to add reviews, so insert them directly. The other movie's review must never be
+	// listed.
+	var ids []int64
+	for _, rating := range []int{3, 5, 1, 4, 5} {
+		var id int64
+		err := db.QueryRow(`INSERT INTO reviews (movie_id, user_id, rating, body) VALUES ($1, $2, $3, 'Review') RETURNING id`,
+			movies[0].ID, user.ID, rating).Scan(&id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	_, err = db.Exec(`INSERT INTO reviews (movie_id, user_id, rating, body) VALUES ($1, $2, 5, 'Other movie')`, movies[1].ID, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	safeList := []string{"created_at", "rating", "-created_at", "-rating"}
+
+	tests := []struct {
+		name      string
+		minRating int
+		filters   Filters
+		want      []int64
+		total     int
+	}{
+		// Ties are broken by ID, so the order is stable.
+		{"by rating", 0, Filters{Page: 1, PageSize: 10, Sort: "rating"}, []int64{ids[2], ids[0], ids[3], ids[1], ids[4]}, 5},
+		{"by rating descending", 0, Filters{Page: 1, PageSize: 10, Sort: "-rating"}, []int64{ids[1], ids[4], ids[3], ids[0], ids[2]}, 5},
+		{"first page", 0, Filters{Page: 1, PageSize: 2, Sort: "-rating"}, []int64{ids[1], ids[4]}, 5},
+		{"last page", 0, Filters{Page: 3, PageSize: 2, Sort: "-rating"}, []int64{ids[2]}, 5},
+		{"min rating", 4, Filters{Page: 1, PageSize: 10, Sort: "rating"}, []int64{ids[3], ids[1], ids[4]}, 3},
+	}
+
+	for _, tt := range tests {
+		reviews, metadata, err := m.Reviews.GetAllForMovie(movies[0].ID, tt.minRating, tt.filters.WithSortSafeList(safeList...))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		var got []int64
+		for _, review := range reviews {
+			got = append(got, review.ID)
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got reviews %v; want %v", tt.name, got, tt.want)
+		}
+		if metadata.TotalRecords != tt.total || metadata.CurrentPage != tt.filters.Page {
+			t.Errorf("%s: got total %d on page %d; want %d on page %d", tt.name, metadata.TotalRecords, metadata.CurrentPage, tt.total, tt.filters.Page)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+)
+
+// slowPool is a connection pool whose queries never complete: they only return once their context is done.
+type slowPool struct{}
+
+func (slowPool) Connect(context.Context) (driver.Conn, error) { return slowConn{}, nil }
+func (slowPool) Driver() driver.Driver                        { return nil }
+
+type slowConn struct{}
+
+func (slowConn) QueryContext(ctx context.Context, _ string, _ []driver.NamedValue) (driver.Rows, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (c slowConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	_, err := c.QueryContext(ctx, query, args)
+	return nil, err
+}
+
+func (slowConn) Prepare(string) (driver.Stmt, error) { return nil, errStub }
+func (slowConn) Close() error                        { return nil }
+func (slowConn) Begin() (driver.Tx, error)           { return nil, errStub }
+
+func TestQueryTimeout(t *testing.T) {
+	db := sql.OpenDB(slowPool{})
+	defer db.Close()
+
+	const timeout = 20 * time.Millisecond
+
+	m := NewModels(db, timeout)
+
+	tests := []struct {
+		name string
+		call func() error
+	}{
+		{"Movies.Get", func() error { _, err := m.Movies.Get(1); return err }},
+		{"Users.GetByEmail", func() error { _, err := m.Users.GetByEmail("alice@example.com"); return err }},
+		{"Tokens.CountForUser", func() error { _, err := m.Tokens.CountForUser(ScopeAuthentication, 1); return err }},
+	}
+
+	for _, tt := range tests {
+		start := time.Now()
+
+		err := tt.call()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%s: got error %v; want %v", tt.name, err, context.DeadlineExceeded)
+		}
+
+		// The default timeout is 3 seconds, so a quick return shows that the configured one was used.
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("%s: returned after %s; want about %s", tt.name, elapsed, timeout)
+		}
+	}
+}
+
+func TestQueryContextDefaultTimeout(t *testing.T) {
+	for _, timeout := range []time.Duration{0, -time.Second} {
+		ctx, cancel := queryContext(timeout)
+
+		deadline, ok := ctx.Deadline()
+		cancel()
+
+		if !ok {
+			t.Fatalf("timeout %s: the context has no deadline", timeout)
+		}
+		if remaining := time.Until(deadline); remaining <= defaultTimeout-time.Second || remaining > defaultTimeout {
+			t.Errorf("timeout %s: got a deadline in %s; want %s", timeout, remaining, defaultTimeout)
+		}
+	}
+}
@@ -1,88 +1,336 @@
 package data
 
 import (
-	"context"
+	"crypto/rand"
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
-	"math/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/jseow5177/greenlight/internal/validator"
 )
 
 // Define constants for the token scope.
 const (
-	ScopeActivation = "activation"
+	ScopeActivation     = "activation"
+	ScopeAuthentication = "authentication"
 )
 
-const (
-	TokenByteLength = 26
-)
+// defaultTokenEntropy is the number of random bytes used to generate a token when no entropy is given.
+// It gives tokens 128 bits of entropy.
+const defaultTokenEntropy = 16
+
+// tokenEntropy() returns the entropy, or defaultTokenEntropy if it is not set.
+func tokenEntropy(entropy int) int {
+	if entropy <= 0 {
+		return defaultTokenEntropy
+	}
+	return entropy
+}
+
+// TokenPlaintextLength() returns the length of a plaintext token generated with the given number of random bytes.
+// The plaintext is the unpadded base-32 encoding of the random bytes, so 16 bytes give a 26 character token.
+func TokenPlaintextLength(entropy int) int {
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodedLen(entropy)
+}
 
 // Define a Token struct to hold the data for an individual token.
 // This includes the plaintext and hashed versions of the token, associated user ID, expiry time and scope.
+// Only the plaintext and expiry are included in JSON responses.
 type Token struct {
-	Plaintext string // To be sent to client
-	Hash      []byte // To be stored in DB
-	UserID    int64
-	Expiry    time.Time
-	Scope     string
+	Plaintext string    `json:"token"` // To be sent to client
+	Hash      []byte    `json:"-"`     // To be stored in DB
+	UserID    int64     `json:"-"`
+	Expiry    time.Time `json:"expiry"`
+	Scope     string    `json:"-"`
+	CreatedAt time.Time `json:"-"`
+	UserAgent string    `json:"-"` // User-Agent of the client which created the token (optional)
+	IP        string    `json:"-"` // IP address of the client which created the token (optional)
+}
+
+// maxUserAgentLength is the number of bytes of the User-Agent header which are stored with a token.
+const maxUserAgentLength = 256
+
+// truncateUserAgent() returns the User-Agent cut to at most maxUserAgentLength bytes. The header can contain any
+// bytes, but the column only accepts valid UTF-8, so invalid sequences are dropped first, and the cut is made on a
+// rune boundary rather than in the middle of a multi-byte character.
+func truncateUserAgent(userAgent string) string {
+	userAgent = strings.ToValidUTF8(userAgent, "")
+	if len(userAgent) <= maxUserAgentLength {
+		return userAgent
+	}
+
+	cut := maxUserAgentLength
+	for cut > 0 && !utf8.RuneStart(userAgent[cut]) {
+		cut--
+	}
+
+	return userAgent[:cut]
+}
+
+// Define a Session struct to describe an authentication token to its owner. It never contains the plaintext
+// or the full hash of the token. The ID is a short prefix of the hash, which is enough to tell sessions apart.
+type Session struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Expiry    time.Time `json:"expiry"`
+	UserAgent string    `json:"user_agent"`
+	IP        string    `json:"ip"`
 }
 
 // Define the TokenModel struct
 type TokenModel struct {
-	DB *sql.DB
+	DB       *sql.DB
+	Timeout  time.Duration // Timeout for each query, defaults to 3 seconds if not set
+	Entropy  int           // Number of random bytes in each token, defaults to 16 (128 bits) if not set
+	Rand     io.Reader     // Source of the random token bytes, defaults to crypto/rand.Reader if not set
+	QueryLog QueryLogFunc  // Called after every query to log it, nil to disable
 }
 
-// Check that the plaintext token is provided and is exactly 26 bytes long
-func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
+// Check that the plaintext token is provided and has the length of a token generated with the given number of
+// random bytes (26 bytes long by default).
+func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string, entropy int) {
+	length := TokenPlaintextLength(tokenEntropy(entropy))
+
 	v.Check(tokenPlaintext != "", "token", "must be provided")
-	v.Check(len(tokenPlaintext) == TokenByteLength, "token", "must be 26 bytes long")
+	v.Checkf(len(tokenPlaintext) == length, "token", "must be %d bytes long", length)
 }
 
 // New() is a shortcut method to create a new Token struct and then insert the data
 // in the tokens table.
 func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
-	token, err := generateToken(userID, ttl, scope)
+	return m.NewForClient(userID, ttl, scope, "", "")
+}
+
+// NewForClient() creates a new token like New(), and also records the user agent and IP address of the client
+// which requested it.
+func (m TokenModel) NewForClient(userID int64, ttl time.Duration, scope, userAgent, ip string) (*Token, error) {
+	random := m.Rand
+	if random == nil {
+		random = rand.Reader
+	}
+
+	token, err := generateToken(userID, ttl, scope, m.Entropy, random)
 	if err != nil {
 		return nil, err
 	}
 
+	token.UserAgent = truncateUserAgent(userAgent)
+	token.IP = ip
+
 	err = m.Insert(token)
 	return token, err
 }
 
 // Insert() adds the data for a specific token to the tokens table.
+// The system-generated creation time is stored in the token.
 func (m TokenModel) Insert(token *Token) error {
+	defer observeQuery(m.QueryLog, "tokens.insert", time.Now())
+
+	query := `
+		INSERT INTO tokens (hash, user_id, expiry, scope, user_agent, ip)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING created_at`
+
+	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope, token.UserAgent, token.IP}
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&token.CreatedAt)
+}
+
+// CountForUser() returns the number of unexpired tokens for a specific user and scope.
+func (m TokenModel) CountForUser(scope string, userID int64) (int, error) {
+	defer observeQuery(m.QueryLog, "tokens.count_for_user", time.Now())
+
+	query := `
+		SELECT count(*)
+		FROM tokens
+		WHERE scope = $1 AND user_id = $2 AND expiry > $3`
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	var count int
+
+	err := m.DB.QueryRowContext(ctx, query, scope, userID, time.Now()).Scan(&count)
+	return count, err
+}
+
+// ListForUser() returns the unexpired tokens for a specific user and scope as sessions, newest first.
+func (m TokenModel) ListForUser(scope string, userID int64) ([]*Session, error) {
+	defer observeQuery(m.QueryLog, "tokens.list_for_user", time.Now())
+
 	query := `
-		INSERT INTO tokens (hash, user_id, expiry, scope)
-		VALUES ($1, $2, $3, $4)`
+		SELECT hash, created_at, expiry, user_agent, ip
+		FROM tokens
+		WHERE scope = $1 AND user_id = $2 AND expiry > $3
+		ORDER BY created_at DESC, expiry DESC`
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, scope, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+
+	for rows.Next() {
+		var hash []byte
+		session := new(Session)
+
+		err := rows.Scan(&hash, &session.CreatedAt, &session.Expiry, &session.UserAgent, &session.IP)
+		if err != nil {
+			return nil, err
+		}
 
-	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope}
+		session.ID = sessionID(hash)
+		sessions = append(sessions, session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// sessionID() masks a token hash as a short identifier: the hex encoding of its first 4 bytes.
+func sessionID(hash []byte) string {
+	if len(hash) > 4 {
+		hash = hash[:4]
+	}
+	return hex.EncodeToString(hash)
+}
+
+// DeleteOldestForUser() keeps the newest tokens for a specific user and scope, and deletes the rest.
+// Expired tokens are the oldest, so they are deleted first. created_at only has a precision of a second, so the
+// token just issued can tie with older ones: the token with the current hash is always kept, whatever its
+// timestamps. Pass a nil hash if there is no such token.
+func (m TokenModel) DeleteOldestForUser(scope string, userID int64, keep int, current []byte) error {
+	var rows int64
+	defer observeQueryRows(m.QueryLog, "tokens.delete_oldest_for_user", time.Now(), &rows)
+
+	query := `
+		DELETE FROM tokens
+		WHERE hash IN (
+			SELECT hash
+			FROM tokens
+			WHERE scope = $1 AND user_id = $2
+			ORDER BY hash = $4 DESC, created_at DESC, expiry DESC
+			OFFSET $3
+		)`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(m.Timeout)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, args...)
+	result, err := m.DB.ExecContext(ctx, query, scope, userID, keep, current)
+	if err != nil {
+		return err
+	}
 
+	rows, err = result.RowsAffected()
 	return err
 }
 
 // DeleteAllForUser() deletes all tokens for a specific user and scope.
 func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
+	var rows int64
+	defer observeQueryRows(m.QueryLog, "tokens.delete_all_for_user", time.Now(), &rows)
+
 	query := `
 		DELETE FROM tokens
 		WHERE scope = $1 AND user_id = $2`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(m.Timeout)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, scope, userID)
+	result, err := m.DB.ExecContext(ctx, query, scope, userID)
+	if err != nil {
+		return err
+	}
+
+	rows, err = result.RowsAffected()
 	return err
 }
 
-func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
+// GetExpiry() returns the expiry time of a token, identified by its plaintext, for a specific scope.
+// ErrRecordNotFound is returned if no such token exists, or if it has expired.
+func (m TokenModel) GetExpiry(scope, tokenPlaintext string) (time.Time, error) {
+	defer observeQuery(m.QueryLog, "tokens.get_expiry", time.Now())
+
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		SELECT expiry
+		FROM tokens
+		WHERE hash = $1 AND scope = $2 AND expiry > $3`
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	var expiry time.Time
+
+	err := m.DB.QueryRowContext(ctx, query, tokenHash[:], scope, time.Now()).Scan(&expiry)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return time.Time{}, ErrRecordNotFound
+		default:
+			return time.Time{}, err
+		}
+	}
+
+	return expiry, nil
+}
+
+// DeleteForUser() deletes a single token, identified by its plaintext, for a specific user and scope.
+// The user ID is part of the predicate so that a user can never delete another user's token.
+// ErrRecordNotFound is returned if no matching token exists.
+func (m TokenModel) DeleteForUser(scope string, userID int64, tokenPlaintext string) error {
+	var rows int64
+	defer observeQueryRows(m.QueryLog, "tokens.delete_for_user", time.Now(), &rows)
+
+	// Tokens are stored as a SHA-256 hash, so hash the plaintext before looking it up.
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		DELETE FROM tokens
+		WHERE hash = $1 AND scope = $2 AND user_id = $3`
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, tokenHash[:], scope, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	rows = rowsAffected
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// generateToken() creates a token with the given number of random bytes read from the random source.
+// In production the source is the OS's CSPRNG. A fixed source gives a known token, which is useful in tests.
+func generateToken(userID int64, ttl time.Duration, scope string, entropy int, random io.Reader) (*Token, error) {
 	// Create a Token instance containing the user ID, expiry, and scope information.
 	// We add the provided ttl (time-to-live) duration parameter to the current time to get the expiry time.
 	token := &Token{
@@ -91,13 +339,14 @@ func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error
 		Scope:  scope,
 	}
 
-	// Initialize a zero-valued byte slice with a length of 16 bytes.
-	// This gives our tokens 128-bits (16 bytes) of entropy (randomness).
-	randomBytes := make([]byte, 16)
+	// Initialize a zero-valued byte slice with a length of entropy bytes.
+	// By default, this gives our tokens 128-bits (16 bytes) of entropy (randomness).
+	randomBytes := make([]byte, tokenEntropy(entropy))
 
-	// Use the Read() function from the crypto/rand package to fill the byte slice with random bytes from the OS's
-	// cryptographically secure random number generator (CSPRNG). This returns an error if CSPRNG fails to function properly.
-	_, err := rand.Read(randomBytes)
+	// Fill the byte slice with random bytes from the random source, by default the OS's cryptographically secure
+	// random number generator (CSPRNG) from the crypto/rand package. This returns an error if the CSPRNG fails to
+	// function properly. io.ReadFull() makes sure that the slice is filled, even if the source returns fewer bytes.
+	_, err := io.ReadFull(random, randomBytes)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,284 @@
+package data
+
+import (
+	"bytes"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jseow5177/greenlight/internal/testdb"
+	"github.com/jseow5177/greenlight/internal/validator"
+)
+
+func TestTokenEntropy(t *testing.T) {
+	tests := []struct {
+		entropy int
+		length  int
+	}{
+		{16, 26},
+		{32, 52},
+	}
+
+	for _, tt := range tests {
+		if got := TokenPlaintextLength(tt.entropy); got != tt.length {
+			t.Errorf("TokenPlaintextLength(%d) = %d; want %d", tt.entropy, got, tt.length)
+		}
+
+		token, err := generateToken(1, time.Hour, ScopeActivation, tt.entropy, rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(token.Plaintext) != tt.length {
+			t.Errorf("entropy %d: got a %d-character token; want %d", tt.entropy, len(token.Plaintext), tt.length)
+		}
+
+		v := validator.New()
+		ValidateTokenPlaintext(v, token.Plaintext, tt.entropy)
+		if !v.Valid() {
+			t.Errorf("entropy %d: generated token is rejected: %v", tt.entropy, v.Errors)
+		}
+
+		for _, other := range tests {
+			if other.length == tt.length {
+				continue
+			}
+
+			// A well-formed base32 token of the other length, so that only its length can be rejected.
+			v := validator.New()
+			ValidateTokenPlaintext(v, strings.Repeat("A", other.length), tt.entropy)
+			if v.Valid() {
+				t.Errorf("entropy %d: a %d-character token is accepted", tt.entropy, other.length)
+			}
+		}
+	}
+}
+
+func TestGenerateTokenIsRandom(t *testing.T) {
+	// generateToken() must read from the CSPRNG, so tokens never repeat (the odds of a collision of 128-bit tokens
+	// are negligible).
+	seen := make(map[string]bool)
+
+	for i := 0; i < 1000; i++ {
+		token, err := generateToken(1, time.Hour, ScopeActivation, 0, rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if seen[token.Plaintext] {
+			t.Fatalf("token %q was generated twice", token.Plaintext)
+		}
+		seen[token.Plaintext] = true
+	}
+}
+
+func TestGenerateTokenFixedReader(t *testing.T) {
+	// A fixed source gives a known token: the bytes 0x00 to 0x0f.
+	random := bytes.NewReader([]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15})
+
+	m := TokenModel{DB: sql.OpenDB(new(stubPool)), Rand: random}
+
+	// The insert fails without a database, but the token has been generated by then.
+	token, err := m.New(1, time.Hour, ScopeAuthentication)
+	if !errors.Is(err, errStub) {
+		t.Fatalf("got error %v; want %v", err, errStub)
+	}
+
+	if want := "AAAQEAYEAUDAOCAJBIFQYDIOB4"; token.Plaintext != want {
+		t.Errorf("got token %q; want %q", token.Plaintext, want)
+	}
+	if want := "b3f0010fec117d12f0a1d428855f4e1b64bcc4ab28c8cfc4b168d4b677a37578"; hex.EncodeToString(token.Hash) != want {
+		t.Errorf("got hash %x; want %s", token.Hash, want)
+	}
+
+	// The source is exhausted, so the next token can't be generated.
+	_, err = generateToken(1, time.Hour, ScopeAuthentication, 0, random)
+	if !errors.Is(err, io.EOF) {
+		t.Errorf("got error %v; want %v", err, io.EOF)
+	}
+}
+
+func TestTruncateUserAgent(t *testing.T) {
+	long := strings.Repeat("a", maxUserAgentLength-1) + "é" // "é" is 2 bytes, straddling the limit
+
+	tests := []struct {
+		name      string
+		userAgent string
+		want      string
+	}{
+		{"short", "curl/8.0", "curl/8.0"},
+		{"exact", strings.Repeat("a", maxUserAgentLength), strings.Repeat("a", maxUserAgentLength)},
+		{"multi-byte rune at the limit", long, strings.Repeat("a", maxUserAgentLength-1)},
+		{"invalid UTF-8", "curl\xff/8.0", "curl/8.0"},
+	}
+
+	for _, tt := range tests {
+		got := truncateUserAgent(tt.userAgent)
+		if got != tt.want {
+			t.Errorf("%s: got %q; want %q", tt.name, got, tt.want)
+		}
+		if !utf8.ValidString(got) || len(got) > maxUserAgentLength {
+			t.Errorf("%s: got %d bytes of valid UTF-8 %t", tt.name, len(got), utf8.ValidString(got))
+		}
+	}
+}
+
+func TestTokenModelSessions(t *testing.T) {
+	m := NewModels(testdb.Open(t), 0)
+
+	user := &User{Name: "Test", Email: "alice@example.com", Activated: true}
+	err := user.Password.Set("pa55word-for-tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Users.Insert(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userAgent := strings.Repeat("ü", maxUserAgentLength)
+	token, err := m.Tokens.NewForClient(user.ID, time.Hour, ScopeAuthentication, userAgent, "203.0.113.7")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, err := m.Tokens.ListForUser(ScopeAuthentication, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 {
+		t.Fatalf("got %d sessions; want 1", len(sessions))
+	}
+
+	session := sessions[0]
+	if session.UserAgent != token.UserAgent || len(session.UserAgent) != maxUserAgentLength {
+		t.Errorf("got user agent of %d bytes; want the %d-byte truncated one", len(session.UserAgent), maxUserAgentLength)
+	}
+	if session.IP != "203.0.113.7" {
+		t.Errorf("got IP %q; want %q", session.IP, "203.0.113.7")
+	}
+	if strings.Contains(token.Plaintext, session.ID) || session.ID == hex.EncodeToString(token.Hash) {
+		t.Errorf("session ID %q reveals the token", session.ID)
+	}
+}
+
+func TestTokenModelDeleteOldestForUser(t *testing.T) {
+	db := testdb.Open(t)
+	m := NewModels(db, 0)
+
+	var users []*User
+	for _, email := range []string{"alice@example.com", "bob@example.com"} {
+		user := &User{Name: "Test", Email: email, Activated: true}
+		err := user.Password.Set("pa55word-for-tests")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = m.Users.Insert(user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, user)
+	}
+	alice, bob := users[0], users[1]
+
+	// Create the tokens of alice oldest first. created_at only has a precision of a second, so set it explicitly.
+	var tokens []*Token
+	for i := 0; i < 4; i++ {
+		token, err := m.Tokens.New(alice.ID, time.Hour, ScopeAuthentication)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = db.Exec("UPDATE tokens SET created_at = NOW() - $1 * INTERVAL '1 minute' WHERE hash = $2", 10-i, token.Hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tokens = append(tokens, token)
+	}
+
+	bobToken, err := m.Tokens.New(bob.ID, time.Hour, ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+	activation, err := m.Tokens.New(alice.ID, time.Hour, ScopeActivation)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := m.Tokens.CountForUser(ScopeAuthentication, alice.ID)
+	if err != nil || count != 4 {
+		t.Fatalf("before: got a count of %d, error %v; want 4", count, err)
+	}
+
+	err = m.Tokens.DeleteOldestForUser(ScopeAuthentication, alice.ID, 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count, err = m.Tokens.CountForUser(ScopeAuthentication, alice.ID)
+	if err != nil || count != 2 {
+		t.Errorf("after: got a count of %d, error %v; want 2", count, err)
+	}
+
+	// The two oldest tokens are deleted, and the newest kept.
+	for i, token := range tokens {
+		_, err := m.Users.GetForToken(ScopeAuthentication, token.Plaintext)
+		if deleted := errors.Is(err, ErrRecordNotFound); deleted != (i < 2) {
+			t.Errorf("token %d: got deleted %t (error %v); want %t", i, deleted, err, i < 2)
+		}
+	}
+
+	// Other users and other scopes are left alone.
+	if _, err := m.Users.GetForToken(ScopeAuthentication, bobToken.Plaintext); err != nil {
+		t.Errorf("the token of another user: %v", err)
+	}
+	if _, err := m.Users.GetForToken(ScopeActivation, activation.Plaintext); err != nil {
+		t.Errorf("the activation token: %v", err)
+	}
+}
+
+func TestTokenModelDeleteOldestForUserKeepsCurrent(t *testing.T) {
+	m := NewModels(testdb.Open(t), 0)
+
+	user := &User{Name: "Test", Email: "alice@example.com", Activated: true}
+	err := user.Password.Set("pa55word-for-tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Users.Insert(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The tokens are created within the same second, so their created_at values tie.
+	var tokens []*Token
+	for i := 0; i < 3; i++ {
+		token, err := m.Tokens.New(user.ID, time.Hour, ScopeAuthentication)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens = append(tokens, token)
+	}
+
+	// Keep the first token as the current one, although the others would be kept by the order of creation.
+	err = m.Tokens.DeleteOldestForUser(ScopeAuthentication, user.ID, 1, tokens[0].Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.Users.GetForToken(ScopeAuthentication, tokens[0].Plaintext); err != nil {
+		t.Errorf("the current token was deleted: %v", err)
+	}
+
+	count, err := m.Tokens.CountForUser(ScopeAuthentication, user.ID)
+	if err != nil || count != 1 {
+		t.Errorf("got a count of %d, error %v; want 1", count, err)
+	}
+}
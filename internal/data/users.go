@@ -1,11 +1,13 @@
 package data
 
 import (
-	"context"
+	"crypto/sha256"
 	"database/sql"
 	"errors"
 	"time"
 
+	"github.com/jseow5177/greenlight/internal/i18n"
+	"github.com/jseow5177/greenlight/internal/strength"
 	"github.com/jseow5177/greenlight/internal/validator"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -14,6 +16,9 @@ var (
 	ErrDuplicateEmail = errors.New("duplicate email")
 )
 
+// AnonymousUser represents a client which has not provided an authentication token.
+var AnonymousUser = &User{}
+
 // A custom password type.
 // The plaintext field is a pointer to a string so that we can distinguish between an a plaintext password not
 // present in the struct, versus a plaintext password which is an empty string.
@@ -27,15 +32,23 @@ type User struct {
 	ID int64 `json:"id"`
 	CreatedAt time.Time `json:"created_at"`
 	Name string `json:"name"`
-	Email string `json:"emai"`
+	Email string `json:"email"`
 	Password password `json:"-"` // private field
 	Activated bool `json:"activated"`
 	Version int `json:"-"` // private field
 }
 
+// IsAnonymous() checks if a User instance is the AnonymousUser.
+func (u *User) IsAnonymous() bool {
+	return u == AnonymousUser
+}
+
 // Define a UserModel that wraps around a sql.DB connection pool
 type UserModel struct {
-	DB *sql.DB
+	DB                   *sql.DB
+	Timeout              time.Duration // Timeout for each query, defaults to 3 seconds if not set
+	SerializationRetries int           // Retries of an update which fails with a serialization failure, 0 disables them
+	QueryLog             QueryLogFunc  // Called after every query to log it, nil to disable
 }
 
 func ValidateEmail(v *validator.Validator, email string) {
@@ -45,13 +58,37 @@ func ValidateEmail(v *validator.Validator, email string) {
 
 func ValidatePassword(v *validator.Validator, password string) {
 	v.Check(password != "", "password", "must be provided")
-	v.Check(len(password) >= 8, "password", "must be at least 8 bytes long")
-	v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long")
+	v.Checkf(len(password) >= 8, "password", "must be at least %d bytes long", 8)
+	v.Checkf(len(password) <= 72, "password", "must not be more than %d bytes long", 72)
+}
+
+// ValidatePasswordStrength() rejects a new password whose estimated strength is below minScore, from 0 to 4 (set
+// with the -password-min-score flag), with feedback on how to make it stronger. A minScore of 0 accepts any
+// password which satisfies the length bounds. The user inputs (such as the user's name and email address) count
+// as very common words. It only runs when the password satisfies the length bounds, which remain the hard floor.
+// It is not used when logging in, so that existing passwords keep working when the minimum is raised.
+func ValidatePasswordStrength(v *validator.Validator, password string, minScore int, userInputs ...string) {
+	if minScore <= 0 || len(password) < 8 || len(password) > 72 {
+		return
+	}
+
+	result := strength.Estimate(password, userInputs...)
+	if result.Score >= minScore {
+		return
+	}
+
+	feedback := result.Feedback
+	if result.Warning != "" {
+		feedback = append([]string{result.Warning}, feedback...)
+	}
+
+	// The feedback is passed as an i18n.List, so that each suggestion is translated along with the message.
+	v.AddErrorf("password", "is too weak: %s", i18n.List(feedback))
 }
 
-func ValidateUser(v *validator.Validator, user *User) {
+func ValidateUser(v *validator.Validator, user *User, minPasswordScore int) {
 	v.Check(user.Name != "", "name", "must be provided")
-	v.Check(len(user.Name) <= 500, "name", "must not be more than 500 bytes long")
+	v.Checkf(len(user.Name) <= 500, "name", "must not be more than %d bytes long", 500)
 
 	// Validate email
 	ValidateEmail(v, user.Email)
@@ -59,6 +96,7 @@ func ValidateUser(v *validator.Validator, user *User) {
 	// If plaintext password is not nil, validate password
 	if user.Password.plaintext != nil {
 		ValidatePassword(v, *user.Password.plaintext)
+		ValidatePasswordStrength(v, *user.Password.plaintext, minPasswordScore, user.Name, user.Email)
 	}
 
 	// If the password hash is ever nil, this will be due to a logic error in our code base. 
@@ -107,6 +145,17 @@ func (p *password) Matches(plaintextPassword string) (bool, error) {
 // Insert a new record in the database for the user. The id, created_at and version fields
 // are generated by the database, so we use the RETURNING clause to read them into the user struct.
 func (m UserModel) Insert(user *User) error {
+	return m.insert(m.DB, user)
+}
+
+// InsertTx() inserts a new record for the user as part of the provided transaction.
+func (m UserModel) InsertTx(tx *sql.Tx, user *User) error {
+	return m.insert(tx, user)
+}
+
+func (m UserModel) insert(q queryer, user *User) error {
+	defer observeQuery(m.QueryLog, "users.insert", time.Now())
+
 	query := `
 		INSERT INTO users (name, email, password_hash, activated)
 		VALUES ($1, $2, $3, $4)
@@ -114,32 +163,49 @@ func (m UserModel) Insert(user *User) error {
 
 	args := []interface{}{user.Name, user.Email, user.Password.hash, user.Activated}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3 * time.Second)
+	ctx, cancel := queryContext(m.Timeout)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Activated)
+	err := q.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
 	if err != nil {
-		switch {
-		// Check if there is a violation of the UNIQUE constraint of email field
-		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
-			return ErrDuplicateEmail
-		default:
-			return err
-		}
+		// A violation of the UNIQUE constraint of the email field is returned as ErrDuplicateEmail.
+		return pgError(err)
 	}
 
 	return nil
 }
 
+// EmailExists() reports whether a user is registered with the email address. The email column is citext,
+// so the comparison is case-insensitive, like the UNIQUE constraint which rejects duplicate registrations.
+func (m UserModel) EmailExists(email string) (bool, error) {
+	defer observeQuery(m.QueryLog, "users.email_exists", time.Now())
+
+	query := `SELECT EXISTS(SELECT 1 FROM users WHERE email = $1)`
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	var exists bool
+
+	err := m.DB.QueryRowContext(ctx, query, email).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+
+	return exists, nil
+}
+
 // Retrive the User details from the database based on the user's email address.
 // The query is expected to return only one record, or none at all (which we will return ErrRecordNotFound)
 func (m UserModel) GetByEmail(email string) (*User, error) {
+	defer observeQuery(m.QueryLog, "users.get_by_email", time.Now())
+
 	query := `
 		SELECT id, created_at, email, password_hash, activated, version
 		FROM users
 		WHERE email = $1`
 	
-	ctx, cancel := context.WithTimeout(context.Background(), 3 * time.Second)
+	ctx, cancel := queryContext(m.Timeout)
 	defer cancel()
 
 	user := new(User)
@@ -165,9 +231,48 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 	return user, nil
 }
 
+// GetByID() retrieves a specific user by ID. ErrRecordNotFound is returned if the user doesn't exist.
+func (m UserModel) GetByID(id int64) (*User, error) {
+	defer observeQuery(m.QueryLog, "users.get_by_id", time.Now())
+
+	query := `
+		SELECT id, created_at, name, email, password_hash, activated, version
+		FROM users
+		WHERE id = $1`
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	user := new(User)
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return user, nil
+}
+
 // Update the details of a specific user. We check against the version field to help prevent race conditions.
 // We also check for a violation of the "users_email_key" constraint when performing the update.
 func (m UserModel) Update(user *User) error {
+	var rows int64
+	defer observeQueryRows(m.QueryLog, "users.update", time.Now(), &rows)
+
 	query := `
 		UPDATE users
 		SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
@@ -179,25 +284,70 @@ func (m UserModel) Update(user *User) error {
 		user.Email,
 		user.Password.hash,
 		user.Activated,
-		user.Version,
 		user.ID,
 		user.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3 *time.Second)
-	defer cancel()
+	// A serialization failure is retried, each attempt with its own query timeout.
+	err := retrySerializable(m.SerializationRetries, func() error {
+		ctx, cancel := queryContext(m.Timeout)
+		defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
+		return m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
+	})
 	if err != nil {
 		switch {
-			case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
-				return ErrDuplicateEmail
 			case errors.Is(err, sql.ErrNoRows):
 				return ErrEditConflict
 			default:
-				return err
+				return pgError(err)
 		}
 	}
+	rows = 1
 
 	return nil
-}
\ No newline at end of file
+}
+
+// GetForToken() retrieves the user associated with a token of a specific scope.
+// Only tokens which have not expired are considered. ErrRecordNotFound is returned if there is no match.
+func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
+	defer observeQuery(m.QueryLog, "users.get_for_token", time.Now())
+
+	// Calculate the SHA-256 hash of the plaintext token provided by the client.
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
+		FROM users
+		INNER JOIN tokens ON users.id = tokens.user_id
+		WHERE tokens.hash = $1 AND tokens.scope = $2 AND tokens.expiry > $3`
+
+	// sha256.Sum256() returns an array, so convert it to a slice before passing it to the query.
+	args := []interface{}{tokenHash[:], tokenScope, time.Now()}
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	user := new(User)
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return user, nil
+}
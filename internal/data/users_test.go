@@ -0,0 +1,115 @@
+package data
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jseow5177/greenlight/internal/i18n"
+	"github.com/jseow5177/greenlight/internal/testdb"
+	"github.com/jseow5177/greenlight/internal/validator"
+)
+
+func TestValidatePasswordStrength(t *testing.T) {
+	tests := []struct {
+		minScore int
+		password string
+		valid    bool
+	}{
+		{0, "password", true}, // The check is off by default
+		{3, "password", false},
+		{3, "alice-smith-2016", false},
+		{3, "correct horse battery staple", true},
+		{4, "kq9vl2xr7m", false},
+		{4, "kq9vl2xr7mw", true},
+	}
+
+	for _, tt := range tests {
+		v := validator.New()
+		ValidatePasswordStrength(v, tt.password, tt.minScore, "Alice Smith", "alice@example.com")
+		if v.Valid() != tt.valid {
+			t.Errorf("score %d, %q: got valid %t; want %t (%v)", tt.minScore, tt.password, v.Valid(), tt.valid, v.Errors)
+		}
+	}
+}
+
+func TestValidatePasswordStrengthIsTranslated(t *testing.T) {
+	v := validator.New()
+	ValidatePasswordStrength(v, "password", 3, "Alice Smith", "alice@example.com")
+
+	want := "is too weak: this is a top-10 common password; add another word or two, uncommon words are better"
+	if v.Errors["password"] != want {
+		t.Errorf("got %q; want %q", v.Errors["password"], want)
+	}
+
+	translated := v.Translate(func(format string, args ...interface{}) string {
+		return i18n.Translate("es", format, args...)
+	})
+
+	want = "es demasiado débil: es una de las 10 contraseñas más comunes; añada una o dos palabras más, mejor si son poco comunes"
+	if translated.Errors["password"] != want {
+		t.Errorf("got %q; want %q", translated.Errors["password"], want)
+	}
+}
+
+func TestUserModelGetByID(t *testing.T) {
+	m := NewModels(testdb.Open(t), 0)
+
+	user := &User{Name: "Alice", Email: "alice@example.com", Activated: true}
+	err := user.Password.Set("pa55word-for-tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Users.Insert(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := m.Users.GetByID(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != user.ID || got.Name != user.Name || got.Email != user.Email || !got.Activated || got.Version != user.Version {
+		t.Errorf("got %+v; want %+v", got, user)
+	}
+	if match, err := got.Password.Matches("pa55word-for-tests"); err != nil || !match {
+		t.Errorf("the password hash wasn't read: match %t, error %v", match, err)
+	}
+
+	_, err = m.Users.GetByID(user.ID + 1)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("missing user: got error %v; want %v", err, ErrRecordNotFound)
+	}
+}
+
+func TestUserModelEmailExists(t *testing.T) {
+	m := NewModels(testdb.Open(t), 0)
+
+	user := &User{Name: "Alice", Email: "alice@example.com", Activated: true}
+	err := user.Password.Set("pa55word-for-tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Users.Insert(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		email string
+		want  bool
+	}{
+		{"alice@example.com", true},
+		{"ALICE@Example.com", true}, // Email addresses are compared case-insensitively
+		{"bob@example.com", false},
+	}
+
+	for _, tt := range tests {
+		got, err := m.Users.EmailExists(tt.email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("EmailExists(%q) = %t; want %t", tt.email, got, tt.want)
+		}
+	}
+}
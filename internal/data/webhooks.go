@@ -0,0 +1,284 @@
+package data
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jseow5177/greenlight/internal/validator"
+	"github.com/lib/pq"
+)
+
+// Define constants for the movie lifecycle events that subscribers can register for.
+const (
+	EventMovieCreated = "movie.created"
+	EventMovieUpdated = "movie.updated"
+	EventMovieDeleted = "movie.deleted"
+)
+
+// WebhookEvents holds all the event types that a webhook can subscribe to.
+var WebhookEvents = []string{EventMovieCreated, EventMovieUpdated, EventMovieDeleted}
+
+// A Webhook struct to represent a single subscription.
+// The secret is never included in JSON responses. It is only handed to the client once, when the
+// subscription is registered.
+type Webhook struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"-"`
+	Version   int32     `json:"version"`
+	UserID    int64     `json:"-"` // ID of the user who registered the webhook, 0 for webhooks registered before owners were recorded
+}
+
+// Define a WebhookModel struct type which wraps a sql.DB connection pool.
+type WebhookModel struct {
+	DB       *sql.DB
+	Timeout  time.Duration // Timeout for each query, defaults to 3 seconds if not set
+	QueryLog QueryLogFunc  // Called after every query to log it, nil to disable
+}
+
+func ValidateWebhook(v *validator.Validator, webhook *Webhook) {
+	v.Check(webhook.URL != "", "url", "must be provided")
+	v.Checkf(len(webhook.URL) <= 2048, "url", "must not be more than %d bytes long", 2048)
+
+	// The subscriber URL must be absolute and use either the http or https scheme.
+	v.Check(validator.ValidURL(webhook.URL), "url", "must be a valid http or https URL")
+
+	// The application POSTs to the subscriber URL, so it must not point at an internal service.
+	// Host names are only checked when a delivery connects, since they can resolve to anything.
+	v.Check(publicWebhookHost(webhook.URL), "url", "must not point to a private, loopback or link-local address")
+
+	v.Check(webhook.Events != nil, "events", "must be provided")
+	v.Check(len(webhook.Events) >= 1, "events", "must contain at least 1 event")
+	v.Check(validator.Unique(webhook.Events), "events", "must not contain duplicate values")
+
+	for _, event := range webhook.Events {
+		v.Check(validator.In(event, WebhookEvents...), "events", "must only contain supported events")
+	}
+}
+
+// nonPublicNetworks holds the networks which webhooks must not be delivered to: private, loopback, link-local
+// (such as the 169.254.169.254 cloud metadata service), shared and reserved addresses, and multicast.
+var nonPublicNetworks = mustParseCIDRs(
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16", "172.16.0.0/12",
+	"192.0.0.0/24", "192.168.0.0/16", "198.18.0.0/15", "224.0.0.0/4", "240.0.0.0/4",
+	"::/128", "::1/128", "fc00::/7", "fe80::/10", "ff00::/8",
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+
+	return networks
+}
+
+// PublicIP() reports whether a webhook may be delivered to the IP address, i.e. whether it is outside of the
+// private, loopback, link-local and reserved networks. IPv4-mapped IPv6 addresses are checked as IPv4 addresses.
+func PublicIP(ip net.IP) bool {
+	for _, network := range nonPublicNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// publicWebhookHost() reports whether the host of a webhook URL may be public: a public IP address, or a host
+// name other than localhost. The addresses a host name resolves to are checked by the delivery client instead.
+func publicWebhookHost(value string) bool {
+	u, err := url.Parse(value)
+	if err != nil {
+		return false
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		return PublicIP(ip)
+	}
+
+	return true
+}
+
+// Sign() returns the hex-encoded HMAC-SHA256 signature of the payload, using the webhook secret as the key.
+// Subscribers recompute the signature over the raw request body to verify that the delivery came from us.
+func (w *Webhook) Sign(payload []byte) string {
+	return SignWebhookPayload(w.Secret, payload)
+}
+
+// SignWebhookPayload() computes the hex-encoded HMAC-SHA256 signature of a payload with the given secret.
+func SignWebhookPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhookSignature() reports whether the signature is valid for the payload and secret.
+// hmac.Equal() is used to compare the signatures in constant time (to mitigate the risks of timing attacks).
+func VerifyWebhookSignature(secret string, payload []byte, signature string) bool {
+	expected := SignWebhookPayload(secret, payload)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+// generateWebhookSecret() returns a hex-encoded secret with 256 bits of entropy read from the CSPRNG.
+func generateWebhookSecret() (string, error) {
+	randomBytes := make([]byte, 32)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(randomBytes), nil
+}
+
+// Insert() generates a new secret for the webhook and adds it to the webhooks table, owned by webhook.UserID.
+// The id, created_at and version fields are generated by the database.
+func (m WebhookModel) Insert(webhook *Webhook) error {
+	defer observeQuery(m.QueryLog, "webhooks.insert", time.Now())
+
+	secret, err := generateWebhookSecret()
+	if err != nil {
+		return err
+	}
+	webhook.Secret = secret
+
+	query := `
+		INSERT INTO webhooks (url, events, secret, user_id)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, version`
+
+	args := []interface{}{webhook.URL, pq.Array(webhook.Events), webhook.Secret, webhook.UserID}
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&webhook.ID, &webhook.CreatedAt, &webhook.Version)
+}
+
+// GetAllForUser() returns the webhooks registered by a specific user, ordered by ID.
+func (m WebhookModel) GetAllForUser(userID int64) ([]*Webhook, error) {
+	defer observeQuery(m.QueryLog, "webhooks.get_all_for_user", time.Now())
+
+	query := `
+		SELECT id, created_at, url, events, secret, version, user_id
+		FROM webhooks
+		WHERE user_id = $1
+		ORDER BY id ASC`
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	return m.query(ctx, query, userID)
+}
+
+// GetAllForEvent() returns the webhooks that have subscribed to a specific event.
+func (m WebhookModel) GetAllForEvent(event string) ([]*Webhook, error) {
+	defer observeQuery(m.QueryLog, "webhooks.get_all_for_event", time.Now())
+
+	query := `
+		SELECT id, created_at, url, events, secret, version, user_id
+		FROM webhooks
+		WHERE events @> $1
+		ORDER BY id ASC`
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	return m.query(ctx, query, pq.Array([]string{event}))
+}
+
+// query() executes a SELECT query and scans every row of the resultset into a Webhook struct.
+func (m WebhookModel) query(ctx context.Context, query string, args ...interface{}) ([]*Webhook, error) {
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []*Webhook{}
+
+	for rows.Next() {
+		webhook := new(Webhook)
+
+		// Webhooks registered before owners were recorded have a NULL user_id.
+		var userID sql.NullInt64
+
+		err := rows.Scan(
+			&webhook.ID,
+			&webhook.CreatedAt,
+			&webhook.URL,
+			pq.Array(&webhook.Events),
+			&webhook.Secret,
+			&webhook.Version,
+			&userID,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		webhook.UserID = userID.Int64
+
+		webhooks = append(webhooks, webhook)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+// Delete() removes a specific webhook of a user from the webhooks table. A webhook which belongs to another user
+// is reported as ErrRecordNotFound, so that users can't find out which IDs exist.
+func (m WebhookModel) Delete(id, userID int64) error {
+	var rows int64
+	defer observeQueryRows(m.QueryLog, "webhooks.delete", time.Now(), &rows)
+
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM webhooks
+		WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	rows = rowsAffected
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,108 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/jseow5177/greenlight/internal/testdb"
+	"github.com/jseow5177/greenlight/internal/validator"
+)
+
+func TestWebhookSignature(t *testing.T) {
+	payload := []byte(`{"event":"movie.created"}`)
+
+	signature := SignWebhookPayload("secret", payload)
+
+	if !VerifyWebhookSignature("secret", payload, signature) {
+		t.Error("signature of the payload isn't valid")
+	}
+	if VerifyWebhookSignature("other secret", payload, signature) {
+		t.Error("signature is valid with another secret")
+	}
+	if VerifyWebhookSignature("secret", []byte(`{"event":"movie.deleted"}`), signature) {
+		t.Error("signature is valid for another payload")
+	}
+}
+
+func TestValidateWebhook(t *testing.T) {
+	tests := []struct {
+		name    string
+		webhook Webhook
+		valid   bool
+	}{
+		{"valid", Webhook{URL: "https://example.com/hook", Events: []string{EventMovieCreated}}, true},
+		{"no URL", Webhook{Events: []string{EventMovieCreated}}, false},
+		{"relative URL", Webhook{URL: "/hook", Events: []string{EventMovieCreated}}, false},
+		{"ftp URL", Webhook{URL: "ftp://example.com/hook", Events: []string{EventMovieCreated}}, false},
+		{"public IP", Webhook{URL: "http://203.0.113.5/hook", Events: []string{EventMovieCreated}}, true},
+		{"loopback IP", Webhook{URL: "http://127.0.0.1:8080/hook", Events: []string{EventMovieCreated}}, false},
+		{"localhost", Webhook{URL: "http://localhost/hook", Events: []string{EventMovieCreated}}, false},
+		{"private IP", Webhook{URL: "https://10.1.2.3/hook", Events: []string{EventMovieCreated}}, false},
+		{"metadata service", Webhook{URL: "http://169.254.169.254/latest/meta-data", Events: []string{EventMovieCreated}}, false},
+		{"IPv6 loopback", Webhook{URL: "http://[::1]/hook", Events: []string{EventMovieCreated}}, false},
+		{"IPv4-mapped loopback", Webhook{URL: "http://[::ffff:127.0.0.1]/hook", Events: []string{EventMovieCreated}}, false},
+		{"no events", Webhook{URL: "https://example.com/hook", Events: []string{}}, false},
+		{"unknown event", Webhook{URL: "https://example.com/hook", Events: []string{"movie.watched"}}, false},
+		{"duplicate events", Webhook{URL: "https://example.com/hook", Events: []string{EventMovieCreated, EventMovieCreated}}, false},
+	}
+
+	for _, tt := range tests {
+		v := validator.New()
+		ValidateWebhook(v, &tt.webhook)
+		if v.Valid() != tt.valid {
+			t.Errorf("%s: got valid %t; want %t (%v)", tt.name, v.Valid(), tt.valid, v.Errors)
+		}
+	}
+}
+
+func TestWebhookModelOwnership(t *testing.T) {
+	db := testdb.Open(t)
+	m := NewModels(db, 0)
+
+	var users [2]*User
+	for i, email := range []string{"alice@example.com", "bob@example.com"} {
+		users[i] = &User{Name: "Test", Email: email, Activated: true}
+		err := users[i].Password.Set("pa55word-for-tests")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = m.Users.Insert(users[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	webhook := &Webhook{URL: "https://example.com/hook", Events: []string{EventMovieCreated}, UserID: users[0].ID}
+	err := m.Webhooks.Insert(webhook)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(webhook.Secret) != 64 {
+		t.Errorf("got a %d-character secret; want 64", len(webhook.Secret))
+	}
+
+	webhooks, err := m.Webhooks.GetAllForUser(users[1].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(webhooks) != 0 {
+		t.Errorf("other user has %d webhooks; want 0", len(webhooks))
+	}
+
+	webhooks, err = m.Webhooks.GetAllForEvent(EventMovieCreated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(webhooks) != 1 || webhooks[0].UserID != users[0].ID {
+		t.Errorf("got webhooks %v for the event; want the owner's webhook", webhooks)
+	}
+
+	err = m.Webhooks.Delete(webhook.ID, users[1].ID)
+	if err != ErrRecordNotFound {
+		t.Errorf("delete by another user: got error %v; want ErrRecordNotFound", err)
+	}
+
+	err = m.Webhooks.Delete(webhook.ID, users[0].ID)
+	if err != nil {
+		t.Errorf("delete by owner: got error %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package i18n
+
+// catalog holds the translations of the error and validation messages, by locale and then by English message.
+// Messages with arguments are keyed by their format, and the translation must use the same verbs in the same order.
+// To add a locale, add a map of its translations here. Missing messages fall back to English.
+var catalog = map[string]map[string]string{
+	"es": {
+		// Error responses
+		"the requested resource could not be found":                             "no se pudo encontrar el recurso solicitado",
+		"the %s method is not supported for this resource":                      "el método %s no está permitido para este recurso",
+		"the server encountered a problem and could not process your request":   "el servidor encontró un problema y no pudo procesar su solicitud",
+		"the server is temporarily overloaded, please try again later":          "el servidor está sobrecargado temporalmente, inténtelo de nuevo más tarde",
+		"unable to update the record due to an edit conflict, please try again": "no se pudo actualizar el registro debido a un conflicto de edición, inténtelo de nuevo",
+		"the Content-Type header must be application/json":                      "la cabecera Content-Type debe ser application/json",
+		"the X-On-Duplicate header must be error or return":                     "la cabecera X-On-Duplicate debe ser error o return",
+		"rate limit exceeded":                                                              "se superó el límite de solicitudes",
+		"the request URI is too long":                                                      "la URI de la solicitud es demasiado larga",
+		"the query string must not have more than %d parameters":                           "la cadena de consulta no debe tener más de %d parámetros",
+		"invalid authentication credentials":                                               "credenciales de autenticación no válidas",
+		"invalid or missing authentication token":                                          "token de autenticación no válido o ausente",
+		"you must be authenticated to access this resource":                                "debe autenticarse para acceder a este recurso",
+		"your user account doesn't have the necessary permissions to access this resource": "su cuenta de usuario no tiene los permisos necesarios para acceder a este recurso",
+		"access to this resource is not allowed from your IP address":                      "el acceso a este recurso no está permitido desde su dirección IP",
+
+		// Warnings
+		"movie %v not found": "no se encontró la película %v",
+
+		// Validation rules
+		"must be one of %s":                                                   "debe ser uno de %s",
+		"is too weak: %s":                                                     "es demasiado débil: %s",
+		"must be provided":                                                    "es obligatorio",
+		"must be greater than zero":                                           "debe ser mayor que cero",
+		"must be greater than %d":                                             "debe ser mayor que %d",
+		"must not be negative":                                                "no debe ser negativo",
+		"must be a positive integer":                                          "debe ser un número entero positivo",
+		"must be an integer value":                                            "debe ser un número entero",
+		"must be a decimal value":                                             "debe ser un número decimal",
+		"must be a boolean value":                                             "debe ser un valor booleano",
+		"must be a maximum of %d":                                             "debe ser como máximo %d",
+		"must be a maximum of 10 million":                                     "debe ser como máximo 10 millones",
+		"must be %d bytes long":                                               "debe tener %d bytes",
+		"must be at least %d bytes long":                                      "debe tener al menos %d bytes",
+		"must not be more than %d bytes long":                                 "no debe tener más de %d bytes",
+		"must be a valid email address":                                       "debe ser una dirección de correo electrónico válida",
+		"must be a valid http or https URL":                                   "debe ser una URL http o https válida",
+		"must not be in the future":                                           "no debe estar en el futuro",
+		"must contain at least 1 genre":                                       "debe contener al menos 1 género",
+		"must contain at least %d genres":                                     "debe contener al menos %d géneros",
+		"must contain at least 1 event":                                       "debe contener al menos 1 evento",
+		"must not contain more than %d genres":                                "no debe contener más de %d géneros",
+		"must not contain more than %d tags":                                  "no debe contener más de %d etiquetas",
+		"must not contain more than %d values":                                "no debe contener más de %d valores",
+		"must not contain duplicate values":                                   "no debe contener valores duplicados",
+		"must not contain empty values":                                       "no debe contener valores vacíos",
+		"must not contain a genre more than %d bytes long":                    "no debe contener un género de más de %d bytes",
+		"must not contain a tag more than %d bytes long":                      "no debe contener una etiqueta de más de %d bytes",
+		"must only contain supported events":                                  "solo debe contener eventos admitidos",
+		"must be a JPEG or PNG image":                                         "debe ser una imagen JPEG o PNG",
+		"must only contain valid IDs":                                         "solo debe contener IDs válidos",
+		"invalid sort value":                                                  "valor de ordenación no válido",
+		"invalid cursor":                                                      "cursor no válido",
+		"must be an RFC 3339 timestamp":                                       "debe ser una marca de tiempo RFC 3339",
+		"a movie with this title and year already exists":                     "ya existe una película con este título y año",
+		"a user with this email address already exists":                       "ya existe un usuario con esta dirección de correo electrónico",
+		"too deep, narrow the results with filters or a different sort order": "página demasiado profunda, acote los resultados con filtros o un orden diferente",
+
+		// Password strength feedback (internal/strength)
+		"a password must be provided":                                            "se debe proporcionar una contraseña",
+		"add another word or two, uncommon words are better":                     "añada una o dos palabras más, mejor si son poco comunes",
+		"use a few words and avoid common phrases":                               "use varias palabras y evite frases comunes",
+		"avoid using your name or email address":                                 "evite usar su nombre o su dirección de correo electrónico",
+		"this is a top-10 common password":                                       "es una de las 10 contraseñas más comunes",
+		"this is a top-100 common password":                                      "es una de las 100 contraseñas más comunes",
+		"this is similar to a commonly used password":                            "se parece a una contraseña muy usada",
+		"a word by itself is easy to guess":                                      "una palabra sola es fácil de adivinar",
+		"all-uppercase is almost as easy to guess as all-lowercase":              "todo en mayúsculas es casi tan fácil de adivinar como todo en minúsculas",
+		"capitalization doesn't help very much":                                  "usar mayúsculas no ayuda mucho",
+		"reversed words aren't much harder to guess":                             "las palabras al revés no son mucho más difíciles de adivinar",
+		"predictable substitutions like '@' instead of 'a' don't help very much": "las sustituciones previsibles como '@' en lugar de 'a' no ayudan mucho",
+		`repeats like "aaa" are easy to guess`:                                   `las repeticiones como "aaa" son fáciles de adivinar`,
+		`repeats like "abcabcabc" are only slightly harder to guess than "abc"`:  `las repeticiones como "abcabcabc" son solo un poco más difíciles de adivinar que "abc"`,
+		"avoid repeated words and characters":                                    "evite repetir palabras y caracteres",
+		"sequences like abc or 6543 are easy to guess":                           "las secuencias como abc o 6543 son fáciles de adivinar",
+		"avoid sequences":                               "evite las secuencias",
+		"straight rows of keys are easy to guess":       "las filas rectas de teclas son fáciles de adivinar",
+		"use a longer keyboard pattern with more turns": "use un patrón de teclado más largo y con más giros",
+		"recent years are easy to guess":                "los años recientes son fáciles de adivinar",
+		"avoid recent years":                            "evite los años recientes",
+		"avoid years that are associated with you":      "evite los años relacionados con usted",
+	},
+}
@@ -0,0 +1,107 @@
+package i18n
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultLocale is the locale used when the client doesn't accept any of the supported locales.
+// The messages in the code are written in this locale, so it needs no catalog.
+const DefaultLocale = "en"
+
+// Translate() returns the message in the given locale, formatted with the arguments (if any).
+// Messages are identified by their English text (or format, for a message with arguments), which is the key of
+// the catalog. A message which hasn't been translated to the locale is returned in English.
+func Translate(locale, format string, args ...interface{}) string {
+	if translated, ok := catalog[locale][format]; ok {
+		format = translated
+	}
+
+	if len(args) == 0 {
+		return format
+	}
+
+	// Arguments which are lists of messages are translated too. The caller's slice is left unchanged.
+	translatedArgs := make([]interface{}, len(args))
+	for i, arg := range args {
+		if list, ok := arg.(List); ok {
+			arg = list.translate(locale)
+		}
+		translatedArgs[i] = arg
+	}
+
+	return fmt.Sprintf(format, translatedArgs...)
+}
+
+// List is a message argument made of other messages, such as the feedback on a weak password. It is formatted as
+// the messages joined with "; ", and Translate() translates each message of the list as well as the format.
+type List []string
+
+// String() joins the untranslated messages of the list.
+func (l List) String() string {
+	return strings.Join(l, "; ")
+}
+
+func (l List) translate(locale string) string {
+	translated := make([]string, len(l))
+	for i, message := range l {
+		translated[i] = Translate(locale, message)
+	}
+	return strings.Join(translated, "; ")
+}
+
+// Negotiate() picks the locale to respond in from the value of an Accept-Language header, following the
+// quality values of the language ranges. A language range matches a locale by its primary subtag, so "es-MX"
+// matches "es". The default locale is returned if no supported locale is acceptable.
+func Negotiate(acceptLanguage string) string {
+	best := DefaultLocale
+	bestQuality := 0.0
+
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		fields := strings.Split(part, ";")
+
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		quality := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, err := strconv.ParseFloat(param[2:], 64)
+				if err != nil {
+					q = 0
+				}
+				quality = q
+			}
+		}
+
+		// Only the primary subtag is needed to pick a catalog.
+		if i := strings.IndexByte(tag, '-'); i >= 0 {
+			tag = tag[:i]
+		}
+
+		if !supported(tag) {
+			continue
+		}
+
+		// Ranges of equal quality are preferred in the order the client listed them.
+		if quality > bestQuality {
+			best = tag
+			bestQuality = quality
+		}
+	}
+
+	return best
+}
+
+func supported(locale string) bool {
+	if locale == DefaultLocale {
+		return true
+	}
+
+	_, ok := catalog[locale]
+	return ok
+}
@@ -0,0 +1,74 @@
+package i18n
+
+import (
+	"testing"
+
+	"github.com/jseow5177/greenlight/internal/strength"
+)
+
+func TestTranslate(t *testing.T) {
+	tests := []struct {
+		locale string
+		format string
+		args   []interface{}
+		want   string
+	}{
+		{"en", "rate limit exceeded", nil, "rate limit exceeded"},
+		{"es", "rate limit exceeded", nil, "se superó el límite de solicitudes"},
+		{"es", "must be a maximum of %d", []interface{}{5}, "debe ser como máximo 5"},
+		{"es", "not in the catalog", nil, "not in the catalog"},
+		{"fr", "rate limit exceeded", nil, "rate limit exceeded"},
+		{"en", "is too weak: %s", []interface{}{List{"avoid sequences", "avoid recent years"}}, "is too weak: avoid sequences; avoid recent years"},
+		{"es", "is too weak: %s", []interface{}{List{"avoid sequences", "avoid recent years"}}, "es demasiado débil: evite las secuencias; evite los años recientes"},
+	}
+
+	for _, tt := range tests {
+		if got := Translate(tt.locale, tt.format, tt.args...); got != tt.want {
+			t.Errorf("Translate(%q, %q) = %q; want %q", tt.locale, tt.format, got, tt.want)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		acceptLanguage string
+		want           string
+	}{
+		{"", "en"},
+		{"es", "es"},
+		{"es-MX,es;q=0.9", "es"},
+		{"fr, es;q=0.5", "es"},
+		{"es;q=0.5, en", "en"},
+		{"fr", "en"},
+	}
+
+	for _, tt := range tests {
+		if got := Negotiate(tt.acceptLanguage); got != tt.want {
+			t.Errorf("Negotiate(%q) = %q; want %q", tt.acceptLanguage, got, tt.want)
+		}
+	}
+}
+
+// Every warning and suggestion of the password strength estimate must have a translation, since they are sent to
+// clients inside the "is too weak" validation error.
+func TestStrengthFeedbackIsTranslated(t *testing.T) {
+	passwords := []string{
+		"", "password", "Password", "PASSWORD", "qwertyuiop", "drowssap", "p@ssw0rd", "movie", "aaaaaaaa", "abcabcabc",
+		"abcdefgh", "zxcvbnm,./", "1999", "kq9vl2", "alice2016",
+	}
+
+	for _, password := range passwords {
+		result := strength.Estimate(password, "Alice Smith", "alice@example.com")
+
+		messages := result.Feedback
+		if result.Warning != "" {
+			messages = append([]string{result.Warning}, messages...)
+		}
+
+		for _, message := range messages {
+			if _, ok := catalog["es"][message]; !ok {
+				t.Errorf("%q: %q has no es translation", password, message)
+			}
+		}
+	}
+}
@@ -2,9 +2,11 @@ package jsonlog
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"os"
 	"runtime/debug"
+	"strings"
 	"sync"
 	"time"
 )
@@ -17,17 +19,23 @@ type Level int8
 // It starts at zero, and increments by 1 for every constant declaration, resetting to zero
 // again when the word const appears in the code again.
 const (
-	LevelInfo Level = iota  // Has a value of 0
-	LevelError						 // Has a value of 1
-	LevelFatal						 // Has a value of 2
-	LevelOff							// Has a value of 3
+	LevelDebug Level = iota // Has a value of 0
+	LevelInfo						 // Has a value of 1
+	LevelWarn						 // Has a value of 2
+	LevelError						 // Has a value of 3
+	LevelFatal						 // Has a value of 4
+	LevelOff							// Has a value of 5
 )
 
 // Return a human-friendly string for the severity level
 func (l Level) String() string {
 	switch l {
+	case LevelDebug:
+		return "DEBUG"
 	case LevelInfo:
 		return "INFO"
+	case LevelWarn:
+		return "WARN"
 	case LevelError:
 		return "ERROR"
 	case LevelFatal:
@@ -37,6 +45,22 @@ func (l Level) String() string {
 	}
 }
 
+// ParseLevel() returns the level with the given name (debug, info, warn or error), for the -log-level flag.
+func ParseLevel(name string) (Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warn":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	default:
+		return 0, fmt.Errorf("unknown log level %q", name)
+	}
+}
+
 // Return a new Logger instance which writes log entries at or above a minimum severity 
 // level to a specific output destination
 func New(out io.Writer, minLevel Level) *Logger {
@@ -100,10 +124,22 @@ func (l *Logger) print(level Level, message string, properties map[string]string
 	return l.out.Write(append(line, '\n'))
 }
 
+// Enabled() reports whether entries at the given level are written. Callers can use it to skip building the
+// properties of entries which would be dropped, such as DEBUG entries logged for every query.
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.minLevel
+}
+
 // Declare some helper methods for writing log entries at different levels.
+func (l *Logger) PrintDebug(message string, properties map[string]string) {
+	l.print(LevelDebug, message, properties)
+}
 func (l *Logger) PrintInfo(message string, properties map[string]string) {
 	l.print(LevelInfo, message, properties)
 }
+func (l *Logger) PrintWarn(message string, properties map[string]string) {
+	l.print(LevelWarn, message, properties)
+}
 func (l *Logger) PrintError(err error, properties map[string]string) {
 	l.print(LevelError, err.Error(), properties)
 }
@@ -116,4 +152,22 @@ func (l *Logger) PrintFatal(err error, properties map[string]string) {
 // This writes a log entry at the ERROR level with no additional properties.
 func (l *Logger) Write(message []byte) (int, error) {
 	return l.print(LevelError, string(message), nil)
-}
\ No newline at end of file
+}
+
+// Close() closes the output destination of the logger if it is an io.Closer, such as a log file, so that
+// everything written to it is flushed. The standard output and error streams are never closed.
+// Log entries can still be written afterwards, if the output destination allows it.
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.out == os.Stdout || l.out == os.Stderr {
+		return nil
+	}
+
+	if closer, ok := l.out.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
@@ -2,7 +2,12 @@ package mailer
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"embed"
+	"errors"
+	"fmt"
+	"sync/atomic"
 	"text/template"
 	"time"
 
@@ -21,33 +26,200 @@ var templateFS embed.FS
 // Define a Mailer struct which contains a mail.Dialer instance (used to connect to a SMTP server)
 // and the sender information for your emails (the name and address you want the email to be from)
 type Mailer struct {
-	dialer *mail.Dialer
-	sender string
+	dialer  *mail.Dialer
+	sender  string            // Default sender, for the kinds of email without a sender of their own
+	senders map[string]Sender // Senders by kind of email, set with WithSender()
+	quota   *recipientQuota   // Shared by copies of the Mailer. Nil if there is no limit.
+	closed  *int32            // Set to 1 by Close(). Shared by copies of the Mailer.
 }
 
-func New(host string, port int, username, password, sender string) Mailer {
+// ErrClosed is returned by Send() once the mailer has been closed.
+var ErrClosed = errors.New("mailer is closed")
+
+// Define the TLS modes used to secure the connection to the SMTP server.
+const (
+	TLSModeStartTLS = "starttls" // Connect in plain text, then upgrade with STARTTLS (usually port 587, 25 or 2525)
+	TLSModeImplicit = "implicit" // Use TLS from the start of the connection (usually port 465)
+	TLSModeNone     = "none"     // Never use TLS. Only for local testing SMTP servers
+)
+
+// New() returns a Mailer for the given SMTP server settings. The tlsMode must be one of the TLSMode constants.
+// Setting insecure skips verifying the server's certificate, which should only be done for local testing.
+func New(host string, port int, username, password, sender, tlsMode string, insecure bool) (Mailer, error) {
 	// Initializes a new mail.Dialer with the given SMTP server settings.
 	dialer := mail.NewDialer(host, port, username, password)
 
 	// Configure a 5-second timeout whenever we send an email.
 	dialer.Timeout = 5 * time.Second
 
+	// NewDialer() guesses the TLS mode from the port number. Set it explicitly instead.
+	switch tlsMode {
+	case TLSModeStartTLS:
+		dialer.SSL = false
+		dialer.StartTLSPolicy = mail.MandatoryStartTLS
+	case TLSModeImplicit:
+		dialer.SSL = true
+	case TLSModeNone:
+		dialer.SSL = false
+		dialer.StartTLSPolicy = mail.NoStartTLS
+	default:
+		return Mailer{}, fmt.Errorf("invalid SMTP TLS mode %q", tlsMode)
+	}
+
+	if insecure {
+		dialer.TLSConfig = &tls.Config{ServerName: host, InsecureSkipVerify: true}
+	}
+
 	return Mailer{
 		dialer: dialer,
 		sender: sender,
+		closed: new(int32),
+	}, nil
+}
+
+// Close() stops the mailer: any later call to Send() fails with ErrClosed. Every email is sent over its own
+// SMTP connection, which is closed once the email has been sent, so there are no open connections to close.
+// Emails which are being sent when Close() is called are not interrupted. Close() is safe to call more than once.
+func (m Mailer) Close() error {
+	if m.closed != nil {
+		atomic.StoreInt32(m.closed, 1)
+	}
+	return nil
+}
+
+// Ping() checks that the SMTP server can be reached and accepts the credentials, by opening a connection and
+// closing it again without sending anything. It gives up when the context is done, even if the dial hasn't
+// returned yet.
+func (m Mailer) Ping(ctx context.Context) error {
+	if m.dialer == nil {
+		return errors.New("mailer is not configured")
+	}
+
+	errs := make(chan error, 1)
+
+	go func() {
+		conn, err := m.dialer.Dial()
+		if err == nil {
+			err = conn.Close()
+		}
+		errs <- err
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// WithDailyLimit() returns a copy of the Mailer which sends at most limit emails to each recipient in a
+// rolling 24-hour window. Sends beyond the limit fail with ErrDailyLimitExceeded. A limit of 0 removes the limit.
+func (m Mailer) WithDailyLimit(limit int) Mailer {
+	if limit <= 0 {
+		m.quota = nil
+		return m
 	}
 
+	m.quota = &recipientQuota{limit: limit, sends: make(map[string][]time.Time)}
+	return m
 }
 
 // Define a Send() method on the Mailer type. This takes the recipient email address as the first parameter, the name of the file containing
 // the templates, and any dynamic data for the templates as an interface{} parameter.
+// The email is sent as a transactional email. Use SendKind() for the other kinds.
 func (m Mailer) Send(recipient, templateFile string, data interface{}) error {
+	return m.SendKindContext(context.Background(), KindTransactional, recipient, templateFile, data)
+}
+
+// SendContext() is like Send(), but gives up when the context is done, even between retries.
+func (m Mailer) SendContext(ctx context.Context, recipient, templateFile string, data interface{}) error {
+	return m.SendKindContext(ctx, KindTransactional, recipient, templateFile, data)
+}
+
+// SendKind() is like Send(), but sends the email with the sender configured for the given kind of email.
+func (m Mailer) SendKind(kind, recipient, templateFile string, data interface{}) error {
+	return m.SendKindContext(context.Background(), kind, recipient, templateFile, data)
+}
+
+// SendKindContext() is like SendKind(), but gives up when the context is done and returns the context's error.
+// An attempt which is in progress when that happens is abandoned rather than interrupted: the SMTP connection is
+// closed by the dialer's own timeout, and the email may still be sent.
+func (m Mailer) SendKindContext(ctx context.Context, kind, recipient, templateFile string, data interface{}) error {
+	if m.closed != nil && atomic.LoadInt32(m.closed) == 1 {
+		return ErrClosed
+	}
+
+	// Don't send anything if the recipient has already reached the daily limit.
+	if m.quota != nil && !m.quota.allow(recipient, time.Now()) {
+		return ErrDailyLimitExceeded
+	}
+
+	msg, err := m.message(kind, recipient, templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	// Try sending the email up to three times before aborting and returning the final error.
+	// We sleep for 500 milliseconds after each failed attempt, unless the context is done first.
+	for i := 1; i <= 3; i++ {
+		err = m.dialAndSend(ctx, msg)
+		if err == nil {
+			return nil
+		}
+
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+
+		select {
+		case <-time.After(500 * time.Millisecond):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return err
+}
+
+// dialAndSend() opens a connection to the SMTP server, sends the given email and closes the connection.
+// If there is a timeout, it will return a "dial tcp: i/o timeout" error. It returns the context's error as soon as
+// the context is done, without waiting for the attempt to complete.
+func (m Mailer) dialAndSend(ctx context.Context, msg *mail.Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	errs := make(chan error, 1)
+
+	go func() {
+		errs <- m.dialer.DialAndSend(msg)
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Email holds the three parts of a rendered email template.
+type Email struct {
+	Subject   string `json:"subject"`
+	PlainBody string `json:"plain_body"`
+	HTMLBody  string `json:"html_body"`
+}
+
+// Render() executes the "subject", "plainBody" and "htmlBody" templates of the template file with the dynamic data.
+// It doesn't send anything, so it can also be used to preview an email.
+func (m Mailer) Render(templateFile string, data interface{}) (*Email, error) {
 	// Use ParseFS() to parse the required template file from the embedded file system.
 	// The file system is rooted in the directory which contains the //go:embed directive.
 	// Hence, to retrieve a file in it, we need to start with path templates/
 	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Execute the named template "subject", passing in the dynamic data and store
@@ -55,21 +227,36 @@ func (m Mailer) Send(recipient, templateFile string, data interface{}) error {
 	subject := new(bytes.Buffer)
 	err = tmpl.ExecuteTemplate(subject, "subject", data)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Do the same thing with the "plainBody" template
 	plainBody := new(bytes.Buffer)
 	err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Do the same thing with the "htmlBody" template
 	htmlBody := new(bytes.Buffer)
 	err = tmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	return &Email{
+		Subject:   subject.String(),
+		PlainBody: plainBody.String(),
+		HTMLBody:  htmlBody.String(),
+	}, nil
+}
+
+// message() renders the template file and returns the email to send to the recipient, with the From and
+// Reply-To headers of the sender of the kind of email.
+func (m Mailer) message(kind, recipient, templateFile string, data interface{}) (*mail.Message, error) {
+	email, err := m.Render(templateFile, data)
+	if err != nil {
+		return nil, err
 	}
 
 	// Use the mail.NewMessage() function to initialize a new mail.Message instance.
@@ -77,25 +264,17 @@ func (m Mailer) Send(recipient, templateFile string, data interface{}) error {
 	// The SetBody() method set the plain-text body.
 	// The AddAlternative() method sets the HTML body. This should always be called after SetBody().
 	// It is common to send HTML emails that default to their plain text version for backward compatibility.
+	sender := m.senderFor(kind)
+
 	msg := mail.NewMessage()
 	msg.SetHeader("To", recipient)
-	msg.SetHeader("From", m.sender)
-	msg.SetHeader("Subject", subject.String())
-	msg.SetBody("text/plain", plainBody.String())
-	msg.AddAlternative("text/html", htmlBody.String())
-
-	// Try sending the email up to three times before aborting and returning the final error.
-	// We sleep for 500 milliseconds before each attempt.
-	for i := 1; i <= 3; i++ {
-		// Opens a connection to the SMTP server, send the given email and closes the connection.
-		// If there is a timeout, it will return a "dial tcp: i/o timeout" error.
-		err = m.dialer.DialAndSend(msg)
-		if err == nil {
-			return nil
-		}
-
-		time.Sleep(500 * time.Millisecond)
+	msg.SetHeader("From", sender.From)
+	if sender.ReplyTo != "" {
+		msg.SetHeader("Reply-To", sender.ReplyTo)
 	}
+	msg.SetHeader("Subject", email.Subject)
+	msg.SetBody("text/plain", email.PlainBody)
+	msg.AddAlternative("text/html", email.HTMLBody)
 
-	return err
+	return msg, nil
 }
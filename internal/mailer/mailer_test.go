@@ -0,0 +1,128 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-mail/mail/v2"
+)
+
+func TestNewTLSModes(t *testing.T) {
+	tests := []struct {
+		mode           string
+		port           int
+		ssl            bool
+		startTLSPolicy mail.StartTLSPolicy
+	}{
+		// The modes are set explicitly, whatever the port number suggests.
+		{TLSModeStartTLS, 465, false, mail.MandatoryStartTLS},
+		{TLSModeStartTLS, 587, false, mail.MandatoryStartTLS},
+		{TLSModeImplicit, 587, true, mail.OpportunisticStartTLS},
+		{TLSModeImplicit, 465, true, mail.OpportunisticStartTLS},
+		{TLSModeNone, 465, false, mail.NoStartTLS},
+		{TLSModeNone, 1025, false, mail.NoStartTLS},
+	}
+
+	for _, tt := range tests {
+		for _, insecure := range []bool{false, true} {
+			m, err := New("smtp.example.com", tt.port, "user", "pa55word", "Greenlight <no-reply@example.com>", tt.mode, insecure)
+			if err != nil {
+				t.Fatalf("%s on port %d: %v", tt.mode, tt.port, err)
+			}
+
+			if m.dialer.SSL != tt.ssl {
+				t.Errorf("%s on port %d: got SSL %t; want %t", tt.mode, tt.port, m.dialer.SSL, tt.ssl)
+			}
+			if !tt.ssl && m.dialer.StartTLSPolicy != tt.startTLSPolicy {
+				t.Errorf("%s on port %d: got STARTTLS policy %v; want %v", tt.mode, tt.port, m.dialer.StartTLSPolicy, tt.startTLSPolicy)
+			}
+
+			// Certificate verification is only skipped when asked for explicitly.
+			skipVerify := m.dialer.TLSConfig != nil && m.dialer.TLSConfig.InsecureSkipVerify
+			if skipVerify != insecure {
+				t.Errorf("%s on port %d, insecure %t: got InsecureSkipVerify %t", tt.mode, tt.port, insecure, skipVerify)
+			}
+			if insecure && m.dialer.TLSConfig.ServerName != "smtp.example.com" {
+				t.Errorf("%s on port %d: got ServerName %q; want smtp.example.com", tt.mode, tt.port, m.dialer.TLSConfig.ServerName)
+			}
+		}
+	}
+}
+
+func TestNewInvalidTLSMode(t *testing.T) {
+	_, err := New("smtp.example.com", 587, "user", "pa55word", "no-reply@example.com", "ssl", false)
+	if err == nil {
+		t.Error("an invalid TLS mode was accepted")
+	}
+}
+
+func TestClose(t *testing.T) {
+	m, err := New("localhost", 1, "", "", "Greenlight <no-reply@greenlight.example.com>", TLSModeNone, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Copies of the mailer share its state, so closing one closes them all.
+	other := m
+
+	for i := 0; i < 2; i++ {
+		if err := m.Close(); err != nil {
+			t.Fatalf("Close() #%d: %v", i+1, err)
+		}
+	}
+
+	err = other.Send("alice@example.com", "user_welcome.html", nil)
+	if !errors.Is(err, ErrClosed) {
+		t.Errorf("got error %v; want %v", err, ErrClosed)
+	}
+}
+
+func TestSendContextCancelledMidRetry(t *testing.T) {
+	// Take a free port and close it again, so that every attempt fails at once with "connection refused".
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	m, err := New("127.0.0.1", port, "", "", "Greenlight <no-reply@greenlight.example.com>", TLSModeNone, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Cancel the context while Send is waiting between the first and second attempts.
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	err = m.SendContext(ctx, "alice@example.com", "user_welcome.html", map[string]interface{}{"ID": 1})
+	elapsed := time.Since(start)
+
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v; want %v", err, context.Canceled)
+	}
+	// Without the context, the three attempts take at least a second, with the waits between them.
+	if elapsed > 400*time.Millisecond {
+		t.Errorf("SendContext() returned after %s; want it to return soon after the context is cancelled", elapsed)
+	}
+}
+
+func TestSendContextDeadline(t *testing.T) {
+	m, err := New("127.0.0.1", 1, "", "", "Greenlight <no-reply@greenlight.example.com>", TLSModeNone, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A context which is already done fails before dialing.
+	ctx, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+
+	err = m.SendContext(ctx, "alice@example.com", "user_welcome.html", map[string]interface{}{"ID": 1})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v; want %v", err, context.DeadlineExceeded)
+	}
+}
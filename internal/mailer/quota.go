@@ -0,0 +1,65 @@
+package mailer
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrDailyLimitExceeded is returned by Send() when the recipient has already been sent the maximum number
+// of emails in the last 24 hours.
+var ErrDailyLimitExceeded = errors.New("recipient daily email limit exceeded")
+
+// quotaWindow is the rolling window over which the emails sent to each recipient are counted.
+const quotaWindow = 24 * time.Hour
+
+// recipientQuota keeps track of the times at which emails were sent to each recipient, to cap the number of
+// emails any one inbox receives in a rolling 24-hour window. This stops the registration flow from being
+// used to flood an inbox (which could also get our sender address blocklisted).
+type recipientQuota struct {
+	mu        sync.Mutex
+	limit     int
+	sends     map[string][]time.Time
+	lastSweep time.Time
+}
+
+// allow() records a send to the recipient and returns true, or returns false if the recipient has reached
+// the limit. Addresses are compared case-insensitively.
+func (q *recipientQuota) allow(recipient string, now time.Time) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	// Once per window, forget the recipients who haven't been sent anything recently, so that the map
+	// doesn't keep growing.
+	if now.Sub(q.lastSweep) > quotaWindow {
+		for key, times := range q.sends {
+			if len(recent(times, now)) == 0 {
+				delete(q.sends, key)
+			}
+		}
+		q.lastSweep = now
+	}
+
+	key := strings.ToLower(recipient)
+	times := recent(q.sends[key], now)
+
+	if len(times) >= q.limit {
+		q.sends[key] = times
+		return false
+	}
+
+	q.sends[key] = append(times, now)
+	return true
+}
+
+// recent() returns the send times which are still within the window. The times are in ascending order,
+// so everything before the first recent time can be dropped.
+func recent(times []time.Time, now time.Time) []time.Time {
+	for i, t := range times {
+		if now.Sub(t) < quotaWindow {
+			return times[i:]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package mailer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecipientQuota(t *testing.T) {
+	q := &recipientQuota{limit: 2, sends: make(map[string][]time.Time)}
+
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	sends := []struct {
+		recipient string
+		at        time.Duration // Since start
+		want      bool
+	}{
+		{"alice@example.com", 0, true},
+		{"ALICE@example.com", time.Hour, true},
+		{"alice@example.com", 2 * time.Hour, false}, // Over the cap
+		{"bob@example.com", 2 * time.Hour, true},    // Other recipients proceed
+		{"alice@example.com", 23 * time.Hour, false},
+		{"alice@example.com", 24 * time.Hour, true}, // The first send has left the window
+		{"alice@example.com", 24*time.Hour + time.Minute, false},
+		{"alice@example.com", 25 * time.Hour, true}, // So has the second
+	}
+
+	for i, send := range sends {
+		if got := q.allow(send.recipient, start.Add(send.at)); got != send.want {
+			t.Errorf("send %d to %s at +%s: got %t; want %t", i+1, send.recipient, send.at, got, send.want)
+		}
+	}
+}
+
+func TestRecipientQuotaSweep(t *testing.T) {
+	q := &recipientQuota{limit: 1, sends: make(map[string][]time.Time)}
+
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	q.allow("alice@example.com", start)
+	q.allow("bob@example.com", start.Add(-time.Minute))
+
+	// Once a window has passed, the recipients without recent sends are forgotten.
+	q.allow("carol@example.com", start.Add(quotaWindow+time.Hour))
+
+	if _, ok := q.sends["alice@example.com"]; ok {
+		t.Error("alice@example.com is still tracked")
+	}
+	if len(q.sends) != 1 {
+		t.Errorf("got %d tracked recipients; want 1", len(q.sends))
+	}
+}
@@ -0,0 +1,39 @@
+package mailer
+
+// Define the kinds of email. Each kind can be sent from its own address, configured with WithSender().
+const (
+	KindTransactional = "transactional" // Emails triggered by a user action, such as the welcome email
+	KindSystem        = "system"        // Emails sent by the application itself, such as notices to administrators
+)
+
+// Sender holds the addresses used for a kind of email.
+type Sender struct {
+	From    string // The From address, e.g. "Greenlight <no-reply@greenlight.net>"
+	ReplyTo string // The Reply-To address. Optional: replies go to the From address if it is empty.
+}
+
+// WithSender() returns a copy of the Mailer which sends the emails of the given kind with the sender.
+// An empty From address is ignored, so that the kind keeps using the default sender given to New().
+func (m Mailer) WithSender(kind string, sender Sender) Mailer {
+	if sender.From == "" {
+		return m
+	}
+
+	// Copy the registry, so that the Mailer this was called on is unchanged.
+	senders := make(map[string]Sender, len(m.senders)+1)
+	for k, s := range m.senders {
+		senders[k] = s
+	}
+	senders[kind] = sender
+
+	m.senders = senders
+	return m
+}
+
+// senderFor() returns the sender of a kind of email, or the default sender if the kind has no sender of its own.
+func (m Mailer) senderFor(kind string) Sender {
+	if sender, ok := m.senders[kind]; ok {
+		return sender
+	}
+	return Sender{From: m.sender}
+}
@@ -0,0 +1,45 @@
+package mailer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMessageSenders(t *testing.T) {
+	base, err := New("localhost", 25, "", "", "Greenlight <no-reply@greenlight.example.com>", TLSModeNone, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := base.WithSender(KindTransactional, Sender{From: "Greenlight <accounts@greenlight.example.com>", ReplyTo: "support@greenlight.example.com"})
+	m = m.WithSender(KindSystem, Sender{From: "Greenlight Ops <ops@greenlight.example.com>"})
+	// A sender without a From address is ignored.
+	m = m.WithSender("marketing", Sender{ReplyTo: "news@greenlight.example.com"})
+
+	tests := []struct {
+		mailer  Mailer
+		kind    string
+		from    string
+		replyTo []string
+	}{
+		{m, KindTransactional, "Greenlight <accounts@greenlight.example.com>", []string{"support@greenlight.example.com"}},
+		{m, KindSystem, "Greenlight Ops <ops@greenlight.example.com>", nil},
+		{m, "marketing", "Greenlight <no-reply@greenlight.example.com>", nil},
+		// WithSender() returns a copy, so the original mailer still uses the default sender.
+		{base, KindTransactional, "Greenlight <no-reply@greenlight.example.com>", nil},
+	}
+
+	for _, tt := range tests {
+		msg, err := tt.mailer.message(tt.kind, "alice@example.com", "user_welcome.html", map[string]int{"ID": 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if from := msg.GetHeader("From"); len(from) != 1 || from[0] != tt.from {
+			t.Errorf("%s: got From %q; want %q", tt.kind, from, tt.from)
+		}
+		if replyTo := msg.GetHeader("Reply-To"); !reflect.DeepEqual(replyTo, tt.replyTo) {
+			t.Errorf("%s: got Reply-To %q; want %q", tt.kind, replyTo, tt.replyTo)
+		}
+	}
+}
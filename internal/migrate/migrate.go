@@ -0,0 +1,305 @@
+// Package migrate is a small migration runner which applies SQL migration files to a PostgreSQL database.
+// It tracks the current schema version in a schema_migrations table using the same layout as golang-migrate,
+// so the two tools can be used interchangeably on the same database.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNoChange = errors.New("no change")
+	ErrDirty    = errors.New("database is in a dirty state, fix the failed migration manually")
+)
+
+// Migration file names are in the format <version>_<title>.<up|down>.sql
+var fileRX = regexp.MustCompile(`^([0-9]+)_(.*)\.(up|down)\.sql$`)
+
+// Define a migration struct to hold the up and down file names for a single version.
+type migration struct {
+	version int64
+	up      string
+	down    string
+}
+
+// Define a Migrator struct which wraps a sql.DB connection pool and the file system containing
+// the migration files.
+type Migrator struct {
+	db         *sql.DB
+	files      fs.FS
+	migrations []migration
+}
+
+// New() returns a Migrator for the migration files at the root of the given file system.
+func New(db *sql.DB, files fs.FS) (*Migrator, error) {
+	entries, err := fs.ReadDir(files, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*migration)
+
+	for _, entry := range entries {
+		matches := fileRX.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || matches == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(matches[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		m, found := byVersion[version]
+		if !found {
+			m = &migration{version: version}
+			byVersion[version] = m
+		}
+
+		if matches[3] == "up" {
+			m.up = entry.Name()
+		} else {
+			m.down = entry.Name()
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		migrations = append(migrations, *m)
+	}
+
+	// Migrations are always applied in ascending order of version.
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+
+	return &Migrator{db: db, files: files, migrations: migrations}, nil
+}
+
+// Version() returns the current schema version and whether the last migration failed part way through.
+// A version of 0 means that no migrations have been applied.
+func (m *Migrator) Version() (int64, bool, error) {
+	err := m.ensureVersionTable()
+	if err != nil {
+		return 0, false, err
+	}
+
+	return m.readVersion()
+}
+
+// Pending() returns the names of the up migrations which haven't been applied yet, without their .up.sql
+// extension, and whether the last migration failed part way through. Unlike Version(), it never creates the
+// schema_migrations table, so it can check the schema with a database user which can't create tables.
+func (m *Migrator) Pending() ([]string, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// to_regclass() returns NULL instead of failing when the table doesn't exist.
+	var exists bool
+
+	err := m.db.QueryRowContext(ctx, `SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&exists)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var version int64
+	var dirty bool
+
+	if exists {
+		version, dirty, err = m.readVersion()
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	pending := []string{}
+
+	for _, mig := range m.migrations {
+		if mig.version > version && mig.up != "" {
+			pending = append(pending, strings.TrimSuffix(mig.up, ".up.sql"))
+		}
+	}
+
+	return pending, dirty, nil
+}
+
+// readVersion() reads the current schema version from the schema_migrations table, which must exist.
+func (m *Migrator) readVersion() (int64, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var version int64
+	var dirty bool
+
+	err := m.db.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, false, nil
+		default:
+			return 0, false, err
+		}
+	}
+
+	return version, dirty, nil
+}
+
+// Up() applies all the pending up migrations. It returns ErrNoChange if the schema is already up to date.
+func (m *Migrator) Up() error {
+	version, dirty, err := m.Version()
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return ErrDirty
+	}
+
+	applied := 0
+
+	for _, mig := range m.migrations {
+		if mig.version <= version || mig.up == "" {
+			continue
+		}
+
+		err = m.apply(mig.up, mig.version, mig.version)
+		if err != nil {
+			return err
+		}
+		applied++
+	}
+
+	if applied == 0 {
+		return ErrNoChange
+	}
+
+	return nil
+}
+
+// Down() rolls back the given number of most recently applied migrations.
+// It returns ErrNoChange if there is nothing to roll back.
+func (m *Migrator) Down(steps int) error {
+	version, dirty, err := m.Version()
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return ErrDirty
+	}
+
+	rolledBack := 0
+
+	for i := len(m.migrations) - 1; i >= 0 && rolledBack < steps; i-- {
+		mig := m.migrations[i]
+		if mig.version > version {
+			continue
+		}
+
+		if mig.down == "" {
+			return fmt.Errorf("no down migration for version %d", mig.version)
+		}
+
+		// After rolling back, the schema is at the version of the previous migration (or 0).
+		var previous int64
+		if i > 0 {
+			previous = m.migrations[i-1].version
+		}
+
+		err = m.apply(mig.down, mig.version, previous)
+		if err != nil {
+			return err
+		}
+		rolledBack++
+	}
+
+	if rolledBack == 0 {
+		return ErrNoChange
+	}
+
+	return nil
+}
+
+// apply() runs a single migration file inside a transaction and records the resulting version.
+// The version is first marked as dirty so that a failure part way through is detected by later runs.
+func (m *Migrator) apply(file string, version, target int64) error {
+	script, err := fs.ReadFile(m.files, file)
+	if err != nil {
+		return err
+	}
+
+	err = m.setVersion(version, true)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, string(script))
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("migration %s failed: %w", file, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	return m.setVersion(target, false)
+}
+
+// setVersion() replaces the single row held in the schema_migrations table.
+// A target version of 0 with a clean state leaves the table empty.
+func (m *Migrator) setVersion(version int64, dirty bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM schema_migrations`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if version > 0 || dirty {
+		_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, dirty) VALUES ($1, $2)`, version, dirty)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ensureVersionTable() creates the schema_migrations table if it does not already exist.
+func (m *Migrator) ensureVersionTable() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version bigint NOT NULL PRIMARY KEY,
+			dirty boolean NOT NULL
+		)`)
+
+	return err
+}
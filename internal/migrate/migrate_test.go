@@ -0,0 +1,124 @@
+package migrate_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	"github.com/jseow5177/greenlight/internal/migrate"
+	"github.com/jseow5177/greenlight/internal/testdb"
+)
+
+// testMigrations holds two migrations, listed out of order, and a file which isn't a migration.
+var testMigrations = fstest.MapFS{
+	"000002_add_year.up.sql":       {Data: []byte(`ALTER TABLE films ADD COLUMN year integer`)},
+	"000002_add_year.down.sql":     {Data: []byte(`ALTER TABLE films DROP COLUMN year`)},
+	"000001_create_films.up.sql":   {Data: []byte(`CREATE TABLE films (id bigserial PRIMARY KEY, title text NOT NULL)`)},
+	"000001_create_films.down.sql": {Data: []byte(`DROP TABLE films`)},
+	"README.md":                    {Data: []byte(`not a migration`)},
+}
+
+func TestMigrator(t *testing.T) {
+	db := testdb.OpenEmpty(t)
+
+	m, err := migrate.New(db, testMigrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pending, _, err := m.Pending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"000001_create_films", "000002_add_year"}; !reflect.DeepEqual(pending, want) {
+		t.Errorf("Pending() before Up() = %q; want %q", pending, want)
+	}
+
+	err = m.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The migrations were applied in order, so the column added by the second one exists.
+	_, err = db.Exec(`INSERT INTO films (title, year) VALUES ('Moana', 2016)`)
+	if err != nil {
+		t.Fatalf("the migrations weren't applied: %v", err)
+	}
+
+	version, dirty, err := m.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 2 || dirty {
+		t.Errorf("Version() after Up() = %d, dirty %t; want 2, false", version, dirty)
+	}
+
+	if err := m.Up(); !errors.Is(err, migrate.ErrNoChange) {
+		t.Errorf("second Up(): got error %v; want ErrNoChange", err)
+	}
+
+	err = m.Down(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	version, _, err = m.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 1 {
+		t.Errorf("Version() after Down(1) = %d; want 1", version)
+	}
+
+	_, err = db.Exec(`SELECT year FROM films`)
+	if err == nil {
+		t.Error("the year column is still there after Down(1)")
+	}
+
+	err = m.Down(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Down(1); !errors.Is(err, migrate.ErrNoChange) {
+		t.Errorf("Down() with nothing applied: got error %v; want ErrNoChange", err)
+	}
+}
+
+func TestMigratorDirty(t *testing.T) {
+	db := testdb.OpenEmpty(t)
+
+	files := fstest.MapFS{
+		"000001_broken.up.sql":   {Data: []byte(`CREATE TABLE films (`)},
+		"000001_broken.down.sql": {Data: []byte(`DROP TABLE IF EXISTS films`)},
+	}
+
+	m, err := migrate.New(db, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Up(); err == nil {
+		t.Fatal("Up() of a broken migration: got nil error")
+	}
+
+	// The failed migration leaves the schema dirty, and nothing else runs until it is fixed.
+	version, dirty, err := m.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 1 || !dirty {
+		t.Errorf("Version() = %d, dirty %t; want 1, true", version, dirty)
+	}
+
+	if err := m.Up(); !errors.Is(err, migrate.ErrDirty) {
+		t.Errorf("Up() when dirty: got error %v; want ErrDirty", err)
+	}
+}
+
+func TestNewWithoutMigrations(t *testing.T) {
+	m, err := migrate.New(nil, fstest.MapFS{"README.md": {Data: []byte(`not a migration`)}})
+	if err != nil || m == nil {
+		t.Errorf("New() = %v, %v; want a migrator", m, err)
+	}
+}
@@ -0,0 +1,88 @@
+package strength
+
+import (
+	"strings"
+	"unicode"
+)
+
+// dictionary is a list of words, ranked from the most to the least common. The rank of a word is the number of
+// guesses an attacker going through the list needs to reach it.
+type dictionary struct {
+	name  string
+	ranks map[string]int
+}
+
+func newDictionary(name string, words []string) dictionary {
+	ranks := make(map[string]int, len(words))
+	for i, word := range words {
+		if _, exists := ranks[word]; !exists {
+			ranks[word] = i + 1
+		}
+	}
+	return dictionary{name: name, ranks: ranks}
+}
+
+// The most common passwords from leaked password lists, and common English words, in order of frequency.
+// zxcvbn ships much longer lists; these short ones catch the passwords which are guessed first.
+var (
+	passwords = newDictionary("passwords", []string{
+		"123456", "password", "12345678", "qwerty", "123456789", "12345", "1234", "111111", "1234567", "dragon",
+		"123123", "baseball", "abc123", "football", "monkey", "letmein", "696969", "shadow", "master", "666666",
+		"qwertyuiop", "123321", "mustang", "1234567890", "michael", "654321", "superman", "1qaz2wsx", "7777777", "121212",
+		"000000", "qazwsx", "123qwe", "killer", "trustno1", "jordan", "jennifer", "zxcvbnm", "asdfgh", "hunter",
+		"buster", "soccer", "harley", "batman", "andrew", "tigger", "sunshine", "iloveyou", "2000", "charlie",
+		"robert", "thomas", "hockey", "ranger", "daniel", "starwars", "klaster", "112233", "george", "computer",
+		"michelle", "jessica", "pepper", "1111", "zxcvbn", "555555", "11111111", "131313", "freedom", "777777",
+		"pass", "maggie", "159753", "aaaaaa", "ginger", "princess", "joshua", "cheese", "amanda", "summer",
+		"love", "ashley", "nicole", "chelsea", "biteme", "matthew", "access", "yankees", "987654321", "dallas",
+		"austin", "thunder", "taylor", "matrix", "welcome", "admin", "login", "passw0rd", "qwerty123", "secret",
+		"greenlight", "changeme", "letmein1", "password1", "default", "guest", "root", "test", "hello", "whatever",
+	})
+
+	englishWords = newDictionary("english_words", []string{
+		"the", "and", "you", "that", "was", "for", "are", "with", "his", "they",
+		"this", "have", "from", "one", "had", "word", "but", "not", "what", "all",
+		"were", "when", "your", "can", "said", "there", "use", "each", "which", "she",
+		"how", "their", "will", "other", "about", "out", "many", "then", "them", "these",
+		"some", "her", "would", "make", "like", "him", "into", "time", "has", "look",
+		"two", "more", "write", "see", "number", "way", "could", "people", "than", "first",
+		"water", "been", "call", "who", "oil", "its", "now", "find", "long", "down",
+		"day", "did", "get", "come", "made", "may", "part", "over", "new", "sound",
+		"take", "only", "little", "work", "know", "place", "year", "live", "back", "give",
+		"most", "very", "after", "thing", "our", "just", "name", "good", "sentence", "man",
+		"think", "say", "great", "where", "help", "through", "much", "before", "line", "right",
+		"too", "mean", "old", "any", "same", "tell", "boy", "follow", "came", "want",
+		"show", "also", "around", "form", "three", "small", "set", "put", "end", "does",
+		"another", "well", "large", "must", "big", "even", "such", "because", "turn", "here",
+		"why", "ask", "went", "men", "read", "need", "land", "different", "home", "move",
+		"try", "kind", "hand", "picture", "again", "change", "off", "play", "spell", "air",
+		"away", "animal", "house", "point", "page", "letter", "mother", "answer", "found", "study",
+		"still", "learn", "should", "america", "world", "movie", "film", "secret", "summer", "winter",
+		"dog", "cat", "horse", "money", "dragon", "monkey", "baby", "angel", "happy", "friend",
+	})
+)
+
+// l33tTable maps the characters commonly substituted for letters back to the letters.
+var l33tTable = map[rune]rune{
+	'4': 'a', '@': 'a', '8': 'b', '(': 'c', '3': 'e', '6': 'g', '1': 'i', '!': 'i', '|': 'i',
+	'0': 'o', '$': 's', '5': 's', '7': 't', '+': 't', '2': 'z',
+}
+
+// withUserInputs() returns the dictionaries to match against, with the words of the user inputs (such as the
+// parts of the user's name and email address) as the most common words of all.
+func withUserInputs(userInputs []string) []dictionary {
+	var words []string
+
+	for _, input := range userInputs {
+		fields := strings.FieldsFunc(strings.ToLower(input), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		for _, field := range fields {
+			if len([]rune(field)) >= 3 {
+				words = append(words, field)
+			}
+		}
+	}
+
+	return []dictionary{newDictionary("user_inputs", words), passwords, englishWords}
+}
@@ -0,0 +1,468 @@
+// Package strength estimates how hard a password is to guess, in the style of Dropbox's zxcvbn
+// (https://github.com/dropbox/zxcvbn). It is a small subset of it, written for this project: the password is split
+// into the patterns an attacker would try first (common passwords and words, the user's own details, repeats,
+// sequences, keyboard rows and years), and the number of guesses needed is estimated from the cheapest way of
+// covering the password with those patterns and brute force. The estimate is turned into a score from 0 (too
+// guessable) to 4 (very unguessable), with feedback explaining what makes a weak password weak.
+//
+// The warning and feedback are in English. They are also the keys of their translations in the internal/i18n
+// catalog, so a new message needs a translation there too.
+package strength
+
+import (
+	"math"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Score thresholds, in guesses, from zxcvbn. A score of 3 resists an online attack with no throttling, and a
+// score of 4 resists an offline attack on a slow hash such as bcrypt.
+var thresholds = []float64{1e3, 1e6, 1e8, 1e10}
+
+const (
+	// bruteforceCardinality is the number of guesses per character of the parts of the password which don't
+	// match a pattern.
+	bruteforceCardinality = 10
+	// minGuesses is the smallest number of guesses for a pattern, so that single characters are never cheaper
+	// than brute force.
+	minGuesses = 10
+)
+
+// Result is the strength estimate of a password.
+type Result struct {
+	Score    int      // From 0 (too guessable) to 4 (very unguessable)
+	Guesses  float64  // Estimated number of guesses needed to find the password
+	Warning  string   // What makes the password weak, empty if there is nothing specific
+	Feedback []string // Suggestions to make the password stronger, empty for passwords with a score of 3 or 4
+}
+
+// match is a part of the password, from the rune at index i to the rune at index j (inclusive), which follows
+// one of the patterns.
+type match struct {
+	pattern  string // dictionary, repeat, sequence, spatial or year
+	i, j     int
+	guesses  float64
+	token    string
+	rank     int    // Rank of the word in its list (dictionary only)
+	list     string // Which list the word comes from (dictionary only)
+	reversed bool   // The word is reversed in the password (dictionary only)
+	l33t     bool   // The word has character substitutions in the password (dictionary only)
+	base     string // The repeated string (repeat only)
+}
+
+// Estimate returns the strength of the password. The user inputs, such as the user's name and email address, are
+// treated as very common words, since they are the first things an attacker who knows the user would try.
+func Estimate(password string, userInputs ...string) Result {
+	runes := []rune(password)
+	if len(runes) == 0 {
+		return Result{Warning: "a password must be provided"}
+	}
+
+	dictionaries := withUserInputs(userInputs)
+
+	var matches []match
+	matches = append(matches, dictionaryMatches(runes, dictionaries)...)
+	matches = append(matches, repeatMatches(runes, dictionaries)...)
+	matches = append(matches, sequenceMatches(runes)...)
+	matches = append(matches, spatialMatches(runes)...)
+	matches = append(matches, yearMatches(runes)...)
+
+	guesses, sequence := mostGuessableSequence(runes, matches)
+
+	result := Result{Score: score(guesses), Guesses: guesses}
+	result.Warning, result.Feedback = feedback(result.Score, sequence)
+
+	return result
+}
+
+// score() converts a number of guesses into a score from 0 to 4.
+func score(guesses float64) int {
+	for i, threshold := range thresholds {
+		// A small margin keeps passwords which are just at a threshold in the lower score.
+		if guesses < threshold+5 {
+			return i
+		}
+	}
+	return len(thresholds)
+}
+
+// mostGuessableSequence() finds the cheapest way of covering the password with non-overlapping matches, with the
+// characters between them brute forced, and returns its number of guesses and the matches it uses.
+func mostGuessableSequence(runes []rune, matches []match) (float64, []match) {
+	n := len(runes)
+
+	// best[k] is the smallest number of guesses for the first k runes, and last[k] the match which ends it
+	// (nil for a brute forced rune).
+	best := make([]float64, n+1)
+	last := make([]*match, n+1)
+	best[0] = 1
+
+	byEnd := make([][]match, n)
+	for _, m := range matches {
+		byEnd[m.j] = append(byEnd[m.j], m)
+	}
+
+	for k := 1; k <= n; k++ {
+		best[k] = best[k-1] * bruteforceCardinality
+
+		for idx := range byEnd[k-1] {
+			m := &byEnd[k-1][idx]
+			if guesses := best[m.i] * math.Max(m.guesses, minGuesses); guesses < best[k] {
+				best[k] = guesses
+				last[k] = m
+			}
+		}
+	}
+
+	var sequence []match
+	for k := n; k > 0; {
+		if last[k] == nil {
+			k--
+			continue
+		}
+		sequence = append([]match{*last[k]}, sequence...)
+		k = last[k].i
+	}
+
+	return best[n], sequence
+}
+
+// dictionaryMatches() finds the words of the dictionaries in the password, case-insensitively, reversed and
+// with common character substitutions (such as "p@55w0rd" for "password").
+func dictionaryMatches(runes []rune, dictionaries []dictionary) []match {
+	lower := []rune(strings.ToLower(string(runes)))
+	unsubstituted := make([]rune, len(lower))
+	for k, r := range lower {
+		if sub, ok := l33tTable[r]; ok {
+			unsubstituted[k] = sub
+		} else {
+			unsubstituted[k] = r
+		}
+	}
+
+	var matches []match
+
+	for i := 0; i < len(lower); i++ {
+		for j := i + 2; j < len(lower); j++ {
+			token := string(runes[i : j+1])
+			word := string(lower[i : j+1])
+			plain := string(unsubstituted[i : j+1])
+
+			for _, d := range dictionaries {
+				if rank, ok := d.ranks[word]; ok {
+					matches = append(matches, dictionaryMatch(token, i, j, rank, d.name, false, false))
+				}
+				if rank, ok := d.ranks[reverse(word)]; ok {
+					matches = append(matches, dictionaryMatch(token, i, j, rank, d.name, true, false))
+				}
+				if plain != word {
+					if rank, ok := d.ranks[plain]; ok {
+						matches = append(matches, dictionaryMatch(token, i, j, rank, d.name, false, true))
+					}
+				}
+			}
+		}
+	}
+
+	return matches
+}
+
+func dictionaryMatch(token string, i, j, rank int, list string, reversed, l33t bool) match {
+	guesses := float64(rank) * uppercaseVariations(token)
+	if reversed {
+		guesses *= 2
+	}
+	if l33t {
+		guesses *= 2
+	}
+
+	return match{pattern: "dictionary", i: i, j: j, guesses: guesses, token: token, rank: rank, list: list, reversed: reversed, l33t: l33t}
+}
+
+// uppercaseVariations() returns how many more guesses the capitalization of the word adds. Capitalizing the
+// first letter, or every letter, is tried early, so it only doubles the guesses.
+func uppercaseVariations(token string) float64 {
+	upper, lower := 0, 0
+	for _, r := range token {
+		switch {
+		case unicode.IsUpper(r):
+			upper++
+		case unicode.IsLower(r):
+			lower++
+		}
+	}
+
+	first := []rune(token)[0]
+	switch {
+	case upper == 0:
+		return 1
+	case lower == 0 || (upper == 1 && unicode.IsUpper(first)):
+		return 2
+	default:
+		return math.Pow(2, float64(upper))
+	}
+}
+
+// repeatMatches() finds runs of a repeated character or string, such as "aaa" or "abcabc".
+func repeatMatches(runes []rune, dictionaries []dictionary) []match {
+	var matches []match
+
+	for i := 0; i < len(runes); {
+		longest := 0
+		var longestBase []rune
+
+		for size := 1; i+2*size <= len(runes); size++ {
+			base := runes[i : i+size]
+			count := 1
+			for i+(count+1)*size <= len(runes) && string(runes[i+count*size:i+(count+1)*size]) == string(base) {
+				count++
+			}
+
+			if count > 1 && count*size > longest && count*size >= 3 {
+				longest = count * size
+				longestBase = base
+			}
+		}
+
+		if longest == 0 {
+			i++
+			continue
+		}
+
+		// The repeated string is guessed first, then the number of repeats.
+		baseGuesses, _ := mostGuessableSequence(longestBase, append(dictionaryMatches(longestBase, dictionaries), sequenceMatches(longestBase)...))
+		count := longest / len(longestBase)
+
+		matches = append(matches, match{
+			pattern: "repeat",
+			i:       i,
+			j:       i + longest - 1,
+			guesses: baseGuesses * float64(count),
+			token:   string(runes[i : i+longest]),
+			base:    string(longestBase),
+		})
+		i += longest
+	}
+
+	return matches
+}
+
+// sequenceMatches() finds runs of at least three characters which follow each other in the alphabet or in the
+// digits, in either direction, such as "abc", "XYZ" or "6543".
+func sequenceMatches(runes []rune) []match {
+	var matches []match
+
+	for i := 0; i < len(runes)-2; {
+		delta := runes[i+1] - runes[i]
+		if (delta != 1 && delta != -1) || class(runes[i]) == "" || class(runes[i]) != class(runes[i+1]) {
+			i++
+			continue
+		}
+
+		j := i + 1
+		for j+1 < len(runes) && runes[j+1]-runes[j] == delta && class(runes[j+1]) == class(runes[i]) {
+			j++
+		}
+
+		if j-i+1 < 3 {
+			i++
+			continue
+		}
+
+		// Sequences starting at either end of the alphabet or at 0, 1 or 9 are tried first.
+		var base float64
+		switch {
+		case strings.ContainsRune("aAzZ019", runes[i]):
+			base = 4
+		case class(runes[i]) == "digit":
+			base = 10
+		default:
+			base = 26
+		}
+		if delta < 0 {
+			base *= 2
+		}
+
+		matches = append(matches, match{pattern: "sequence", i: i, j: j, guesses: base * float64(j-i+1), token: string(runes[i : j+1])})
+		i = j + 1
+	}
+
+	return matches
+}
+
+// class() returns the class of characters which a sequence can be made of.
+func class(r rune) string {
+	switch {
+	case r >= 'a' && r <= 'z':
+		return "lower"
+	case r >= 'A' && r <= 'Z':
+		return "upper"
+	case r >= '0' && r <= '9':
+		return "digit"
+	default:
+		return ""
+	}
+}
+
+// keyboardRows are the rows of a QWERTY keyboard, used to find straight runs of keys such as "qwerty" or "asdf".
+var keyboardRows = []string{"`1234567890-=", "qwertyuiop[]\\", "asdfghjkl;'", "zxcvbnm,./"}
+
+// spatialMatches() finds runs of at least four adjacent keys of the same keyboard row, in either direction.
+func spatialMatches(runes []rune) []match {
+	lower := []rune(strings.ToLower(string(runes)))
+
+	var matches []match
+
+	for _, row := range keyboardRows {
+		for i := 0; i < len(lower)-3; {
+			direction := step(row, lower[i], lower[i+1])
+			if direction == 0 {
+				i++
+				continue
+			}
+
+			j := i + 1
+			for j+1 < len(lower) && step(row, lower[j], lower[j+1]) == direction {
+				j++
+			}
+
+			if j-i+1 < 4 {
+				i++
+				continue
+			}
+
+			// Any of the 47 keys can start the run, and it can go in either direction.
+			matches = append(matches, match{pattern: "spatial", i: i, j: j, guesses: 47 * 2 * float64(j-i+1), token: string(runes[i : j+1])})
+			i = j + 1
+		}
+	}
+
+	return matches
+}
+
+// step() returns 1 if b is the key to the right of a in the keyboard row, -1 if it is the key to the left, and 0
+// otherwise.
+func step(row string, a, b rune) int {
+	ia, ib := strings.IndexRune(row, a), strings.IndexRune(row, b)
+	if ia < 0 || ib < 0 || (ib-ia != 1 && ib-ia != -1) {
+		return 0
+	}
+	return ib - ia
+}
+
+// yearMatches() finds years between 1900 and 2099, which are often birth years or the current year.
+func yearMatches(runes []rune) []match {
+	var matches []match
+
+	for i := 0; i+4 <= len(runes); i++ {
+		token := string(runes[i : i+4])
+		if !isDigits(token) || !(strings.HasPrefix(token, "19") || strings.HasPrefix(token, "20")) {
+			continue
+		}
+
+		year := 0
+		for _, r := range token {
+			year = year*10 + int(r-'0')
+		}
+
+		// Years close to the current one are guessed first.
+		distance := math.Abs(float64(year - time.Now().Year()))
+		matches = append(matches, match{pattern: "year", i: i, j: i + 3, guesses: math.Max(distance, 20), token: token})
+	}
+
+	return matches
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func reverse(s string) string {
+	runes := []rune(s)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes)
+}
+
+// feedback() explains what makes a weak password weak, based on the longest pattern it contains.
+func feedback(score int, sequence []match) (string, []string) {
+	if score >= 3 {
+		return "", nil
+	}
+
+	const defaultSuggestion = "add another word or two, uncommon words are better"
+
+	if len(sequence) == 0 {
+		return "", []string{"use a few words and avoid common phrases", defaultSuggestion}
+	}
+
+	longest := sequence[0]
+	for _, m := range sequence[1:] {
+		if m.j-m.i > longest.j-longest.i {
+			longest = m
+		}
+	}
+
+	warning, suggestions := matchFeedback(longest, len(sequence) == 1)
+
+	return warning, append([]string{defaultSuggestion}, suggestions...)
+}
+
+func matchFeedback(m match, soleMatch bool) (string, []string) {
+	switch m.pattern {
+	case "dictionary":
+		var warning string
+		switch {
+		case m.list == "user_inputs":
+			warning = "avoid using your name or email address"
+		case m.list == "passwords" && soleMatch && !m.l33t && !m.reversed && m.rank <= 10:
+			warning = "this is a top-10 common password"
+		case m.list == "passwords" && soleMatch && !m.l33t && !m.reversed && m.rank <= 100:
+			warning = "this is a top-100 common password"
+		case m.list == "passwords":
+			warning = "this is similar to a commonly used password"
+		case soleMatch:
+			warning = "a word by itself is easy to guess"
+		}
+
+		var suggestions []string
+		first := []rune(m.token)[0]
+		switch {
+		case strings.ToUpper(m.token) == m.token && strings.ToLower(m.token) != m.token:
+			suggestions = append(suggestions, "all-uppercase is almost as easy to guess as all-lowercase")
+		case unicode.IsUpper(first):
+			suggestions = append(suggestions, "capitalization doesn't help very much")
+		}
+		if m.reversed {
+			suggestions = append(suggestions, "reversed words aren't much harder to guess")
+		}
+		if m.l33t {
+			suggestions = append(suggestions, "predictable substitutions like '@' instead of 'a' don't help very much")
+		}
+		return warning, suggestions
+
+	case "repeat":
+		if len([]rune(m.base)) == 1 {
+			return `repeats like "aaa" are easy to guess`, []string{"avoid repeated words and characters"}
+		}
+		return `repeats like "abcabcabc" are only slightly harder to guess than "abc"`, []string{"avoid repeated words and characters"}
+
+	case "sequence":
+		return "sequences like abc or 6543 are easy to guess", []string{"avoid sequences"}
+
+	case "spatial":
+		return "straight rows of keys are easy to guess", []string{"use a longer keyboard pattern with more turns"}
+
+	case "year":
+		return "recent years are easy to guess", []string{"avoid recent years", "avoid years that are associated with you"}
+	}
+
+	return "", nil
+}
@@ -0,0 +1,90 @@
+package strength
+
+import "testing"
+
+func TestScore(t *testing.T) {
+	tests := []struct {
+		guesses float64
+		want    int
+	}{
+		{0, 0},
+		{1e3, 0},
+		{1e3 + 4, 0}, // Just at a threshold stays in the lower score
+		{1e3 + 5, 1},
+		{1e6, 1},
+		{1e6 + 5, 2},
+		{1e8, 2},
+		{1e8 + 5, 3},
+		{1e10, 3},
+		{1e10 + 5, 4},
+		{1e20, 4},
+	}
+
+	for _, tt := range tests {
+		if got := score(tt.guesses); got != tt.want {
+			t.Errorf("score(%g) = %d; want %d", tt.guesses, got, tt.want)
+		}
+	}
+}
+
+func TestEstimate(t *testing.T) {
+	tests := []struct {
+		password string
+		score    int
+		warning  string
+	}{
+		// Random characters have no pattern, so they are brute-forced: 10 guesses per character. These sit on
+		// each side of the thresholds.
+		{"kq9", 0, ""},
+		{"kq9v", 1, ""},
+		{"kq9vl2", 1, ""},
+		{"kq9vl2x", 2, ""},
+		{"kq9vl2xr", 2, ""},
+		{"kq9vl2xr7", 3, ""},
+		{"kq9vl2xr7m", 3, ""},
+		{"kq9vl2xr7mw", 4, ""},
+
+		{"password", 0, "this is a top-10 common password"},
+		{"qwertyuiop", 0, "this is a top-100 common password"},
+		{"drowssap", 0, "this is similar to a commonly used password"},
+		{"p@ssw0rd", 0, "this is similar to a commonly used password"},
+		{"movie", 0, "a word by itself is easy to guess"},
+		{"aaaaaaaa", 0, `repeats like "aaa" are easy to guess`},
+		{"abcabcabc", 0, `repeats like "abcabcabc" are only slightly harder to guess than "abc"`},
+		{"abcdefgh", 0, "sequences like abc or 6543 are easy to guess"},
+		{"zxcvbnm,./", 0, "straight rows of keys are easy to guess"},
+		{"1999", 0, "recent years are easy to guess"},
+		{"correcthorsebatterystaple", 4, ""},
+		{"", 0, "a password must be provided"},
+	}
+
+	for _, tt := range tests {
+		result := Estimate(tt.password)
+
+		if result.Score != tt.score {
+			t.Errorf("%q: got score %d (%g guesses); want %d", tt.password, result.Score, result.Guesses, tt.score)
+		}
+		if result.Warning != tt.warning {
+			t.Errorf("%q: got warning %q; want %q", tt.password, result.Warning, tt.warning)
+		}
+		if result.Score >= 3 && len(result.Feedback) > 0 {
+			t.Errorf("%q: got feedback %q for a strong password", tt.password, result.Feedback)
+		}
+		if result.Score < 3 && tt.password != "" && len(result.Feedback) == 0 {
+			t.Errorf("%q: got no feedback for a weak password", tt.password)
+		}
+	}
+}
+
+func TestEstimateUserInputs(t *testing.T) {
+	result := Estimate("alice2016", "Alice Smith", "alice@example.com")
+
+	if result.Score != 0 || result.Warning != "avoid using your name or email address" {
+		t.Errorf("got score %d, warning %q; want 0 and a warning about the user inputs", result.Score, result.Warning)
+	}
+
+	// Without the user inputs, the name is just an unknown word.
+	if without := Estimate("alice2016"); without.Guesses <= result.Guesses {
+		t.Errorf("got %g guesses without the user inputs; want more than %g", without.Guesses, result.Guesses)
+	}
+}
@@ -1,6 +1,10 @@
 package validator
 
-import "regexp"
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+)
 
 // Declare a regular expression for sanity checking the format of email addresses.
 // The Regex pattern is taken from https://html.spec.whatwg.org/#valid-e-mail-address.
@@ -9,8 +13,24 @@ var (
 )
 
 // Define a new Validator type which contains a map of validation errors.
+// The order in which the errors were added is also recorded, because the iteration order of a map is random.
+// The unformatted messages are kept too, so that the errors can be translated with Translate().
 type Validator struct {
-	Errors map[string]string
+	Errors   map[string]string
+	keys     []string
+	messages map[string]message
+}
+
+// message holds the format and arguments of a validation error message, before formatting.
+type message struct {
+	format string
+	args   []interface{}
+}
+
+// FieldError holds the validation error message for a single field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
 }
 
 // New() creates a new Validator instance with an empty errors map.
@@ -25,9 +45,63 @@ func (v *Validator) Valid() bool {
 
 // AddError() adds an error message to the map so long as no entry already exists for the given key.
 func (v *Validator) AddError(key, message string) {
-	if _, exists := v.Errors[key]; !exists {
-		v.Errors[key] = message
+	v.addError(key, message, nil)
+}
+
+// AddErrorf() adds an error message formatted with fmt.Sprintf() to the map, for messages which include
+// dynamic values such as a configurable limit.
+func (v *Validator) AddErrorf(key, format string, args ...interface{}) {
+	v.addError(key, format, args)
+}
+
+func (v *Validator) addError(key, format string, args []interface{}) {
+	if _, exists := v.Errors[key]; exists {
+		return
+	}
+
+	text := format
+	if args != nil {
+		text = fmt.Sprintf(format, args...)
+	}
+
+	if v.messages == nil {
+		v.messages = make(map[string]message)
+	}
+
+	v.Errors[key] = text
+	v.keys = append(v.keys, key)
+	v.messages[key] = message{format: format, args: args}
+}
+
+// Translate() returns a copy of the validator with every error message passed through the translate function,
+// which receives the unformatted message and its arguments (nil for a message added with AddError()).
+// The order of the errors is preserved.
+func (v *Validator) Translate(translate func(format string, args ...interface{}) string) *Validator {
+	translated := New()
+
+	for _, key := range v.keys {
+		msg, ok := v.messages[key]
+		if !ok {
+			// The error was added to the Errors map directly, so there is no unformatted message.
+			msg = message{format: v.Errors[key]}
+		}
+
+		translated.Errors[key] = translate(msg.format, msg.args...)
+		translated.keys = append(translated.keys, key)
 	}
+
+	return translated
+}
+
+// OrderedErrors() returns the validation errors as a slice, in the order they were added.
+func (v *Validator) OrderedErrors() []FieldError {
+	errors := make([]FieldError, 0, len(v.keys))
+
+	for _, key := range v.keys {
+		errors = append(errors, FieldError{Field: key, Message: v.Errors[key]})
+	}
+
+	return errors
 }
 
 // Check() adds an error message to the map only if a validation check is not 'ok'.
@@ -37,6 +111,14 @@ func (v *Validator) Check(ok bool, key, message string) {
 	}
 }
 
+// Checkf() adds a formatted error message to the map only if a validation check is not 'ok'.
+// The message is only formatted when the check fails.
+func (v *Validator) Checkf(ok bool, key, format string, args ...interface{}) {
+	if !ok {
+		v.AddErrorf(key, format, args...)
+	}
+}
+
 // In() helper returns true if a specific value is in a list of strings.
 func In(value string, list ...string) bool {
 	for i := range list {
@@ -52,6 +134,17 @@ func Matches(value string, rx *regexp.Regexp) bool {
 	return rx.MatchString(value)
 }
 
+// ValidURL() helper returns true if a string is an absolute URL with the http or https scheme and a host.
+// Relative URLs and other schemes, such as javascript: or file:, are rejected.
+func ValidURL(value string) bool {
+	u, err := url.Parse(value)
+	if err != nil {
+		return false
+	}
+
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Hostname() != ""
+}
+
 // Unique() helper returns true if all string values in a slice are unique.
 func Unique(values []string) bool {
 	uniqueValues := make(map[string] bool)
@@ -0,0 +1,123 @@
+package validator
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestValidURL(t *testing.T) {
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{"https://example.com", true},
+		{"http://example.com:8080/hooks?x=1", true},
+		{"HTTPS://EXAMPLE.COM/path", true}, // Schemes are case-insensitive,
+		{"ftp://example.com", false},
+		{"javascript:alert(1)", false},
+		{"file:///etc/passwd", false},
+		{"/relative/path", false},
+		{"//example.com", false},
+		{"https://", false},
+		{"", false},
+		{"https://exa mple.com", false},
+	}
+
+	for _, tt := range tests {
+		if got := ValidURL(tt.value); got != tt.want {
+			t.Errorf("ValidURL(%q) = %t; want %t", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestUnique(t *testing.T) {
+	if !Unique([]string{"a", "b"}) || !Unique(nil) {
+		t.Error("Unique() rejected unique values")
+	}
+	if Unique([]string{"a", "b", "a"}) {
+		t.Error("Unique() accepted duplicate values")
+	}
+}
+
+func TestAddErrorf(t *testing.T) {
+	v := New()
+	v.AddErrorf("genres", "must not contain more than %d genres", 5)
+	v.AddErrorf("genres", "must contain at least %d genres", 2)
+
+	if got, want := v.Errors["genres"], "must not contain more than 5 genres"; got != want {
+		t.Errorf("got %q; want %q, the first error for the key", got, want)
+	}
+
+	// A message without arguments is kept as it is, even if it contains a verb.
+	v.AddErrorf("title", "must be 100% unique")
+	if got, want := v.Errors["title"], "must be 100% unique"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
+func TestCheckf(t *testing.T) {
+	v := New()
+	v.Checkf(true, "year", "must be greater than %d", 1888)
+	if !v.Valid() {
+		t.Fatalf("a passing check added %v", v.Errors)
+	}
+
+	v.Checkf(false, "year", "must be greater than %d", 1888)
+	if got, want := v.Errors["year"], "must be greater than 1888"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	v := New()
+	v.AddError("title", "must be provided")
+	v.AddErrorf("year", "must be greater than %d", 1888)
+
+	var formats []string
+	translated := v.Translate(func(format string, args ...interface{}) string {
+		formats = append(formats, format)
+		return "translated: " + fmt.Sprintf(format, args...)
+	})
+
+	// The translate function gets the unformatted messages, in the order they were added.
+	if len(formats) != 2 || formats[0] != "must be provided" || formats[1] != "must be greater than %d" {
+		t.Errorf("translate got formats %q", formats)
+	}
+	if got, want := translated.Errors["year"], "translated: must be greater than 1888"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+	if errs := translated.OrderedErrors(); len(errs) != 2 || errs[0].Field != "title" || errs[1].Field != "year" {
+		t.Errorf("got ordered errors %v", errs)
+	}
+}
+
+func TestOrderedErrors(t *testing.T) {
+	v := New()
+
+	// Enough fields that a map would be unlikely to keep them in order by chance.
+	fields := []string{"title", "year", "runtime", "genres", "tags", "poster", "email", "password", "name", "token"}
+	for _, field := range fields {
+		v.AddError(field, "must be provided")
+	}
+
+	// A second error for a field is ignored, and doesn't move it.
+	v.AddErrorf("title", "must not be more than %d bytes long", 500)
+	v.Check(false, "year", "must be greater than 1888")
+
+	var got []string
+	for _, e := range v.OrderedErrors() {
+		got = append(got, e.Field)
+		if e.Message != "must be provided" {
+			t.Errorf("%s: got message %q; want the first one", e.Field, e.Message)
+		}
+	}
+
+	if !reflect.DeepEqual(got, fields) {
+		t.Errorf("got fields %v; want %v", got, fields)
+	}
+
+	if len(New().OrderedErrors()) != 0 {
+		t.Error("a new validator has errors")
+	}
+}
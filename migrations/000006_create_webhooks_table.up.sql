@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS webhooks (
+  id bigserial PRIMARY KEY,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  url text NOT NULL,
+  events text[] NOT NULL, -- Event types the subscriber is notified of (e.g. movie.created)
+  secret text NOT NULL, -- Per-subscription secret used to sign the delivered payloads
+  version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS webhooks_events_idx ON webhooks USING GIN(events);
\ No newline at end of file
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS permissions (
+  id bigserial PRIMARY KEY,
+  code text NOT NULL UNIQUE -- Permission code in the format <resource>:<action> (e.g. movies:write)
+);
+
+-- Joining table for the many-to-many relationship between users and permissions
+CREATE TABLE IF NOT EXISTS users_permissions (
+  user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+  permission_id bigint NOT NULL REFERENCES permissions ON DELETE CASCADE,
+  PRIMARY KEY (user_id, permission_id)
+);
+
+INSERT INTO permissions (code)
+VALUES
+  ('movies:read'),
+  ('movies:write'),
+  ('users:read');
@@ -0,0 +1,4 @@
+-- Timestamp for when the movie was last changed. Existing movies default to their creation time.
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW();
+
+UPDATE movies SET updated_at = created_at;
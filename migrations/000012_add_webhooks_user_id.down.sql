@@ -0,0 +1,5 @@
+DELETE FROM permissions WHERE code IN ('webhooks:read', 'webhooks:write');
+
+DROP INDEX IF EXISTS webhooks_user_id_idx;
+
+ALTER TABLE webhooks DROP COLUMN IF EXISTS user_id;
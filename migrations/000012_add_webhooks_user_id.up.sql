@@ -0,0 +1,12 @@
+-- Each webhook belongs to the user who registered it, who is the only one able to list or delete it.
+-- Webhooks registered before this migration have no owner: they are still delivered, but can only be
+-- managed directly in the database.
+ALTER TABLE webhooks ADD COLUMN IF NOT EXISTS user_id bigint REFERENCES users ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS webhooks_user_id_idx ON webhooks (user_id);
+
+INSERT INTO permissions (code)
+VALUES
+  ('webhooks:read'),
+  ('webhooks:write')
+ON CONFLICT DO NOTHING;
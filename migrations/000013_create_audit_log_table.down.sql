@@ -0,0 +1,3 @@
+DELETE FROM permissions WHERE code = 'audit:read';
+
+DROP TABLE IF EXISTS audit_log;
\ No newline at end of file
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS audit_log (
+  id bigserial PRIMARY KEY,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  user_id bigint REFERENCES users ON DELETE SET NULL, -- NULL for anonymous users
+  action text NOT NULL, -- e.g. create, update, delete
+  entity text NOT NULL, -- Type of the changed record, e.g. movie
+  entity_id bigint NOT NULL,
+  before jsonb, -- State of the record before the change (NULL when created)
+  after jsonb -- State of the record after the change (NULL when deleted)
+);
+
+INSERT INTO permissions (code)
+VALUES ('audit:read')
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,2 @@
+-- URL of the movie poster. Movies without a poster have an empty string.
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS poster text NOT NULL DEFAULT '';
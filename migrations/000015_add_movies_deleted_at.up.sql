@@ -0,0 +1,5 @@
+-- Timestamp for when the movie was (soft) deleted. Movies which haven't been deleted have NULL.
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS deleted_at timestamp(0) with time zone;
+
+-- Only deleted movies are indexed, for the purge of the ones past the retention period.
+CREATE INDEX IF NOT EXISTS movies_deleted_at_idx ON movies (deleted_at) WHERE deleted_at IS NOT NULL;
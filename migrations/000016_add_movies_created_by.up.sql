@@ -0,0 +1,5 @@
+-- ID of the user who created the movie. Movies created before this column was added, or by seeding, have NULL.
+-- The movies are kept if the user is deleted.
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS created_by bigint REFERENCES users ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS movies_created_by_idx ON movies (created_by);
@@ -0,0 +1,5 @@
+-- Speeds up the lookup of a movie by title and year, which checks for a duplicate before a movie is created or
+-- updated (see MovieModel.UniqueTitleYear and InsertOrGet()). Deleted movies are left out, since they are never
+-- duplicates.
+-- The index isn't UNIQUE: duplicates are only rejected when asked to, and the table may already contain some.
+CREATE INDEX IF NOT EXISTS movies_title_year_idx ON movies (title, year) WHERE deleted_at IS NULL;
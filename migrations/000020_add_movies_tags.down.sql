@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS movies_tags_idx;
+
+ALTER TABLE movies DROP CONSTRAINT IF EXISTS tags_length_check;
+
+ALTER TABLE movies DROP COLUMN IF EXISTS tags;
@@ -0,0 +1,9 @@
+-- Tags are free-form labels (e.g. "90s", "rewatchable"), unlike the genres, which are validated.
+-- Existing movies get no tags.
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS tags text[] NOT NULL DEFAULT '{}';
+
+-- There must be no more than 20 tags
+ALTER TABLE movies ADD CONSTRAINT tags_length_check CHECK (cardinality(tags) <= 20);
+
+-- The GIN index serves the tags @> containment filter of the movie list.
+CREATE INDEX IF NOT EXISTS movies_tags_idx ON movies USING GIN(tags);
@@ -0,0 +1,11 @@
+// Package migrations embeds the SQL migration files so that binaries which apply them
+// are self-contained.
+package migrations
+
+import "embed"
+
+// The //go:embed directive path is relative to this file, so FS holds every .sql file in this directory.
+// Files follow the golang-migrate naming convention: <version>_<title>.up.sql and <version>_<title>.down.sql.
+
+//go:embed *.sql
+var FS embed.FS